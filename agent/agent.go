@@ -10,8 +10,11 @@ import (
 	"kyanos/agent/compatible"
 	"kyanos/agent/conn"
 	"kyanos/agent/protocol"
+	"kyanos/agent/recorder"
+	"kyanos/agent/render/graph"
 	loader_render "kyanos/agent/render/loader"
 	"kyanos/agent/render/stat"
+	"kyanos/agent/render/top"
 	"kyanos/agent/render/watch"
 	"kyanos/bpf"
 	"kyanos/bpf/loader"
@@ -40,18 +43,20 @@ func SetupAgent(options ac.AgentOptions) {
 			common.AgentLog.Warn(err)
 		}
 	}
-	if enabled, err := common.IsEnableBPF(); err == nil && !enabled {
-		common.AgentLog.Error("BPF is not enabled in your kernel. This might be because your kernel version is too old. " +
-			"Please check the requirements for Kyanos at https://kyanos.io/quickstart.html#installation-requirements.")
-		return
-	}
+	if !options.IsOffline() {
+		if enabled, err := common.IsEnableBPF(); err == nil && !enabled {
+			common.AgentLog.Error("BPF is not enabled in your kernel. This might be because your kernel version is too old. " +
+				"Please check the requirements for Kyanos at https://kyanos.io/quickstart.html#installation-requirements.")
+			return
+		}
 
-	if ok, err := ac.HasPermission(); err != nil {
-		common.AgentLog.Error("check capabilities failed: ", err)
-		return
-	} else if !ok {
-		common.AgentLog.Error("Kyanos requires CAP_BPF to run. Please run kyanos with sudo or run container in privilege mode.")
-		return
+		if ok, err := ac.HasPermission(); err != nil {
+			common.AgentLog.Error("check capabilities failed: ", err)
+			return
+		} else if !ok {
+			common.AgentLog.Error("Kyanos requires CAP_BPF to run. Please run kyanos with sudo or run container in privilege mode.")
+			return
+		}
 	}
 
 	if common.Is256ColorSupported() {
@@ -62,6 +67,29 @@ func SetupAgent(options ac.AgentOptions) {
 
 	options = ac.ValidateAndRepairOptions(options)
 	common.LaunchEpochTime = GetMachineStartTimeNano()
+	var replayReader *bpf.EventFileReader
+	if options.IsReplay() {
+		var err error
+		replayReader, err = bpf.NewEventFileReader(options.ReplayFile)
+		if err != nil {
+			common.AgentLog.Errorf("open event file %s failed: %v", options.ReplayFile, err)
+			return
+		}
+		// timestamps of the events are relative to the boot time of the recording machine
+		common.LaunchEpochTime = replayReader.LaunchEpochTime
+		conn.UseEventClock = true
+	} else if options.ReadPcapFile != "" {
+		// packet timestamps are nanoseconds since the Unix epoch
+		common.LaunchEpochTime = 0
+		conn.UseEventClock = true
+	} else if options.RecordEventsFile != "" {
+		recorder, err := setupEventRecorder(&options)
+		if err != nil {
+			common.AgentLog.Errorf("create event file %s failed: %v", options.RecordEventsFile, err)
+			return
+		}
+		defer recorder.Close()
+	}
 	stopper := options.Stopper
 	connManager := conn.InitConnManager()
 
@@ -101,6 +129,19 @@ func SetupAgent(options ac.AgentOptions) {
 	var _bf loader.BPF
 	go func(_bf *loader.BPF) {
 		defer wg.Done()
+		if options.IsOffline() {
+			if options.IsReplay() {
+				options.LoadPorgressChannel <- "🍩 Kyanos replaying " + options.ReplayFile + "..."
+				go replayEvents(ctx, &options, replayReader, pm)
+			} else {
+				options.LoadPorgressChannel <- "🍩 Kyanos reading " + options.ReadPcapFile + "..."
+				go readPcapFile(ctx, &options, pm)
+			}
+			if options.UseTui() {
+				options.LoadPorgressChannel <- "quit"
+			}
+			return
+		}
 		options.LoadPorgressChannel <- "🍩 Kyanos starting..."
 		kernelVersion := compatible.GetCurrentKernelVersion()
 		options.Kv = &kernelVersion
@@ -142,13 +183,13 @@ func SetupAgent(options ac.AgentOptions) {
 		firstPacketChannel := make(chan *bpf.AgentFirstPacketEvt, 10)
 		firstPacketProcessor := conn.NewFirstPacketProcessor(firstPacketChannel, pm.GetFirstPacketEventsChannels())
 		go firstPacketProcessor.Start()
-		err = bpf.PullFirstPacketEvents(ctx, firstPacketChannel, options.FirstPacketEventMapPageNum)
+		err = bpf.PullFirstPacketEvents(ctx, firstPacketChannel, options.FirstPacketEventMapPageNum, options.CustomFirstPacketHook)
 
 		err = _bf.AttachProgs(&options)
 		if err != nil {
 			return
 		}
-		if options.WatchOptions.UseTui() {
+		if options.UseTui() {
 			options.LoadPorgressChannel <- "🍹 All programs attached"
 			options.LoadPorgressChannel <- "🍭 Waiting for events.."
 			time.Sleep(500 * time.Millisecond)
@@ -158,7 +199,7 @@ func SetupAgent(options ac.AgentOptions) {
 	defer func() {
 		_bf.Close()
 	}()
-	if options.WatchOptions.UseTui() {
+	if options.UseTui() {
 		loader_render.Start(ctx, options)
 		common.SetLogToStdout()
 	} else {
@@ -183,7 +224,22 @@ func SetupAgent(options ac.AgentOptions) {
 		options.InitCompletedHook()
 	}
 
-	if options.AnalysisEnable {
+	if options.PrometheusListen != "" {
+		serveMetrics(ctx, &options, recordsChannel)
+	} else if options.TopEnable {
+		snapshotChannel := make(chan *analysis.TopSnapshot, 1)
+		topAnalyzer := analysis.CreateTopAnalyzer(recordsChannel, snapshotChannel, options.TopInterval, containerNameResolver(ctx, &options))
+		go topAnalyzer.Run(ctx)
+		groupBy := analysis.TopByProcess
+		if options.TopByContainer {
+			groupBy = analysis.TopByContainer
+		}
+		top.StartTopRender(ctx, snapshotChannel, groupBy)
+	} else if options.FlightRecorderOptions.Enabled() {
+		recorder.NewFlightRecorder(options.FlightRecorderOptions).Run(ctx, recordsChannel)
+	} else if options.GraphFormat != "" {
+		graph.Run(ctx, recordsChannel, options.GraphFormat, options.GraphOutput, options.GraphDuration, containerNameResolver(ctx, &options))
+	} else if options.AnalysisEnable {
 		resultChannel := make(chan []*analysis.ConnStat, 1000)
 		renderStopper := make(chan int)
 		analyzer := analysis.CreateAnalyzer(recordsChannel, &options.AnalysisOptions, resultChannel, renderStopper, options.Ctx)
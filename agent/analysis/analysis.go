@@ -62,7 +62,7 @@ func (a *aggregator) receive(record *analysis_common.AnnotatedRecord) error {
 
 	statefulMsg, hasStatus := record.Response().(protocol.StatusfulMessage)
 	if hasStatus {
-		if statefulMsg.Status() != protocol.SuccessStatus {
+		if status := statefulMsg.Status(); status != protocol.SuccessStatus && status != protocol.MissStatus {
 			o.FailedCount++
 		}
 	}
@@ -71,7 +71,7 @@ func (a *aggregator) receive(record *analysis_common.AnnotatedRecord) error {
 	for rawMetricType, enabled := range a.AnalysisOptions.EnabledMetricTypeSet {
 		metricType := analysis_common.MetricType(rawMetricType)
 
-		if enabled {
+		if enabled && record.IsMetricAvailable(metricType) {
 			MetricExtract := analysis_common.GetMetricExtractFunc[float64](metricType)
 			samples := a.SamplesMap[metricType]
 			// only sample if aggregator is sub or no sub classfier
@@ -5,6 +5,15 @@ import (
 
 	anc "kyanos/agent/analysis/common"
 	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/cql"
+	"kyanos/agent/protocol/dubbo"
+	"kyanos/agent/protocol/http2"
+	"kyanos/agent/protocol/mongodb"
+	"kyanos/agent/protocol/mqtt"
+	"kyanos/agent/protocol/mysql"
+	"kyanos/agent/protocol/nats"
+	"kyanos/agent/protocol/postgresql"
+	"kyanos/agent/protocol/thrift"
 	"kyanos/bpf"
 )
 
@@ -46,6 +55,65 @@ func init() {
 			return anc.ClassId(redisReq.Command()), nil
 		}
 	}
+	classfierMap[anc.GrpcMethod] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		http2Req, ok := ar.Record.Request().(*http2.Request)
+		if !ok {
+			return "_not_a_grpc_req_", nil
+		} else {
+			return anc.ClassId(http2Req.FullMethod()), nil
+		}
+	}
+	classfierMap[anc.CqlQuery] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		cqlReq, ok := ar.Record.Request().(*cql.Request)
+		if !ok {
+			return "_not_a_cql_req_", nil
+		} else {
+			return anc.ClassId(cqlReq.QueryDigest()), nil
+		}
+	}
+	classfierMap[anc.NatsSubject] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		natsReq, ok := ar.Record.Request().(*nats.NatsMessage)
+		if !ok {
+			return "_not_a_nats_req_", nil
+		} else {
+			return anc.ClassId(natsReq.Subject), nil
+		}
+	}
+	classfierMap[anc.DubboMethod] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		dubboReq, ok := ar.Record.Request().(*dubbo.Request)
+		if !ok {
+			return "_not_a_dubbo_req_", nil
+		} else {
+			return anc.ClassId(dubboReq.FullMethod()), nil
+		}
+	}
+	classfierMap[anc.ThriftMethod] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		thriftReq, ok := ar.Record.Request().(*thrift.Request)
+		if !ok {
+			return "_not_a_thrift_req_", nil
+		} else {
+			return anc.ClassId(thriftReq.FullMethod()), nil
+		}
+	}
+	classfierMap[anc.MqttTopic] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		mqttReq, ok := ar.Record.Request().(*mqtt.Packet)
+		if !ok {
+			return "_not_a_mqtt_req_", nil
+		} else {
+			return anc.ClassId(mqttReq.ClassName()), nil
+		}
+	}
+	classfierMap[anc.SqlDigest] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		return anc.ClassId(sqlDigest(ar)), nil
+	}
+	classfierMap[anc.MongoCommand] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
+		mongoReq, ok := ar.Record.Request().(*mongodb.MongoDBFrame)
+		if !ok {
+			return "_not_a_mongo_req_", nil
+		} else {
+			return anc.ClassId(mongoReq.ClassName()), nil
+		}
+	}
 
 	classfierMap[anc.ProtocolAdaptive] = func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
 		redisReq, ok := ar.Record.Request().(*protocol.RedisMessage)
@@ -85,12 +153,82 @@ func init() {
 			return redisReq.Command()
 		}
 	}
+	classIdHumanReadableMap[anc.GrpcMethod] = func(ar *anc.AnnotatedRecord) string {
+		http2Req, ok := ar.Record.Request().(*http2.Request)
+		if !ok {
+			return "_not_a_grpc_req_"
+		} else {
+			return http2Req.FullMethod()
+		}
+	}
+	classIdHumanReadableMap[anc.CqlQuery] = func(ar *anc.AnnotatedRecord) string {
+		cqlReq, ok := ar.Record.Request().(*cql.Request)
+		if !ok {
+			return "_not_a_cql_req_"
+		} else {
+			return cqlReq.QueryDigest()
+		}
+	}
+	classIdHumanReadableMap[anc.NatsSubject] = func(ar *anc.AnnotatedRecord) string {
+		natsReq, ok := ar.Record.Request().(*nats.NatsMessage)
+		if !ok {
+			return "_not_a_nats_req_"
+		} else {
+			return natsReq.Subject
+		}
+	}
+	classIdHumanReadableMap[anc.DubboMethod] = func(ar *anc.AnnotatedRecord) string {
+		dubboReq, ok := ar.Record.Request().(*dubbo.Request)
+		if !ok {
+			return "_not_a_dubbo_req_"
+		} else {
+			return dubboReq.FullMethod()
+		}
+	}
+	classIdHumanReadableMap[anc.ThriftMethod] = func(ar *anc.AnnotatedRecord) string {
+		thriftReq, ok := ar.Record.Request().(*thrift.Request)
+		if !ok {
+			return "_not_a_thrift_req_"
+		} else {
+			return thriftReq.FullMethod()
+		}
+	}
+	classIdHumanReadableMap[anc.MqttTopic] = func(ar *anc.AnnotatedRecord) string {
+		mqttReq, ok := ar.Record.Request().(*mqtt.Packet)
+		if !ok {
+			return "_not_a_mqtt_req_"
+		} else {
+			return mqttReq.ClassName()
+		}
+	}
+	classIdHumanReadableMap[anc.SqlDigest] = sqlDigest
+	classIdHumanReadableMap[anc.MongoCommand] = func(ar *anc.AnnotatedRecord) string {
+		mongoReq, ok := ar.Record.Request().(*mongodb.MongoDBFrame)
+		if !ok {
+			return "_not_a_mongo_req_"
+		} else {
+			return mongoReq.ClassName()
+		}
+	}
 
 	classIdHumanReadableMap[anc.Protocol] = func(ar *anc.AnnotatedRecord) string {
 		return bpf.ProtocolNamesMap[bpf.AgentTrafficProtocolT(ar.Protocol)]
 	}
 }
 
+func sqlDigest(ar *anc.AnnotatedRecord) string {
+	switch req := ar.Record.Request().(type) {
+	case *mysql.MysqlPacket:
+		return req.Digest()
+	case *mysql.Transaction:
+		return req.Digest()
+	case *postgresql.Request:
+		return req.Digest()
+	default:
+		return "_not_a_sql_req_"
+	}
+}
+
 func getClassfier(classfierType anc.ClassfierType, options anc.AnalysisOptions) Classfier {
 	if classfierType == anc.ProtocolAdaptive {
 		return func(ar *anc.AnnotatedRecord) (anc.ClassId, error) {
@@ -9,6 +9,14 @@ var ClassfierTypeNames = map[ClassfierType]string{
 	Protocol:         "protocol",
 	HttpPath:         "http-path",
 	RedisCommand:     "redis-command",
+	GrpcMethod:       "grpc-method",
+	CqlQuery:         "cql-query",
+	NatsSubject:      "nats-subject",
+	DubboMethod:      "dubbo-method",
+	ThriftMethod:     "thrift-method",
+	MqttTopic:        "mqtt-topic",
+	SqlDigest:        "sql-digest",
+	MongoCommand:     "mongo-command",
 	ProtocolAdaptive: "protocol-adaptive",
 	Default:          "default",
 }
@@ -28,6 +36,30 @@ const (
 	// Redis
 	RedisCommand
 
+	// gRPC
+	GrpcMethod
+
+	// CQL
+	CqlQuery
+
+	// NATS
+	NatsSubject
+
+	// Dubbo
+	DubboMethod
+
+	// Thrift
+	ThriftMethod
+
+	// MQTT
+	MqttTopic
+
+	// MySQL and PostgreSQL
+	SqlDigest
+
+	// MongoDB
+	MongoCommand
+
 	ProtocolAdaptive
 )
 
@@ -32,6 +32,7 @@ type annotatedRecordAlias struct {
 	RespSyscallEventDetails    []SyscallEventDetail `json:"resp_syscall_events"`
 	ReqNicEventDetails         []NicEventDetail     `json:"req_nic_events"`
 	RespNicEventDetails        []NicEventDetail     `json:"resp_nic_events"`
+	KernelTimingUnavailable    bool                 `json:"kernel_timing_unavailable,omitempty"`
 }
 
 // MarshalJSON implements custom JSON marshaling for AnnotatedRecord
@@ -61,5 +62,6 @@ func (r *AnnotatedRecord) MarshalJSON() ([]byte, error) {
 		RespSyscallEventDetails:    r.RespSyscallEventDetails,
 		ReqNicEventDetails:         r.ReqNicEventDetails,
 		RespNicEventDetails:        r.RespNicEventDetails,
+		KernelTimingUnavailable:    r.KernelTimingUnavailable,
 	})
 }
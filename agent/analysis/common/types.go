@@ -186,6 +186,28 @@ type AnnotatedRecord struct {
 	RespSyscallEventDetails      []SyscallEventDetail
 	ReqNicEventDetails           []NicEventDetail
 	RespNicEventDetails          []NicEventDetail
+	// set for the records read from a capture file, the durations measured
+	// with kernel events are -1
+	KernelTimingUnavailable bool
+}
+
+// NotAvailable is shown instead of the durations a record can't provide.
+const NotAvailable = "n/a"
+
+// IsMetricAvailable tells whether the metric can be measured for the record.
+func (a *AnnotatedRecord) IsMetricAvailable(t MetricType) bool {
+	if !a.KernelTimingUnavailable {
+		return true
+	}
+	switch t {
+	case ReadFromSocketBufferDuration:
+		return false
+	case BlackBoxDuration:
+		// the network duration of a client needs the time of the NIC
+		return a.ConnDesc.Side == common.ServerSide
+	default:
+		return true
+	}
 }
 
 func (a *AnnotatedRecord) GetTotalDurationMills() float64 {
@@ -277,13 +299,21 @@ func (r *AnnotatedRecord) String(options AnnotatedRecordToStringOptions) string
 			common.FormatTimestampWithPrecision(r.EndTs, nano))
 	}
 	if _, ok := options.MetricTypeSet[ReadFromSocketBufferDuration]; ok {
-		result += fmt.Sprintf("[read from sockbuf]=%.3f(%s)\n", common.ConvertDurationToMillisecondsIfNeeded(float64(r.ReadFromSocketBufferDuration), nano),
-			timeUnitName(nano))
+		if r.IsMetricAvailable(ReadFromSocketBufferDuration) {
+			result += fmt.Sprintf("[read from sockbuf]=%.3f(%s)\n", common.ConvertDurationToMillisecondsIfNeeded(float64(r.ReadFromSocketBufferDuration), nano),
+				timeUnitName(nano))
+		} else {
+			result += fmt.Sprintf("[read from sockbuf]=%s\n", NotAvailable)
+		}
 	}
 	if _, ok := options.MetricTypeSet[BlackBoxDuration]; ok {
-		result += fmt.Sprintf("[%s]=%.3f(%s)\n", r.BlackboxName(),
-			common.ConvertDurationToMillisecondsIfNeeded(float64(r.BlackBoxDuration), nano),
-			timeUnitName(nano))
+		if r.IsMetricAvailable(BlackBoxDuration) {
+			result += fmt.Sprintf("[%s]=%.3f(%s)\n", r.BlackboxName(),
+				common.ConvertDurationToMillisecondsIfNeeded(float64(r.BlackBoxDuration), nano),
+				timeUnitName(nano))
+		} else {
+			result += fmt.Sprintf("[%s]=%s\n", r.BlackboxName(), NotAvailable)
+		}
 	}
 
 	if options.IncludeSyscallStat {
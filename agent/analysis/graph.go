@@ -0,0 +1,142 @@
+package analysis
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+)
+
+type GraphNodeKind string
+
+const (
+	ProcessNode   GraphNodeKind = "process"
+	ContainerNode GraphNodeKind = "container"
+	RemoteNode    GraphNodeKind = "remote"
+)
+
+type GraphNode struct {
+	Id   string
+	Name string
+	Kind GraphNodeKind
+}
+
+// GraphEdge points from the client to the server, Port is the port of the
+// server.
+type GraphEdge struct {
+	From     string
+	To       string
+	Protocol bpf.AgentTrafficProtocolT
+	Port     uint16
+	Requests int
+	Failed   int
+	// in milliseconds
+	Latency *PercentileCalculator
+}
+
+func (e *GraphEdge) ErrorRate() float64 {
+	if e.Requests == 0 {
+		return 0
+	}
+	return float64(e.Failed) * 100 / float64(e.Requests)
+}
+
+type graphEdgeKey struct {
+	from, to string
+	protocol bpf.AgentTrafficProtocolT
+	port     uint16
+}
+
+// DependencyGraph aggregates records into edges between the local processes,
+// or their containers, and the remote endpoints. Remote endpoints are merged
+// by ip, so a peer calling a local server and serving a local client is one
+// node.
+type DependencyGraph struct {
+	containerResolver func(pid uint32) string
+	processNames      common.ProcessNameCache
+	nodes             map[string]*GraphNode
+	edges             map[graphEdgeKey]*GraphEdge
+}
+
+// NewDependencyGraph creates an empty graph, containerResolver returns the
+// container name of a process and may be nil.
+func NewDependencyGraph(containerResolver func(pid uint32) string) *DependencyGraph {
+	return &DependencyGraph{
+		containerResolver: containerResolver,
+		processNames:      make(common.ProcessNameCache),
+		nodes:             make(map[string]*GraphNode),
+		edges:             make(map[graphEdgeKey]*GraphEdge),
+	}
+}
+
+func (g *DependencyGraph) node(kind GraphNodeKind, name string) string {
+	id := fmt.Sprintf("%s:%s", kind, name)
+	if _, ok := g.nodes[id]; !ok {
+		g.nodes[id] = &GraphNode{Id: id, Name: name, Kind: kind}
+	}
+	return id
+}
+
+func (g *DependencyGraph) localNode(pid uint32) string {
+	if g.containerResolver != nil {
+		if container := g.containerResolver(pid); container != "" {
+			return g.node(ContainerNode, container)
+		}
+	}
+	// process names are stable across restarts unlike pids
+	if name := g.processNames.Get(pid); name != "" {
+		return g.node(ProcessNode, name)
+	}
+	return g.node(ProcessNode, fmt.Sprintf("pid %d", pid))
+}
+
+func (g *DependencyGraph) Receive(record *anc.AnnotatedRecord) {
+	local := g.localNode(record.Pid)
+	remote := g.node(RemoteNode, record.ConnDesc.RemoteAddr.String())
+	key := graphEdgeKey{from: local, to: remote, protocol: bpf.AgentTrafficProtocolT(record.Protocol), port: uint16(record.ConnDesc.RemotePort)}
+	if record.Side == common.ServerSide {
+		key = graphEdgeKey{from: remote, to: local, protocol: key.protocol, port: uint16(record.ConnDesc.LocalPort)}
+	}
+	edge, ok := g.edges[key]
+	if !ok {
+		edge = &GraphEdge{From: key.from, To: key.to, Protocol: key.protocol, Port: key.port, Latency: NewPercentileCalculator()}
+		g.edges[key] = edge
+	}
+	edge.Requests++
+	if status, ok := record.Response().(protocol.StatusfulMessage); ok && status.Status() == protocol.FailStatus {
+		edge.Failed++
+	}
+	if record.TotalDuration >= 0 {
+		edge.Latency.AddValue(record.TotalDuration / 1e6)
+	}
+}
+
+// Nodes returns the nodes sorted by id, so the output of the same traffic
+// can be diffed.
+func (g *DependencyGraph) Nodes() []*GraphNode {
+	nodes := make([]*GraphNode, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	slices.SortFunc(nodes, func(n1, n2 *GraphNode) int {
+		return cmp.Compare(n1.Id, n2.Id)
+	})
+	return nodes
+}
+
+// Edges returns the edges sorted by their endpoints, protocol and port.
+func (g *DependencyGraph) Edges() []*GraphEdge {
+	edges := make([]*GraphEdge, 0, len(g.edges))
+	for _, edge := range g.edges {
+		edges = append(edges, edge)
+	}
+	slices.SortFunc(edges, func(e1, e2 *GraphEdge) int {
+		return cmp.Or(cmp.Compare(e1.From, e2.From), cmp.Compare(e1.To, e2.To),
+			cmp.Compare(e1.Protocol, e2.Protocol), cmp.Compare(e1.Port, e2.Port))
+	})
+	return edges
+}
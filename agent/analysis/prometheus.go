@@ -0,0 +1,201 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The number of distinct classes kept per protocol, classes seen after that
+// are counted as kOtherClass so that values like HTTP paths with ids don't
+// create a series per request.
+const kMaxClassesPerProtocol = 200
+const kOtherClass = "other"
+
+var prometheusLabels = []string{"protocol", "side", "remote_ip", "remote_port", "local_port", "process", "container", "class"}
+
+// PrometheusExporter aggregates annotated records into Prometheus histograms
+// and counters, the class label is the protocol specific classification used
+// by stat, like the HTTP path or the Redis command.
+type PrometheusExporter struct {
+	options           *anc.AnalysisOptions
+	containerResolver func(pid uint32) string
+	processNames      common.ProcessNameCache
+	classes           map[uint32]map[string]bool
+	registry          *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	failedRequests   *prometheus.CounterVec
+	totalDuration    *prometheus.HistogramVec
+	networkDuration  *prometheus.HistogramVec
+	internalDuration *prometheus.HistogramVec
+	socketDuration   *prometheus.HistogramVec
+	requestSize      *prometheus.HistogramVec
+	responseSize     *prometheus.HistogramVec
+}
+
+// NewPrometheusExporter creates an exporter, containerResolver returns the
+// container name of a process and may be nil.
+func NewPrometheusExporter(options *anc.AnalysisOptions, containerResolver func(pid uint32) string) *PrometheusExporter {
+	durationBuckets := prometheus.ExponentialBuckets(0.0005, 2, 16)
+	sizeBuckets := prometheus.ExponentialBuckets(64, 4, 10)
+	e := &PrometheusExporter{
+		options:           options,
+		containerResolver: containerResolver,
+		processNames:      make(common.ProcessNameCache),
+		classes:           make(map[uint32]map[string]bool),
+		registry:          prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kyanos_requests_total",
+			Help: "Number of request-response pairs.",
+		}, prometheusLabels),
+		failedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kyanos_failed_requests_total",
+			Help: "Number of request-response pairs whose response indicates a failure.",
+		}, prometheusLabels),
+		totalDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kyanos_request_duration_seconds",
+			Help:    "Total time of request-response pairs.",
+			Buckets: durationBuckets,
+		}, prometheusLabels),
+		networkDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kyanos_network_duration_seconds",
+			Help:    "Time between the request leaving and the response arriving at the network interface, client side only.",
+			Buckets: durationBuckets,
+		}, prometheusLabels),
+		internalDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kyanos_internal_duration_seconds",
+			Help:    "Time the process spent between reading the request and writing the response, server side only.",
+			Buckets: durationBuckets,
+		}, prometheusLabels),
+		socketDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kyanos_socket_read_duration_seconds",
+			Help:    "Time spent reading from the socket buffer.",
+			Buckets: durationBuckets,
+		}, prometheusLabels),
+		requestSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kyanos_request_size_bytes",
+			Help:    "Size of requests.",
+			Buckets: sizeBuckets,
+		}, prometheusLabels),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kyanos_response_size_bytes",
+			Help:    "Size of responses.",
+			Buckets: sizeBuckets,
+		}, prometheusLabels),
+	}
+	e.registry.MustRegister(e.requests, e.failedRequests, e.totalDuration, e.networkDuration,
+		e.internalDuration, e.socketDuration, e.requestSize, e.responseSize)
+	return e
+}
+
+func (e *PrometheusExporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+func (e *PrometheusExporter) Registry() *prometheus.Registry {
+	return e.registry
+}
+
+// Run observes the records until ctx is done.
+func (e *PrometheusExporter) Run(ctx context.Context, recordsChannel <-chan *anc.AnnotatedRecord) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-recordsChannel:
+			e.Observe(record)
+		}
+	}
+}
+
+func (e *PrometheusExporter) Observe(record *anc.AnnotatedRecord) {
+	labels := prometheus.Labels{
+		"protocol":    bpf.ProtocolNamesMap[bpf.AgentTrafficProtocolT(record.Protocol)],
+		"side":        record.Side.String(),
+		"remote_ip":   record.RemoteAddr.String(),
+		"remote_port": fmt.Sprintf("%d", record.RemotePort),
+		"local_port":  fmt.Sprintf("%d", record.LocalPort),
+		"process":     e.processNames.Get(record.Pid),
+		"container":   "",
+		"class":       e.class(record),
+	}
+	// the port of the client is ephemeral, only the server port is kept, and
+	// the clients of a server are unbounded, only the servers a client calls
+	// are kept
+	if record.Side == common.ServerSide {
+		labels["remote_ip"] = ""
+		labels["remote_port"] = ""
+	} else {
+		labels["local_port"] = ""
+	}
+	if e.containerResolver != nil {
+		labels["container"] = e.containerResolver(record.Pid)
+	}
+
+	e.requests.With(labels).Inc()
+	if status, ok := record.Response().(protocol.StatusfulMessage); ok && status.Status() != protocol.SuccessStatus && status.Status() != protocol.MissStatus {
+		e.failedRequests.With(labels).Inc()
+	}
+	if record.TotalDuration >= 0 {
+		e.totalDuration.With(labels).Observe(record.TotalDuration / 1e9)
+	}
+	if record.BlackBoxDuration >= 0 {
+		if record.Side == common.ServerSide {
+			e.internalDuration.With(labels).Observe(record.BlackBoxDuration / 1e9)
+		} else {
+			e.networkDuration.With(labels).Observe(record.BlackBoxDuration / 1e9)
+		}
+	}
+	if record.ReadFromSocketBufferDuration >= 0 {
+		e.socketDuration.With(labels).Observe(record.ReadFromSocketBufferDuration / 1e9)
+	}
+	if record.ReqSize >= 0 {
+		e.requestSize.With(labels).Observe(float64(record.ReqSize))
+	}
+	if record.RespSize >= 0 {
+		e.responseSize.With(labels).Observe(float64(record.RespSize))
+	}
+}
+
+// class returns the protocol specific class of the record, it is empty for
+// protocols classified by connection attributes which are labels already.
+func (e *PrometheusExporter) class(record *anc.AnnotatedRecord) string {
+	classfierType, ok := e.options.ProtocolSpecificClassfiers[bpf.AgentTrafficProtocolT(record.Protocol)]
+	if !ok {
+		return ""
+	}
+	switch classfierType {
+	case anc.None, anc.Conn, anc.RemotePort, anc.LocalPort, anc.RemoteIp, anc.Protocol:
+		return ""
+	}
+	humanReadableFunc, ok := getClassIdHumanReadableFunc(classfierType, *e.options)
+	if !ok {
+		return ""
+	}
+	return e.boundedClass(record.Protocol, humanReadableFunc(record))
+}
+
+func (e *PrometheusExporter) boundedClass(protocol uint32, class string) string {
+	classes, ok := e.classes[protocol]
+	if !ok {
+		classes = make(map[string]bool)
+		e.classes[protocol] = classes
+	}
+	if classes[class] {
+		return class
+	}
+	if len(classes) >= kMaxClassesPerProtocol {
+		return kOtherClass
+	}
+	classes[class] = true
+	return class
+}
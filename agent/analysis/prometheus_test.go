@@ -0,0 +1,135 @@
+package analysis_test
+
+import (
+	"fmt"
+	"kyanos/agent/analysis"
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseRedis(t *testing.T, data string, messageType protocol.MessageType) protocol.ParsedMessage {
+	streamBuffer := buffer.New(1024)
+	streamBuffer.Add(0, []byte(data), 1)
+	result := protocol.GetParserByProtocol(bpf.AgentTrafficProtocolTKProtocolRedis).ParseStream(streamBuffer, messageType)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	return result.ParsedMessages[0]
+}
+
+func redisRecord(t *testing.T, resp string) *anc.AnnotatedRecord {
+	record := analysis.CreateAnnotedRecord()
+	record.Record = protocol.Record{
+		Req:  parseRedis(t, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", protocol.Request),
+		Resp: parseRedis(t, resp, protocol.Response),
+	}
+	record.ConnDesc = common.ConnDesc{
+		LocalPort:  40000,
+		RemotePort: 6379,
+		RemoteAddr: net.ParseIP("10.0.0.2"),
+		LocalAddr:  net.ParseIP("10.0.0.1"),
+		Protocol:   uint32(bpf.AgentTrafficProtocolTKProtocolRedis),
+		Side:       common.ClientSide,
+	}
+	record.TotalDuration = 2e6
+	record.BlackBoxDuration = 1e6
+	record.ReqSize = 22
+	record.RespSize = 9
+	return record
+}
+
+func TestPrometheusExporter(t *testing.T) {
+	options := &anc.AnalysisOptions{ProtocolSpecificClassfiers: map[bpf.AgentTrafficProtocolT]anc.ClassfierType{
+		bpf.AgentTrafficProtocolTKProtocolRedis: anc.RedisCommand,
+	}}
+	exporter := analysis.NewPrometheusExporter(options, func(pid uint32) string { return "redis-client" })
+	exporter.Observe(redisRecord(t, "$3\r\nbar\r\n"))
+	exporter.Observe(redisRecord(t, "-ERR unknown\r\n"))
+
+	expected := `
+# HELP kyanos_requests_total Number of request-response pairs.
+# TYPE kyanos_requests_total counter
+kyanos_requests_total{class="GET",container="redis-client",local_port="",process="",protocol="Redis",remote_ip="10.0.0.2",remote_port="6379",side="client"} 2
+# HELP kyanos_failed_requests_total Number of request-response pairs whose response indicates a failure.
+# TYPE kyanos_failed_requests_total counter
+kyanos_failed_requests_total{class="GET",container="redis-client",local_port="",process="",protocol="Redis",remote_ip="10.0.0.2",remote_port="6379",side="client"} 1
+`
+	err := testutil.GatherAndCompare(exporter.Registry(), strings.NewReader(expected), "kyanos_requests_total", "kyanos_failed_requests_total")
+	assert.NoError(t, err)
+
+	count, err := testutil.GatherAndCount(exporter.Registry(), "kyanos_request_duration_seconds", "kyanos_network_duration_seconds",
+		"kyanos_internal_duration_seconds", "kyanos_socket_read_duration_seconds")
+	assert.NoError(t, err)
+	// socket read duration is not available and the record is not from the server side
+	assert.Equal(t, 2, count)
+}
+
+func TestPrometheusExporterDropsClientAddress(t *testing.T) {
+	exporter := analysis.NewPrometheusExporter(&anc.AnalysisOptions{}, nil)
+	for i := 0; i < 10; i++ {
+		record := redisRecord(t, "$3\r\nbar\r\n")
+		record.Side = common.ServerSide
+		record.LocalPort = 6379
+		record.RemotePort = common.Port(40000 + i)
+		record.RemoteAddr = net.ParseIP(fmt.Sprintf("10.0.1.%d", i))
+		exporter.Observe(record)
+	}
+
+	expected := `
+# HELP kyanos_requests_total Number of request-response pairs.
+# TYPE kyanos_requests_total counter
+kyanos_requests_total{class="",container="",local_port="6379",process="",protocol="Redis",remote_ip="",remote_port="",side="server"} 10
+`
+	err := testutil.GatherAndCompare(exporter.Registry(), strings.NewReader(expected), "kyanos_requests_total")
+	assert.NoError(t, err)
+}
+
+func TestPrometheusExporterBoundsClasses(t *testing.T) {
+	options := &anc.AnalysisOptions{ProtocolSpecificClassfiers: map[bpf.AgentTrafficProtocolT]anc.ClassfierType{
+		bpf.AgentTrafficProtocolTKProtocolHTTP: anc.HttpPath,
+	}}
+	exporter := analysis.NewPrometheusExporter(options, nil)
+	parser := protocol.GetParserByProtocol(bpf.AgentTrafficProtocolTKProtocolHTTP)
+	parse := func(data string, messageType protocol.MessageType) protocol.ParsedMessage {
+		streamBuffer := buffer.New(1024)
+		streamBuffer.Add(0, []byte(data), 1)
+		result := parser.ParseStream(streamBuffer, messageType)
+		assert.Equal(t, protocol.Success, result.ParseState)
+		return result.ParsedMessages[0]
+	}
+	for i := 0; i < 250; i++ {
+		record := redisRecord(t, "$3\r\nbar\r\n")
+		record.Protocol = uint32(bpf.AgentTrafficProtocolTKProtocolHTTP)
+		record.Req = parse(fmt.Sprintf("GET /users/%d HTTP/1.1\r\nHost: localhost\r\n\r\n", i), protocol.Request)
+		record.Resp = parse("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", protocol.Response)
+		exporter.Observe(record)
+	}
+
+	count, err := testutil.GatherAndCount(exporter.Registry(), "kyanos_requests_total")
+	assert.NoError(t, err)
+	// 200 paths and the overflow class
+	assert.Equal(t, 201, count)
+	metrics, err := exporter.Registry().Gather()
+	assert.NoError(t, err)
+	var other float64
+	for _, family := range metrics {
+		if family.GetName() != "kyanos_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "class" && label.GetValue() == "other" {
+					other += metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	assert.Equal(t, float64(50), other)
+}
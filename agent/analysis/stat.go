@@ -16,6 +16,10 @@ var outputLog *logrus.Logger = logrus.New()
 var traceDevEvent bool
 var traceSocketEvent bool
 
+// kernelTimingUnavailable is set when reading a capture file, there are only
+// syscall events made from the packets.
+var kernelTimingUnavailable bool
+
 type StatRecorder struct {
 }
 
@@ -23,9 +27,21 @@ func InitStatRecorder(options *AgentOptions) *StatRecorder {
 	sr := new(StatRecorder)
 	traceDevEvent = options.WatchOptions.TraceDevEvent
 	traceSocketEvent = options.WatchOptions.TraceSocketEvent
+	kernelTimingUnavailable = options.ReadPcapFile != ""
 	return sr
 }
 
+func markKernelTimingUnavailable(r *analysisCommon.AnnotatedRecord) {
+	r.KernelTimingUnavailable = true
+	r.CopyToSocketBufferDuration = -1
+	if !r.IsMetricAvailable(analysisCommon.ReadFromSocketBufferDuration) {
+		r.ReadFromSocketBufferDuration = -1
+	}
+	if !r.IsMetricAvailable(analysisCommon.BlackBoxDuration) {
+		r.BlackBoxDuration = -1
+	}
+}
+
 func CreateAnnotedRecord() *analysisCommon.AnnotatedRecord {
 	return &analysisCommon.AnnotatedRecord{
 		StartTs:                      0,
@@ -177,6 +193,20 @@ func (s *StatRecorder) ReceiveRecord(r protocol.Record, connection *conn.Connect
 		IsSsl:      connection.IsSsl(),
 	}
 
+	if protocol.IsSyntheticRecord(r) {
+		// there are no syscall events of its own, the events found by its
+		// seq would belong to other messages
+		annotatedRecord.StartTs = r.Req.TimestampNs()
+		annotatedRecord.EndTs = r.Resp.TimestampNs()
+		annotatedRecord.TotalDuration = float64(annotatedRecord.EndTs) - float64(annotatedRecord.StartTs)
+		annotatedRecord.ReqSize = 0
+		annotatedRecord.RespSize = 0
+		if filterByDuration(connection, annotatedRecord) {
+			outputRecord(annotatedRecord, recordsChannel)
+		}
+		return nil
+	}
+
 	events := prepareEvents(r, connection)
 
 	hasNicInEvents := len(events.nicIngressEvents) > 0
@@ -320,6 +350,10 @@ func (s *StatRecorder) ReceiveRecord(r protocol.Record, connection *conn.Connect
 		annotatedRecord.RespNicEventDetails = KernEventsToNicEventDetails(events.nicIngressEvents)
 	}
 
+	if kernelTimingUnavailable {
+		markKernelTimingUnavailable(annotatedRecord)
+	}
+
 	streamEvents.MarkNeedDiscardSeq(events.egressKernSeq+uint64(events.egressKernLen), true)
 	streamEvents.MarkNeedDiscardSeq(events.ingressKernSeq+uint64(events.ingressKernLen), false)
 	if connection.IsSsl() {
@@ -327,6 +361,25 @@ func (s *StatRecorder) ReceiveRecord(r protocol.Record, connection *conn.Connect
 		streamEvents.MarkNeedDiscardSslSeq(events.ingressSeq+uint64(events.ingressMessage.ByteSize()), false)
 	}
 
+	if filterByDuration(connection, annotatedRecord) {
+		outputRecord(annotatedRecord, recordsChannel)
+	}
+	return nil
+}
+
+// filterByDuration applies the message filter of the connection if it
+// depends on the total duration of the record, submitRecord let the record
+// through without it.
+func filterByDuration(connection *conn.Connection4, annotatedRecord *analysisCommon.AnnotatedRecord) bool {
+	durationFilter, ok := connection.MessageFilter.(protocol.DurationFilter)
+	if !ok {
+		return true
+	}
+	r := annotatedRecord.Record
+	return durationFilter.FilterWithDuration(r.Req, r.Resp, annotatedRecord.TotalDuration)
+}
+
+func outputRecord(annotatedRecord *analysisCommon.AnnotatedRecord, recordsChannel chan<- *analysisCommon.AnnotatedRecord) {
 	if recordsChannel == nil {
 		outputLog.Infoln(annotatedRecord.String(analysisCommon.AnnotatedRecordToStringOptions{
 			Nano: false,
@@ -347,7 +400,6 @@ func (s *StatRecorder) ReceiveRecord(r protocol.Record, connection *conn.Connect
 	} else {
 		recordsChannel <- annotatedRecord
 	}
-	return nil
 }
 
 // some syscalls are not nested int ssl events, so we need to check if all ssl events have kernLen>0
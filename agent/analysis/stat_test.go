@@ -0,0 +1,114 @@
+package analysis_test
+
+import (
+	"kyanos/agent/analysis"
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/buffer"
+	ac "kyanos/agent/common"
+	"kyanos/agent/conn"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/filter"
+	"kyanos/agent/protocol/mysql"
+	"kyanos/bpf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newConnection(role bpf.AgentEndpointRoleT, trafficProtocol bpf.AgentTrafficProtocolT) *conn.Connection4 {
+	event := &bpf.AgentConnEvtT{}
+	event.ConnInfo.Role = role
+	event.ConnInfo.Protocol = trafficProtocol
+	return conn.NewConnFromEvent(event, &conn.Processor{})
+}
+
+func addSyscallEvent(connection *conn.Connection4, step bpf.AgentStepT, seq int, length int, ts uint64) {
+	connection.StreamEvents.AddSyscallEvent(&bpf.SyscallEventData{
+		SyscallEvent: bpf.SyscallEvent{Ke: bpf.AgentKernEvt{Ts: ts, Seq: uint32(seq), Len: uint32(length), Step: step}},
+	})
+}
+
+func parseRedisAt(t *testing.T, data string, messageType protocol.MessageType, ts uint64) protocol.ParsedMessage {
+	streamBuffer := buffer.New(1024)
+	streamBuffer.Add(0, []byte(data), ts)
+	result := protocol.GetParserByProtocol(bpf.AgentTrafficProtocolTKProtocolRedis).ParseStream(streamBuffer, messageType)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	return result.ParsedMessages[0]
+}
+
+func TestReceiveRecordFromCaptureFile(t *testing.T) {
+	sr := analysis.InitStatRecorder(&ac.AgentOptions{ReadPcapFile: "capture.pcap"})
+	defer analysis.InitStatRecorder(&ac.AgentOptions{})
+
+	const req, resp = "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "$3\r\nbar\r\n"
+	connection := newConnection(bpf.AgentEndpointRoleTKRoleClient, bpf.AgentTrafficProtocolTKProtocolRedis)
+	addSyscallEvent(connection, bpf.AgentStepTSYSCALL_OUT, 0, len(req), 1000)
+	addSyscallEvent(connection, bpf.AgentStepTSYSCALL_IN, 0, len(resp), 3000)
+	record := protocol.Record{
+		Req:  parseRedisAt(t, req, protocol.Request, 1000),
+		Resp: parseRedisAt(t, resp, protocol.Response, 3000),
+	}
+
+	records := make(chan *anc.AnnotatedRecord, 1)
+	assert.NoError(t, sr.ReceiveRecord(record, connection, records))
+	annotated := <-records
+	assert.True(t, annotated.KernelTimingUnavailable)
+	assert.Equal(t, float64(2000), annotated.TotalDuration)
+	assert.Equal(t, float64(-1), annotated.BlackBoxDuration)
+	assert.Equal(t, float64(-1), annotated.ReadFromSocketBufferDuration)
+	assert.Equal(t, float64(-1), annotated.CopyToSocketBufferDuration)
+	assert.False(t, annotated.IsMetricAvailable(anc.BlackBoxDuration))
+	assert.Contains(t, annotated.TimeDetailInfo(), "[network duration]=n/a")
+	assert.Contains(t, annotated.TimeDetailInfo(), "[read from sockbuf]=n/a")
+}
+
+func TestReceiveTransactionRecord(t *testing.T) {
+	sr := analysis.InitStatRecorder(&ac.AgentOptions{})
+	connection := newConnection(bpf.AgentEndpointRoleTKRoleServer, bpf.AgentTrafficProtocolTKProtocolMySQL)
+	// the handshake starts at seq 0 of both directions
+	addSyscallEvent(connection, bpf.AgentStepTSYSCALL_OUT, 0, 78, 5)
+	addSyscallEvent(connection, bpf.AgentStepTSYSCALL_IN, 0, 64, 8)
+	record := protocol.Record{
+		Req:            &mysql.Transaction{FrameBase: protocol.NewFrameBase(2000, 0, 0), Statements: 3, Outcome: mysql.TransactionCommit},
+		Resp:           &mysql.TransactionEnd{FrameBase: protocol.NewFrameBase(6500, 0, 0), Outcome: mysql.TransactionCommit},
+		ResponseStatus: protocol.SuccessStatus,
+	}
+
+	records := make(chan *anc.AnnotatedRecord, 1)
+	assert.NoError(t, sr.ReceiveRecord(record, connection, records))
+	annotated := <-records
+	assert.Equal(t, uint64(2000), annotated.StartTs)
+	assert.Equal(t, uint64(6500), annotated.EndTs)
+	assert.Equal(t, float64(4500), annotated.TotalDuration)
+	assert.Empty(t, annotated.ReqSyscallEventDetails)
+	assert.Empty(t, annotated.RespSyscallEventDetails)
+	assert.Equal(t, 0, annotated.ReqSize)
+}
+
+func TestReceiveRecordFiltersByTotalDuration(t *testing.T) {
+	sr := analysis.InitStatRecorder(&ac.AgentOptions{})
+	const req, resp = "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "$3\r\nbar\r\n"
+	receive := func(expression string) *anc.AnnotatedRecord {
+		connection := newConnection(bpf.AgentEndpointRoleTKRoleClient, bpf.AgentTrafficProtocolTKProtocolRedis)
+		f, err := filter.New(expression, nil)
+		assert.NoError(t, err)
+		connection.MessageFilter = f
+		// the messages are 500ns apart but the syscalls 8us
+		addSyscallEvent(connection, bpf.AgentStepTSYSCALL_OUT, 0, len(req), 1000)
+		addSyscallEvent(connection, bpf.AgentStepTSYSCALL_IN, 0, len(resp), 9000)
+		record := protocol.Record{
+			Req:  parseRedisAt(t, req, protocol.Request, 1000),
+			Resp: parseRedisAt(t, resp, protocol.Response, 1500),
+		}
+		records := make(chan *anc.AnnotatedRecord, 1)
+		assert.NoError(t, sr.ReceiveRecord(record, connection, records))
+		close(records)
+		return <-records
+	}
+
+	annotated := receive(`latency > 5us`)
+	if assert.NotNil(t, annotated) {
+		assert.Equal(t, float64(8000), annotated.TotalDuration)
+	}
+	assert.Nil(t, receive(`latency < 1us`))
+}
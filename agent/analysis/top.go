@@ -0,0 +1,224 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+)
+
+type TopGroupBy int
+
+const (
+	TopByProcess TopGroupBy = iota
+	TopByContainer
+)
+
+type topKey struct {
+	name     string
+	protocol bpf.AgentTrafficProtocolT
+}
+
+// TopStat aggregates the records of a process or a container speaking one
+// protocol during one refresh interval.
+type TopStat struct {
+	Name     string
+	Pid      uint32
+	Protocol bpf.AgentTrafficProtocolT
+	// requests sent by the process as a client, a slow or failing dependency
+	// shows here
+	OutRequests int
+	OutFailed   int
+	OutLatency  *PercentileCalculator
+	// requests served by the process, a slow or failing handler shows here
+	InRequests int
+	InFailed   int
+	InLatency  *PercentileCalculator
+	BytesIn    int64
+	BytesOut   int64
+	// connections which had requests during the interval, idle connections
+	// aren't counted
+	ActiveConns int
+
+	connIds map[string]struct{}
+}
+
+func newTopStat(name string, pid uint32, p bpf.AgentTrafficProtocolT) *TopStat {
+	return &TopStat{
+		Name:       name,
+		Pid:        pid,
+		Protocol:   p,
+		OutLatency: NewPercentileCalculator(),
+		InLatency:  NewPercentileCalculator(),
+		connIds:    make(map[string]struct{}),
+	}
+}
+
+func (s *TopStat) receive(record *anc.AnnotatedRecord) {
+	reqSize, respSize := int64(max(record.ReqSize, 0)), int64(max(record.RespSize, 0))
+	failed := false
+	if status, ok := record.Response().(protocol.StatusfulMessage); ok && status.Status() == protocol.FailStatus {
+		failed = true
+	}
+	latency := s.OutLatency
+	if record.Side == common.ServerSide {
+		latency = s.InLatency
+		s.InRequests++
+		if failed {
+			s.InFailed++
+		}
+		s.BytesIn += reqSize
+		s.BytesOut += respSize
+	} else {
+		s.OutRequests++
+		if failed {
+			s.OutFailed++
+		}
+		s.BytesOut += reqSize
+		s.BytesIn += respSize
+	}
+	if record.TotalDuration >= 0 {
+		latency.AddValue(record.TotalDuration / 1e6)
+	}
+	connId := record.ConnDesc.Identity()
+	if _, ok := s.connIds[connId]; !ok {
+		s.connIds[connId] = struct{}{}
+		s.ActiveConns++
+	}
+}
+
+func (s *TopStat) Requests() int {
+	return s.InRequests + s.OutRequests
+}
+
+// OutErrorRate is the percentage of failed requests sent by the process.
+func (s *TopStat) OutErrorRate() float64 {
+	return errorRate(s.OutFailed, s.OutRequests)
+}
+
+// InErrorRate is the percentage of failed requests served by the process.
+func (s *TopStat) InErrorRate() float64 {
+	return errorRate(s.InFailed, s.InRequests)
+}
+
+func errorRate(failed int, requests int) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(failed) * 100 / float64(requests)
+}
+
+// TopSnapshot holds the stats of one refresh interval grouped both ways, so
+// the render can switch between them without waiting for the next interval.
+type TopSnapshot struct {
+	Interval    time.Duration
+	ByProcess   []*TopStat
+	ByContainer []*TopStat
+}
+
+func (s *TopSnapshot) Stats(groupBy TopGroupBy) []*TopStat {
+	if groupBy == TopByContainer {
+		return s.ByContainer
+	}
+	return s.ByProcess
+}
+
+// PerSecond converts a count of the interval to a rate.
+func (s *TopSnapshot) PerSecond(value float64) float64 {
+	return value / s.Interval.Seconds()
+}
+
+type TopAnalyzer struct {
+	recordsChannel    <-chan *anc.AnnotatedRecord
+	resultChannel     chan<- *TopSnapshot
+	interval          time.Duration
+	containerResolver func(pid uint32) string
+	processNames      common.ProcessNameCache
+
+	start       time.Time
+	byProcess   map[topKey]*TopStat
+	byContainer map[topKey]*TopStat
+}
+
+// CreateTopAnalyzer creates an analyzer sending a snapshot to resultChannel
+// every interval, containerResolver returns the container name of a process
+// and may be nil.
+func CreateTopAnalyzer(recordsChannel <-chan *anc.AnnotatedRecord, resultChannel chan<- *TopSnapshot,
+	interval time.Duration, containerResolver func(pid uint32) string) *TopAnalyzer {
+	t := &TopAnalyzer{
+		recordsChannel:    recordsChannel,
+		resultChannel:     resultChannel,
+		interval:          interval,
+		containerResolver: containerResolver,
+		processNames:      make(common.ProcessNameCache),
+	}
+	t.reset(time.Now())
+	return t
+}
+
+func (t *TopAnalyzer) reset(now time.Time) {
+	t.start = now
+	t.byProcess = make(map[topKey]*TopStat)
+	t.byContainer = make(map[topKey]*TopStat)
+}
+
+func (t *TopAnalyzer) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-t.recordsChannel:
+			t.Receive(record)
+		case now := <-ticker.C:
+			select {
+			case t.resultChannel <- t.Harvest(now):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (t *TopAnalyzer) Receive(record *anc.AnnotatedRecord) {
+	p := bpf.AgentTrafficProtocolT(record.Protocol)
+	processName := fmt.Sprintf("%d %s", record.Pid, t.processNames.Get(record.Pid))
+	t.stat(t.byProcess, processName, record.Pid, p).receive(record)
+
+	containerName := ""
+	if t.containerResolver != nil {
+		containerName = t.containerResolver(record.Pid)
+	}
+	if containerName == "" {
+		containerName = "(host)"
+	}
+	t.stat(t.byContainer, containerName, 0, p).receive(record)
+}
+
+func (t *TopAnalyzer) stat(stats map[topKey]*TopStat, name string, pid uint32, p bpf.AgentTrafficProtocolT) *TopStat {
+	key := topKey{name, p}
+	stat, ok := stats[key]
+	if !ok {
+		stat = newTopStat(name, pid, p)
+		stats[key] = stat
+	}
+	return stat
+}
+
+// Harvest returns the stats since the last harvest and starts a new interval.
+func (t *TopAnalyzer) Harvest(now time.Time) *TopSnapshot {
+	snapshot := &TopSnapshot{Interval: now.Sub(t.start)}
+	for _, stat := range t.byProcess {
+		snapshot.ByProcess = append(snapshot.ByProcess, stat)
+	}
+	for _, stat := range t.byContainer {
+		snapshot.ByContainer = append(snapshot.ByContainer, stat)
+	}
+	t.reset(now)
+	return snapshot
+}
@@ -0,0 +1,45 @@
+package analysis_test
+
+import (
+	"kyanos/agent/analysis"
+	"kyanos/bpf"
+	"kyanos/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopAnalyzerHarvest(t *testing.T) {
+	topAnalyzer := analysis.CreateTopAnalyzer(nil, nil, time.Second, nil)
+	start := time.Now()
+	topAnalyzer.Receive(redisRecord(t, "$3\r\nbar\r\n"))
+	topAnalyzer.Receive(redisRecord(t, "-ERR unknown\r\n"))
+	served := redisRecord(t, "$3\r\nbar\r\n")
+	served.ConnDesc.Side = common.ServerSide
+	served.ConnDesc.LocalPort = 6379
+	served.ConnDesc.RemotePort = 40000
+	// a slow handler doesn't show in the latency of the dependencies
+	served.TotalDuration = 40e6
+	topAnalyzer.Receive(served)
+
+	snapshot := topAnalyzer.Harvest(start.Add(2 * time.Second))
+	assert.Len(t, snapshot.ByProcess, 1)
+	assert.Len(t, snapshot.ByContainer, 1)
+	stat := snapshot.ByContainer[0]
+	assert.Equal(t, "(host)", stat.Name)
+	assert.Equal(t, bpf.AgentTrafficProtocolTKProtocolRedis, stat.Protocol)
+	assert.Equal(t, 2, stat.OutRequests)
+	assert.Equal(t, 1, stat.InRequests)
+	assert.InDelta(t, 50.0, stat.OutErrorRate(), 0.01)
+	assert.Equal(t, 0.0, stat.InErrorRate())
+	assert.Equal(t, int64(22*2+9), stat.BytesOut)
+	assert.Equal(t, int64(9*2+22), stat.BytesIn)
+	assert.Equal(t, 2, stat.ActiveConns)
+	assert.InDelta(t, 2.0, stat.OutLatency.CalculatePercentile(0.99), 0.1)
+	assert.Greater(t, stat.InLatency.CalculatePercentile(0.99), 32.0)
+	assert.InDelta(t, 1.5, snapshot.PerSecond(float64(stat.Requests())), 0.01)
+
+	snapshot = topAnalyzer.Harvest(start.Add(4 * time.Second))
+	assert.Empty(t, snapshot.ByProcess)
+}
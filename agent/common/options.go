@@ -8,12 +8,14 @@ import (
 	"kyanos/agent/compatible"
 	"kyanos/agent/metadata"
 	"kyanos/agent/protocol"
+	"kyanos/agent/recorder"
 	"kyanos/agent/render/watch"
 	"kyanos/bpf"
 	"kyanos/common"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 )
 
 type LoadBpfProgramFunction func() *list.List
@@ -31,6 +33,7 @@ type AgentOptions struct {
 	CustomConnEventHook    bpf.ConnEventHook
 	CustomKernEventHook    bpf.KernEventHook
 	CustomSslEventHook     bpf.SslEventHook
+	CustomFirstPacketHook  bpf.FirstPacketEventHook
 	InitCompletedHook      InitCompletedHook
 	ConnManagerInitHook    ConnManagerInitHook
 	LoadBpfProgramFunction LoadBpfProgramFunction
@@ -50,6 +53,28 @@ type AgentOptions struct {
 	ConntrackCloseWaitTimeMills int
 	MaxAllowStuckTimeMills      int
 	StartGopsServer             bool
+	// write the events read from perf buffers to this file
+	RecordEventsFile string
+	// read events from this file instead of loading BPF programs
+	ReplayFile  string
+	ReplaySpeed float64
+	// read packets from this pcap or pcapng file instead of loading BPF programs
+	ReadPcapFile string
+	// expose metrics on this address instead of rendering the records
+	PrometheusListen string
+	// render per process request rates refreshed every TopInterval instead
+	// of the records
+	TopEnable      bool
+	TopInterval    time.Duration
+	TopByContainer bool
+	// write the dependency graph in this format after collecting records for
+	// GraphDuration instead of rendering the statistics
+	GraphFormat   string
+	GraphOutput   string
+	GraphDuration time.Duration
+	// keep the records in memory and dump them when triggered instead of
+	// rendering them
+	FlightRecorderOptions recorder.Options
 
 	FilterComm              string
 	ProcessExecEventChannel chan *bpf.AgentProcessExecEvent
@@ -78,6 +103,22 @@ func (o AgentOptions) FilterByContainer() bool {
 	return o.ContainerId != "" || o.ContainerName != "" || o.PodName != ""
 }
 
+func (o AgentOptions) IsReplay() bool {
+	return o.ReplayFile != ""
+}
+
+// IsOffline reports whether events come from a file rather than the kernel.
+func (o AgentOptions) IsOffline() bool {
+	return o.IsReplay() || o.ReadPcapFile != ""
+}
+
+func (o AgentOptions) UseTui() bool {
+	if o.PrometheusListen != "" || o.GraphFormat != "" || o.FlightRecorderOptions.Enabled() {
+		return false
+	}
+	return o.TopEnable || o.WatchOptions.UseTui()
+}
+
 func (o AgentOptions) FilterByK8s() bool {
 	return o.PodName != ""
 }
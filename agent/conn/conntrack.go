@@ -26,6 +26,32 @@ var OnCloseRecordFunc func(*Connection4) error
 
 var ConnectionMap *sync.Map = new(sync.Map)
 
+// UseEventClock is set when the events are replayed from a file, their
+// timestamps are far behind the wall clock, so the timestamp of the latest
+// event is used to check whether the parsing progress is stuck.
+var UseEventClock bool
+var eventClockMills atomic.Int64
+
+func currentTimeMills() int64 {
+	if UseEventClock {
+		return eventClockMills.Load()
+	}
+	return time.Now().UnixMilli()
+}
+
+func advanceEventClock(tsNano uint64) {
+	if !UseEventClock {
+		return
+	}
+	mills := int64(common.NanoToMills(tsNano))
+	for {
+		cur := eventClockMills.Load()
+		if mills <= cur || eventClockMills.CompareAndSwap(cur, mills) {
+			return
+		}
+	}
+}
+
 type Connection4 struct {
 	LocalIp    net.IP
 	RemoteIp   net.IP
@@ -315,7 +341,8 @@ func (c *Connection4) extractSockKeys() (bpf.AgentSockKey, bpf.AgentSockKey) {
 func (c *Connection4) OnClose(needClearBpfMap bool) {
 	OnCloseRecordFunc(c)
 	c.Status = Closed
-	if needClearBpfMap {
+	// bpf.Objs is nil when replaying recorded events
+	if needClearBpfMap && bpf.Objs != nil {
 		var err error
 		// connInfoMap := bpf.GetMapFromObjs(bpf.Objs, "ConnInfoMap")
 		// err = connInfoMap.Delete(c.TgidFd)
@@ -337,6 +364,9 @@ func (c *Connection4) UpdateConnectionTraceable(traceableState bpf.AgentConnTrac
 		return
 	}
 	c.tracable = traceableState
+	if bpf.Objs == nil {
+		return
+	}
 	key, _ := c.extractSockKeys()
 	sockKeyConnIdMap := bpf.GetMapFromObjs(bpf.Objs, "SockKeyConnIdMap")
 	c.doUpdateConnIdMapProtocolToUnknwon(key, sockKeyConnIdMap, traceableState)
@@ -501,12 +531,7 @@ func (c *Connection4) OnSslDataEvent(data []byte, event *bpf.SslData, recordChan
 		return
 	}
 
-	records := parser.Match(c.ReqQueue, c.RespQueue)
-	if len(records) != 0 {
-		for _, record := range records {
-			recordChannel <- RecordWithConn{record, c}
-		}
-	}
+	c.matchRecords(parser, &event.SslEventHeader.Ke, recordChannel)
 }
 
 func isSyscallFunctionMultiMessage(f bpf.AgentSourceFunctionT) bool {
@@ -567,13 +592,45 @@ func (c *Connection4) OnSyscallEvent(data []byte, event *bpf.SyscallEventData, r
 		return true
 	}
 
+	c.matchRecords(parser, &event.SyscallEvent.Ke, recordChannel)
+	return true
+}
+
+// upgradedProtocols are the protocols an HTTP/1.1 connection switches to
+// with 101 Switching Protocols, by the Upgrade header in lower case.
+var upgradedProtocols = map[string]bpf.AgentTrafficProtocolT{
+	"websocket": bpf.AgentTrafficProtocolTKProtocolWebSocket,
+}
+
+// matchRecords sends the records of the parsed messages. When a request is
+// answered with 101 Switching Protocols, the connection switches to the
+// parser of the new protocol and the data left in the buffers after the
+// upgrade is parsed again.
+func (c *Connection4) matchRecords(parser protocol.ProtocolStreamParser, ke *bpf.AgentKernEvt, recordChannel chan RecordWithConn) {
 	records := parser.Match(c.ReqQueue, c.RespQueue)
-	if len(records) != 0 {
-		for _, record := range records {
-			recordChannel <- RecordWithConn{record, c}
+	upgraded := false
+	for _, record := range records {
+		recordChannel <- RecordWithConn{record, c}
+		if resp, ok := record.Response().(*protocol.ParsedHttpResponse); ok && resp.Upgrade != "" {
+			if p, ok := upgradedProtocols[resp.Upgrade]; ok {
+				c.Protocol = p
+				upgraded = true
+			}
 		}
 	}
-	return true
+	if !upgraded {
+		return
+	}
+	if common.ConntrackLog.Level >= logrus.DebugLevel {
+		common.ConntrackLog.Debugf("[protocol-upgrade][%s] protocol upgraded: %d", c.ToString(), c.Protocol)
+	}
+	c.ReqQueue = make(map[protocol.StreamId]*protocol.ParsedMessageQueue)
+	c.RespQueue = make(map[protocol.StreamId]*protocol.ParsedMessageQueue)
+	c.parseStreamBuffer(c.reqStreamBuffer, protocol.Request, c.ReqQueue, ke)
+	c.parseStreamBuffer(c.respStreamBuffer, protocol.Response, c.RespQueue, ke)
+	if parser := c.GetProtocolParser(c.Protocol); parser != nil {
+		c.matchRecords(parser, ke, recordChannel)
+	}
 }
 
 func (c *Connection4) parseStreamBuffer(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, resultQueue map[protocol.StreamId]*protocol.ParsedMessageQueue, ke *bpf.AgentKernEvt) {
@@ -697,9 +754,9 @@ func (c *Connection4) parseStreamBuffer(streamBuffer *buffer.StreamBuffer, messa
 }
 func (c *Connection4) updateProgressTime(sb *buffer.StreamBuffer) {
 	if c.reqStreamBuffer == sb {
-		c.lastReqMadeProgressTime = time.Now().UnixMilli()
+		c.lastReqMadeProgressTime = currentTimeMills()
 	} else {
-		c.lastRespMadeProgressTime = time.Now().UnixMilli()
+		c.lastRespMadeProgressTime = currentTimeMills()
 	}
 	// common.ConntrackLog.Debugf("%s update progress time to %v", c.ToString(), time.Now())
 }
@@ -717,7 +774,7 @@ func (c *Connection4) progressIsStucked(sb *buffer.StreamBuffer) bool {
 		return false
 	}
 	headTime, ok := sb.FindTimestampBySeq(uint64(sb.Position0()))
-	stuckDuration := time.Now().UnixMilli() - int64(common.NanoToMills(headTime))
+	stuckDuration := currentTimeMills() - int64(common.NanoToMills(headTime))
 	if !ok || stuckDuration > int64(ac.Options.MaxAllowStuckTimeMills) {
 		return true
 	}
@@ -732,7 +789,7 @@ func (c *Connection4) checkProgress(sb *buffer.StreamBuffer) bool {
 		return false
 	}
 	headTime, ok := sb.FindTimestampBySeq(uint64(sb.Position0()))
-	now := time.Now().UnixMilli()
+	now := currentTimeMills()
 	headTimeMills := int64(common.NanoToMills(headTime))
 	if !ok || now-headTimeMills > int64(ac.Options.MaxAllowStuckTimeMills) {
 		sb.RemoveHead()
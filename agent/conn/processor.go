@@ -318,6 +318,10 @@ func (p *Processor) run() {
 }
 
 func (p *Processor) handleFirstPacketEvent(event *agentKernEvtWithConn, recordChannel chan RecordWithConn) {
+	if UseEventClock && p.tempFirstPacketEvents.IsFull() {
+		oldest, _ := p.tempFirstPacketEvents.Read()
+		p.processFirstPacketEvent(oldest.(TimedFirstPacketEvent).event, recordChannel)
+	}
 	// Add event to the temporary queue
 	p.tempFirstPacketEvents.Write(TimedFirstPacketEvent{event: event, timestamp: time.Now()})
 	// Process events in the queue that have been there for more than 100ms
@@ -353,6 +357,10 @@ func (p *Processor) processFirstPacketEvent(event *agentKernEvtWithConn, recordC
 }
 
 func (p *Processor) handleKernEvent(event *bpf.AgentKernEvt, recordChannel chan RecordWithConn) {
+	if UseEventClock && p.tempKernEvents.IsFull() {
+		oldest, _ := p.tempKernEvents.Read()
+		p.processKernEvent(oldest.(TimedEvent).event, recordChannel)
+	}
 	// Add event to the temporary queue
 	p.tempKernEvents.Write(TimedEvent{event: event, timestamp: time.Now()})
 
@@ -431,6 +439,13 @@ func (p *Processor) processKernEvent(event *bpf.AgentKernEvt, recordChannel chan
 }
 
 func (p *Processor) handleSyscallEvent(event *bpf.SyscallEventData, recordChannel chan RecordWithConn) {
+	// Events read from a file arrive faster than they expire, the oldest event
+	// is processed in advance instead of dropping the new one when the queue
+	// is full.
+	if UseEventClock && p.tempSyscallEvents.IsFull() {
+		oldest, _ := p.tempSyscallEvents.Read()
+		p.processSyscallEvent(oldest.(TimedSyscallEvent).event, recordChannel)
+	}
 	// Add event to the temporary queue
 	p.tempSyscallEvents.Write(TimedSyscallEvent{event: event, timestamp: time.Now()})
 
@@ -463,6 +478,7 @@ func (p *Processor) processOldSyscallEvents(recordChannel chan RecordWithConn) {
 func (p *Processor) processSyscallEvent(event *bpf.SyscallEventData, recordChannel chan RecordWithConn) {
 	tgidFd := event.SyscallEvent.Ke.ConnIdS.TgidFd
 	event.SyscallEvent.Ke.Ts += common.LaunchEpochTime
+	advanceEventClock(event.SyscallEvent.GetEndTs())
 	conn := p.connManager.LookupConnection4ByTimestamp(tgidFd, event.SyscallEvent.GetEndTs())
 
 	timeCheck := conn != nil && conn.timeBoundCheck(event.SyscallEvent.GetEndTs())
@@ -502,6 +518,10 @@ func (p *Processor) processSyscallEvent(event *bpf.SyscallEventData, recordChann
 }
 
 func (p *Processor) handleSslEvent(event *bpf.SslData, recordChannel chan RecordWithConn) {
+	if UseEventClock && p.tempSslEvents.IsFull() {
+		oldest, _ := p.tempSslEvents.Read()
+		p.processSslEvent(oldest.(TimedSslEvent).event, recordChannel)
+	}
 	// Add event to the temporary queue
 	p.tempSslEvents.Write(TimedSslEvent{event: event, timestamp: time.Now()})
 
@@ -533,6 +553,7 @@ func (p *Processor) processOldSslEvents(recordChannel chan RecordWithConn) {
 func (p *Processor) processSslEvent(event *bpf.SslData, recordChannel chan RecordWithConn) {
 	tgidFd := event.SslEventHeader.Ke.ConnIdS.TgidFd
 	event.SslEventHeader.Ke.Ts += common.LaunchEpochTime
+	advanceEventClock(event.SslEventHeader.GetEndTs())
 	conn := p.connManager.LookupConnection4ByTimestamp(tgidFd, event.SslEventHeader.GetEndTs())
 	if conn == nil {
 		if common.BPFEventLog.Level >= logrus.DebugLevel {
@@ -64,7 +64,10 @@ func submitRecord(record protocol.Record, c *Connection4) {
 		if c.MessageFilter.FilterByResponse() {
 			parsedResponse = record.Response()
 		}
-		if parsedRequest != nil || parsedResponse != nil {
+		if _, ok := c.MessageFilter.(protocol.DurationFilter); ok {
+			// filtered once the record is analyzed
+			needSubmit = true
+		} else if parsedRequest != nil || parsedResponse != nil {
 			needSubmit = c.MessageFilter.Filter(parsedRequest, parsedResponse)
 		} else {
 			needSubmit = true
@@ -0,0 +1,204 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	kEtherTypeIPv4  = 0x0800
+	kEtherTypeIPv6  = 0x86dd
+	kEtherTypeVLAN  = 0x8100
+	kEtherTypeQinQ  = 0x88a8
+	kIPProtocolTCP  = 6
+	kIPv6HopByHop   = 0
+	kIPv6Routing    = 43
+	kIPv6Fragment   = 44
+	kIPv6DestOption = 60
+)
+
+const (
+	TcpFlagFin uint8 = 0x01
+	TcpFlagSyn uint8 = 0x02
+	TcpFlagRst uint8 = 0x04
+	TcpFlagAck uint8 = 0x10
+)
+
+// errNotTcp is returned for packets other than unfragmented TCP over IP,
+// they are skipped silently.
+var errNotTcp = errors.New("not a tcp packet")
+
+// TcpSegment is a decoded TCP segment, Payload refers to the packet data.
+type TcpSegment struct {
+	Timestamp uint64
+	SrcIp     net.IP
+	DstIp     net.IP
+	SrcPort   uint16
+	DstPort   uint16
+	Seq       uint32
+	Flags     uint8
+	Payload   []byte
+}
+
+func (s *TcpSegment) HasFlag(flag uint8) bool {
+	return s.Flags&flag != 0
+}
+
+// DecodeTcpSegment decodes the link layer, IP and TCP headers of the packet.
+func DecodeTcpSegment(packet *RawPacket) (*TcpSegment, error) {
+	etherType, ipPacket, err := decodeLinkLayer(packet.LinkType, packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	segment := &TcpSegment{Timestamp: packet.Timestamp}
+	var tcpPacket []byte
+	switch etherType {
+	case kEtherTypeIPv4:
+		tcpPacket, err = decodeIPv4(ipPacket, segment)
+	case kEtherTypeIPv6:
+		tcpPacket, err = decodeIPv6(ipPacket, segment)
+	default:
+		return nil, errNotTcp
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeTcp(tcpPacket, segment); err != nil {
+		return nil, err
+	}
+	return segment, nil
+}
+
+// decodeLinkLayer returns the ether type of the network layer and the network layer packet.
+func decodeLinkLayer(linkType LinkType, data []byte) (uint16, []byte, error) {
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return 0, nil, errNotTcp
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for etherType == kEtherTypeVLAN || etherType == kEtherTypeQinQ {
+			if len(data) < 4 {
+				return 0, nil, errNotTcp
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return etherType, data, nil
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return 0, nil, errNotTcp
+		}
+		return binary.BigEndian.Uint16(data[14:16]), data[16:], nil
+	case LinkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return 0, nil, errNotTcp
+		}
+		return binary.BigEndian.Uint16(data[0:2]), data[20:], nil
+	case LinkTypeNull, LinkTypeLoop:
+		if len(data) < 4 {
+			return 0, nil, errNotTcp
+		}
+		// the address family is in the byte order of the capturing host,
+		// AF_INET is 2 everywhere while AF_INET6 differs between systems
+		family := binary.LittleEndian.Uint32(data[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data[0:4])
+		}
+		if family == 2 {
+			return kEtherTypeIPv4, data[4:], nil
+		} else if family == 10 || family == 24 || family == 28 || family == 30 {
+			return kEtherTypeIPv6, data[4:], nil
+		}
+		return 0, nil, errNotTcp
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		if len(data) < 1 {
+			return 0, nil, errNotTcp
+		}
+		switch data[0] >> 4 {
+		case 4:
+			return kEtherTypeIPv4, data, nil
+		case 6:
+			return kEtherTypeIPv6, data, nil
+		}
+		return 0, nil, errNotTcp
+	default:
+		return 0, nil, errNotTcp
+	}
+}
+
+func decodeIPv4(data []byte, segment *TcpSegment) ([]byte, error) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return nil, errNotTcp
+	}
+	headerLength := int(data[0]&0x0f) * 4
+	totalLength := int(binary.BigEndian.Uint16(data[2:4]))
+	fragment := binary.BigEndian.Uint16(data[6:8])
+	// more fragments or a non-zero fragment offset
+	if fragment&0x3fff != 0 || data[9] != kIPProtocolTCP {
+		return nil, errNotTcp
+	}
+	if headerLength < 20 || totalLength < headerLength {
+		return nil, errNotTcp
+	}
+	// ethernet frames may be padded, and the packet may be truncated by the snaplen
+	if totalLength < len(data) {
+		data = data[:totalLength]
+	}
+	if headerLength > len(data) {
+		return nil, errNotTcp
+	}
+	segment.SrcIp = net.IP(data[12:16]).To4()
+	segment.DstIp = net.IP(data[16:20]).To4()
+	return data[headerLength:], nil
+}
+
+func decodeIPv6(data []byte, segment *TcpSegment) ([]byte, error) {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return nil, errNotTcp
+	}
+	payloadLength := int(binary.BigEndian.Uint16(data[4:6]))
+	nextHeader := data[6]
+	segment.SrcIp = net.IP(data[8:24])
+	segment.DstIp = net.IP(data[24:40])
+	data = data[40:]
+	if payloadLength < len(data) {
+		data = data[:payloadLength]
+	}
+	for nextHeader != kIPProtocolTCP {
+		switch nextHeader {
+		case kIPv6HopByHop, kIPv6Routing, kIPv6DestOption:
+			if len(data) < 8 {
+				return nil, errNotTcp
+			}
+			length := (int(data[1]) + 1) * 8
+			if length > len(data) {
+				return nil, errNotTcp
+			}
+			nextHeader = data[0]
+			data = data[length:]
+		default:
+			// fragments are not reassembled
+			return nil, errNotTcp
+		}
+	}
+	return data, nil
+}
+
+func decodeTcp(data []byte, segment *TcpSegment) error {
+	if len(data) < 20 {
+		return errNotTcp
+	}
+	headerLength := int(data[12]>>4) * 4
+	if headerLength < 20 || headerLength > len(data) {
+		return errNotTcp
+	}
+	segment.SrcPort = binary.BigEndian.Uint16(data[0:2])
+	segment.DstPort = binary.BigEndian.Uint16(data[2:4])
+	segment.Seq = binary.BigEndian.Uint32(data[4:8])
+	segment.Flags = data[13]
+	segment.Payload = data[headerLength:]
+	return nil
+}
@@ -0,0 +1,85 @@
+package pcap
+
+import (
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+)
+
+// inferenceOrder follows the order in which the kernel tries protocols,
+// parsers with loose formats come last.
+var inferenceOrder = []bpf.AgentTrafficProtocolT{
+	bpf.AgentTrafficProtocolTKProtocolHTTP,
+	bpf.AgentTrafficProtocolTKProtocolHTTP2,
+	bpf.AgentTrafficProtocolTKProtocolMongo,
+	bpf.AgentTrafficProtocolTKProtocolMySQL,
+	bpf.AgentTrafficProtocolTKProtocolPGSQL,
+	bpf.AgentTrafficProtocolTKProtocolCQL,
+	bpf.AgentTrafficProtocolTKProtocolRocketMQ,
+	bpf.AgentTrafficProtocolTKProtocolDubbo,
+	bpf.AgentTrafficProtocolTKProtocolThrift,
+	bpf.AgentTrafficProtocolTKProtocolAMQP,
+	bpf.AgentTrafficProtocolTKProtocolNATS,
+	bpf.AgentTrafficProtocolTKProtocolMemcached,
+	bpf.AgentTrafficProtocolTKProtocolMQTT,
+	bpf.AgentTrafficProtocolTKProtocolKafka,
+	bpf.AgentTrafficProtocolTKProtocolZooKeeper,
+	bpf.AgentTrafficProtocolTKProtocolRedis,
+}
+
+// wellKnownPorts are tried first, they also decide which side is the server
+// when the handshake is not captured.
+var wellKnownPorts = map[uint16]bpf.AgentTrafficProtocolT{
+	80:    bpf.AgentTrafficProtocolTKProtocolHTTP,
+	1883:  bpf.AgentTrafficProtocolTKProtocolMQTT,
+	2181:  bpf.AgentTrafficProtocolTKProtocolZooKeeper,
+	8080:  bpf.AgentTrafficProtocolTKProtocolHTTP,
+	3306:  bpf.AgentTrafficProtocolTKProtocolMySQL,
+	4222:  bpf.AgentTrafficProtocolTKProtocolNATS,
+	5432:  bpf.AgentTrafficProtocolTKProtocolPGSQL,
+	5672:  bpf.AgentTrafficProtocolTKProtocolAMQP,
+	6379:  bpf.AgentTrafficProtocolTKProtocolRedis,
+	9042:  bpf.AgentTrafficProtocolTKProtocolCQL,
+	9092:  bpf.AgentTrafficProtocolTKProtocolKafka,
+	9090:  bpf.AgentTrafficProtocolTKProtocolThrift,
+	9876:  bpf.AgentTrafficProtocolTKProtocolRocketMQ,
+	10911: bpf.AgentTrafficProtocolTKProtocolRocketMQ,
+	11211: bpf.AgentTrafficProtocolTKProtocolMemcached,
+	20880: bpf.AgentTrafficProtocolTKProtocolDubbo,
+	27017: bpf.AgentTrafficProtocolTKProtocolMongo,
+}
+
+// inferProtocol returns the first protocol whose parser parses a complete
+// message of the given type from data, or KProtocolUnset if none does.
+// Only traceProtocol is tried unless it is KProtocolUnset.
+func inferProtocol(data []byte, messageType protocol.MessageType, serverPort uint16, traceProtocol bpf.AgentTrafficProtocolT) bpf.AgentTrafficProtocolT {
+	candidates := inferenceOrder
+	if traceProtocol != bpf.AgentTrafficProtocolTKProtocolUnset {
+		candidates = []bpf.AgentTrafficProtocolT{traceProtocol}
+	} else if hint, ok := wellKnownPorts[serverPort]; ok {
+		candidates = append([]bpf.AgentTrafficProtocolT{hint}, inferenceOrder...)
+	}
+	for _, candidate := range candidates {
+		if canParse(candidate, data, messageType) {
+			return candidate
+		}
+	}
+	return bpf.AgentTrafficProtocolTKProtocolUnset
+}
+
+func canParse(p bpf.AgentTrafficProtocolT, data []byte, messageType protocol.MessageType) (ok bool) {
+	parser := protocol.GetParserByProtocol(p)
+	if parser == nil {
+		return false
+	}
+	// parsers expect data of their own protocol and may panic on anything else
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	streamBuffer := buffer.New(1024 * 1024)
+	streamBuffer.Add(0, data, 1)
+	result := parser.ParseStream(streamBuffer, messageType)
+	return result.ParseState == protocol.Success && len(result.ParsedMessages) > 0
+}
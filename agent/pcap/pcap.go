@@ -0,0 +1,54 @@
+package pcap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"kyanos/bpf"
+	"kyanos/common"
+)
+
+// ReplayFile reads the TCP segments of a pcap or pcapng file and feeds them
+// to the channels as the connection and syscall events of the endpoint
+// selected by side, the same way the Pull*Events functions do. Timestamps of
+// the events are nanoseconds since the Unix epoch. Packets are fed as fast as
+// the channels accept them.
+func ReplayFile(ctx context.Context, path string, side common.SideEnum, traceProtocol bpf.AgentTrafficProtocolT,
+	syscallChannels []chan *bpf.SyscallEventData, connChannels []chan *bpf.AgentConnEvtT) error {
+	reader, closer, err := OpenFile(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	tracker := NewTracker(side, traceProtocol, func(evt *bpf.AgentConnEvtT) {
+		tgidFd := uint64(evt.ConnInfo.ConnId.Upid.Pid)<<32 | uint64(evt.ConnInfo.ConnId.Fd)
+		connChannels[int(tgidFd)%len(connChannels)] <- evt
+	}, func(evt *bpf.SyscallEventData) {
+		tgidFd := evt.SyscallEvent.Ke.ConnIdS.TgidFd
+		syscallChannels[int(tgidFd)%len(syscallChannels)] <- evt
+	})
+	var packets, segments int
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		packet, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				common.AgentLog.Debugf("[pcap] %d packets read, %d tcp segments", packets, segments)
+				return nil
+			}
+			return err
+		}
+		packets++
+		segment, err := DecodeTcpSegment(packet)
+		if err != nil {
+			continue
+		}
+		segments++
+		tracker.AddSegment(segment)
+	}
+}
@@ -0,0 +1,294 @@
+package pcap_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/pcap"
+	"kyanos/bpf"
+	"kyanos/common"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var clientIp = net.IPv4(192, 168, 1, 2).To4()
+var serverIp = net.IPv4(192, 168, 1, 3).To4()
+
+const clientPort = 40000
+const serverPort = 8000
+
+type testPacket struct {
+	ts      uint64
+	fromSrv bool
+	seq     uint32
+	flags   uint8
+	payload string
+}
+
+// ethernetPacket builds an ethernet frame carrying an IPv4 TCP segment.
+func ethernetPacket(p testPacket) []byte {
+	srcIp, dstIp, srcPort, dstPort := clientIp, serverIp, uint16(clientPort), uint16(serverPort)
+	if p.fromSrv {
+		srcIp, dstIp, srcPort, dstPort = serverIp, clientIp, serverPort, clientPort
+	}
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], p.seq)
+	tcp[12] = 5 << 4
+	tcp[13] = p.flags
+	tcp = append(tcp, p.payload...)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(tcp)))
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], srcIp)
+	copy(ip[16:20], dstIp)
+
+	frame := make([]byte, 12)
+	frame = binary.BigEndian.AppendUint16(frame, 0x0800)
+	frame = append(frame, ip...)
+	frame = append(frame, tcp...)
+	// ethernet padding is not part of the TCP payload
+	return append(frame, 0, 0, 0, 0)
+}
+
+func writePcap(t *testing.T, packets []testPacket) string {
+	data := binary.LittleEndian.AppendUint32(nil, 0xa1b2c3d4)
+	data = binary.LittleEndian.AppendUint16(data, 2)
+	data = binary.LittleEndian.AppendUint16(data, 4)
+	data = append(data, make([]byte, 8)...)
+	data = binary.LittleEndian.AppendUint32(data, 65535)
+	data = binary.LittleEndian.AppendUint32(data, uint32(pcap.LinkTypeEthernet))
+	for _, p := range packets {
+		frame := ethernetPacket(p)
+		data = binary.LittleEndian.AppendUint32(data, uint32(p.ts/1e9))
+		data = binary.LittleEndian.AppendUint32(data, uint32(p.ts%1e9/1e3))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(frame)))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(frame)))
+		data = append(data, frame...)
+	}
+	path := filepath.Join(t.TempDir(), "test.pcap")
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	block := binary.BigEndian.AppendUint32(nil, blockType)
+	block = binary.BigEndian.AppendUint32(block, uint32(len(body)+12))
+	block = append(block, body...)
+	return binary.BigEndian.AppendUint32(block, uint32(len(body)+12))
+}
+
+// writePcapng writes a big endian pcapng file with nanosecond timestamps.
+func writePcapng(t *testing.T, packets []testPacket) string {
+	return writePcapngWithTsResol(t, 9, packets)
+}
+
+func writePcapngWithTsResol(t *testing.T, tsResol byte, packets []testPacket) string {
+	shb := binary.BigEndian.AppendUint32(nil, 0x1a2b3c4d)
+	shb = binary.BigEndian.AppendUint16(shb, 1)
+	shb = binary.BigEndian.AppendUint16(shb, 0)
+	shb = binary.BigEndian.AppendUint64(shb, 0xffffffffffffffff)
+	data := pcapngBlock(0x0a0d0d0a, shb)
+
+	idb := binary.BigEndian.AppendUint16(nil, uint16(pcap.LinkTypeEthernet))
+	idb = binary.BigEndian.AppendUint16(idb, 0)
+	idb = binary.BigEndian.AppendUint32(idb, 65535)
+	// if_tsresol
+	idb = binary.BigEndian.AppendUint16(idb, 9)
+	idb = binary.BigEndian.AppendUint16(idb, 1)
+	idb = append(idb, tsResol, 0, 0, 0)
+	idb = append(idb, 0, 0, 0, 0)
+	data = append(data, pcapngBlock(1, idb)...)
+	// interface statistics blocks are skipped
+	data = append(data, pcapngBlock(5, make([]byte, 12))...)
+
+	for _, p := range packets {
+		frame := ethernetPacket(p)
+		epb := binary.BigEndian.AppendUint32(nil, 0)
+		epb = binary.BigEndian.AppendUint32(epb, uint32(p.ts>>32))
+		epb = binary.BigEndian.AppendUint32(epb, uint32(p.ts))
+		epb = binary.BigEndian.AppendUint32(epb, uint32(len(frame)))
+		epb = binary.BigEndian.AppendUint32(epb, uint32(len(frame)))
+		epb = append(epb, frame...)
+		data = append(data, pcapngBlock(6, epb)...)
+	}
+	path := filepath.Join(t.TempDir(), "test.pcapng")
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func readSegments(t *testing.T, path string) []*pcap.TcpSegment {
+	reader, closer, err := pcap.OpenFile(path)
+	assert.NoError(t, err)
+	defer closer.Close()
+	var segments []*pcap.TcpSegment
+	for {
+		packet, err := reader.Next()
+		if err != nil {
+			break
+		}
+		segment, err := pcap.DecodeTcpSegment(packet)
+		assert.NoError(t, err)
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+const baseTs uint64 = 1700000000 * 1e9
+
+const httpRequest = "GET /foo HTTP/1.1\r\nHost: example.com\r\n\r\n"
+const httpResponse = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+
+func httpExchange() []testPacket {
+	return []testPacket{
+		{ts: baseTs + 1000, seq: 100, flags: pcap.TcpFlagSyn},
+		{ts: baseTs + 2000, fromSrv: true, seq: 500, flags: pcap.TcpFlagSyn | pcap.TcpFlagAck},
+		{ts: baseTs + 3000, seq: 101, flags: pcap.TcpFlagAck},
+		// the request is split and its segments arrive out of order
+		{ts: baseTs + 4000, seq: 111, flags: pcap.TcpFlagAck, payload: httpRequest[10:]},
+		{ts: baseTs + 5000, seq: 101, flags: pcap.TcpFlagAck, payload: httpRequest[:10]},
+		{ts: baseTs + 9000, fromSrv: true, seq: 501, flags: pcap.TcpFlagAck, payload: httpResponse},
+		// retransmission
+		{ts: baseTs + 12000, fromSrv: true, seq: 501, flags: pcap.TcpFlagAck, payload: httpResponse},
+	}
+}
+
+func TestReadPcap(t *testing.T) {
+	segments := readSegments(t, writePcap(t, httpExchange()))
+	assert.Len(t, segments, 7)
+	assert.Equal(t, baseTs+1000, segments[0].Timestamp)
+	assert.True(t, segments[0].HasFlag(pcap.TcpFlagSyn))
+	assert.Equal(t, uint16(clientPort), segments[3].SrcPort)
+	assert.Equal(t, uint16(serverPort), segments[3].DstPort)
+	assert.True(t, serverIp.Equal(segments[3].DstIp))
+	assert.Equal(t, uint32(111), segments[3].Seq)
+	assert.Equal(t, httpRequest[10:], string(segments[3].Payload))
+	assert.Equal(t, httpResponse, string(segments[5].Payload))
+}
+
+func TestReadPcapng(t *testing.T) {
+	packets := httpExchange()
+	packets[0].ts = baseTs + 1
+	segments := readSegments(t, writePcapng(t, packets))
+	assert.Len(t, segments, 7)
+	assert.Equal(t, baseTs+1, segments[0].Timestamp)
+	assert.Equal(t, httpResponse, string(segments[5].Payload))
+}
+
+func TestReadPcapngInvalidTsResol(t *testing.T) {
+	for _, tsResol := range []byte{20, 0x80 | 64} {
+		reader, closer, err := pcap.OpenFile(writePcapngWithTsResol(t, tsResol, httpExchange()))
+		assert.NoError(t, err)
+		_, err = reader.Next()
+		assert.Error(t, err)
+		closer.Close()
+	}
+}
+
+func TestNotPcapFile(t *testing.T) {
+	_, _, err := pcap.OpenFile("pcap_test.go")
+	assert.ErrorIs(t, err, pcap.ErrInvalidFormat)
+}
+
+type trackedEvents struct {
+	connEvents    []*bpf.AgentConnEvtT
+	syscallEvents []*bpf.SyscallEventData
+}
+
+func track(side common.SideEnum, packets []testPacket) *trackedEvents {
+	result := &trackedEvents{}
+	tracker := pcap.NewTracker(side, bpf.AgentTrafficProtocolTKProtocolUnset, func(evt *bpf.AgentConnEvtT) {
+		result.connEvents = append(result.connEvents, evt)
+	}, func(evt *bpf.SyscallEventData) {
+		result.syscallEvents = append(result.syscallEvents, evt)
+	})
+	for _, p := range packets {
+		segment, _ := pcap.DecodeTcpSegment(&pcap.RawPacket{Timestamp: p.ts, LinkType: pcap.LinkTypeEthernet, Data: ethernetPacket(p)})
+		tracker.AddSegment(segment)
+	}
+	return result
+}
+
+func TestTrackHttpConnection(t *testing.T) {
+	events := track(common.AllSide, httpExchange())
+
+	assert.Len(t, events.connEvents, 2)
+	connect, infer := events.connEvents[0], events.connEvents[1]
+	assert.Equal(t, bpf.AgentConnTypeTKConnect, connect.ConnType)
+	assert.Equal(t, bpf.AgentConnTypeTKProtocolInfer, infer.ConnType)
+	assert.Equal(t, bpf.AgentTrafficProtocolTKProtocolHTTP, infer.ConnInfo.Protocol)
+	assert.Equal(t, bpf.AgentEndpointRoleTKRoleClient, infer.ConnInfo.Role)
+	assert.Less(t, connect.Ts, infer.Ts)
+	assert.Equal(t, common.AF_INET, infer.ConnInfo.Laddr.In6.Sin6Family)
+	assert.Equal(t, uint16(clientPort), infer.ConnInfo.Laddr.In6.Sin6Port)
+	assert.Equal(t, uint16(serverPort), infer.ConnInfo.Raddr.In6.Sin6Port)
+	assert.Equal(t, []byte(serverIp), infer.ConnInfo.Raddr.In6.Sin6Addr.In6U.U6Addr8[:4])
+
+	assert.Len(t, events.syscallEvents, 4)
+	for _, evt := range events.syscallEvents {
+		assert.Equal(t, uint64(connect.ConnInfo.ConnId.Fd), evt.SyscallEvent.Ke.ConnIdS.TgidFd)
+		assert.Less(t, connect.Ts, evt.SyscallEvent.Ke.Ts)
+	}
+	// sequence numbers are relative to the handshake
+	assert.Equal(t, uint32(10), events.syscallEvents[0].SyscallEvent.Ke.Seq)
+	assert.Equal(t, uint32(0), events.syscallEvents[1].SyscallEvent.Ke.Seq)
+	assert.Equal(t, bpf.AgentStepTSYSCALL_OUT, events.syscallEvents[0].SyscallEvent.Ke.Step)
+	assert.Equal(t, bpf.AgentSourceFunctionTKSyscallWrite, events.syscallEvents[0].SyscallEvent.GetSourceFunction())
+	assert.Equal(t, uint32(0), events.syscallEvents[2].SyscallEvent.Ke.Seq)
+	assert.Equal(t, bpf.AgentStepTSYSCALL_IN, events.syscallEvents[2].SyscallEvent.Ke.Step)
+	assert.Equal(t, bpf.AgentSourceFunctionTKSyscallRead, events.syscallEvents[2].SyscallEvent.GetSourceFunction())
+	assert.Equal(t, httpResponse, string(events.syscallEvents[2].Buf))
+	assert.Equal(t, uint32(len(httpResponse)), events.syscallEvents[2].SyscallEvent.BufSize)
+}
+
+func TestTrackServerSideWithoutHandshake(t *testing.T) {
+	events := track(common.ServerSide, httpExchange()[3:])
+
+	assert.Len(t, events.connEvents, 2)
+	infer := events.connEvents[1]
+	assert.Equal(t, bpf.AgentTrafficProtocolTKProtocolHTTP, infer.ConnInfo.Protocol)
+	assert.Equal(t, bpf.AgentEndpointRoleTKRoleServer, infer.ConnInfo.Role)
+	// the lower port is the server
+	assert.Equal(t, uint16(serverPort), infer.ConnInfo.Laddr.In6.Sin6Port)
+
+	assert.Len(t, events.syscallEvents, 4)
+	// the first captured segment starts the stream
+	assert.Equal(t, uint32(0), events.syscallEvents[0].SyscallEvent.Ke.Seq)
+	assert.Equal(t, uint32(0xfffffff6), events.syscallEvents[1].SyscallEvent.Ke.Seq)
+	assert.Equal(t, bpf.AgentStepTSYSCALL_IN, events.syscallEvents[0].SyscallEvent.Ke.Step)
+	assert.Equal(t, bpf.AgentStepTSYSCALL_OUT, events.syscallEvents[2].SyscallEvent.Ke.Step)
+}
+
+func TestTrackUnknownProtocol(t *testing.T) {
+	packets := []testPacket{{ts: baseTs, seq: 1, flags: pcap.TcpFlagSyn}}
+	for i := 0; i < 10; i++ {
+		packets = append(packets, testPacket{ts: baseTs + uint64(i+1)*1000, seq: 2 + uint32(i)*4, flags: pcap.TcpFlagAck, payload: "\x00\x01\x02\x03"})
+	}
+	events := track(common.AllSide, packets)
+
+	assert.Len(t, events.connEvents, 2)
+	assert.Equal(t, bpf.AgentTrafficProtocolTKProtocolUnknown, events.connEvents[1].ConnInfo.Protocol)
+	assert.Empty(t, events.syscallEvents)
+}
+
+func TestTrackReusedTuple(t *testing.T) {
+	packets := httpExchange()
+	for _, p := range httpExchange() {
+		p.ts += 1e9
+		packets = append(packets, p)
+	}
+	events := track(common.AllSide, packets)
+
+	assert.Len(t, events.connEvents, 4)
+	assert.NotEqual(t, events.connEvents[0].ConnInfo.ConnId.Fd, events.connEvents[2].ConnInfo.ConnId.Fd)
+	assert.Len(t, events.syscallEvents, 8)
+}
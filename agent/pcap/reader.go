@@ -0,0 +1,328 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// See https://www.ietf.org/archive/id/draft-gharris-opsawg-pcap-01.html and
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html.
+
+const (
+	kPcapMagicMicro uint32 = 0xa1b2c3d4
+	kPcapMagicNano  uint32 = 0xa1b23c4d
+
+	kPcapngSectionHeaderBlock  uint32 = 0x0a0d0d0a
+	kPcapngInterfaceDescBlock  uint32 = 0x00000001
+	kPcapngPacketBlock         uint32 = 0x00000002
+	kPcapngSimplePacketBlock   uint32 = 0x00000003
+	kPcapngEnhancedPacketBlock uint32 = 0x00000006
+	kPcapngByteOrderMagic      uint32 = 0x1a2b3c4d
+	kPcapngOptionTsResol       uint16 = 9
+	kPcapngOptionEnd           uint16 = 0
+)
+
+// Packets larger than this are certainly corrupted.
+const kMaxPacketLength = 256 * 1024
+
+var ErrInvalidFormat = errors.New("not a pcap or pcapng file")
+
+type LinkType uint16
+
+const (
+	LinkTypeNull      LinkType = 0
+	LinkTypeEthernet  LinkType = 1
+	LinkTypeRaw       LinkType = 101
+	LinkTypeLoop      LinkType = 108
+	LinkTypeLinuxSLL  LinkType = 113
+	LinkTypeIPv4      LinkType = 228
+	LinkTypeIPv6      LinkType = 229
+	LinkTypeLinuxSLL2 LinkType = 276
+)
+
+// RawPacket is a packet read from a capture file.
+type RawPacket struct {
+	// nanoseconds since the Unix epoch
+	Timestamp uint64
+	LinkType  LinkType
+	Data      []byte
+}
+
+type PacketReader interface {
+	// Next returns io.EOF when there are no more packets.
+	Next() (*RawPacket, error)
+}
+
+// OpenFile opens a pcap or pcapng file, the format is detected by the magic number.
+func OpenFile(path string) (PacketReader, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReaderSize(file, 1024*1024)
+	magic, err := r.Peek(4)
+	if err != nil {
+		file.Close()
+		return nil, nil, ErrInvalidFormat
+	}
+	var reader PacketReader
+	if binary.LittleEndian.Uint32(magic) == kPcapngSectionHeaderBlock {
+		reader, err = newPcapngReader(r)
+	} else {
+		reader, err = newPcapReader(r)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return reader, file, nil
+}
+
+type pcapReader struct {
+	r         io.Reader
+	byteOrder binary.ByteOrder
+	nano      bool
+	linkType  LinkType
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrInvalidFormat
+	}
+	reader := &pcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(header) == kPcapMagicMicro:
+		reader.byteOrder = binary.LittleEndian
+	case binary.LittleEndian.Uint32(header) == kPcapMagicNano:
+		reader.byteOrder, reader.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == kPcapMagicMicro:
+		reader.byteOrder = binary.BigEndian
+	case binary.BigEndian.Uint32(header) == kPcapMagicNano:
+		reader.byteOrder, reader.nano = binary.BigEndian, true
+	default:
+		return nil, ErrInvalidFormat
+	}
+	// the upper 16 bits of LinkType may carry the FCS length
+	reader.linkType = LinkType(reader.byteOrder.Uint32(header[20:24]) & 0xffff)
+	return reader, nil
+}
+
+func (p *pcapReader) Next() (*RawPacket, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		return nil, eofIfTruncated(err)
+	}
+	sec := uint64(p.byteOrder.Uint32(header[0:4]))
+	frac := uint64(p.byteOrder.Uint32(header[4:8]))
+	capturedLength := p.byteOrder.Uint32(header[8:12])
+	if capturedLength > kMaxPacketLength {
+		return nil, fmt.Errorf("invalid packet length: %d", capturedLength)
+	}
+	data := make([]byte, capturedLength)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, eofIfTruncated(err)
+	}
+	if !p.nano {
+		frac *= 1000
+	}
+	return &RawPacket{Timestamp: sec*1e9 + frac, LinkType: p.linkType, Data: data}, nil
+}
+
+type pcapngInterface struct {
+	linkType LinkType
+	// nanoseconds per timestamp unit, or units per nanosecond when unitsPerNano is set
+	nanoPerUnit  uint64
+	unitsPerNano uint64
+}
+
+func (i pcapngInterface) toNano(ts uint64) uint64 {
+	if i.unitsPerNano > 0 {
+		return ts / i.unitsPerNano
+	}
+	return ts * i.nanoPerUnit
+}
+
+type pcapngReader struct {
+	r          io.Reader
+	byteOrder  binary.ByteOrder
+	interfaces []pcapngInterface
+	lastTs     uint64
+}
+
+func newPcapngReader(r io.Reader) (*pcapngReader, error) {
+	reader := &pcapngReader{r: r, byteOrder: binary.LittleEndian}
+	blockType, body, err := reader.readBlock()
+	if err != nil || blockType != kPcapngSectionHeaderBlock {
+		return nil, ErrInvalidFormat
+	}
+	if err := reader.handleSectionHeader(body); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// readBlock returns the block type and the block body, the section header
+// block is detected before the length is read since it defines the byte order.
+func (p *pcapngReader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(p.r, header[:8]); err != nil {
+		return 0, nil, eofIfTruncated(err)
+	}
+	blockType := p.byteOrder.Uint32(header[0:4])
+	if binary.LittleEndian.Uint32(header[0:4]) == kPcapngSectionHeaderBlock {
+		blockType = kPcapngSectionHeaderBlock
+		if _, err := io.ReadFull(p.r, header[8:12]); err != nil {
+			return 0, nil, eofIfTruncated(err)
+		}
+		if binary.BigEndian.Uint32(header[8:12]) == kPcapngByteOrderMagic {
+			p.byteOrder = binary.BigEndian
+		} else if binary.LittleEndian.Uint32(header[8:12]) == kPcapngByteOrderMagic {
+			p.byteOrder = binary.LittleEndian
+		} else {
+			return 0, nil, ErrInvalidFormat
+		}
+	}
+	totalLength := p.byteOrder.Uint32(header[4:8])
+	if totalLength < 12 || totalLength%4 != 0 || totalLength > kMaxPacketLength+64 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length: %d", totalLength)
+	}
+	// body + trailing total length
+	rest := make([]byte, totalLength-8)
+	if blockType == kPcapngSectionHeaderBlock {
+		copy(rest, header[8:12])
+		if _, err := io.ReadFull(p.r, rest[4:]); err != nil {
+			return 0, nil, eofIfTruncated(err)
+		}
+	} else if _, err := io.ReadFull(p.r, rest); err != nil {
+		return 0, nil, eofIfTruncated(err)
+	}
+	return blockType, rest[:len(rest)-4], nil
+}
+
+func (p *pcapngReader) handleSectionHeader(body []byte) error {
+	if len(body) < 16 {
+		return ErrInvalidFormat
+	}
+	// interface ids are local to a section
+	p.interfaces = p.interfaces[:0]
+	return nil
+}
+
+func (p *pcapngReader) handleInterfaceDescription(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("invalid pcapng interface description block")
+	}
+	iface := pcapngInterface{linkType: LinkType(p.byteOrder.Uint16(body[0:2])), nanoPerUnit: 1000}
+	options := body[8:]
+	for len(options) >= 4 {
+		code := p.byteOrder.Uint16(options[0:2])
+		length := int(p.byteOrder.Uint16(options[2:4]))
+		if code == kPcapngOptionEnd || 4+length > len(options) {
+			break
+		}
+		if code == kPcapngOptionTsResol && length >= 1 {
+			var err error
+			iface.nanoPerUnit, iface.unitsPerNano, err = tsResolution(options[4])
+			if err != nil {
+				return err
+			}
+		}
+		// option values are padded to 32 bits
+		padded := 4 + (length+3)/4*4
+		if padded > len(options) {
+			break
+		}
+		options = options[padded:]
+	}
+	p.interfaces = append(p.interfaces, iface)
+	return nil
+}
+
+// tsResolution converts if_tsresol to nanoseconds per unit, or units per
+// nanosecond for resolutions finer than a nanosecond.
+func tsResolution(resol byte) (uint64, uint64, error) {
+	var unitsPerSecond uint64 = 1
+	if resol&0x80 == 0 {
+		// 10^19 is the largest power of 10 in an uint64
+		if resol > 19 {
+			return 0, 0, fmt.Errorf("unsupported pcapng timestamp resolution 10^-%d", resol)
+		}
+		for i := 0; i < int(resol); i++ {
+			unitsPerSecond *= 10
+		}
+	} else {
+		if resol&0x7f >= 64 {
+			return 0, 0, fmt.Errorf("unsupported pcapng timestamp resolution 2^-%d", resol&0x7f)
+		}
+		unitsPerSecond = 1 << (resol & 0x7f)
+	}
+	if unitsPerSecond <= 1e9 {
+		return 1e9 / unitsPerSecond, 0, nil
+	}
+	return 0, unitsPerSecond / 1e9, nil
+}
+
+func (p *pcapngReader) Next() (*RawPacket, error) {
+	for {
+		blockType, body, err := p.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case kPcapngSectionHeaderBlock:
+			if err := p.handleSectionHeader(body); err != nil {
+				return nil, err
+			}
+		case kPcapngInterfaceDescBlock:
+			if err := p.handleInterfaceDescription(body); err != nil {
+				return nil, err
+			}
+		case kPcapngEnhancedPacketBlock, kPcapngPacketBlock:
+			if len(body) < 20 {
+				return nil, fmt.Errorf("invalid pcapng packet block")
+			}
+			var interfaceId int
+			if blockType == kPcapngEnhancedPacketBlock {
+				interfaceId = int(p.byteOrder.Uint32(body[0:4]))
+			} else {
+				interfaceId = int(p.byteOrder.Uint16(body[0:2]))
+			}
+			if interfaceId >= len(p.interfaces) {
+				return nil, fmt.Errorf("unknown pcapng interface: %d", interfaceId)
+			}
+			iface := p.interfaces[interfaceId]
+			ts := uint64(p.byteOrder.Uint32(body[4:8]))<<32 | uint64(p.byteOrder.Uint32(body[8:12]))
+			capturedLength := int(p.byteOrder.Uint32(body[12:16]))
+			if 20+capturedLength > len(body) {
+				return nil, fmt.Errorf("invalid pcapng packet length: %d", capturedLength)
+			}
+			p.lastTs = iface.toNano(ts)
+			return &RawPacket{Timestamp: p.lastTs, LinkType: iface.linkType, Data: body[20 : 20+capturedLength]}, nil
+		case kPcapngSimplePacketBlock:
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				return nil, fmt.Errorf("invalid pcapng simple packet block")
+			}
+			originalLength := int(p.byteOrder.Uint32(body[0:4]))
+			data := body[4:]
+			if originalLength < len(data) {
+				data = data[:originalLength]
+			}
+			// simple packet blocks have no timestamp
+			return &RawPacket{Timestamp: p.lastTs, LinkType: p.interfaces[0].linkType, Data: data}, nil
+		default:
+			// name resolution, interface statistics and custom blocks
+		}
+	}
+}
+
+func eofIfTruncated(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+	return err
+}
@@ -0,0 +1,20 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterfaceDescriptionTruncatedOption(t *testing.T) {
+	reader := &pcapngReader{byteOrder: binary.BigEndian}
+	body := make([]byte, 8)
+	// if_tsresol = 6 without the padding of its value
+	body = append(body, 0, 9, 0, 1, 6)
+	assert.NotPanics(t, func() {
+		assert.NoError(t, reader.handleInterfaceDescription(body))
+	})
+	assert.Len(t, reader.interfaces, 1)
+	assert.Equal(t, uint64(1000), reader.interfaces[0].nanoPerUnit)
+}
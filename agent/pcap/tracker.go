@@ -0,0 +1,245 @@
+package pcap
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"net"
+)
+
+// Connections whose protocol can't be inferred from this many segments are
+// marked as KProtocolUnknown and their data is dropped.
+const kMaxInferenceSegments = 8
+
+// Data kept for inference is limited, protocols are recognizable from the
+// beginning of a message.
+const kMaxInferenceBytes = 64 * 1024
+
+const (
+	directionRequest = iota
+	directionResponse
+)
+
+type endpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+type connKey struct {
+	srcIp   string
+	dstIp   string
+	srcPort uint16
+	dstPort uint16
+}
+
+type tcpConn struct {
+	fd        uint32
+	client    endpoint
+	server    endpoint
+	startTs   uint64
+	isn       [2]uint32
+	isnKnown  [2]bool
+	dataSeen  bool
+	protocol  bpf.AgentTrafficProtocolT
+	segments  int
+	inferData [2][]byte
+	pending   []*bpf.SyscallEventData
+}
+
+// Tracker follows the TCP connections of a capture and converts them to the
+// events the kernel would have reported for one endpoint of each connection:
+// a connect event, a protocol inference event, then a syscall event per
+// segment. Sequence numbers are relative to the start of each direction, so
+// the stream buffers reorder segments and drop retransmissions.
+type Tracker struct {
+	conns          map[connKey]*tcpConn
+	nextFd         uint32
+	side           common.SideEnum
+	traceProtocol  bpf.AgentTrafficProtocolT
+	onConnEvent    func(*bpf.AgentConnEvtT)
+	onSyscallEvent func(*bpf.SyscallEventData)
+}
+
+// NewTracker creates a Tracker, connections are seen from the server when
+// side is ServerSide, and from the client otherwise.
+func NewTracker(side common.SideEnum, traceProtocol bpf.AgentTrafficProtocolT,
+	onConnEvent func(*bpf.AgentConnEvtT), onSyscallEvent func(*bpf.SyscallEventData)) *Tracker {
+	return &Tracker{
+		conns:          make(map[connKey]*tcpConn),
+		side:           side,
+		traceProtocol:  traceProtocol,
+		onConnEvent:    onConnEvent,
+		onSyscallEvent: onSyscallEvent,
+	}
+}
+
+func (t *Tracker) AddSegment(segment *TcpSegment) {
+	key := connKey{string(segment.SrcIp), string(segment.DstIp), segment.SrcPort, segment.DstPort}
+	reversedKey := connKey{key.dstIp, key.srcIp, key.dstPort, key.srcPort}
+	conn := t.conns[key]
+	if conn == nil {
+		conn = t.conns[reversedKey]
+	}
+	src := endpoint{segment.SrcIp, segment.SrcPort}
+	dst := endpoint{segment.DstIp, segment.DstPort}
+
+	if segment.HasFlag(TcpFlagSyn) {
+		if !segment.HasFlag(TcpFlagAck) {
+			// a new connection, the tuple may be reused
+			conn = t.newConn(src, dst, segment.Timestamp)
+			t.conns[key] = conn
+			delete(t.conns, reversedKey)
+			conn.isn[directionRequest], conn.isnKnown[directionRequest] = segment.Seq+1, true
+		} else {
+			if conn == nil || conn.dataSeen {
+				conn = t.newConn(dst, src, segment.Timestamp)
+				t.conns[reversedKey] = conn
+				delete(t.conns, key)
+			}
+			conn.isn[directionResponse], conn.isnKnown[directionResponse] = segment.Seq+1, true
+		}
+		return
+	}
+	if len(segment.Payload) == 0 {
+		return
+	}
+	if conn == nil {
+		// the handshake is not captured, guess the server by the ports
+		if isServerPort(segment.SrcPort, segment.DstPort) {
+			conn = t.newConn(dst, src, segment.Timestamp)
+			t.conns[reversedKey] = conn
+		} else {
+			conn = t.newConn(src, dst, segment.Timestamp)
+			t.conns[key] = conn
+		}
+	}
+	direction := directionRequest
+	if conn.server.port == segment.SrcPort && conn.server.ip.Equal(segment.SrcIp) {
+		direction = directionResponse
+	}
+	conn.dataSeen = true
+	if !conn.isnKnown[direction] {
+		conn.isn[direction], conn.isnKnown[direction] = segment.Seq, true
+	}
+	event := t.newSyscallEvent(conn, direction, segment)
+
+	switch conn.protocol {
+	case bpf.AgentTrafficProtocolTKProtocolUnknown:
+		return
+	case bpf.AgentTrafficProtocolTKProtocolUnset:
+		conn.pending = append(conn.pending, event)
+		t.inferProtocol(conn, direction, segment.Payload)
+	default:
+		t.onSyscallEvent(event)
+	}
+}
+
+func (t *Tracker) newConn(client, server endpoint, ts uint64) *tcpConn {
+	t.nextFd++
+	return &tcpConn{fd: t.nextFd, client: client, server: server, startTs: ts}
+}
+
+// isServerPort reports whether port rather than otherPort belongs to the
+// server, well-known ports win, then the lower port.
+func isServerPort(port uint16, otherPort uint16) bool {
+	_, isWellKnown := wellKnownPorts[port]
+	_, otherIsWellKnown := wellKnownPorts[otherPort]
+	if isWellKnown != otherIsWellKnown {
+		return isWellKnown
+	}
+	return port < otherPort
+}
+
+func (t *Tracker) inferProtocol(conn *tcpConn, direction int, payload []byte) {
+	conn.segments++
+	messageType := protocol.Request
+	if direction == directionResponse {
+		messageType = protocol.Response
+	}
+	// like the kernel, try the data of a single read or write first, then the
+	// accumulated data for messages split across segments
+	accumulated := conn.inferData[direction]
+	if len(accumulated) < kMaxInferenceBytes {
+		accumulated = append(accumulated, payload...)
+		conn.inferData[direction] = accumulated
+	}
+	inferred := inferProtocol(payload, messageType, conn.server.port, t.traceProtocol)
+	if inferred == bpf.AgentTrafficProtocolTKProtocolUnset && len(accumulated) > len(payload) {
+		inferred = inferProtocol(accumulated, messageType, conn.server.port, t.traceProtocol)
+	}
+
+	if inferred == bpf.AgentTrafficProtocolTKProtocolUnset {
+		if conn.segments < kMaxInferenceSegments {
+			return
+		}
+		inferred = bpf.AgentTrafficProtocolTKProtocolUnknown
+	}
+	conn.protocol = inferred
+	conn.inferData = [2][]byte{}
+	t.onConnEvent(t.newConnEvent(conn, bpf.AgentConnTypeTKConnect))
+	t.onConnEvent(t.newConnEvent(conn, bpf.AgentConnTypeTKProtocolInfer))
+	if inferred != bpf.AgentTrafficProtocolTKProtocolUnknown {
+		for _, event := range conn.pending {
+			t.onSyscallEvent(event)
+		}
+	}
+	conn.pending = nil
+}
+
+func (t *Tracker) localAndRemote(conn *tcpConn) (endpoint, endpoint, bpf.AgentEndpointRoleT) {
+	if t.side == common.ServerSide {
+		return conn.server, conn.client, bpf.AgentEndpointRoleTKRoleServer
+	}
+	return conn.client, conn.server, bpf.AgentEndpointRoleTKRoleClient
+}
+
+func (t *Tracker) newConnEvent(conn *tcpConn, connType bpf.AgentConnTypeT) *bpf.AgentConnEvtT {
+	local, remote, role := t.localAndRemote(conn)
+	event := &bpf.AgentConnEvtT{ConnType: connType}
+	// the connection must start before its first event
+	event.Ts = conn.startTs - 1
+	if connType == bpf.AgentConnTypeTKProtocolInfer {
+		event.Ts = conn.startTs
+		event.ConnInfo.Protocol = conn.protocol
+	}
+	event.ConnInfo.ConnId.Fd = int32(conn.fd)
+	event.ConnInfo.Role = role
+	family := common.AF_INET
+	if local.ip.To4() == nil {
+		family = common.AF_INET6
+	}
+	event.ConnInfo.Laddr.In6.Sin6Family = family
+	event.ConnInfo.Laddr.In6.Sin6Port = local.port
+	copy(event.ConnInfo.Laddr.In6.Sin6Addr.In6U.U6Addr8[:], ipBytes(local.ip))
+	event.ConnInfo.Raddr.In6.Sin6Family = family
+	event.ConnInfo.Raddr.In6.Sin6Port = remote.port
+	copy(event.ConnInfo.Raddr.In6.Sin6Addr.In6U.U6Addr8[:], ipBytes(remote.ip))
+	return event
+}
+
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+func (t *Tracker) newSyscallEvent(conn *tcpConn, direction int, segment *TcpSegment) *bpf.SyscallEventData {
+	_, _, role := t.localAndRemote(conn)
+	egress := (direction == directionRequest) == (role == bpf.AgentEndpointRoleTKRoleClient)
+	event := &bpf.SyscallEventData{Buf: segment.Payload}
+	ke := &event.SyscallEvent.Ke
+	ke.Ts = segment.Timestamp
+	ke.Seq = segment.Seq - conn.isn[direction]
+	ke.Len = uint32(len(segment.Payload))
+	ke.ConnIdS.TgidFd = uint64(conn.fd)
+	if egress {
+		ke.Step = bpf.AgentStepTSYSCALL_OUT
+		ke.FuncName[0] = int8(bpf.AgentSourceFunctionTKSyscallWrite)
+	} else {
+		ke.Step = bpf.AgentStepTSYSCALL_IN
+		ke.FuncName[0] = int8(bpf.AgentSourceFunctionTKSyscallRead)
+	}
+	event.SyscallEvent.BufSize = uint32(len(segment.Payload))
+	return event
+}
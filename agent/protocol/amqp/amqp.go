@@ -0,0 +1,526 @@
+package amqp
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolAMQP] = func() protocol.ProtocolStreamParser {
+		return NewAmqpStreamParser()
+	}
+}
+
+func NewAmqpStreamParser() *AmqpStreamParser {
+	return &AmqpStreamParser{
+		pendingContent: make(map[contentKey]*Message),
+		publishSeqs:    make(map[uint16]uint64),
+	}
+}
+
+// responseMethods are the methods which answer a synchronous request.
+var responseMethods = make(map[Method]bool)
+
+func init() {
+	for method, info := range methods {
+		for _, response := range info.responses {
+			responseMethods[Method{method.ClassId, response}] = true
+		}
+	}
+}
+
+type frameHeader struct {
+	frameType byte
+	channel   uint16
+	size      int
+}
+
+func parseFrameHeader(buf []byte) (frameHeader, bool) {
+	header := frameHeader{
+		frameType: buf[0],
+		channel:   binary.BigEndian.Uint16(buf[1:3]),
+		size:      int(binary.BigEndian.Uint32(buf[3:7])),
+	}
+	switch header.frameType {
+	case kFrameMethod:
+		// class id and method id
+		return header, header.size >= 4 && header.size <= kMaxFrameSize
+	case kFrameHeader, kFrameBody:
+		return header, header.size <= kMaxFrameSize && header.channel != 0
+	case kFrameHeartbeat:
+		return header, header.size == 0 && header.channel == 0
+	}
+	return header, false
+}
+
+func (d direction) accepts(messageType protocol.MessageType) bool {
+	switch messageType {
+	case protocol.Request:
+		return d&fromClient != 0
+	case protocol.Response:
+		return d&fromServer != 0
+	}
+	return true
+}
+
+// isRequest returns whether a method of d sent in the stream of messageType
+// is sent by the client, ok is false if that can't be told.
+func (d direction) isRequest(messageType protocol.MessageType) (isReq bool, ok bool) {
+	switch {
+	case d == fromClient:
+		return true, true
+	case d == fromServer:
+		return false, true
+	case messageType == protocol.Unknown:
+		return false, false
+	}
+	return messageType == protocol.Request, true
+}
+
+func (p *AmqpStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	if len(buf) > 0 && buf[0] == kProtocolHeader[0] {
+		if len(buf) < len(kProtocolHeader) && bytes.HasPrefix(kProtocolHeader, buf) {
+			return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+		}
+		if bytes.HasPrefix(buf, kProtocolHeader) && messageType != protocol.Response {
+			return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: len(kProtocolHeader)}
+		}
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	if len(buf) < kFrameHeaderLength {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	header, ok := parseFrameHeader(buf)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	readBytes := kFrameHeaderLength + header.size + 1
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	if buf[readBytes-1] != kFrameEnd {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	payload := buf[kFrameHeaderLength : readBytes-1]
+	switch header.frameType {
+	case kFrameMethod:
+		return p.parseMethod(streamBuffer, header, payload, messageType, readBytes)
+	case kFrameHeader, kFrameBody:
+		return p.parseContent(header, payload, messageType, readBytes)
+	}
+	return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+}
+
+func (p *AmqpStreamParser) parseMethod(streamBuffer *buffer.StreamBuffer, header frameHeader, payload []byte, messageType protocol.MessageType, readBytes int) protocol.ParseResult {
+	method := Method{binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4])}
+	info, ok := methods[method]
+	if !ok || !info.direction.accepts(messageType) {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	isReq, ok := info.direction.isRequest(messageType)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	message := &Message{Channel: header.channel, Method: method, isReq: isReq}
+	d := &decoder{buf: payload[4:]}
+	decodeArguments(d, message)
+	if d.err != nil {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	if isReq {
+		p.trackConfirms(message)
+	}
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[AMQP] failed to create FrameBase for %s", method)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	message.FrameBase = fb
+	if info.content {
+		p.pendingContent[contentKey{isReq, header.channel}] = message
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	if !isRecordPart(message) {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// isRecordPart returns whether message is a synchronous request or the
+// method answering one, the other methods are asynchronous.
+func isRecordPart(message *Message) bool {
+	if message.isReq {
+		return len(methods[message.Method].responses) > 0 && !message.NoWait
+	}
+	switch message.Method {
+	case BasicAck, BasicNack, ChannelClose, ConnectionClose:
+		return true
+	}
+	return responseMethods[message.Method]
+}
+
+// trackConfirms numbers the publishes of the channels in confirm mode, the
+// server acks them by their sequence number.
+func (p *AmqpStreamParser) trackConfirms(message *Message) {
+	switch message.Method {
+	case ChannelOpen:
+		delete(p.publishSeqs, message.Channel)
+	case ConfirmSelect:
+		if _, ok := p.publishSeqs[message.Channel]; !ok {
+			p.publishSeqs[message.Channel] = 0
+		}
+	case BasicPublish:
+		if seq, ok := p.publishSeqs[message.Channel]; ok {
+			p.publishSeqs[message.Channel] = seq + 1
+			message.DeliveryTag = seq + 1
+		}
+	}
+}
+
+// parseContent adds a content header or body frame to the message waiting
+// for it on the channel.
+func (p *AmqpStreamParser) parseContent(header frameHeader, payload []byte, messageType protocol.MessageType, readBytes int) protocol.ParseResult {
+	key := contentKey{messageType != protocol.Response, header.channel}
+	message, ok := p.pendingContent[key]
+	if !ok && messageType == protocol.Unknown {
+		key.isReq = false
+		message, ok = p.pendingContent[key]
+	}
+	if !ok {
+		// the method of the content was not captured
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	message.IncrByteSize(readBytes)
+
+	if header.frameType == kFrameHeader {
+		d := &decoder{buf: payload}
+		decodeContentHeader(d, message)
+		if d.err != nil {
+			delete(p.pendingContent, key)
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		message.headerReceived = true
+	} else if !message.headerReceived {
+		delete(p.pendingContent, key)
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	} else {
+		if len(message.Body) < kMaxBodyKept {
+			message.Body = append(message.Body, payload[:min(len(payload), kMaxBodyKept-len(message.Body))]...)
+		}
+		message.bodyReceived += uint64(len(payload))
+	}
+	if message.bodyReceived < message.BodySize {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	delete(p.pendingContent, key)
+	if message.isConfirmedPublish() || message.Method == BasicGetOk {
+		return protocol.ParseResult{
+			ParseState:     protocol.Success,
+			ReadBytes:      readBytes,
+			ParsedMessages: []protocol.ParsedMessage{message},
+		}
+	}
+	// a publish out of confirm mode, basic.deliver and basic.return answer
+	// no request
+	return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+}
+
+func decodeArguments(d *decoder, m *Message) {
+	switch m.Method {
+	case ConnectionClose, ChannelClose:
+		m.ReplyCode = d.readShort()
+		m.ReplyText = d.readShortStr()
+		m.FailedMethod = Method{d.readShort(), d.readShort()}
+	case ExchangeDeclare:
+		d.readShort()
+		m.Exchange = d.readShortStr()
+		d.readShortStr()
+		// passive, durable, auto-delete, internal
+		for i := 0; i < 4; i++ {
+			d.readBit()
+		}
+		m.NoWait = d.readBit()
+	case ExchangeDelete:
+		d.readShort()
+		m.Exchange = d.readShortStr()
+		d.readBit()
+		m.NoWait = d.readBit()
+	case ExchangeBind, ExchangeUnbind:
+		d.readShort()
+		m.Exchange = d.readShortStr()
+		// the source exchange
+		d.readShortStr()
+		m.RoutingKey = d.readShortStr()
+		m.NoWait = d.readBit()
+	case QueueDeclare:
+		d.readShort()
+		m.Queue = d.readShortStr()
+		// passive, durable, exclusive, auto-delete
+		for i := 0; i < 4; i++ {
+			d.readBit()
+		}
+		m.NoWait = d.readBit()
+	case QueueDeclareOk:
+		m.Queue = d.readShortStr()
+		m.MessageCount = d.readLong()
+		m.ConsumerCount = d.readLong()
+	case QueueBind, QueueUnbind:
+		d.readShort()
+		m.Queue = d.readShortStr()
+		m.Exchange = d.readShortStr()
+		m.RoutingKey = d.readShortStr()
+		if m.Method == QueueBind {
+			m.NoWait = d.readBit()
+		}
+	case QueuePurge:
+		d.readShort()
+		m.Queue = d.readShortStr()
+		m.NoWait = d.readBit()
+	case QueueDelete:
+		d.readShort()
+		m.Queue = d.readShortStr()
+		// if-unused, if-empty
+		d.readBit()
+		d.readBit()
+		m.NoWait = d.readBit()
+	case QueuePurgeOk, QueueDeleteOk:
+		m.MessageCount = d.readLong()
+	case BasicConsume:
+		d.readShort()
+		m.Queue = d.readShortStr()
+		m.ConsumerTag = d.readShortStr()
+		// no-local, no-ack, exclusive
+		for i := 0; i < 3; i++ {
+			d.readBit()
+		}
+		m.NoWait = d.readBit()
+	case BasicConsumeOk, BasicCancelOk:
+		m.ConsumerTag = d.readShortStr()
+	case BasicCancel:
+		m.ConsumerTag = d.readShortStr()
+		m.NoWait = d.readBit()
+	case BasicPublish:
+		d.readShort()
+		m.Exchange = d.readShortStr()
+		m.RoutingKey = d.readShortStr()
+	case BasicReturn:
+		m.ReplyCode = d.readShort()
+		m.ReplyText = d.readShortStr()
+		m.Exchange = d.readShortStr()
+		m.RoutingKey = d.readShortStr()
+	case BasicDeliver:
+		m.ConsumerTag = d.readShortStr()
+		m.DeliveryTag = d.readLongLong()
+		d.readBit()
+		m.Exchange = d.readShortStr()
+		m.RoutingKey = d.readShortStr()
+	case BasicGet:
+		d.readShort()
+		m.Queue = d.readShortStr()
+	case BasicGetOk:
+		m.DeliveryTag = d.readLongLong()
+		d.readBit()
+		m.Exchange = d.readShortStr()
+		m.RoutingKey = d.readShortStr()
+		m.MessageCount = d.readLong()
+	case BasicAck, BasicNack:
+		m.DeliveryTag = d.readLongLong()
+		m.Multiple = d.readBit()
+	case ConfirmSelect:
+		m.NoWait = d.readBit()
+	}
+}
+
+// Property flags of the basic class, the properties after message-id are
+// not decoded.
+const (
+	kPropContentType     uint16 = 1 << 15
+	kPropContentEncoding uint16 = 1 << 14
+	kPropHeaders         uint16 = 1 << 13
+	kPropDeliveryMode    uint16 = 1 << 12
+	kPropPriority        uint16 = 1 << 11
+	kPropCorrelationId   uint16 = 1 << 10
+	kPropReplyTo         uint16 = 1 << 9
+	kPropExpiration      uint16 = 1 << 8
+	kPropMessageId       uint16 = 1 << 7
+)
+
+func decodeContentHeader(d *decoder, m *Message) {
+	// class id and weight
+	d.readShort()
+	d.readShort()
+	m.BodySize = d.readLongLong()
+	flags := d.readShort()
+	// the last bit tells if more flags follow
+	for more := flags & 1; more != 0 && d.err == nil; {
+		more = d.readShort() & 1
+	}
+	if flags&kPropContentType != 0 {
+		m.ContentType = d.readShortStr()
+	}
+	if flags&kPropContentEncoding != 0 {
+		d.readShortStr()
+	}
+	if flags&kPropHeaders != 0 {
+		d.skipTable()
+	}
+	if flags&kPropDeliveryMode != 0 {
+		m.DeliveryMode = d.readOctet()
+	}
+	if flags&kPropPriority != 0 {
+		d.readOctet()
+	}
+	if flags&kPropCorrelationId != 0 {
+		m.CorrelationId = d.readShortStr()
+	}
+	if flags&kPropReplyTo != 0 {
+		m.ReplyTo = d.readShortStr()
+	}
+	if flags&kPropExpiration != 0 {
+		d.readShortStr()
+	}
+	if flags&kPropMessageId != 0 {
+		m.MessageId = d.readShortStr()
+	}
+}
+
+// FindBoundary looks for a frame whose frame-end octet is where its size
+// says.
+func (p *AmqpStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i+kFrameHeaderLength <= len(buf); i++ {
+		if bytes.HasPrefix(buf[i:], kProtocolHeader) {
+			return i
+		}
+		header, ok := parseFrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		end := i + kFrameHeaderLength + header.size
+		if end >= len(buf) || buf[end] != kFrameEnd {
+			continue
+		}
+		if header.frameType == kFrameMethod {
+			method := Method{binary.BigEndian.Uint16(buf[i+7 : i+9]), binary.BigEndian.Uint16(buf[i+9 : i+11])}
+			if info, ok := methods[method]; !ok || !info.direction.accepts(messageType) {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+func (m *Message) isConfirmedPublish() bool {
+	return m.Method == BasicPublish && m.DeliveryTag != 0
+}
+
+// Match pairs the synchronous requests of a channel with the methods
+// answering them in order, and the publishes of a channel in confirm mode
+// with the basic.ack or basic.nack of their delivery tags.
+func (p *AmqpStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	records := []protocol.Record{}
+	for channel, respQueue := range respStreams {
+		reqQueue := reqStreams[channel]
+		if reqQueue == nil {
+			reqQueue = &protocol.ParsedMessageQueue{}
+		}
+		for _, each := range *respQueue {
+			resp := each.(*Message)
+			var matched []protocol.ParsedMessage
+			switch resp.Method {
+			case BasicAck, BasicNack:
+				matched = takeRequests(reqQueue, resp, func(req *Message) bool {
+					return req.isConfirmedPublish() &&
+						(req.DeliveryTag == resp.DeliveryTag || (resp.Multiple && req.DeliveryTag <= resp.DeliveryTag))
+				})
+			case ChannelClose, ConnectionClose:
+				// the server closes the channel instead of answering a
+				// request which failed, the other requests are not answered
+				matched = takeRequests(reqQueue, resp, func(req *Message) bool {
+					return req.Method == resp.FailedMethod
+				})
+				if len(matched) > 1 {
+					matched = matched[:1]
+				}
+				*reqQueue = slices.DeleteFunc(*reqQueue, func(req protocol.ParsedMessage) bool {
+					return req.TimestampNs() <= resp.TimestampNs()
+				})
+			default:
+				matched = takeSyncRequest(reqQueue, resp)
+				if resp.Method == ChannelCloseOk || resp.Method == ConnectionCloseOk {
+					*reqQueue = slices.DeleteFunc(*reqQueue, func(req protocol.ParsedMessage) bool {
+						return req.TimestampNs() <= resp.TimestampNs()
+					})
+				}
+			}
+			if len(matched) == 0 {
+				common.ProtocolParserLog.Debugf("[AMQP] no request found for %s on channel %d", resp.Method, channel)
+			}
+			for _, req := range matched {
+				records = append(records, protocol.Record{
+					Req:            req,
+					Resp:           resp,
+					ResponseStatus: resp.Status(),
+				})
+			}
+		}
+		delete(respStreams, channel)
+		if len(*reqQueue) == 0 {
+			delete(reqStreams, channel)
+		}
+	}
+	slices.SortFunc(records, func(r1, r2 protocol.Record) int {
+		return cmp.Compare(r1.Req.TimestampNs(), r2.Req.TimestampNs())
+	})
+	return records
+}
+
+// takeRequests removes the requests sent before resp which match from
+// queue.
+func takeRequests(queue *protocol.ParsedMessageQueue, resp *Message, match func(req *Message) bool) []protocol.ParsedMessage {
+	var matched []protocol.ParsedMessage
+	*queue = slices.DeleteFunc(*queue, func(each protocol.ParsedMessage) bool {
+		if each.TimestampNs() <= resp.TimestampNs() && match(each.(*Message)) {
+			matched = append(matched, each)
+			return true
+		}
+		return false
+	})
+	return matched
+}
+
+// takeSyncRequest removes the first synchronous request from queue, a
+// client waits for the answer before sending the next one on a channel.
+// The requests before it whose answer was lost are dropped as well.
+func takeSyncRequest(queue *protocol.ParsedMessageQueue, resp *Message) []protocol.ParsedMessage {
+	for i, each := range *queue {
+		req := each.(*Message)
+		if req.TimestampNs() > resp.TimestampNs() {
+			break
+		}
+		if req.isConfirmedPublish() {
+			continue
+		}
+		if req.Method.ClassId == resp.Method.ClassId && slices.Contains(methods[req.Method].responses, resp.Method.MethodId) {
+			*queue = slices.Delete(*queue, i, i+1)
+			*queue = slices.DeleteFunc(*queue, func(other protocol.ParsedMessage) bool {
+				return other.TimestampNs() < req.TimestampNs() && !other.(*Message).isConfirmedPublish()
+			})
+			return []protocol.ParsedMessage{req}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package amqp_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/amqp"
+	"kyanos/agent/protocol/protocoltest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func frame(frameType byte, channel uint16, payload ...[]byte) []byte {
+	body := []byte{}
+	for _, each := range payload {
+		body = append(body, each...)
+	}
+	header := []byte{frameType, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[1:], channel)
+	binary.BigEndian.PutUint32(header[3:], uint32(len(body)))
+	return append(append(header, body...), 0xCE)
+}
+
+func method(channel uint16, m amqp.Method, args ...[]byte) []byte {
+	return frame(1, channel, append([][]byte{short(m.ClassId), short(m.MethodId)}, args...)...)
+}
+
+func contentHeader(channel uint16, bodySize uint64, contentType string) []byte {
+	return frame(2, channel, short(amqp.ClassBasic), short(0), binary.BigEndian.AppendUint64(nil, bodySize),
+		short(1<<15), shortStr(contentType))
+}
+
+func short(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func long(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func longLong(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func shortStr(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func concat(frames ...[]byte) []byte {
+	result := []byte{}
+	for _, each := range frames {
+		result = append(result, each...)
+	}
+	return result
+}
+
+// parseAll parses all frames of data and returns the messages in queues
+// keyed by stream id.
+func TestMatchQueueDeclare(t *testing.T) {
+	parser := amqp.NewAmqpStreamParser()
+
+	reqs := protocoltest.ParseAll(t, parser, concat(
+		[]byte("AMQP\x00\x00\x09\x01"),
+		method(1, amqp.ChannelOpen, shortStr("")),
+		// queue.declare durable
+		method(1, amqp.QueueDeclare, short(0), shortStr("orders"), []byte{0x02}, long(0)),
+		// queue.bind no-wait is not answered
+		method(1, amqp.QueueBind, short(0), shortStr("orders"), shortStr("shop"), shortStr("order.*"), []byte{0x01}, long(0)),
+	), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, concat(
+		method(1, amqp.Method{ClassId: amqp.ClassChannel, MethodId: 11}, long(0)),
+		method(1, amqp.QueueDeclareOk, shortStr("orders"), long(42), long(2)),
+		frame(8, 0),
+	), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 2)
+	assert.Equal(t, amqp.ChannelOpen, records[0].Req.(*amqp.Message).Method)
+	req := records[1].Req.(*amqp.Message)
+	resp := records[1].Resp.(*amqp.Message)
+	assert.Equal(t, amqp.QueueDeclare, req.Method)
+	assert.Equal(t, "orders", req.Queue)
+	assert.Equal(t, uint32(42), resp.MessageCount)
+	assert.Equal(t, uint32(2), resp.ConsumerCount)
+	assert.Equal(t, protocol.SuccessStatus, records[1].ResponseStatus)
+	assert.Empty(t, reqs)
+}
+
+func TestMatchChannelCloseOnError(t *testing.T) {
+	parser := amqp.NewAmqpStreamParser()
+
+	// passive declare of a queue which doesn't exist
+	reqs := protocoltest.ParseAll(t, parser, method(2, amqp.QueueDeclare, short(0), shortStr("missing"), []byte{0x01}, long(0)), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, method(2, amqp.ChannelClose, short(404), shortStr("NOT_FOUND - no queue 'missing'"),
+		short(amqp.ClassQueue), short(10)), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	resp := records[0].Resp.(*amqp.Message)
+	assert.Equal(t, uint16(404), resp.ReplyCode)
+	assert.Equal(t, amqp.QueueDeclare, resp.FailedMethod)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+	assert.Contains(t, resp.FormatToString(), "failed_method=[queue.declare]")
+}
+
+func TestMatchPublisherConfirms(t *testing.T) {
+	parser := amqp.NewAmqpStreamParser()
+
+	body := []byte(`{"id":1}`)
+	publish := func(routingKey string) []byte {
+		return concat(
+			method(1, amqp.BasicPublish, short(0), shortStr("shop"), shortStr(routingKey), []byte{0}),
+			contentHeader(1, uint64(len(body)), "application/json"),
+			frame(3, 1, body[:3]),
+			frame(3, 1, body[3:]),
+		)
+	}
+	reqs := protocoltest.ParseAll(t, parser, concat(
+		// not in confirm mode yet, there is nothing to match
+		publish("order.created"),
+		method(1, amqp.ConfirmSelect, []byte{0}),
+		publish("order.paid"),
+		publish("order.shipped"),
+		publish("order.closed"),
+	), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, concat(
+		method(1, amqp.Method{ClassId: amqp.ClassConfirm, MethodId: 11}),
+		// multiple
+		method(1, amqp.BasicAck, longLong(2), []byte{1}),
+		method(1, amqp.BasicNack, longLong(3), []byte{0}),
+	), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 4)
+	assert.Equal(t, amqp.ConfirmSelect, records[0].Req.(*amqp.Message).Method)
+	routingKeys := []string{}
+	for _, record := range records[1:] {
+		req := record.Req.(*amqp.Message)
+		assert.Equal(t, "shop", req.Exchange)
+		assert.Equal(t, uint64(len(body)), req.BodySize)
+		assert.Equal(t, body, req.Body)
+		assert.Equal(t, "application/json", req.ContentType)
+		routingKeys = append(routingKeys, req.RoutingKey)
+	}
+	assert.ElementsMatch(t, []string{"order.paid", "order.shipped", "order.closed"}, routingKeys)
+	assert.Equal(t, protocol.FailStatus, records[3].ResponseStatus)
+	assert.Empty(t, reqs)
+}
+
+func TestMatchBasicGet(t *testing.T) {
+	parser := amqp.NewAmqpStreamParser()
+
+	reqs := protocoltest.ParseAll(t, parser, concat(
+		method(1, amqp.BasicGet, short(0), shortStr("orders"), []byte{0}),
+		method(1, amqp.BasicGet, short(0), shortStr("orders"), []byte{0}),
+	), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, concat(
+		method(1, amqp.BasicGetOk, longLong(1), []byte{0}, shortStr("shop"), shortStr("order.paid"), long(7)),
+		contentHeader(1, 0, "text/plain"),
+		// a delivery to a consumer answers no request
+		method(1, amqp.BasicDeliver, shortStr("ctag"), longLong(2), []byte{0}, shortStr("shop"), shortStr("order.paid")),
+		contentHeader(1, 2, "text/plain"),
+		frame(3, 1, []byte("hi")),
+		method(1, amqp.BasicGetEmpty, shortStr("")),
+	), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 2)
+	getOk := records[0].Resp.(*amqp.Message)
+	assert.Equal(t, amqp.BasicGetOk, getOk.Method)
+	assert.Equal(t, "order.paid", getOk.RoutingKey)
+	assert.Equal(t, uint32(7), getOk.MessageCount)
+	assert.Equal(t, amqp.BasicGetEmpty, records[1].Resp.(*amqp.Message).Method)
+}
+
+func TestParseInvalid(t *testing.T) {
+	parser := amqp.NewAmqpStreamParser()
+	streamBuffer := buffer.New(1 << 20)
+	// queue.declare-ok is sent by the server
+	streamBuffer.Add(1, method(1, amqp.QueueDeclareOk, shortStr("q"), long(0), long(0)), 1)
+	assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Request).ParseState)
+
+	streamBuffer = buffer.New(1 << 20)
+	data := method(1, amqp.BasicQos, long(0), short(10), []byte{0})
+	data[len(data)-1] = 0
+	streamBuffer.Add(1, data, 1)
+	assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Request).ParseState)
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := amqp.NewAmqpStreamParser()
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, concat([]byte{0xCE, 0x01, 0x02}, method(1, amqp.BasicQos, long(0), short(10), []byte{0})), 1)
+	assert.Equal(t, 3, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+	assert.Equal(t, -1, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+}
+
+func TestFilter(t *testing.T) {
+	parser := amqp.NewAmqpStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, method(1, amqp.QueueBind, short(0), shortStr("orders"), shortStr("shop"), shortStr("order.*"), []byte{0}, long(0)), protocol.Request, 10)
+	req := (*reqs[1])[0]
+
+	assert.True(t, amqp.Filter{}.Filter(req, nil))
+	assert.True(t, amqp.Filter{TargetExchanges: []string{"shop"}, TargetQueues: []string{"orders"}}.Filter(req, nil))
+	assert.True(t, amqp.Filter{TargetRoutingKeys: []string{"order.*"}}.Filter(req, nil))
+	assert.False(t, amqp.Filter{TargetQueues: []string{"payments"}}.Filter(req, nil))
+	assert.True(t, amqp.Filter{TargetQueues: []string{"payments"}}.Filter(req, &amqp.Message{Queue: "payments"}))
+}
@@ -0,0 +1,85 @@
+package amqp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errNotEnoughData = errors.New("not enough data")
+
+// decoder reads the fields of a method frame or a content header, the
+// first error is kept and all reads after it return zero values.
+type decoder struct {
+	buf []byte
+	err error
+	// consecutive bit fields are packed into octets
+	bits    byte
+	bitMask byte
+}
+
+func (d *decoder) take(n int) []byte {
+	d.bitMask = 0
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errNotEnoughData
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) readOctet() byte {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readShort() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) readLong() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) readLongLong() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) readShortStr() string {
+	return string(d.take(int(d.readOctet())))
+}
+
+func (d *decoder) skipTable() {
+	d.take(int(d.readLong()))
+}
+
+func (d *decoder) readBit() bool {
+	if d.bitMask == 0 {
+		bits := d.take(1)
+		if bits == nil {
+			return false
+		}
+		d.bits, d.bitMask = bits[0], 1
+	}
+	set := d.bits&d.bitMask != 0
+	d.bitMask <<= 1
+	return set
+}
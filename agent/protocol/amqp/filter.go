@@ -0,0 +1,56 @@
+package amqp
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+)
+
+type Filter struct {
+	TargetExchanges   []string
+	TargetQueues      []string
+	TargetRoutingKeys []string
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	amqpReq, ok := req.(*Message)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[AMQPFilter] cast to AMQP Message failed: %v\n", req)
+		return false
+	}
+	// basic.get names only the queue, the exchange and the routing key come
+	// with basic.get-ok. Likewise a queue declared with an empty name is
+	// named by queue.declare-ok.
+	amqpResp, _ := resp.(*Message)
+	matches := func(targets []string, field func(*Message) string) bool {
+		if len(targets) == 0 {
+			return true
+		}
+		if slices.Contains(targets, field(amqpReq)) {
+			return true
+		}
+		return amqpResp != nil && slices.Contains(targets, field(amqpResp))
+	}
+	return matches(f.TargetExchanges, func(m *Message) string { return m.Exchange }) &&
+		matches(f.TargetQueues, func(m *Message) string { return m.Queue }) &&
+		matches(f.TargetRoutingKeys, func(m *Message) string { return m.RoutingKey })
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolAMQP
+}
+
+func (f Filter) FilterByRequest() bool {
+	return len(f.TargetExchanges) > 0 || len(f.TargetQueues) > 0 || len(f.TargetRoutingKeys) > 0
+}
+
+func (f Filter) FilterByResponse() bool {
+	return f.FilterByRequest()
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolAMQP
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,308 @@
+package amqp
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See https://www.rabbitmq.com/resources/specs/amqp0-9-1.pdf.
+
+const (
+	kFrameMethod    byte = 1
+	kFrameHeader    byte = 2
+	kFrameBody      byte = 3
+	kFrameHeartbeat byte = 8
+	kFrameEnd       byte = 0xCE
+)
+
+// type(1 byte) + channel(2 bytes) + size(4 bytes)
+const kFrameHeaderLength = 7
+
+// frame_max is negotiated by the client and the server, RabbitMQ uses
+// 128KB by default and refuses more than 1MB.
+const kMaxFrameSize = 16 << 20
+
+// The protocol header sent by the client before the first frame.
+var kProtocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+const (
+	ClassConnection uint16 = 10
+	ClassChannel    uint16 = 20
+	ClassExchange   uint16 = 40
+	ClassQueue      uint16 = 50
+	ClassBasic      uint16 = 60
+	ClassConfirm    uint16 = 85
+	ClassTx         uint16 = 90
+)
+
+type Method struct {
+	ClassId  uint16
+	MethodId uint16
+}
+
+var (
+	ConnectionClose   = Method{ClassConnection, 50}
+	ConnectionCloseOk = Method{ClassConnection, 51}
+	ChannelOpen       = Method{ClassChannel, 10}
+	ChannelClose      = Method{ClassChannel, 40}
+	ChannelCloseOk    = Method{ClassChannel, 41}
+	ExchangeDeclare   = Method{ClassExchange, 10}
+	ExchangeDelete    = Method{ClassExchange, 20}
+	ExchangeBind      = Method{ClassExchange, 30}
+	ExchangeUnbind    = Method{ClassExchange, 40}
+	QueueDeclare      = Method{ClassQueue, 10}
+	QueueDeclareOk    = Method{ClassQueue, 11}
+	QueueBind         = Method{ClassQueue, 20}
+	QueuePurge        = Method{ClassQueue, 30}
+	QueuePurgeOk      = Method{ClassQueue, 31}
+	QueueDelete       = Method{ClassQueue, 40}
+	QueueDeleteOk     = Method{ClassQueue, 41}
+	QueueUnbind       = Method{ClassQueue, 50}
+	BasicQos          = Method{ClassBasic, 10}
+	BasicConsume      = Method{ClassBasic, 20}
+	BasicConsumeOk    = Method{ClassBasic, 21}
+	BasicCancel       = Method{ClassBasic, 30}
+	BasicCancelOk     = Method{ClassBasic, 31}
+	BasicPublish      = Method{ClassBasic, 40}
+	BasicReturn       = Method{ClassBasic, 50}
+	BasicDeliver      = Method{ClassBasic, 60}
+	BasicGet          = Method{ClassBasic, 70}
+	BasicGetOk        = Method{ClassBasic, 71}
+	BasicGetEmpty     = Method{ClassBasic, 72}
+	BasicAck          = Method{ClassBasic, 80}
+	BasicNack         = Method{ClassBasic, 120}
+	ConfirmSelect     = Method{ClassConfirm, 10}
+)
+
+type direction int
+
+const (
+	fromClient direction = 1 << iota
+	fromServer
+)
+
+type methodInfo struct {
+	name      string
+	direction direction
+	// the methods answering a synchronous request
+	responses []uint16
+	// followed by a content header and body frames
+	content bool
+}
+
+var methods = map[Method]methodInfo{
+	{ClassConnection, 10}: {name: "connection.start", direction: fromServer},
+	{ClassConnection, 11}: {name: "connection.start-ok", direction: fromClient},
+	{ClassConnection, 20}: {name: "connection.secure", direction: fromServer},
+	{ClassConnection, 21}: {name: "connection.secure-ok", direction: fromClient},
+	{ClassConnection, 30}: {name: "connection.tune", direction: fromServer},
+	{ClassConnection, 31}: {name: "connection.tune-ok", direction: fromClient},
+	{ClassConnection, 40}: {name: "connection.open", direction: fromClient, responses: []uint16{41}},
+	{ClassConnection, 41}: {name: "connection.open-ok", direction: fromServer},
+	ConnectionClose:       {name: "connection.close", direction: fromClient | fromServer, responses: []uint16{51}},
+	ConnectionCloseOk:     {name: "connection.close-ok", direction: fromClient | fromServer},
+	{ClassConnection, 60}: {name: "connection.blocked", direction: fromServer},
+	{ClassConnection, 61}: {name: "connection.unblocked", direction: fromServer},
+	{ClassConnection, 70}: {name: "connection.update-secret", direction: fromClient, responses: []uint16{71}},
+	{ClassConnection, 71}: {name: "connection.update-secret-ok", direction: fromServer},
+
+	ChannelOpen:        {name: "channel.open", direction: fromClient, responses: []uint16{11}},
+	{ClassChannel, 11}: {name: "channel.open-ok", direction: fromServer},
+	{ClassChannel, 20}: {name: "channel.flow", direction: fromClient | fromServer},
+	{ClassChannel, 21}: {name: "channel.flow-ok", direction: fromClient | fromServer},
+	ChannelClose:       {name: "channel.close", direction: fromClient | fromServer, responses: []uint16{41}},
+	ChannelCloseOk:     {name: "channel.close-ok", direction: fromClient | fromServer},
+
+	ExchangeDeclare:     {name: "exchange.declare", direction: fromClient, responses: []uint16{11}},
+	{ClassExchange, 11}: {name: "exchange.declare-ok", direction: fromServer},
+	ExchangeDelete:      {name: "exchange.delete", direction: fromClient, responses: []uint16{21}},
+	{ClassExchange, 21}: {name: "exchange.delete-ok", direction: fromServer},
+	ExchangeBind:        {name: "exchange.bind", direction: fromClient, responses: []uint16{31}},
+	{ClassExchange, 31}: {name: "exchange.bind-ok", direction: fromServer},
+	ExchangeUnbind:      {name: "exchange.unbind", direction: fromClient, responses: []uint16{51}},
+	{ClassExchange, 51}: {name: "exchange.unbind-ok", direction: fromServer},
+
+	QueueDeclare:     {name: "queue.declare", direction: fromClient, responses: []uint16{11}},
+	QueueDeclareOk:   {name: "queue.declare-ok", direction: fromServer},
+	QueueBind:        {name: "queue.bind", direction: fromClient, responses: []uint16{21}},
+	{ClassQueue, 21}: {name: "queue.bind-ok", direction: fromServer},
+	QueuePurge:       {name: "queue.purge", direction: fromClient, responses: []uint16{31}},
+	QueuePurgeOk:     {name: "queue.purge-ok", direction: fromServer},
+	QueueDelete:      {name: "queue.delete", direction: fromClient, responses: []uint16{41}},
+	QueueDeleteOk:    {name: "queue.delete-ok", direction: fromServer},
+	QueueUnbind:      {name: "queue.unbind", direction: fromClient, responses: []uint16{51}},
+	{ClassQueue, 51}: {name: "queue.unbind-ok", direction: fromServer},
+
+	BasicQos:          {name: "basic.qos", direction: fromClient, responses: []uint16{11}},
+	{ClassBasic, 11}:  {name: "basic.qos-ok", direction: fromServer},
+	BasicConsume:      {name: "basic.consume", direction: fromClient, responses: []uint16{21}},
+	BasicConsumeOk:    {name: "basic.consume-ok", direction: fromServer},
+	BasicCancel:       {name: "basic.cancel", direction: fromClient | fromServer, responses: []uint16{31}},
+	BasicCancelOk:     {name: "basic.cancel-ok", direction: fromClient | fromServer},
+	BasicPublish:      {name: "basic.publish", direction: fromClient, content: true},
+	BasicReturn:       {name: "basic.return", direction: fromServer, content: true},
+	BasicDeliver:      {name: "basic.deliver", direction: fromServer, content: true},
+	BasicGet:          {name: "basic.get", direction: fromClient, responses: []uint16{71, 72}},
+	BasicGetOk:        {name: "basic.get-ok", direction: fromServer, content: true},
+	BasicGetEmpty:     {name: "basic.get-empty", direction: fromServer},
+	BasicAck:          {name: "basic.ack", direction: fromClient | fromServer},
+	{ClassBasic, 90}:  {name: "basic.reject", direction: fromClient},
+	{ClassBasic, 100}: {name: "basic.recover-async", direction: fromClient},
+	{ClassBasic, 110}: {name: "basic.recover", direction: fromClient, responses: []uint16{111}},
+	{ClassBasic, 111}: {name: "basic.recover-ok", direction: fromServer},
+	BasicNack:         {name: "basic.nack", direction: fromClient | fromServer},
+
+	ConfirmSelect:      {name: "confirm.select", direction: fromClient, responses: []uint16{11}},
+	{ClassConfirm, 11}: {name: "confirm.select-ok", direction: fromServer},
+
+	{ClassTx, 10}: {name: "tx.select", direction: fromClient, responses: []uint16{11}},
+	{ClassTx, 11}: {name: "tx.select-ok", direction: fromServer},
+	{ClassTx, 20}: {name: "tx.commit", direction: fromClient, responses: []uint16{21}},
+	{ClassTx, 21}: {name: "tx.commit-ok", direction: fromServer},
+	{ClassTx, 30}: {name: "tx.rollback", direction: fromClient, responses: []uint16{31}},
+	{ClassTx, 31}: {name: "tx.rollback-ok", direction: fromServer},
+}
+
+func (m Method) String() string {
+	if info, ok := methods[m]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("unknown(%d.%d)", m.ClassId, m.MethodId)
+}
+
+// The reply codes of channel.close and connection.close, 200 is a normal
+// close.
+const ReplySuccess uint16 = 200
+
+var _ protocol.ParsedMessage = &Message{}
+var _ protocol.StatusfulMessage = &Message{}
+
+// Message is a method frame, with the content header and body frames which
+// follow basic.publish and basic.get-ok.
+type Message struct {
+	protocol.FrameBase
+	Channel uint16
+	Method  Method
+	// exchange.declare, queue.bind, basic.publish and basic.get-ok
+	Exchange   string
+	RoutingKey string
+	// queue.*, basic.consume, basic.get and queue.declare-ok, which names
+	// the queue declared with an empty name
+	Queue       string
+	ConsumerTag string
+	// basic.get-ok, and the publish sequence number of basic.publish on a
+	// channel in confirm mode, which the server acks with basic.ack
+	DeliveryTag uint64
+	// basic.ack and basic.nack answer all the publishes up to the delivery tag
+	Multiple bool
+	NoWait   bool
+	// channel.close and connection.close, with the method which failed
+	ReplyCode    uint16
+	ReplyText    string
+	FailedMethod Method
+	// queue.declare-ok, queue.purge-ok, queue.delete-ok and basic.get-ok
+	MessageCount  uint32
+	ConsumerCount uint32
+	// content of basic.publish and basic.get-ok
+	BodySize      uint64
+	ContentType   string
+	DeliveryMode  byte
+	CorrelationId string
+	ReplyTo       string
+	MessageId     string
+	// the first kMaxBodyKept bytes of the body
+	Body           []byte
+	headerReceived bool
+	bodyReceived   uint64
+	isReq          bool
+}
+
+// Only the start of large message bodies is kept for display.
+const kMaxBodyKept = 4096
+
+func (m *Message) hasContent() bool {
+	return methods[m.Method].content
+}
+
+func (m *Message) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] channel=[%d] method=[%s]", m.FrameBase.String(), m.Channel, m.Method)
+	if m.Exchange != "" || m.Method == BasicPublish || m.Method == BasicGetOk {
+		fmt.Fprintf(&b, " exchange=[%s]", m.Exchange)
+	}
+	if m.RoutingKey != "" {
+		fmt.Fprintf(&b, " routing_key=[%s]", m.RoutingKey)
+	}
+	if m.Queue != "" {
+		fmt.Fprintf(&b, " queue=[%s]", m.Queue)
+	}
+	if m.ConsumerTag != "" {
+		fmt.Fprintf(&b, " consumer_tag=[%s]", m.ConsumerTag)
+	}
+	if m.DeliveryTag != 0 {
+		fmt.Fprintf(&b, " delivery_tag=[%d]", m.DeliveryTag)
+		if m.Multiple {
+			b.WriteString(" multiple=[true]")
+		}
+	}
+	switch m.Method {
+	case ChannelClose, ConnectionClose:
+		fmt.Fprintf(&b, " reply=[%d %s] failed_method=[%s]", m.ReplyCode, m.ReplyText, m.FailedMethod)
+	case QueueDeclareOk:
+		fmt.Fprintf(&b, " messages=[%d] consumers=[%d]", m.MessageCount, m.ConsumerCount)
+	case QueuePurgeOk, QueueDeleteOk, BasicGetOk:
+		fmt.Fprintf(&b, " messages=[%d]", m.MessageCount)
+	}
+	if m.hasContent() {
+		fmt.Fprintf(&b, " body_size=[%d]", m.BodySize)
+		if m.ContentType != "" {
+			fmt.Fprintf(&b, " content_type=[%s]", m.ContentType)
+		}
+		if m.CorrelationId != "" {
+			fmt.Fprintf(&b, " correlation_id=[%s]", m.CorrelationId)
+		}
+		if m.ReplyTo != "" {
+			fmt.Fprintf(&b, " reply_to=[%s]", m.ReplyTo)
+		}
+		if m.MessageId != "" {
+			fmt.Fprintf(&b, " message_id=[%s]", m.MessageId)
+		}
+		fmt.Fprintf(&b, " body=[%s]", m.Body)
+	}
+	return b.String()
+}
+
+func (m *Message) IsReq() bool {
+	return m.isReq
+}
+
+func (m *Message) StreamId() protocol.StreamId {
+	return protocol.StreamId(m.Channel)
+}
+
+func (m *Message) Status() protocol.ResponseStatus {
+	switch m.Method {
+	case BasicNack:
+		return protocol.FailStatus
+	case ChannelClose, ConnectionClose:
+		if m.ReplyCode != ReplySuccess {
+			return protocol.FailStatus
+		}
+	}
+	return protocol.SuccessStatus
+}
+
+var _ protocol.ProtocolStreamParser = &AmqpStreamParser{}
+
+type contentKey struct {
+	isReq   bool
+	channel uint16
+}
+
+type AmqpStreamParser struct {
+	// messages waiting for their content header and body frames
+	pendingContent map[contentKey]*Message
+	// the last publish sequence number of the channels in confirm mode
+	publishSeqs map[uint16]uint64
+}
@@ -1,7 +1,9 @@
 package protocol
 
 import (
+	"cmp"
 	"kyanos/agent/buffer"
+	"slices"
 )
 
 func matchByTimestamp(reqStream *ParsedMessageQueue, respStream *ParsedMessageQueue) []Record {
@@ -36,6 +38,60 @@ func matchByTimestamp(reqStream *ParsedMessageQueue, respStream *ParsedMessageQu
 	return records
 }
 
+// MatchByStreamId pairs each response with the request of the same stream
+// id, for protocols whose responses carry the id of their request and may
+// be sent out of order. Responses whose request isn't parsed yet are kept
+// for the next call. Messages older than maxWaitNs when newer messages
+// arrive were never matched, like the requests of one-way calls, they are
+// dropped.
+func MatchByStreamId(reqStreams map[StreamId]*ParsedMessageQueue, respStreams map[StreamId]*ParsedMessageQueue, maxWaitNs uint64) []Record {
+	records := []Record{}
+	var latest uint64
+	for streamId, respQueue := range respStreams {
+		reqQueue := reqStreams[streamId]
+		unmatched := (*respQueue)[:0]
+		for _, resp := range *respQueue {
+			latest = max(latest, resp.TimestampNs())
+			if reqQueue == nil || len(*reqQueue) == 0 || (*reqQueue)[0].TimestampNs() > resp.TimestampNs() {
+				unmatched = append(unmatched, resp)
+				continue
+			}
+			record := Record{Req: (*reqQueue)[0], Resp: resp}
+			if status, ok := resp.(StatusfulMessage); ok {
+				record.ResponseStatus = status.Status()
+			}
+			records = append(records, record)
+			*reqQueue = (*reqQueue)[1:]
+		}
+		*respQueue = unmatched
+	}
+	for _, reqQueue := range reqStreams {
+		if len(*reqQueue) > 0 {
+			latest = max(latest, (*reqQueue)[len(*reqQueue)-1].TimestampNs())
+		}
+	}
+	for streamId, reqQueue := range reqStreams {
+		for len(*reqQueue) > 0 && (*reqQueue)[0].TimestampNs()+maxWaitNs < latest {
+			*reqQueue = (*reqQueue)[1:]
+		}
+		if len(*reqQueue) == 0 {
+			delete(reqStreams, streamId)
+		}
+	}
+	for streamId, respQueue := range respStreams {
+		for len(*respQueue) > 0 && (*respQueue)[0].TimestampNs()+maxWaitNs < latest {
+			*respQueue = (*respQueue)[1:]
+		}
+		if len(*respQueue) == 0 {
+			delete(respStreams, streamId)
+		}
+	}
+	slices.SortFunc(records, func(r1, r2 Record) int {
+		return cmp.Compare(r1.Req.TimestampNs(), r2.Req.TimestampNs())
+	})
+	return records
+}
+
 func CreateFrameBase(streamBuffer *buffer.StreamBuffer, readBytes int) (FrameBase, bool) {
 	seq := streamBuffer.Head().LeftBoundary()
 	ts, ok := streamBuffer.FindTimestampBySeq(seq)
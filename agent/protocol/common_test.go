@@ -0,0 +1,62 @@
+package protocol_test
+
+import (
+	"kyanos/agent/protocol"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type idMessage struct {
+	protocol.FrameBase
+	id    protocol.StreamId
+	isReq bool
+}
+
+func (m *idMessage) FormatToString() string      { return "" }
+func (m *idMessage) IsReq() bool                 { return m.isReq }
+func (m *idMessage) StreamId() protocol.StreamId { return m.id }
+
+func queues(messages ...*idMessage) map[protocol.StreamId]*protocol.ParsedMessageQueue {
+	streams := make(map[protocol.StreamId]*protocol.ParsedMessageQueue)
+	for _, m := range messages {
+		if streams[m.id] == nil {
+			streams[m.id] = &protocol.ParsedMessageQueue{}
+		}
+		*streams[m.id] = append(*streams[m.id], m)
+	}
+	return streams
+}
+
+func TestMatchByStreamIdResponseBeforeRequest(t *testing.T) {
+	const maxWaitNs = 1000
+	req := &idMessage{FrameBase: protocol.NewFrameBase(10, 5, 0), id: 7, isReq: true}
+	resp := &idMessage{FrameBase: protocol.NewFrameBase(20, 5, 0), id: 7}
+
+	// the response is parsed one tick before its request
+	reqStreams, respStreams := queues(), queues(resp)
+	assert.Empty(t, protocol.MatchByStreamId(reqStreams, respStreams, maxWaitNs))
+	assert.Len(t, *respStreams[7], 1)
+
+	reqStreams = queues(req)
+	records := protocol.MatchByStreamId(reqStreams, respStreams, maxWaitNs)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, req, records[0].Req)
+		assert.Equal(t, resp, records[0].Resp)
+	}
+	assert.Empty(t, reqStreams)
+	assert.Empty(t, respStreams)
+}
+
+func TestMatchByStreamIdExpiresResponses(t *testing.T) {
+	const maxWaitNs = 1000
+	orphan := &idMessage{FrameBase: protocol.NewFrameBase(10, 5, 0), id: 7}
+	req := &idMessage{FrameBase: protocol.NewFrameBase(5000, 5, 0), id: 8, isReq: true}
+	resp := &idMessage{FrameBase: protocol.NewFrameBase(5010, 5, 0), id: 8}
+
+	respStreams := queues(orphan, resp)
+	records := protocol.MatchByStreamId(queues(req), respStreams, maxWaitNs)
+	assert.Len(t, records, 1)
+	// the request of the orphan response was never seen
+	assert.Empty(t, respStreams)
+}
@@ -0,0 +1,511 @@
+package cql
+
+import (
+	"cmp"
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolCQL] = func() protocol.ProtocolStreamParser {
+		return NewCQLStreamParser()
+	}
+}
+
+func NewCQLStreamParser() *CQLStreamParser {
+	return &CQLStreamParser{
+		State: &State{
+			PreparedStatements: make(map[string]*PreparedStatement),
+		},
+	}
+}
+
+type frameHeader struct {
+	version  byte
+	flags    byte
+	streamId int16
+	opcode   Opcode
+	length   int
+	isReq    bool
+}
+
+// parseFrameHeader returns false if buf doesn't start with a valid frame
+// header of messageType, which may be protocol.Unknown.
+func parseFrameHeader(buf []byte, messageType protocol.MessageType) (frameHeader, bool) {
+	header := frameHeader{
+		version:  buf[0] &^ kResponseVersionBit,
+		flags:    buf[1],
+		streamId: int16(binary.BigEndian.Uint16(buf[2:4])),
+		opcode:   Opcode(buf[4]),
+		length:   int(binary.BigEndian.Uint32(buf[5:9])),
+		isReq:    buf[0]&kResponseVersionBit == 0,
+	}
+	if header.version < kMinVersion || header.version > kMaxVersion {
+		return header, false
+	}
+	if messageType != protocol.Unknown && header.isReq != (messageType == protocol.Request) {
+		return header, false
+	}
+	if header.isReq && !isRequestOpcode(header.opcode) || !header.isReq && !isResponseOpcode(header.opcode) {
+		return header, false
+	}
+	if header.flags&^(kFlagCompression|kFlagTracing|kFlagCustomPayload|kFlagWarning|kFlagUseBeta) != 0 {
+		return header, false
+	}
+	return header, header.length <= kMaxFrameLength
+}
+
+func (p *CQLStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	if segment, ok := parseSegmentHeader(buf); ok {
+		return p.parseSegment(streamBuffer, buf, segment, messageType)
+	}
+	if len(buf) < kFrameHeaderLength {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	header, ok := parseFrameHeader(buf, messageType)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	readBytes := kFrameHeaderLength + header.length
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	if header.streamId == kEventStreamId && header.opcode == OpEvent {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[CQL] failed to create FrameBase for %s", header.opcode)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{decodeFrame(fb, header, buf[kFrameHeaderLength:readBytes])},
+	}
+}
+
+// parseSegment parses the frames of a v5 segment. Frames split across
+// segments and compressed segments are skipped.
+func (p *CQLStreamParser) parseSegment(streamBuffer *buffer.StreamBuffer, buf []byte, segment segmentHeader, messageType protocol.MessageType) protocol.ParseResult {
+	readBytes := segment.headerLength + segment.payloadLength + kSegmentTrailerLength
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	if segment.compressed || !segment.selfContained {
+		common.ProtocolParserLog.Debugf("[CQL] skip segment compressed=%v selfContained=%v", segment.compressed, segment.selfContained)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	payload := buf[segment.headerLength : segment.headerLength+segment.payloadLength]
+	messages := []protocol.ParsedMessage{}
+	for offset := 0; offset < len(payload); {
+		frame := payload[offset:]
+		if len(frame) < kFrameHeaderLength {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		header, ok := parseFrameHeader(frame, messageType)
+		if !ok || len(frame) < kFrameHeaderLength+header.length {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		frameLength := kFrameHeaderLength + header.length
+		// the first and last frame carry the wire size of the segment framing
+		byteSize := frameLength
+		if offset == 0 {
+			byteSize += segment.headerLength
+		}
+		if offset+frameLength == len(payload) {
+			byteSize += kSegmentTrailerLength
+		}
+		if header.streamId != kEventStreamId || header.opcode != OpEvent {
+			frameBase := protocol.NewFrameBase(fb.TimestampNs(), byteSize, fb.Seq()+uint64(segment.headerLength+offset))
+			messages = append(messages, decodeFrame(frameBase, header, frame[kFrameHeaderLength:frameLength]))
+		}
+		offset += frameLength
+	}
+	if len(messages) == 0 {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: messages,
+	}
+}
+
+func decodeFrame(fb protocol.FrameBase, header frameHeader, body []byte) protocol.ParsedMessage {
+	d := &decoder{buf: body}
+	compressed := header.flags&kFlagCompression != 0
+	if header.isReq {
+		req := &Request{
+			FrameBase:  fb,
+			Version:    header.version,
+			Opcode:     header.opcode,
+			streamId:   header.streamId,
+			Compressed: compressed,
+		}
+		if !compressed {
+			if header.flags&kFlagCustomPayload != 0 {
+				d.skipBytesMap()
+			}
+			decodeRequest(d, req)
+			if d.err != nil {
+				common.ProtocolParserLog.Debugf("[CQL] decode %s failed: %v", req.Opcode, d.err)
+			}
+		}
+		return req
+	}
+	resp := &Response{
+		FrameBase:  fb,
+		Version:    header.version,
+		Opcode:     header.opcode,
+		streamId:   header.streamId,
+		Compressed: compressed,
+	}
+	if !compressed {
+		if header.flags&kFlagTracing != 0 {
+			// tracing session id
+			d.take(16)
+		}
+		if header.flags&kFlagWarning != 0 {
+			resp.Warnings = d.readStringList()
+		}
+		if header.flags&kFlagCustomPayload != 0 {
+			d.skipBytesMap()
+		}
+		decodeResponse(d, resp)
+		if d.err != nil {
+			common.ProtocolParserLog.Debugf("[CQL] decode %s failed: %v", resp.Opcode, d.err)
+		}
+	}
+	return resp
+}
+
+// Flags of the query parameters, 1 byte before v5 and 4 bytes since.
+const (
+	kQueryFlagValues            int32 = 0x01
+	kQueryFlagPageSize          int32 = 0x04
+	kQueryFlagPagingState       int32 = 0x08
+	kQueryFlagSerialConsistency int32 = 0x10
+	kQueryFlagTimestamp         int32 = 0x20
+	kQueryFlagNamesForValues    int32 = 0x40
+	kQueryFlagKeyspace          int32 = 0x80
+)
+
+func readQueryFlags(d *decoder, version byte) int32 {
+	if version >= 5 {
+		return d.readInt()
+	}
+	return int32(d.readByte())
+}
+
+func decodeQueryParameters(d *decoder, req *Request) {
+	req.Consistency = consistencyName(d.readShort())
+	flags := readQueryFlags(d, req.Version)
+	if flags&kQueryFlagValues != 0 {
+		n := int(d.readShort())
+		for i := 0; i < n && d.err == nil; i++ {
+			if flags&kQueryFlagNamesForValues != 0 {
+				d.readString()
+			}
+			d.skipValue()
+		}
+		req.BoundValues = n
+	}
+	if flags&kQueryFlagPageSize != 0 {
+		d.readInt()
+	}
+	if flags&kQueryFlagPagingState != 0 {
+		d.readBytes()
+	}
+	if flags&kQueryFlagSerialConsistency != 0 {
+		d.readShort()
+	}
+	if flags&kQueryFlagTimestamp != 0 {
+		d.readLong()
+	}
+	if flags&kQueryFlagKeyspace != 0 && req.Version >= 5 {
+		if keyspace := d.readString(); d.err == nil {
+			req.Keyspace = keyspace
+		}
+	}
+}
+
+const (
+	kBatchQueryKindString   byte = 0
+	kBatchQueryKindPrepared byte = 1
+)
+
+func decodeRequest(d *decoder, req *Request) {
+	switch req.Opcode {
+	case OpQuery:
+		req.Query = d.readLongString()
+		req.Keyspace, req.Table = tableOf(req.Query)
+		decodeQueryParameters(d, req)
+	case OpPrepare:
+		req.Query = d.readLongString()
+		req.Keyspace, req.Table = tableOf(req.Query)
+		if req.Version >= 5 && d.readInt()&0x01 != 0 {
+			req.Keyspace = d.readString()
+		}
+	case OpExecute:
+		req.PreparedId = d.readShortBytesHex()
+		if req.Version >= 5 {
+			// result metadata id
+			d.readShortBytes()
+		}
+		decodeQueryParameters(d, req)
+	case OpBatch:
+		req.BatchType = batchTypeNames[d.readByte()]
+		n := int(d.readShort())
+		queries := make([]string, 0, min(n, len(d.buf)))
+		for i := 0; i < n && d.err == nil; i++ {
+			switch d.readByte() {
+			case kBatchQueryKindString:
+				queries = append(queries, d.readLongString())
+			case kBatchQueryKindPrepared:
+				// resolved with the prepared statements of the connection in Match
+				queries = append(queries, kPreparedIdPrefix+d.readShortBytesHex())
+			default:
+				d.err = errInvalidData
+			}
+			values := int(d.readShort())
+			for j := 0; j < values && d.err == nil; j++ {
+				d.skipValue()
+			}
+			req.BoundValues += values
+		}
+		req.BatchSize = n
+		req.Query = strings.Join(queries, "; ")
+		if len(queries) > 0 {
+			req.Keyspace, req.Table = tableOf(queries[0])
+		}
+		req.Consistency = consistencyName(d.readShort())
+	}
+}
+
+// Flags of the metadata of Rows and Prepared results.
+const (
+	kMetadataFlagGlobalTablesSpec int32 = 0x0001
+	kMetadataFlagHasMorePages     int32 = 0x0002
+	kMetadataFlagNoMetadata       int32 = 0x0004
+	kMetadataFlagMetadataChanged  int32 = 0x0008
+)
+
+// decodeMetadata decodes the metadata of a Rows result, or the bound
+// variables of a Prepared result which also carry the partition key indexes.
+func decodeMetadata(d *decoder, resp *Response, prepared bool) {
+	flags := d.readInt()
+	columns := int(d.readInt())
+	if prepared && resp.Version >= 4 {
+		pkCount := int(d.readInt())
+		d.take(pkCount * 2)
+	}
+	if !prepared && flags&kMetadataFlagHasMorePages != 0 {
+		// paging state
+		d.readBytes()
+	}
+	if !prepared && flags&kMetadataFlagMetadataChanged != 0 {
+		// new metadata id
+		d.readShortBytes()
+	}
+	if flags&kMetadataFlagNoMetadata != 0 || d.err != nil {
+		return
+	}
+	if flags&kMetadataFlagGlobalTablesSpec != 0 {
+		resp.Keyspace = d.readString()
+		resp.Table = d.readString()
+	}
+	resp.Columns = make([]string, 0, min(columns, len(d.buf)))
+	for i := 0; i < columns && d.err == nil; i++ {
+		if flags&kMetadataFlagGlobalTablesSpec == 0 {
+			keyspace, table := d.readString(), d.readString()
+			if i == 0 {
+				resp.Keyspace, resp.Table = keyspace, table
+			}
+		}
+		resp.Columns = append(resp.Columns, d.readString())
+		d.skipOption(0)
+	}
+}
+
+func decodeResponse(d *decoder, resp *Response) {
+	switch resp.Opcode {
+	case OpError:
+		resp.ErrorCode = d.readInt()
+		resp.ErrorMessage = d.readString()
+	case OpResult:
+		resp.ResultKind = ResultKind(d.readInt())
+		switch resp.ResultKind {
+		case ResultRows:
+			decodeMetadata(d, resp, false)
+			resp.Rows = d.readInt()
+		case ResultSetKeyspace:
+			resp.Keyspace = d.readString()
+		case ResultPrepared:
+			resp.PreparedId = d.readShortBytesHex()
+			if resp.Version >= 5 {
+				// result metadata id
+				d.readShortBytes()
+			}
+			decodeMetadata(d, resp, true)
+			// the bound variables may not be columns of the table, like
+			// the limit of a select, don't show them
+			resp.Columns = nil
+		case ResultSchemaChange:
+			changeType, target := d.readString(), d.readString()
+			resp.Keyspace = d.readString()
+			if target != "KEYSPACE" {
+				resp.Table = d.readString()
+			}
+			resp.SchemaChange = strings.TrimSpace(changeType + " " + target + " " + joinTable(resp.Keyspace, resp.Table))
+		}
+	}
+}
+
+// [keyspace.]table of the statements which work on a single table.
+var tableRegex = regexp.MustCompile(`(?is)^\s*(?:SELECT\s.*?\sFROM|INSERT\s+INTO|UPDATE|DELETE\s(?:.*?\s)?FROM|TRUNCATE(?:\s+TABLE)?|(?:CREATE|ALTER|DROP)\s+TABLE(?:\s+IF\s+(?:NOT\s+)?EXISTS)?)\s+("(?:[^"]|"")+"|\w+)(?:\s*\.\s*("(?:[^"]|"")+"|\w+))?`)
+var useRegex = regexp.MustCompile(`(?is)^\s*USE\s+("(?:[^"]|"")+"|\w+)`)
+
+// identifier unquotes a quoted identifier, unquoted ones are case
+// insensitive and stored in lower case by Cassandra.
+func identifier(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+	}
+	return strings.ToLower(s)
+}
+
+func tableOf(query string) (keyspace string, table string) {
+	if match := tableRegex.FindStringSubmatch(query); match != nil {
+		if match[2] == "" {
+			return "", identifier(match[1])
+		}
+		return identifier(match[1]), identifier(match[2])
+	}
+	if match := useRegex.FindStringSubmatch(query); match != nil {
+		return identifier(match[1]), ""
+	}
+	return "", ""
+}
+
+// FindBoundary looks for a valid frame header which is either the last
+// frame in the buffer or followed by another valid frame header.
+func (p *CQLStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i+kFrameHeaderLength <= len(buf); i++ {
+		if _, ok := parseSegmentHeader(buf[i:]); ok {
+			return i
+		}
+		header, ok := parseFrameHeader(buf[i:], messageType)
+		if !ok {
+			continue
+		}
+		next := i + kFrameHeaderLength + header.length
+		if next == len(buf) {
+			return i
+		}
+		if next+kFrameHeaderLength <= len(buf) {
+			if _, ok := parseFrameHeader(buf[next:], messageType); ok {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Match pairs the requests and responses of the same stream id by
+// timestamp, a client reuses a stream id only after the previous request
+// on it was answered.
+func (p *CQLStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	records := []protocol.Record{}
+	for streamId, respQueue := range respStreams {
+		reqQueue := reqStreams[streamId]
+		for len(*respQueue) > 0 {
+			resp := (*respQueue)[0]
+			if reqQueue == nil || len(*reqQueue) == 0 || (*reqQueue)[0].TimestampNs() > resp.TimestampNs() {
+				common.ProtocolParserLog.Debugf("[CQL] no request found for stream %d", streamId)
+				*respQueue = (*respQueue)[1:]
+				continue
+			}
+			// requests on the stream before the one answered were lost
+			for len(*reqQueue) > 1 && (*reqQueue)[1].TimestampNs() < resp.TimestampNs() {
+				*reqQueue = (*reqQueue)[1:]
+			}
+			records = append(records, protocol.Record{
+				Req:            (*reqQueue)[0],
+				Resp:           resp,
+				ResponseStatus: resp.(*Response).Status(),
+			})
+			*reqQueue = (*reqQueue)[1:]
+			*respQueue = (*respQueue)[1:]
+		}
+		delete(respStreams, streamId)
+		if reqQueue != nil && len(*reqQueue) == 0 {
+			delete(reqStreams, streamId)
+		}
+	}
+	// a PREPARE or USE must be applied before the requests after it
+	slices.SortFunc(records, func(r1, r2 protocol.Record) int {
+		return cmp.Compare(r1.Req.TimestampNs(), r2.Req.TimestampNs())
+	})
+	for _, record := range records {
+		p.apply(record.Req.(*Request), record.Resp.(*Response))
+	}
+	return records
+}
+
+// kPreparedIdPrefix marks a prepared statement of a BATCH whose CQL text is
+// not known yet.
+const kPreparedIdPrefix = "EXECUTE "
+
+// apply resolves the prepared statements of req with the state of the
+// connection, and updates the state with resp.
+func (p *CQLStreamParser) apply(req *Request, resp *Response) {
+	switch req.Opcode {
+	case OpExecute:
+		if prepared, ok := p.PreparedStatements[req.PreparedId]; ok {
+			req.Query, req.Keyspace, req.Table = prepared.Query, prepared.Keyspace, prepared.Table
+		}
+	case OpBatch:
+		queries := strings.Split(req.Query, "; ")
+		for i, query := range queries {
+			if prepared, ok := p.PreparedStatements[strings.TrimPrefix(query, kPreparedIdPrefix)]; ok && strings.HasPrefix(query, kPreparedIdPrefix) {
+				queries[i] = prepared.Query
+				if i == 0 {
+					req.Keyspace, req.Table = prepared.Keyspace, prepared.Table
+				}
+			}
+		}
+		req.Query = strings.Join(queries, "; ")
+	}
+	if req.Keyspace == "" && req.Table != "" {
+		req.Keyspace = p.Keyspace
+	}
+
+	if resp.Opcode != OpResult {
+		return
+	}
+	switch resp.ResultKind {
+	case ResultSetKeyspace:
+		p.Keyspace = resp.Keyspace
+	case ResultPrepared:
+		if req.Opcode == OpPrepare {
+			prepared := &PreparedStatement{Query: req.Query, Keyspace: req.Keyspace, Table: req.Table}
+			if resp.Table != "" {
+				prepared.Keyspace, prepared.Table = resp.Keyspace, resp.Table
+			}
+			p.PreparedStatements[resp.PreparedId] = prepared
+		}
+	}
+}
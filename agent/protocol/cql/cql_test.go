@@ -0,0 +1,277 @@
+package cql_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/cql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func frame(version byte, flags byte, stream int16, opcode cql.Opcode, body ...[]byte) []byte {
+	payload := []byte{}
+	for _, each := range body {
+		payload = append(payload, each...)
+	}
+	header := []byte{version, flags, 0, 0, byte(opcode), 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(stream))
+	binary.BigEndian.PutUint32(header[5:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func short(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func int32Bytes(v int32) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(v))
+}
+
+func str(s string) []byte {
+	return append(short(uint16(len(s))), s...)
+}
+
+func longString(s string) []byte {
+	return append(int32Bytes(int32(len(s))), s...)
+}
+
+func value(s string) []byte {
+	return append(int32Bytes(int32(len(s))), s...)
+}
+
+func shortBytes(b []byte) []byte {
+	return append(short(uint16(len(b))), b...)
+}
+
+func parse(t *testing.T, parser protocol.ProtocolStreamParser, data []byte, messageType protocol.MessageType, ts uint64) protocol.ParseResult {
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, ts)
+	return parser.ParseStream(streamBuffer, messageType)
+}
+
+func queue(messages ...protocol.ParsedMessage) map[protocol.StreamId]*protocol.ParsedMessageQueue {
+	queues := make(map[protocol.StreamId]*protocol.ParsedMessageQueue)
+	for _, message := range messages {
+		if queues[message.StreamId()] == nil {
+			queues[message.StreamId()] = &protocol.ParsedMessageQueue{}
+		}
+		*queues[message.StreamId()] = append(*queues[message.StreamId()], message)
+	}
+	return queues
+}
+
+var (
+	// QUERY with consistency LOCAL_QUORUM, flags values|page size, 2 values and a page size
+	kQueryFrame = frame(0x04, 0, 3, cql.OpQuery,
+		longString("SELECT name FROM shop.users WHERE id = ? AND age > ?"),
+		short(0x0006), []byte{0x05}, short(2), value("42"), int32Bytes(-1), int32Bytes(100))
+	kRowsFrame = frame(0x84, 0, 3, cql.OpResult, int32Bytes(int32(cql.ResultRows)),
+		int32Bytes(0x0001), int32Bytes(1), str("shop"), str("users"), str("name"), short(0x000D),
+		int32Bytes(2), value("alice"), value("bob"))
+	kErrorFrame = frame(0x84, 0, 4, cql.OpError, int32Bytes(0x2200), str("unconfigured table orders"))
+)
+
+func TestParseQueryAndRows(t *testing.T) {
+	parser := cql.NewCQLStreamParser()
+
+	result := parse(t, parser, kQueryFrame, protocol.Request, 10)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	assert.Equal(t, len(kQueryFrame), result.ReadBytes)
+	req := result.ParsedMessages[0].(*cql.Request)
+	assert.Equal(t, cql.OpQuery, req.Opcode)
+	assert.Equal(t, protocol.StreamId(3), req.StreamId())
+	assert.Equal(t, "LOCAL_QUORUM", req.Consistency)
+	assert.Equal(t, 2, req.BoundValues)
+	assert.Equal(t, "shop", req.Keyspace)
+	assert.Equal(t, "users", req.Table)
+
+	result = parse(t, parser, kRowsFrame, protocol.Response, 20)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	resp := result.ParsedMessages[0].(*cql.Response)
+	assert.Equal(t, cql.ResultRows, resp.ResultKind)
+	assert.Equal(t, []string{"name"}, resp.Columns)
+	assert.Equal(t, "users", resp.Table)
+	assert.Equal(t, int32(2), resp.Rows)
+	assert.Equal(t, protocol.SuccessStatus, resp.Status())
+
+	// the role of the connection is not known yet
+	result = parse(t, parser, kRowsFrame, protocol.Unknown, 20)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	assert.False(t, result.ParsedMessages[0].IsReq())
+
+	result = parse(t, parser, kRowsFrame, protocol.Request, 20)
+	assert.Equal(t, protocol.Invalid, result.ParseState)
+	result = parse(t, parser, kRowsFrame[:len(kRowsFrame)-1], protocol.Response, 20)
+	assert.Equal(t, protocol.NeedsMoreData, result.ParseState)
+}
+
+func TestParseError(t *testing.T) {
+	result := parse(t, cql.NewCQLStreamParser(), kErrorFrame, protocol.Response, 20)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	resp := result.ParsedMessages[0].(*cql.Response)
+	assert.Equal(t, int32(0x2200), resp.ErrorCode)
+	assert.Equal(t, "unconfigured table orders", resp.ErrorMessage)
+	assert.Equal(t, protocol.FailStatus, resp.Status())
+	assert.Contains(t, resp.FormatToString(), "0x2200 Invalid: unconfigured table orders")
+}
+
+func TestMatchByStreamId(t *testing.T) {
+	parser := cql.NewCQLStreamParser()
+	req3 := parse(t, parser, kQueryFrame, protocol.Request, 10).ParsedMessages[0]
+	req4 := parse(t, parser, frame(0x04, 0, 4, cql.OpQuery, longString("SELECT * FROM orders"), short(1), []byte{0}), protocol.Request, 11).ParsedMessages[0]
+	resp4 := parse(t, parser, kErrorFrame, protocol.Response, 15).ParsedMessages[0]
+	resp3 := parse(t, parser, kRowsFrame, protocol.Response, 20).ParsedMessages[0]
+
+	// responses of multiplexed streams come back out of order
+	reqStreams, respStreams := queue(req3, req4), queue(resp4)
+	records := parser.Match(reqStreams, respStreams)
+	assert.Len(t, records, 1)
+	assert.Equal(t, req4, records[0].Req)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+
+	respStreams = queue(resp3)
+	records = parser.Match(reqStreams, respStreams)
+	assert.Len(t, records, 1)
+	assert.Equal(t, req3, records[0].Req)
+	assert.Empty(t, reqStreams)
+	assert.Empty(t, respStreams)
+}
+
+func TestExecuteUsesPreparedStatement(t *testing.T) {
+	parser := cql.NewCQLStreamParser()
+	id := []byte{0xca, 0xfe}
+	prepare := parse(t, parser, frame(0x04, 0, 1, cql.OpPrepare, longString("INSERT INTO orders (id, total) VALUES (?, ?)")), protocol.Request, 10).ParsedMessages[0]
+	// prepared metadata: flags global spec, 2 columns, 1 partition key
+	prepared := parse(t, parser, frame(0x84, 0, 1, cql.OpResult, int32Bytes(int32(cql.ResultPrepared)), shortBytes(id),
+		int32Bytes(0x0001), int32Bytes(2), int32Bytes(1), short(0), str("shop"), str("orders"),
+		str("id"), short(0x0009), str("total"), short(0x0009),
+		int32Bytes(0x0004), int32Bytes(0)), protocol.Response, 11).ParsedMessages[0]
+	execute := parse(t, parser, frame(0x04, 0, 2, cql.OpExecute, shortBytes(id), short(0x0001), []byte{0x01}, short(2), value("1"), value("10")), protocol.Request, 12).ParsedMessages[0]
+	void := parse(t, parser, frame(0x84, 0, 2, cql.OpResult, int32Bytes(int32(cql.ResultVoid))), protocol.Response, 13).ParsedMessages[0]
+	batch := parse(t, parser, frame(0x04, 0, 3, cql.OpBatch, []byte{1}, short(2),
+		[]byte{1}, shortBytes(id), short(2), value("2"), value("20"),
+		[]byte{0}, longString("UPDATE shop.stats SET n = n + 1 WHERE k = 'orders'"), short(0),
+		short(0x0004), []byte{0}), protocol.Request, 14).ParsedMessages[0]
+	batchVoid := parse(t, parser, frame(0x84, 0, 3, cql.OpResult, int32Bytes(int32(cql.ResultVoid))), protocol.Response, 15).ParsedMessages[0]
+
+	records := parser.Match(queue(prepare, execute, batch), queue(prepared, void, batchVoid))
+	assert.Len(t, records, 3)
+	req := records[1].Req.(*cql.Request)
+	assert.Equal(t, cql.OpExecute, req.Opcode)
+	assert.Equal(t, "cafe", req.PreparedId)
+	assert.Equal(t, "INSERT INTO orders (id, total) VALUES (?, ?)", req.Query)
+	assert.Equal(t, "ONE", req.Consistency)
+	assert.Equal(t, 2, req.BoundValues)
+	assert.Equal(t, "shop", req.Keyspace)
+	assert.Equal(t, "orders", req.Table)
+
+	req = records[2].Req.(*cql.Request)
+	assert.Equal(t, "UNLOGGED", req.BatchType)
+	assert.Equal(t, 2, req.BatchSize)
+	assert.Equal(t, 2, req.BoundValues)
+	assert.Equal(t, "QUORUM", req.Consistency)
+	assert.Equal(t, "INSERT INTO orders (id, total) VALUES (?, ?); UPDATE shop.stats SET n = n + 1 WHERE k = 'orders'", req.Query)
+	assert.Equal(t, "INSERT INTO orders (id, total) VALUES (?, ?); UPDATE shop.stats SET n = n + ? WHERE k = ?", req.QueryDigest())
+}
+
+func TestUseSetsKeyspace(t *testing.T) {
+	parser := cql.NewCQLStreamParser()
+	use := parse(t, parser, frame(0x03, 0, 1, cql.OpQuery, longString(`USE "Shop"`), short(1), []byte{0}), protocol.Request, 10).ParsedMessages[0]
+	setKeyspace := parse(t, parser, frame(0x83, 0, 1, cql.OpResult, int32Bytes(int32(cql.ResultSetKeyspace)), str("Shop")), protocol.Response, 11).ParsedMessages[0]
+	query := parse(t, parser, frame(0x03, 0, 1, cql.OpQuery, longString("select * from Users where id = 1"), short(1), []byte{0}), protocol.Request, 12).ParsedMessages[0]
+	// rows without metadata
+	rows := parse(t, parser, frame(0x83, 0, 1, cql.OpResult, int32Bytes(int32(cql.ResultRows)), int32Bytes(0x0004), int32Bytes(1), int32Bytes(0)), protocol.Response, 13).ParsedMessages[0]
+
+	records := parser.Match(queue(use, query), queue(setKeyspace))
+	assert.Len(t, records, 1)
+	assert.Equal(t, "Shop", records[0].Req.(*cql.Request).Keyspace)
+	records = parser.Match(queue(query), queue(rows))
+	assert.Len(t, records, 1)
+	req := records[0].Req.(*cql.Request)
+	assert.Equal(t, "Shop", req.Keyspace)
+	assert.Equal(t, "users", req.Table)
+}
+
+func TestIgnoreEvents(t *testing.T) {
+	event := frame(0x84, 0, -1, cql.OpEvent, str("STATUS_CHANGE"), str("UP"))
+	result := parse(t, cql.NewCQLStreamParser(), event, protocol.Response, 10)
+	assert.Equal(t, protocol.Ignore, result.ParseState)
+	assert.Equal(t, len(event), result.ReadBytes)
+}
+
+// segment wraps frames in an uncompressed self contained v5 segment.
+func segment(frames ...[]byte) []byte {
+	payload := []byte{}
+	for _, each := range frames {
+		payload = append(payload, each...)
+	}
+	header := uint64(len(payload)) | 1<<17
+	crc := crc24(header, 3)
+	data := []byte{byte(header), byte(header >> 8), byte(header >> 16), byte(crc), byte(crc >> 8), byte(crc >> 16)}
+	data = append(data, payload...)
+	// the CRC32 of the payload is not checked
+	return append(data, 0, 0, 0, 0)
+}
+
+func crc24(header uint64, n int) uint32 {
+	crc := uint32(0x875060)
+	for i := 0; i < n; i++ {
+		crc ^= uint32(header&0xff) << 16
+		header >>= 8
+		for j := 0; j < 8; j++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1974F0B
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+func TestParseV5Segment(t *testing.T) {
+	parser := cql.NewCQLStreamParser()
+	query1 := frame(0x05, 0, 1, cql.OpQuery, longString("SELECT * FROM shop.users"), short(1), int32Bytes(0))
+	// v5 query parameters with the keyspace flag
+	query2 := frame(0x05, 0, 2, cql.OpQuery, longString("SELECT * FROM orders"), short(1), int32Bytes(0x80), str("shop"))
+	data := segment(query1, query2)
+
+	result := parse(t, parser, data, protocol.Request, 10)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	assert.Equal(t, len(data), result.ReadBytes)
+	assert.Len(t, result.ParsedMessages, 2)
+	assert.Equal(t, len(data), result.ParsedMessages[0].ByteSize()+result.ParsedMessages[1].ByteSize())
+	req := result.ParsedMessages[1].(*cql.Request)
+	assert.Equal(t, protocol.StreamId(2), req.StreamId())
+	assert.Equal(t, "shop", req.Keyspace)
+	assert.Equal(t, "orders", req.Table)
+
+	result = parse(t, parser, data[:len(data)-1], protocol.Request, 10)
+	assert.Equal(t, protocol.NeedsMoreData, result.ParseState)
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := cql.NewCQLStreamParser()
+	data := append([]byte("garbage"), kQueryFrame...)
+	data = append(data, kQueryFrame...)
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 10)
+	assert.Equal(t, 7, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+	assert.Equal(t, 7+len(kQueryFrame), parser.FindBoundary(streamBuffer, protocol.Request, 8))
+	assert.Equal(t, -1, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+}
+
+func TestFilter(t *testing.T) {
+	parser := cql.NewCQLStreamParser()
+	req := parse(t, parser, kQueryFrame, protocol.Request, 10).ParsedMessages[0]
+	resp := parse(t, parser, kRowsFrame, protocol.Response, 20).ParsedMessages[0]
+
+	assert.True(t, cql.Filter{TargetKeyspaces: []string{"shop"}}.Filter(req, resp))
+	assert.False(t, cql.Filter{TargetKeyspaces: []string{"system"}}.Filter(req, resp))
+	assert.True(t, cql.Filter{TargetTables: []string{"users"}}.Filter(req, resp))
+	assert.True(t, cql.Filter{TargetTables: []string{"shop.users"}}.Filter(req, resp))
+	assert.False(t, cql.Filter{TargetTables: []string{"orders"}}.Filter(req, resp))
+	assert.True(t, cql.Filter{TargetConsistencies: []string{"local_quorum"}}.Filter(req, resp))
+	assert.False(t, cql.Filter{TargetConsistencies: []string{"ONE"}, TargetKeyspaces: []string{"shop"}}.Filter(req, resp))
+}
@@ -0,0 +1,161 @@
+package cql
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+var errNotEnoughData = errors.New("not enough data")
+var errInvalidData = errors.New("invalid data")
+
+// decoder reads the notations of section 3 of the spec from a frame body,
+// the first error is kept and all reads after it return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 {
+		d.err = errInvalidData
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errNotEnoughData
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) readByte() byte {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readShort() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) readInt() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) readLong() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) readString() string {
+	return string(d.take(int(d.readShort())))
+}
+
+func (d *decoder) readLongString() string {
+	return string(d.take(int(d.readInt())))
+}
+
+func (d *decoder) readStringList() []string {
+	n := int(d.readShort())
+	list := make([]string, 0, min(n, len(d.buf)/2))
+	for i := 0; i < n && d.err == nil; i++ {
+		list = append(list, d.readString())
+	}
+	return list
+}
+
+// readBytes returns nil for a null value, a negative length other than -1
+// is only valid for a [value].
+func (d *decoder) readBytes() []byte {
+	n := d.readInt()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+func (d *decoder) readShortBytes() []byte {
+	return d.take(int(d.readShort()))
+}
+
+func (d *decoder) readShortBytesHex() string {
+	return hex.EncodeToString(d.readShortBytes())
+}
+
+// skipValue skips a [value], -1 is null and -2 is "not set" since v4.
+func (d *decoder) skipValue() {
+	n := d.readInt()
+	if n >= 0 {
+		d.take(int(n))
+	} else if n != -1 && n != -2 {
+		d.err = errInvalidData
+	}
+}
+
+func (d *decoder) skipBytesMap() {
+	n := int(d.readShort())
+	for i := 0; i < n && d.err == nil; i++ {
+		d.readString()
+		d.readBytes()
+	}
+}
+
+// Data types of the [option] of a column spec.
+const (
+	kTypeCustom uint16 = 0x0000
+	kTypeList   uint16 = 0x0020
+	kTypeMap    uint16 = 0x0021
+	kTypeSet    uint16 = 0x0022
+	kTypeUDT    uint16 = 0x0030
+	kTypeTuple  uint16 = 0x0031
+)
+
+// Types nest only a few levels in practice, deeper ones are garbage.
+const kMaxTypeDepth = 16
+
+func (d *decoder) skipOption(depth int) {
+	if depth > kMaxTypeDepth {
+		d.err = errInvalidData
+		return
+	}
+	switch d.readShort() {
+	case kTypeCustom:
+		d.readString()
+	case kTypeList, kTypeSet:
+		d.skipOption(depth + 1)
+	case kTypeMap:
+		d.skipOption(depth + 1)
+		d.skipOption(depth + 1)
+	case kTypeUDT:
+		d.readString()
+		d.readString()
+		n := int(d.readShort())
+		for i := 0; i < n && d.err == nil; i++ {
+			d.readString()
+			d.skipOption(depth + 1)
+		}
+	case kTypeTuple:
+		n := int(d.readShort())
+		for i := 0; i < n && d.err == nil; i++ {
+			d.skipOption(depth + 1)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cql
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+	"strings"
+)
+
+type Filter struct {
+	TargetKeyspaces []string
+	// table or keyspace.table
+	TargetTables        []string
+	TargetConsistencies []string
+}
+
+// keyspaceAndTable prefers the request, the response of a SELECT knows the
+// table of a statement kyanos could not parse.
+func keyspaceAndTable(req *Request, resp protocol.ParsedMessage) (string, string) {
+	keyspace, table := req.Keyspace, req.Table
+	if cqlResp, ok := resp.(*Response); ok && table == "" && cqlResp.Table != "" {
+		keyspace, table = cqlResp.Keyspace, cqlResp.Table
+	}
+	return keyspace, table
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	cqlReq, ok := req.(*Request)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[CQLFilter] cast to Request failed: %v\n", req)
+		return false
+	}
+	keyspace, table := keyspaceAndTable(cqlReq, resp)
+	if len(f.TargetKeyspaces) > 0 && !slices.Contains(f.TargetKeyspaces, keyspace) {
+		return false
+	}
+	if len(f.TargetTables) > 0 && !slices.Contains(f.TargetTables, table) &&
+		!slices.Contains(f.TargetTables, joinTable(keyspace, table)) {
+		return false
+	}
+	if len(f.TargetConsistencies) > 0 && !slices.ContainsFunc(f.TargetConsistencies, func(c string) bool {
+		return strings.EqualFold(c, cqlReq.Consistency)
+	}) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolCQL
+}
+
+func (f Filter) FilterByRequest() bool {
+	return len(f.TargetKeyspaces) > 0 || len(f.TargetTables) > 0 || len(f.TargetConsistencies) > 0
+}
+
+func (f Filter) FilterByResponse() bool {
+	return false
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolCQL
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,83 @@
+package cql
+
+// Since v5 the frames are wrapped in segments once the connection is ready:
+//
+//	uncompressed: payload length(17 bits) + self contained(1 bit) + padding(6 bits) + CRC24(3 bytes)
+//	compressed:   compressed length(17 bits) + uncompressed length(17 bits) + self contained(1 bit) + padding(5 bits) + CRC24(3 bytes)
+//
+// the header fields are little endian, the payload is followed by its CRC32.
+// A self contained segment holds one or more complete frames.
+
+const (
+	kSegmentHeaderLength           int = 6
+	kCompressedSegmentHeaderLength int = 8
+	kSegmentTrailerLength          int = 4
+)
+
+const (
+	kCrc24Init uint32 = 0x875060
+	kCrc24Poly uint32 = 0x1974F0B
+)
+
+// crc24 is computed over the first n little endian bytes of header, as
+// Cassandra does.
+func crc24(header uint64, n int) uint32 {
+	crc := kCrc24Init
+	for i := 0; i < n; i++ {
+		crc ^= uint32(header&0xff) << 16
+		header >>= 8
+		for j := 0; j < 8; j++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= kCrc24Poly
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+func littleEndian(buf []byte) uint64 {
+	var v uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(buf[i])
+	}
+	return v
+}
+
+type segmentHeader struct {
+	headerLength  int
+	payloadLength int
+	selfContained bool
+	compressed    bool
+}
+
+// parseSegmentHeader recognizes a segment by the CRC24 of its header, a
+// frame header matches it by chance once in 2^24 frames.
+func parseSegmentHeader(buf []byte) (segmentHeader, bool) {
+	if len(buf) >= kSegmentHeaderLength {
+		header := littleEndian(buf[:3])
+		if crc24(header, 3) == uint32(littleEndian(buf[3:6])) {
+			segment := segmentHeader{
+				headerLength:  kSegmentHeaderLength,
+				payloadLength: int(header & 0x1ffff),
+				selfContained: header&(1<<17) != 0,
+			}
+			// a self contained segment starts with a v5 frame header
+			if !segment.selfContained || len(buf) == kSegmentHeaderLength || buf[kSegmentHeaderLength]&^kResponseVersionBit == 5 {
+				return segment, true
+			}
+		}
+	}
+	if len(buf) >= kCompressedSegmentHeaderLength {
+		header := littleEndian(buf[:5])
+		if crc24(header, 5) == uint32(littleEndian(buf[5:8])) {
+			return segmentHeader{
+				headerLength:  kCompressedSegmentHeaderLength,
+				payloadLength: int(header & 0x1ffff),
+				selfContained: header&(1<<34) != 0,
+				compressed:    true,
+			}, true
+		}
+	}
+	return segmentHeader{}, false
+}
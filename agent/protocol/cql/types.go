@@ -0,0 +1,364 @@
+package cql
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"regexp"
+	"strings"
+)
+
+// See https://github.com/apache/cassandra/blob/trunk/doc/native_protocol_v5.spec.
+
+type Opcode byte
+
+const (
+	OpError         Opcode = 0x00
+	OpStartup       Opcode = 0x01
+	OpReady         Opcode = 0x02
+	OpAuthenticate  Opcode = 0x03
+	OpOptions       Opcode = 0x05
+	OpSupported     Opcode = 0x06
+	OpQuery         Opcode = 0x07
+	OpResult        Opcode = 0x08
+	OpPrepare       Opcode = 0x09
+	OpExecute       Opcode = 0x0A
+	OpRegister      Opcode = 0x0B
+	OpEvent         Opcode = 0x0C
+	OpBatch         Opcode = 0x0D
+	OpAuthChallenge Opcode = 0x0E
+	OpAuthResponse  Opcode = 0x0F
+	OpAuthSuccess   Opcode = 0x10
+)
+
+var opcodeNames = map[Opcode]string{
+	OpError:         "ERROR",
+	OpStartup:       "STARTUP",
+	OpReady:         "READY",
+	OpAuthenticate:  "AUTHENTICATE",
+	OpOptions:       "OPTIONS",
+	OpSupported:     "SUPPORTED",
+	OpQuery:         "QUERY",
+	OpResult:        "RESULT",
+	OpPrepare:       "PREPARE",
+	OpExecute:       "EXECUTE",
+	OpRegister:      "REGISTER",
+	OpEvent:         "EVENT",
+	OpBatch:         "BATCH",
+	OpAuthChallenge: "AUTH_CHALLENGE",
+	OpAuthResponse:  "AUTH_RESPONSE",
+	OpAuthSuccess:   "AUTH_SUCCESS",
+}
+
+func (o Opcode) String() string {
+	if name, ok := opcodeNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(0x%02x)", byte(o))
+}
+
+func isRequestOpcode(o Opcode) bool {
+	switch o {
+	case OpStartup, OpOptions, OpQuery, OpPrepare, OpExecute, OpRegister, OpBatch, OpAuthResponse:
+		return true
+	}
+	return false
+}
+
+func isResponseOpcode(o Opcode) bool {
+	switch o {
+	case OpError, OpReady, OpAuthenticate, OpSupported, OpResult, OpEvent, OpAuthChallenge, OpAuthSuccess:
+		return true
+	}
+	return false
+}
+
+// Frame header flags.
+const (
+	kFlagCompression   byte = 0x01
+	kFlagTracing       byte = 0x02
+	kFlagCustomPayload byte = 0x04
+	kFlagWarning       byte = 0x08
+	kFlagUseBeta       byte = 0x10
+)
+
+// version(1 byte) + flags(1 byte) + stream(2 bytes) + opcode(1 byte) + length(4 bytes)
+const kFrameHeaderLength int = 9
+
+// The default native_transport_max_frame_size of Cassandra is 16MB, leave
+// some room for servers configured with a larger one.
+const kMaxFrameLength int = 256 << 20
+
+const (
+	kMinVersion byte = 3
+	kMaxVersion byte = 5
+	// set in the version byte of the frames sent by the server
+	kResponseVersionBit byte = 0x80
+)
+
+// The server sends EVENT frames on stream -1, they answer no request.
+const kEventStreamId int16 = -1
+
+var consistencyNames = map[uint16]string{
+	0x0000: "ANY",
+	0x0001: "ONE",
+	0x0002: "TWO",
+	0x0003: "THREE",
+	0x0004: "QUORUM",
+	0x0005: "ALL",
+	0x0006: "LOCAL_QUORUM",
+	0x0007: "EACH_QUORUM",
+	0x0008: "SERIAL",
+	0x0009: "LOCAL_SERIAL",
+	0x000A: "LOCAL_ONE",
+}
+
+func consistencyName(c uint16) string {
+	if name, ok := consistencyNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", c)
+}
+
+type ResultKind int32
+
+const (
+	ResultVoid         ResultKind = 0x0001
+	ResultRows         ResultKind = 0x0002
+	ResultSetKeyspace  ResultKind = 0x0003
+	ResultPrepared     ResultKind = 0x0004
+	ResultSchemaChange ResultKind = 0x0005
+)
+
+var resultKindNames = map[ResultKind]string{
+	ResultVoid:         "Void",
+	ResultRows:         "Rows",
+	ResultSetKeyspace:  "SetKeyspace",
+	ResultPrepared:     "Prepared",
+	ResultSchemaChange: "SchemaChange",
+}
+
+func (k ResultKind) String() string {
+	if name, ok := resultKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%d)", int32(k))
+}
+
+var errorCodeNames = map[int32]string{
+	0x0000: "Server error",
+	0x000A: "Protocol error",
+	0x0100: "Bad credentials",
+	0x1000: "Unavailable",
+	0x1001: "Overloaded",
+	0x1002: "Is bootstrapping",
+	0x1003: "Truncate error",
+	0x1100: "Write timeout",
+	0x1200: "Read timeout",
+	0x1300: "Read failure",
+	0x1400: "Function failure",
+	0x1500: "Write failure",
+	0x1600: "CDC write failure",
+	0x1700: "CAS write unknown",
+	0x2000: "Syntax error",
+	0x2100: "Unauthorized",
+	0x2200: "Invalid",
+	0x2300: "Config error",
+	0x2400: "Already exists",
+	0x2500: "Unprepared",
+}
+
+func ErrorCodeName(code int32) string {
+	if name, ok := errorCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown error 0x%04x", code)
+}
+
+var batchTypeNames = map[byte]string{
+	0: "LOGGED",
+	1: "UNLOGGED",
+	2: "COUNTER",
+}
+
+var _ protocol.ParsedMessage = &Request{}
+
+type Request struct {
+	protocol.FrameBase
+	Version  byte
+	Opcode   Opcode
+	streamId int16
+	// CQL text of QUERY and PREPARE, and of EXECUTE if the PREPARE was seen
+	// on the connection. The statements of a BATCH are joined by "; ".
+	Query string
+	// id of the prepared statement of EXECUTE in hex
+	PreparedId  string
+	Consistency string
+	// number of the bound values, of all statements of a BATCH
+	BoundValues int
+	BatchType   string
+	BatchSize   int
+	// from the query parameters of v5, a USE statement on the connection,
+	// or the CQL text
+	Keyspace   string
+	Table      string
+	Compressed bool
+}
+
+func (r *Request) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] opcode=[%s] stream=[%d]", r.FrameBase.String(), r.Opcode, r.streamId)
+	if r.Compressed {
+		b.WriteString(" compressed=[true]")
+		return b.String()
+	}
+	switch r.Opcode {
+	case OpQuery, OpPrepare, OpExecute, OpBatch:
+		if r.BatchType != "" {
+			fmt.Fprintf(&b, " batch=[%s, %d statements]", r.BatchType, r.BatchSize)
+		}
+		if r.PreparedId != "" {
+			fmt.Fprintf(&b, " id=[%s]", r.PreparedId)
+		}
+		fmt.Fprintf(&b, " query=[%s]", r.Query)
+		if r.Opcode != OpPrepare {
+			fmt.Fprintf(&b, " consistency=[%s] values=[%d]", r.Consistency, r.BoundValues)
+		}
+		if r.Keyspace != "" || r.Table != "" {
+			fmt.Fprintf(&b, " table=[%s]", joinTable(r.Keyspace, r.Table))
+		}
+	}
+	return b.String()
+}
+
+func (r *Request) IsReq() bool {
+	return true
+}
+
+func (r *Request) StreamId() protocol.StreamId {
+	return protocol.StreamId(r.streamId)
+}
+
+var _ protocol.ParsedMessage = &Response{}
+var _ protocol.StatusfulMessage = &Response{}
+
+type Response struct {
+	protocol.FrameBase
+	Version    byte
+	Opcode     Opcode
+	streamId   int16
+	ResultKind ResultKind
+	// rows in this page of a Rows result
+	Rows     int32
+	Columns  []string
+	Keyspace string
+	Table    string
+	// id of a Prepared result in hex
+	PreparedId   string
+	SchemaChange string
+	ErrorCode    int32
+	ErrorMessage string
+	Warnings     []string
+	Compressed   bool
+}
+
+func (r *Response) Status() protocol.ResponseStatus {
+	if r.Opcode == OpError {
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+func (r *Response) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] opcode=[%s] stream=[%d]", r.FrameBase.String(), r.Opcode, r.streamId)
+	if r.Compressed {
+		b.WriteString(" compressed=[true]")
+		return b.String()
+	}
+	switch r.Opcode {
+	case OpError:
+		fmt.Fprintf(&b, " error=[0x%04x %s: %s]", r.ErrorCode, ErrorCodeName(r.ErrorCode), r.ErrorMessage)
+	case OpResult:
+		fmt.Fprintf(&b, " kind=[%s]", r.ResultKind)
+		switch r.ResultKind {
+		case ResultRows:
+			fmt.Fprintf(&b, " table=[%s] columns=[%s] rows=[%d]", joinTable(r.Keyspace, r.Table), strings.Join(r.Columns, ", "), r.Rows)
+		case ResultSetKeyspace:
+			fmt.Fprintf(&b, " keyspace=[%s]", r.Keyspace)
+		case ResultPrepared:
+			fmt.Fprintf(&b, " id=[%s]", r.PreparedId)
+		case ResultSchemaChange:
+			fmt.Fprintf(&b, " change=[%s]", r.SchemaChange)
+		}
+	}
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(&b, " warnings=[%s]", strings.Join(r.Warnings, "; "))
+	}
+	return b.String()
+}
+
+func (r *Response) IsReq() bool {
+	return false
+}
+
+func (r *Response) StreamId() protocol.StreamId {
+	return protocol.StreamId(r.streamId)
+}
+
+func joinTable(keyspace string, table string) string {
+	if keyspace == "" {
+		return table
+	}
+	if table == "" {
+		return keyspace
+	}
+	return keyspace + "." + table
+}
+
+var _ protocol.ProtocolStreamParser = &CQLStreamParser{}
+
+type PreparedStatement struct {
+	Query    string
+	Keyspace string
+	Table    string
+}
+
+type State struct {
+	// prepared statement id in hex -> statement
+	PreparedStatements map[string]*PreparedStatement
+	// set by the USE statement
+	Keyspace string
+}
+
+type CQLStreamParser struct {
+	*State
+}
+
+var (
+	stringLiteralRegex = regexp.MustCompile(`'(?:[^']|'')*'`)
+	uuidRegex          = regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+	blobRegex          = regexp.MustCompile(`\b0[xX][0-9a-fA-F]*\b`)
+	numberRegex        = regexp.MustCompile(`\b\d+(?:\.\d+)?(?:[eE][+-]?\d+)?\b`)
+	spacesRegex        = regexp.MustCompile(`\s+`)
+)
+
+// NormalizeQuery replaces the literals of a CQL statement with ?, so the
+// statements which only differ in their values are the same.
+func NormalizeQuery(query string) string {
+	query = stringLiteralRegex.ReplaceAllString(query, "?")
+	query = uuidRegex.ReplaceAllString(query, "?")
+	query = blobRegex.ReplaceAllString(query, "?")
+	query = numberRegex.ReplaceAllString(query, "?")
+	return strings.TrimSpace(spacesRegex.ReplaceAllString(query, " "))
+}
+
+// QueryDigest is the normalized CQL text, or the opcode if there is none.
+func (r *Request) QueryDigest() string {
+	if r.Query != "" {
+		return NormalizeQuery(r.Query)
+	}
+	if r.PreparedId != "" {
+		return kPreparedIdPrefix + r.PreparedId
+	}
+	return r.Opcode.String()
+}
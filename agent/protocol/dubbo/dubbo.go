@@ -0,0 +1,166 @@
+package dubbo
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolDubbo] = func() protocol.ProtocolStreamParser {
+		return NewDubboStreamParser()
+	}
+}
+
+func NewDubboStreamParser() *DubboStreamParser {
+	return &DubboStreamParser{}
+}
+
+// The consumers time out after 1s by default, a request not answered within
+// kMaxResponseWaitNs is dropped.
+const kMaxResponseWaitNs uint64 = 60 * 1000 * 1000 * 1000
+
+type header struct {
+	flag      byte
+	status    byte
+	requestId uint64
+	length    int
+}
+
+func (h header) isRequest() bool {
+	return h.flag&kFlagRequest != 0
+}
+
+// parseHeader returns false if buf doesn't start with a header of
+// messageType.
+func parseHeader(buf []byte, messageType protocol.MessageType) (header, bool) {
+	h := header{
+		flag:      buf[2],
+		status:    buf[3],
+		requestId: binary.BigEndian.Uint64(buf[4:]),
+		length:    int(binary.BigEndian.Uint32(buf[12:])),
+	}
+	if buf[0] != kMagicHigh || buf[1] != kMagicLow || h.length > kMaxBodyLength {
+		return h, false
+	}
+	if _, ok := serializationNames[h.flag&kSerializationMask]; !ok {
+		return h, false
+	}
+	if (messageType == protocol.Request && !h.isRequest()) || (messageType == protocol.Response && h.isRequest()) {
+		return h, false
+	}
+	// the status is only set in responses
+	if !h.isRequest() {
+		if _, ok := statusNames[h.status]; !ok {
+			return h, false
+		}
+	}
+	return h, true
+}
+
+func (p *DubboStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	if len(buf) < kHeaderLength {
+		if (len(buf) > 0 && buf[0] != kMagicHigh) || (len(buf) > 1 && buf[1] != kMagicLow) {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	h, ok := parseHeader(buf, messageType)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	readBytes := kHeaderLength + h.length
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	// heartbeats, and one-way requests which are never answered
+	if h.flag&kFlagEvent != 0 || (h.isRequest() && h.flag&kFlagTwoWay == 0) {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	serialization := h.flag & kSerializationMask
+	body := buf[kHeaderLength:readBytes]
+	var message protocol.ParsedMessage
+	if h.isRequest() {
+		req := &Request{RequestId: h.requestId, Serialization: serializationNames[serialization]}
+		if serialization == kSerializationHessian2 {
+			decodeInvocation(body, req)
+		}
+		message = req
+	} else {
+		resp := &Response{RequestId: h.requestId, Serialization: serializationNames[serialization], StatusCode: h.status, ResultType: kResultUnknown}
+		if serialization == kSerializationHessian2 {
+			decodeResult(body, resp)
+		}
+		message = resp
+	}
+
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[Dubbo] failed to create FrameBase for request id %d", h.requestId)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	switch message := message.(type) {
+	case *Request:
+		message.FrameBase = fb
+	case *Response:
+		message.FrameBase = fb
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// decodeInvocation decodes the dubbo version, the service path, the
+// service version, the method name and the parameter types written before
+// the arguments.
+func decodeInvocation(body []byte, req *Request) {
+	d := &hessianDecoder{buf: body}
+	fields := []*string{&req.DubboVersion, &req.Service, &req.ServiceVersion, &req.Method, &req.ParameterTypes}
+	for _, field := range fields {
+		value, err := d.readString()
+		if err != nil {
+			common.ProtocolParserLog.Debugf("[Dubbo] failed to decode the invocation of request %d: %v", req.RequestId, err)
+			return
+		}
+		*field = value
+	}
+}
+
+// decodeResult decodes the result type of an OK response, or the error
+// message of the others.
+func decodeResult(body []byte, resp *Response) {
+	d := &hessianDecoder{buf: body}
+	if resp.StatusCode != StatusOK {
+		resp.ErrorMessage, _ = d.readString()
+		return
+	}
+	if resultType, err := d.readInt(); err == nil && resultType >= ResultException && resultType <= ResultNullValueWithAttachments {
+		resp.ResultType = int(resultType)
+	}
+}
+
+// FindBoundary looks for the magic followed by a valid header.
+func (p *DubboStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i+kHeaderLength <= len(buf); i++ {
+		if buf[i] != kMagicHigh {
+			continue
+		}
+		if _, ok := parseHeader(buf[i:], messageType); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match pairs the requests and responses by request id, the provider
+// answers the requests of a connection concurrently.
+func (p *DubboStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	return protocol.MatchByStreamId(reqStreams, respStreams, kMaxResponseWaitNs)
+}
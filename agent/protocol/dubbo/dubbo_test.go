@@ -0,0 +1,155 @@
+package dubbo_test
+
+import (
+	"cmp"
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/dubbo"
+	"kyanos/agent/protocol/protocoltest"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func packet(flag byte, status byte, requestId uint64, body []byte) []byte {
+	header := make([]byte, 16)
+	header[0], header[1] = 0xda, 0xbb
+	header[2] = flag
+	header[3] = status
+	binary.BigEndian.PutUint64(header[4:], requestId)
+	binary.BigEndian.PutUint32(header[12:], uint32(len(body)))
+	return append(header, body...)
+}
+
+// hessianString encodes a short string, s must have less than 32 characters.
+func hessianString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func invocation(service string, method string) []byte {
+	var body []byte
+	for _, s := range []string{"2.0.2", service, "0.0.0", method, "Ljava/lang/String;"} {
+		body = append(body, hessianString(s)...)
+	}
+	// the argument
+	return append(body, hessianString("world")...)
+}
+
+func TestParseRequest(t *testing.T) {
+	parser := dubbo.NewDubboStreamParser()
+	data := packet(0xc2, 0, 7, invocation("demo.DemoService", "sayHello"))
+	// a heartbeat and a one-way request are not recorded
+	data = append(data, packet(0xe2, 0, 8, []byte{'N'})...)
+	data = append(data, packet(0x82, 0, 9, invocation("demo.DemoService", "notify"))...)
+	reqs := protocoltest.ParseAll(t, parser, data, protocol.Request, 10)
+	assert.Len(t, reqs, 1)
+
+	req := (*reqs[7])[0].(*dubbo.Request)
+	assert.Equal(t, uint64(7), req.RequestId)
+	assert.Equal(t, "hessian2", req.Serialization)
+	assert.Equal(t, "2.0.2", req.DubboVersion)
+	assert.Equal(t, "demo.DemoService", req.Service)
+	assert.Equal(t, "0.0.0", req.ServiceVersion)
+	assert.Equal(t, "sayHello", req.Method)
+	assert.Equal(t, "Ljava/lang/String;", req.ParameterTypes)
+	assert.Equal(t, "demo.DemoService/sayHello", req.FullMethod())
+}
+
+func TestParseChunkedString(t *testing.T) {
+	parser := dubbo.NewDubboStreamParser()
+	body := hessianString("2.0.2")
+	// 'R' is a non-final chunk of 3 characters followed by the final chunk
+	body = append(body, 'R', 0, 3)
+	body = append(body, "dem"...)
+	body = append(body, 'S', 0, 9)
+	body = append(body, "o.Service"...)
+	body = append(body, 0x30, 5)
+	body = append(body, "1.0.0"...)
+	// the length counts characters, not bytes
+	body = append(body, 5)
+	body = append(body, "héllo"...)
+	body = append(body, 'N')
+	reqs := protocoltest.ParseAll(t, parser, packet(0xc2, 0, 1, body), protocol.Request, 10)
+
+	req := (*reqs[1])[0].(*dubbo.Request)
+	assert.Equal(t, "demo.Service", req.Service)
+	assert.Equal(t, "1.0.0", req.ServiceVersion)
+	assert.Equal(t, "héllo", req.Method)
+	assert.Equal(t, "", req.ParameterTypes)
+}
+
+func TestParseIncomplete(t *testing.T) {
+	parser := dubbo.NewDubboStreamParser()
+	data := packet(0xc2, 0, 7, invocation("demo.DemoService", "sayHello"))
+	for _, n := range []int{1, 15, len(data) - 1} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, data[:n], 1)
+		assert.Equal(t, protocol.NeedsMoreData, parser.ParseStream(streamBuffer, protocol.Request).ParseState, n)
+	}
+	for _, data := range [][]byte{[]byte("GET / HTTP/1.1\r\n\r\n"), packet(0xc2, 0, 7, nil)[:16], packet(0x02, 0, 7, nil)} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, data, 1)
+		assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Response).ParseState, data)
+	}
+}
+
+func TestMatchByRequestId(t *testing.T) {
+	parser := dubbo.NewDubboStreamParser()
+	reqData := packet(0xc2, 0, 1, invocation("demo.DemoService", "sayHello"))
+	reqData = append(reqData, packet(0xc2, 0, 2, invocation("demo.DemoService", "sayBye"))...)
+	reqs := protocoltest.ParseAll(t, parser, reqData, protocol.Request, 10)
+
+	// the provider answers the second request first
+	respData := packet(0x02, dubbo.StatusOK, 2, []byte{0x91, 0x05, 'h', 'e', 'l', 'l', 'o'})
+	respData = append(respData, packet(0x02, dubbo.StatusServiceError, 1, hessianString("boom"))...)
+	resps := protocoltest.ParseAll(t, parser, respData, protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 2)
+	// the requests were sent at the same time, their order is not defined
+	slices.SortFunc(records, func(r1, r2 protocol.Record) int {
+		return cmp.Compare(r1.Request().StreamId(), r2.Request().StreamId())
+	})
+	assert.Equal(t, "sayHello", records[0].Request().(*dubbo.Request).Method)
+	failed := records[0].Response().(*dubbo.Response)
+	assert.Equal(t, "boom", failed.ErrorMessage)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+
+	assert.Equal(t, "sayBye", records[1].Request().(*dubbo.Request).Method)
+	ok := records[1].Response().(*dubbo.Response)
+	assert.Equal(t, dubbo.ResultValue, ok.ResultType)
+	assert.Equal(t, protocol.SuccessStatus, records[1].ResponseStatus)
+	assert.Empty(t, resps)
+}
+
+func TestMatchException(t *testing.T) {
+	parser := dubbo.NewDubboStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, packet(0xc2, 0, 1, invocation("demo.DemoService", "sayHello")), protocol.Request, 10)
+	// the result type 0 is an exception thrown by the method
+	resps := protocoltest.ParseAll(t, parser, packet(0x02, dubbo.StatusOK, 1, []byte{0x90, 'C'}), protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := dubbo.NewDubboStreamParser()
+	data := append([]byte{0xda, 0x00, 0xda}, packet(0x02, dubbo.StatusOK, 1, []byte{0x92})...)
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 1)
+	assert.Equal(t, 3, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+	assert.Equal(t, -1, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+}
+
+func TestFilter(t *testing.T) {
+	req := &dubbo.Request{Service: "demo.DemoService", Method: "sayHello"}
+	assert.True(t, dubbo.Filter{}.Filter(req, nil))
+	assert.True(t, dubbo.Filter{TargetServices: []string{"demo.DemoService"}}.Filter(req, nil))
+	assert.False(t, dubbo.Filter{TargetServices: []string{"demo.OtherService"}}.Filter(req, nil))
+	assert.True(t, dubbo.Filter{TargetMethods: []string{"sayBye", "sayHello"}}.Filter(req, nil))
+	assert.False(t, dubbo.Filter{TargetServices: []string{"demo.DemoService"}, TargetMethods: []string{"sayBye"}}.Filter(req, nil))
+	assert.False(t, dubbo.Filter{}.FilterByRequest())
+	assert.True(t, dubbo.Filter{TargetMethods: []string{"sayHello"}}.FilterByRequest())
+}
@@ -0,0 +1,47 @@
+package dubbo
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+)
+
+type Filter struct {
+	// the interface names of the services, like org.apache.dubbo.demo.DemoService
+	TargetServices []string
+	TargetMethods  []string
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	dubboReq, ok := req.(*Request)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[DubboFilter] cast to dubbo.Request failed: %v\n", req)
+		return false
+	}
+	if len(f.TargetServices) > 0 && !slices.Contains(f.TargetServices, dubboReq.Service) {
+		return false
+	}
+	if len(f.TargetMethods) > 0 && !slices.Contains(f.TargetMethods, dubboReq.Method) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolDubbo
+}
+
+func (f Filter) FilterByRequest() bool {
+	return len(f.TargetServices) > 0 || len(f.TargetMethods) > 0
+}
+
+func (f Filter) FilterByResponse() bool {
+	return false
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolDubbo
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,126 @@
+package dubbo
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// See http://hessian.caucho.com/doc/hessian-serialization.html, only the
+// strings and the ints written before the arguments of an invocation and
+// the result type of a response are decoded.
+
+var errUnsupportedType = errors.New("unsupported hessian type")
+var errNotEnoughData = errors.New("not enough data")
+
+type hessianDecoder struct {
+	buf []byte
+}
+
+func (d *hessianDecoder) take(n int) ([]byte, error) {
+	if len(d.buf) < n {
+		return nil, errNotEnoughData
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// readString reads a string or null, which is returned as an empty string.
+func (d *hessianDecoder) readString() (string, error) {
+	var s strings.Builder
+	for {
+		code, err := d.take(1)
+		if err != nil {
+			return "", err
+		}
+		var length int
+		final := true
+		switch c := code[0]; {
+		case c == 'N':
+			return "", nil
+		case c <= 0x1f:
+			// x00-x1f, utf-8 string length 0-31
+			length = int(c)
+		case c >= 0x30 && c <= 0x33:
+			// x30-x33, utf-8 string length 0-1023
+			b, err := d.take(1)
+			if err != nil {
+				return "", err
+			}
+			length = int(c-0x30)<<8 | int(b[0])
+		case c == 'S' || c == 'R':
+			// 'R' is a non-final chunk
+			b, err := d.take(2)
+			if err != nil {
+				return "", err
+			}
+			length = int(binary.BigEndian.Uint16(b))
+			final = c == 'S'
+		default:
+			return "", errUnsupportedType
+		}
+		if err := d.readChars(&s, length); err != nil {
+			return "", err
+		}
+		if final {
+			return s.String(), nil
+		}
+	}
+}
+
+// readChars reads length UTF-16 characters encoded in UTF-8, characters
+// outside of the BMP count as two.
+func (d *hessianDecoder) readChars(s *strings.Builder, length int) error {
+	for length > 0 {
+		if len(d.buf) == 0 {
+			return errNotEnoughData
+		}
+		r, size := utf8.DecodeRune(d.buf)
+		if r == utf8.RuneError && size <= 1 {
+			if !utf8.FullRune(d.buf) {
+				return errNotEnoughData
+			}
+			return errUnsupportedType
+		}
+		s.Write(d.buf[:size])
+		d.buf = d.buf[size:]
+		if size == 4 {
+			length -= 2
+		} else {
+			length--
+		}
+	}
+	return nil
+}
+
+func (d *hessianDecoder) readInt() (int32, error) {
+	code, err := d.take(1)
+	if err != nil {
+		return 0, err
+	}
+	switch c := code[0]; {
+	case c >= 0x80 && c <= 0xbf:
+		return int32(c) - 0x90, nil
+	case c >= 0xc0 && c <= 0xcf:
+		b, err := d.take(1)
+		if err != nil {
+			return 0, err
+		}
+		return (int32(c)-0xc8)<<8 | int32(b[0]), nil
+	case c >= 0xd0 && c <= 0xd7:
+		b, err := d.take(2)
+		if err != nil {
+			return 0, err
+		}
+		return (int32(c)-0xd4)<<16 | int32(b[0])<<8 | int32(b[1]), nil
+	case c == 'I':
+		b, err := d.take(4)
+		if err != nil {
+			return 0, err
+		}
+		return int32(binary.BigEndian.Uint32(b)), nil
+	}
+	return 0, errUnsupportedType
+}
@@ -0,0 +1,191 @@
+package dubbo
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See https://dubbo.apache.org/en/blog/2018/10/05/introduction-to-the-dubbo-protocol/.
+
+// magic(2) flag(1) status(1) request id(8) data length(4)
+const kHeaderLength = 16
+
+const (
+	kMagicHigh byte = 0xda
+	kMagicLow  byte = 0xbb
+)
+
+const (
+	kFlagRequest byte = 0x80
+	kFlagTwoWay  byte = 0x40
+	kFlagEvent   byte = 0x20
+	// the low 5 bits of the flag
+	kSerializationMask byte = 0x1f
+)
+
+// The data length is limited by the payload option, 8MB by default.
+const kMaxBodyLength = 64 << 20
+
+var serializationNames = map[byte]string{
+	2:  "hessian2",
+	3:  "java",
+	4:  "compactedjava",
+	6:  "fastjson",
+	7:  "nativejava",
+	8:  "kryo",
+	9:  "fst",
+	10: "native-hessian",
+	11: "avro",
+	12: "protostuff",
+	16: "gson",
+	21: "protobuf-json",
+	22: "protobuf",
+	23: "fastjson2",
+	25: "kryo2",
+}
+
+const kSerializationHessian2 byte = 2
+
+// The status of responses.
+const (
+	StatusOK                        byte = 20
+	StatusClientTimeout             byte = 30
+	StatusServerTimeout             byte = 31
+	StatusBadRequest                byte = 40
+	StatusBadResponse               byte = 50
+	StatusServiceNotFound           byte = 60
+	StatusServiceError              byte = 70
+	StatusServerError               byte = 80
+	StatusClientError               byte = 90
+	StatusServerThreadpoolExhausted byte = 100
+)
+
+var statusNames = map[byte]string{
+	StatusOK:                        "OK",
+	StatusClientTimeout:             "CLIENT_TIMEOUT",
+	StatusServerTimeout:             "SERVER_TIMEOUT",
+	StatusBadRequest:                "BAD_REQUEST",
+	StatusBadResponse:               "BAD_RESPONSE",
+	StatusServiceNotFound:           "SERVICE_NOT_FOUND",
+	StatusServiceError:              "SERVICE_ERROR",
+	StatusServerError:               "SERVER_ERROR",
+	StatusClientError:               "CLIENT_ERROR",
+	StatusServerThreadpoolExhausted: "SERVER_THREADPOOL_EXHAUSTED_ERROR",
+}
+
+func StatusName(status byte) string {
+	if name, ok := statusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", status)
+}
+
+// The first value of the body of an OK response.
+const (
+	ResultException                = 0
+	ResultValue                    = 1
+	ResultNullValue                = 2
+	ResultExceptionWithAttachments = 3
+	ResultValueWithAttachments     = 4
+	ResultNullValueWithAttachments = 5
+	kResultUnknown                 = -1
+)
+
+var _ protocol.ParsedMessage = &Request{}
+
+type Request struct {
+	protocol.FrameBase
+	RequestId     uint64
+	Serialization string
+	// the invocation is decoded from hessian2 bodies only
+	DubboVersion   string
+	Service        string
+	ServiceVersion string
+	Method         string
+	// the descriptor of the parameter types, like Ljava/lang/String;I
+	ParameterTypes string
+}
+
+// FullMethod returns service/method, or an empty string if the body was not
+// decoded.
+func (r *Request) FullMethod() string {
+	if r.Method == "" {
+		return ""
+	}
+	return r.Service + "/" + r.Method
+}
+
+func (r *Request) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] request_id=[%d] serialization=[%s]", r.FrameBase.String(), r.RequestId, r.Serialization)
+	if r.Method != "" {
+		fmt.Fprintf(&b, " service=[%s] method=[%s] parameter_types=[%s]", r.Service, r.Method, r.ParameterTypes)
+		if r.ServiceVersion != "" {
+			fmt.Fprintf(&b, " version=[%s]", r.ServiceVersion)
+		}
+		fmt.Fprintf(&b, " dubbo=[%s]", r.DubboVersion)
+	}
+	return b.String()
+}
+
+func (r *Request) IsReq() bool {
+	return true
+}
+
+func (r *Request) StreamId() protocol.StreamId {
+	return protocol.StreamId(r.RequestId)
+}
+
+var _ protocol.ParsedMessage = &Response{}
+var _ protocol.StatusfulMessage = &Response{}
+
+type Response struct {
+	protocol.FrameBase
+	RequestId     uint64
+	Serialization string
+	StatusCode    byte
+	// one of the Result consts, kResultUnknown if the body was not decoded
+	ResultType int
+	// the error message of a response whose status is not OK
+	ErrorMessage string
+}
+
+func (r *Response) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] request_id=[%d] status=[%s]", r.FrameBase.String(), r.RequestId, StatusName(r.StatusCode))
+	switch r.ResultType {
+	case ResultException, ResultExceptionWithAttachments:
+		b.WriteString(" result=[exception]")
+	case ResultValue, ResultValueWithAttachments:
+		b.WriteString(" result=[value]")
+	case ResultNullValue, ResultNullValueWithAttachments:
+		b.WriteString(" result=[null]")
+	}
+	if r.ErrorMessage != "" {
+		fmt.Fprintf(&b, " error=[%s]", r.ErrorMessage)
+	}
+	return b.String()
+}
+
+func (r *Response) IsReq() bool {
+	return false
+}
+
+func (r *Response) StreamId() protocol.StreamId {
+	return protocol.StreamId(r.RequestId)
+}
+
+// Status fails when the status is not OK, or the invoked method threw an
+// exception.
+func (r *Response) Status() protocol.ResponseStatus {
+	if r.StatusCode != StatusOK || r.ResultType == ResultException || r.ResultType == ResultExceptionWithAttachments {
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+var _ protocol.ProtocolStreamParser = &DubboStreamParser{}
+
+type DubboStreamParser struct {
+}
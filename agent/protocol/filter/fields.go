@@ -0,0 +1,655 @@
+package filter
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/amqp"
+	"kyanos/agent/protocol/cql"
+	"kyanos/agent/protocol/dns"
+	"kyanos/agent/protocol/dubbo"
+	"kyanos/agent/protocol/http2"
+	kc "kyanos/agent/protocol/kafka/common"
+	"kyanos/agent/protocol/memcached"
+	"kyanos/agent/protocol/mongodb"
+	"kyanos/agent/protocol/mqtt"
+	"kyanos/agent/protocol/mysql"
+	"kyanos/agent/protocol/nats"
+	"kyanos/agent/protocol/postgresql"
+	"kyanos/agent/protocol/rocketmq"
+	"kyanos/agent/protocol/thrift"
+	"kyanos/agent/protocol/websocket"
+	"kyanos/agent/protocol/zookeeper"
+)
+
+type fieldType int
+
+const (
+	typeString fieldType = iota
+	typeNumber
+	// numbers in nanoseconds, literals need a unit like 200ms
+	typeDuration
+	// numbers in bytes, literals may have a unit like 1MB
+	typeSize
+	typeBool
+)
+
+func (t fieldType) String() string {
+	switch t {
+	case typeString:
+		return "string"
+	case typeNumber:
+		return "number"
+	case typeDuration:
+		return "duration"
+	case typeSize:
+		return "size"
+	default:
+		return "bool"
+	}
+}
+
+// field extracts a value from a record, the value is a string, a []string, a
+// float64 or a bool. ok is false if the record doesn't have the field, for
+// example http.status of a Redis record.
+type field struct {
+	typ fieldType
+	get func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (value any, ok bool)
+}
+
+var fields = map[string]field{
+	// the total duration of the record, it isn't taken from the messages, see
+	// compareNode.eval
+	"latency": {typeDuration, nil},
+	"req.size": {typeSize, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req == nil {
+			return nil, false
+		}
+		return float64(req.ByteSize()), true
+	}},
+	"resp.size": {typeSize, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp == nil {
+			return nil, false
+		}
+		return float64(resp.ByteSize()), true
+	}},
+	"protocol": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		name := protocolName(req)
+		return name, name != ""
+	}},
+	"failed": {typeBool, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		status, ok := resp.(protocol.StatusfulMessage)
+		if !ok {
+			return nil, false
+		}
+		return status.Status() == protocol.FailStatus, true
+	}},
+
+	"http.method": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		switch req := req.(type) {
+		case *protocol.ParsedHttpRequest:
+			return req.Method, true
+		case *http2.Request:
+			return req.Method, true
+		}
+		return nil, false
+	}},
+	"http.path": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		switch req := req.(type) {
+		case *protocol.ParsedHttpRequest:
+			return req.Path, true
+		case *http2.Request:
+			return req.Path, true
+		}
+		return nil, false
+	}},
+	"http.host": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		switch req := req.(type) {
+		case *protocol.ParsedHttpRequest:
+			return req.Host, true
+		case *http2.Request:
+			return req.Authority, true
+		}
+		return nil, false
+	}},
+	"http.status": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		switch resp := resp.(type) {
+		case *protocol.ParsedHttpResponse:
+			return float64(resp.StatusCode), resp.StatusCode > 0
+		case *http2.Response:
+			status, err := strconv.Atoi(resp.StatusCode)
+			return float64(status), err == nil
+		}
+		return nil, false
+	}},
+	"http.req.body": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*protocol.ParsedHttpRequest); ok {
+			return string(req.Body), true
+		}
+		return nil, false
+	}},
+	"http.resp.body": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*protocol.ParsedHttpResponse); ok {
+			return string(resp.Body), true
+		}
+		return nil, false
+	}},
+
+	"grpc.service": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*http2.Request); ok && req.Grpc {
+			return req.GrpcService, true
+		}
+		return nil, false
+	}},
+	"grpc.method": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*http2.Request); ok && req.Grpc {
+			return req.GrpcMethod, true
+		}
+		return nil, false
+	}},
+	"grpc.status": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*http2.Response); ok && resp.Grpc && resp.GrpcStatus >= 0 {
+			return float64(resp.GrpcStatus), true
+		}
+		return nil, false
+	}},
+
+	"redis.cmd": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*protocol.RedisMessage); ok {
+			return req.Command(), true
+		}
+		return nil, false
+	}},
+	"redis.key": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*protocol.RedisMessage); ok {
+			key, _, _ := strings.Cut(req.Payload(), " ")
+			return key, true
+		}
+		return nil, false
+	}},
+	"redis.args": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*protocol.RedisMessage); ok {
+			return req.Payload(), true
+		}
+		return nil, false
+	}},
+
+	"memcached.cmd": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*memcached.Request); ok {
+			return req.Command, true
+		}
+		return nil, false
+	}},
+	"memcached.key": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*memcached.Request); ok {
+			return req.Keys, true
+		}
+		return nil, false
+	}},
+	"memcached.result": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*memcached.Response); ok {
+			return resp.Result, true
+		}
+		return nil, false
+	}},
+	"memcached.hits": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*memcached.Response); ok {
+			return float64(len(resp.Values)), true
+		}
+		return nil, false
+	}},
+
+	"mysql.sql": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mysql.MysqlPacket); ok {
+			return req.Statement(), true
+		}
+		return nil, false
+	}},
+	"mysql.error": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*mysql.MysqlResponse); ok && resp.Status() == protocol.FailStatus {
+			return resp.Msg, true
+		}
+		return nil, false
+	}},
+	"mysql.command": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mysql.MysqlPacket); ok {
+			return req.Command(), true
+		}
+		return nil, false
+	}},
+	"mysql.schema": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		switch req := req.(type) {
+		case *mysql.MysqlPacket:
+			return req.Schema(), req.Schema() != ""
+		case *mysql.Transaction:
+			return req.Schema, req.Schema != ""
+		}
+		return nil, false
+	}},
+	"mysql.rows": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		switch resp := resp.(type) {
+		case *mysql.MysqlResponse:
+			return float64(resp.Rows), true
+		case *mysql.TransactionEnd:
+			return float64(resp.Rows), true
+		}
+		return nil, false
+	}},
+	"mysql.txn.outcome": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*mysql.TransactionEnd); ok {
+			return resp.Outcome, true
+		}
+		return nil, false
+	}},
+	"mysql.txn.statements": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mysql.Transaction); ok {
+			return float64(req.Statements), true
+		}
+		return nil, false
+	}},
+	"mysql.txn.idle": {typeDuration, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mysql.Transaction); ok {
+			return float64(req.IdleNs), true
+		}
+		return nil, false
+	}},
+
+	"postgresql.sql": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*postgresql.Request); ok {
+			return req.Query, true
+		}
+		return nil, false
+	}},
+	"postgresql.error": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*postgresql.Response); ok && resp.Error != nil {
+			return resp.Error.Code, true
+		}
+		return nil, false
+	}},
+	"postgresql.rows": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*postgresql.Response); ok {
+			return float64(resp.Rows), true
+		}
+		return nil, false
+	}},
+
+	"mongodb.op": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mongodb.MongoDBFrame); ok {
+			return req.OpMsgType, true
+		}
+		return nil, false
+	}},
+	"mongodb.command": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mongodb.MongoDBFrame); ok && req.Command != "" {
+			return req.Command, true
+		}
+		return nil, false
+	}},
+	"mongodb.db": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mongodb.MongoDBFrame); ok && req.Database != "" {
+			return req.Database, true
+		}
+		return nil, false
+	}},
+	"mongodb.collection": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mongodb.MongoDBFrame); ok && req.Collection != "" {
+			return req.Collection, true
+		}
+		return nil, false
+	}},
+
+	"kafka.api": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*kc.Request); ok {
+			return req.Apikey.String(), true
+		}
+		return nil, false
+	}},
+	"kafka.topic": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		kafkaReq, ok := req.(*kc.Request)
+		if !ok {
+			return nil, false
+		}
+		var topics []string
+		switch originReq := kafkaReq.OriginReq.(type) {
+		case kc.ProduceReq:
+			for _, topic := range originReq.Topics {
+				topics = append(topics, topic.Name)
+			}
+		case kc.FetchReq:
+			for _, topic := range originReq.Topics {
+				topics = append(topics, topic.Name)
+			}
+		}
+		return topics, true
+	}},
+
+	"rocketmq.code": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*rocketmq.RocketMQMessage); ok {
+			return float64(req.RequestCode), true
+		}
+		return nil, false
+	}},
+	"rocketmq.topic": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		rocketmqReq, ok := req.(*rocketmq.RocketMQMessage)
+		if !ok {
+			return nil, false
+		}
+		// SEND_MESSAGE_V2 uses single letter keys for its header fields
+		if topic, ok := rocketmqReq.Properties["topic"]; ok {
+			return topic, true
+		}
+		return rocketmqReq.Properties["b"], true
+	}},
+
+	"cql.query": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*cql.Request); ok {
+			return req.Query, true
+		}
+		return nil, false
+	}},
+	"cql.keyspace": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if cqlReq, ok := req.(*cql.Request); ok {
+			if cqlReq.Keyspace == "" {
+				if cqlResp, ok := resp.(*cql.Response); ok {
+					return cqlResp.Keyspace, true
+				}
+			}
+			return cqlReq.Keyspace, true
+		}
+		return nil, false
+	}},
+	"cql.table": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if cqlReq, ok := req.(*cql.Request); ok {
+			if cqlReq.Table == "" {
+				if cqlResp, ok := resp.(*cql.Response); ok {
+					return cqlResp.Table, true
+				}
+			}
+			return cqlReq.Table, true
+		}
+		return nil, false
+	}},
+	"cql.consistency": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*cql.Request); ok && req.Consistency != "" {
+			return req.Consistency, true
+		}
+		return nil, false
+	}},
+	"cql.error": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*cql.Response); ok && resp.Opcode == cql.OpError {
+			return float64(resp.ErrorCode), true
+		}
+		return nil, false
+	}},
+
+	"nats.subject": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*nats.NatsMessage); ok {
+			return req.Subject, true
+		}
+		return nil, false
+	}},
+	"nats.reply": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*nats.NatsMessage); ok {
+			return req.ReplyTo, true
+		}
+		return nil, false
+	}},
+	"nats.status": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*nats.NatsMessage); ok && resp.StatusCode != 0 {
+			return float64(resp.StatusCode), true
+		}
+		return nil, false
+	}},
+
+	"amqp.method": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*amqp.Message); ok {
+			return req.Method.String(), true
+		}
+		return nil, false
+	}},
+	"amqp.exchange": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*amqp.Message); ok && resp.Method == amqp.BasicGetOk {
+			return resp.Exchange, true
+		}
+		if req, ok := req.(*amqp.Message); ok {
+			return req.Exchange, true
+		}
+		return nil, false
+	}},
+	"amqp.routing_key": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*amqp.Message); ok && resp.Method == amqp.BasicGetOk {
+			return resp.RoutingKey, true
+		}
+		if req, ok := req.(*amqp.Message); ok {
+			return req.RoutingKey, true
+		}
+		return nil, false
+	}},
+	"amqp.queue": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*amqp.Message); ok && req.Queue != "" {
+			return req.Queue, true
+		}
+		if resp, ok := resp.(*amqp.Message); ok && resp.Queue != "" {
+			return resp.Queue, true
+		}
+		return nil, false
+	}},
+	"amqp.reply_code": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*amqp.Message); ok && resp.ReplyCode != 0 {
+			return float64(resp.ReplyCode), true
+		}
+		return nil, false
+	}},
+
+	"dubbo.service": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*dubbo.Request); ok && req.Method != "" {
+			return req.Service, true
+		}
+		return nil, false
+	}},
+	"dubbo.method": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*dubbo.Request); ok && req.Method != "" {
+			return req.Method, true
+		}
+		return nil, false
+	}},
+	"dubbo.status": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*dubbo.Response); ok {
+			return dubbo.StatusName(resp.StatusCode), true
+		}
+		return nil, false
+	}},
+
+	"thrift.service": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*thrift.Request); ok && req.Service != "" {
+			return req.Service, true
+		}
+		return nil, false
+	}},
+	"thrift.method": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*thrift.Request); ok {
+			return req.Method, true
+		}
+		return nil, false
+	}},
+
+	"mqtt.type": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mqtt.Packet); ok {
+			return req.Type.String(), true
+		}
+		return nil, false
+	}},
+	"mqtt.topic": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		packet, ok := req.(*mqtt.Packet)
+		if !ok {
+			return nil, false
+		}
+		if packet.Topic != "" {
+			return packet.Topic, true
+		}
+		return packet.TopicFilters, len(packet.TopicFilters) > 0
+	}},
+	"mqtt.qos": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*mqtt.Packet); ok && req.Type == mqtt.Publish {
+			return float64(req.QoS), true
+		}
+		return nil, false
+	}},
+	"mqtt.reason_code": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*mqtt.Packet); ok && len(resp.ReasonCodes) > 0 {
+			return float64(slices.Max(resp.ReasonCodes)), true
+		}
+		return nil, false
+	}},
+
+	"zookeeper.op": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*zookeeper.Request); ok {
+			return req.OpCode.String(), true
+		}
+		return nil, false
+	}},
+	"zookeeper.path": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if req, ok := req.(*zookeeper.Request); ok {
+			paths := req.Paths()
+			return paths, len(paths) > 0
+		}
+		return nil, false
+	}},
+	"zookeeper.err": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*zookeeper.Response); ok {
+			return zookeeper.ErrorName(resp.Err), true
+		}
+		return nil, false
+	}},
+
+	"websocket.opcode": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if message, ok := websocketMessage(req, resp); ok {
+			return message.Opcode.String(), true
+		}
+		return nil, false
+	}},
+	"websocket.sender": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if message, ok := websocketMessage(req, resp); ok {
+			return message.Sender(), true
+		}
+		return nil, false
+	}},
+	"websocket.id": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if message, ok := websocketMessage(req, resp); ok && message.Id != "" {
+			return message.Id, true
+		}
+		return nil, false
+	}},
+	"websocket.close_code": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if message, ok := websocketMessage(req, resp); ok && message.Opcode == websocket.OpClose {
+			return float64(message.CloseCode), true
+		}
+		return nil, false
+	}},
+
+	"dns.name": {typeString, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		dnsReq, ok := req.(*dns.Frame)
+		if !ok {
+			return nil, false
+		}
+		names := make([]string, 0, len(dnsReq.Records))
+		for _, record := range dnsReq.Records {
+			names = append(names, record.Name)
+		}
+		return names, true
+	}},
+	"dns.rcode": {typeNumber, func(req protocol.ParsedMessage, resp protocol.ParsedMessage) (any, bool) {
+		if resp, ok := resp.(*dns.Frame); ok {
+			return float64(resp.Header.Flags & 0xf), true
+		}
+		return nil, false
+	}},
+}
+
+func protocolName(req protocol.ParsedMessage) string {
+	switch req := req.(type) {
+	case *protocol.ParsedHttpRequest:
+		return "http"
+	case *http2.Request:
+		if req.Grpc {
+			return "grpc"
+		}
+		return "http2"
+	case *protocol.RedisMessage:
+		return "redis"
+	case *mysql.MysqlPacket, *mysql.Transaction:
+		return "mysql"
+	case *postgresql.Request:
+		return "postgresql"
+	case *mongodb.MongoDBFrame:
+		return "mongodb"
+	case *kc.Request:
+		return "kafka"
+	case *rocketmq.RocketMQMessage:
+		return "rocketmq"
+	case *dns.Frame:
+		return "dns"
+	case *cql.Request:
+		return "cql"
+	case *nats.NatsMessage:
+		return "nats"
+	case *amqp.Message:
+		return "amqp"
+	case *memcached.Request:
+		return "memcached"
+	case *dubbo.Request:
+		return "dubbo"
+	case *thrift.Request:
+		return "thrift"
+	case *mqtt.Packet:
+		return "mqtt"
+	case *zookeeper.Request:
+		return "zookeeper"
+	case *websocket.Message:
+		return "websocket"
+	}
+	return ""
+}
+
+// websocketMessage returns the message of a record, a message which is not
+// paired is on the side of its sender.
+func websocketMessage(req protocol.ParsedMessage, resp protocol.ParsedMessage) (*websocket.Message, bool) {
+	message, ok := req.(*websocket.Message)
+	if ok && message.Empty {
+		message, ok = resp.(*websocket.Message)
+	}
+	return message, ok
+}
+
+// FieldNames returns the names of the fields which can be used in expressions.
+func FieldNames() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// similarFields returns the fields sharing the prefix of name, they are
+// suggested when name is unknown.
+func similarFields(name string) []string {
+	prefix, _, found := strings.Cut(name, ".")
+	var similar []string
+	for _, candidate := range FieldNames() {
+		if found && strings.HasPrefix(candidate, prefix+".") {
+			similar = append(similar, candidate)
+		}
+	}
+	if len(similar) == 0 {
+		for _, candidate := range FieldNames() {
+			if !strings.Contains(candidate, ".") || strings.HasPrefix(candidate, "req.") || strings.HasPrefix(candidate, "resp.") {
+				similar = append(similar, candidate)
+			}
+		}
+	}
+	return similar
+}
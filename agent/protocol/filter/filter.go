@@ -0,0 +1,92 @@
+// Package filter implements the expressions given by --filter, like:
+//
+//	http.status >= 500 && latency > 200ms
+//	redis.cmd in ("GET", "MGET") and resp.size > 1MB
+//	mysql.sql =~ "orders"
+//
+// Comparisons are joined by &&/and, ||/or and negated by !/not. Strings are
+// compared with ==, !=, in, not in and matched against regular expressions
+// with =~ and !~. Durations need a unit (ns, us, ms, s, m, h), sizes are in
+// bytes unless a unit (B, KB, MB, GB) is given. A comparison is false if the
+// record doesn't have the field, so http.status != 200 doesn't match Redis
+// records.
+package filter
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+)
+
+var _ protocol.ProtocolFilter = &Filter{}
+var _ protocol.DurationFilter = &Filter{}
+
+// Filter is a compiled expression, records must pass both the expression and
+// the filter it wraps.
+type Filter struct {
+	expression string
+	root       node
+	base       protocol.ProtocolFilter
+}
+
+// New compiles expression, the returned error is an *Error locating the
+// problem. base is the filter of the sub command, like the --path of
+// 'watch http'.
+func New(expression string, base protocol.ProtocolFilter) (*Filter, error) {
+	root, err := parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		base = protocol.BaseFilter{}
+	}
+	return &Filter{expression: expression, root: root, base: base}, nil
+}
+
+func (f *Filter) String() string {
+	return f.expression
+}
+
+// Filter evaluates latency as the time between the request and the
+// response, FilterWithDuration is used once the total duration of the record
+// is known.
+func (f *Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	duration := -1.0
+	if req != nil && resp != nil {
+		duration = float64(resp.TimestampNs()) - float64(req.TimestampNs())
+	}
+	return f.FilterWithDuration(req, resp, duration)
+}
+
+// FilterWithDuration evaluates latency as duration, the total duration of the
+// record in nanoseconds the renderers show, negative if it is unknown.
+func (f *Filter) FilterWithDuration(req protocol.ParsedMessage, resp protocol.ParsedMessage, duration float64) bool {
+	if f.base.FilterByRequest() || f.base.FilterByResponse() {
+		var baseReq, baseResp protocol.ParsedMessage
+		if f.base.FilterByRequest() {
+			baseReq = req
+		}
+		if f.base.FilterByResponse() {
+			baseResp = resp
+		}
+		if !f.base.Filter(baseReq, baseResp) {
+			return false
+		}
+	}
+	return f.root.eval(record{req: req, resp: resp, duration: duration})
+}
+
+func (f *Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return f.base.FilterByProtocol(p)
+}
+
+func (f *Filter) FilterByRequest() bool {
+	return true
+}
+
+func (f *Filter) FilterByResponse() bool {
+	return true
+}
+
+func (f *Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return f.base.Protocol()
+}
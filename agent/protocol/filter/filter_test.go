@@ -0,0 +1,217 @@
+package filter_test
+
+import (
+	"testing"
+
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/amqp"
+	"kyanos/agent/protocol/cql"
+	"kyanos/agent/protocol/dubbo"
+	"kyanos/agent/protocol/filter"
+	"kyanos/agent/protocol/mqtt"
+	"kyanos/agent/protocol/mysql"
+	"kyanos/agent/protocol/postgresql"
+	"kyanos/agent/protocol/thrift"
+	"kyanos/agent/protocol/websocket"
+	"kyanos/agent/protocol/zookeeper"
+	"kyanos/bpf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, p bpf.AgentTrafficProtocolT, data string, messageType protocol.MessageType, ts uint64) protocol.ParsedMessage {
+	streamBuffer := buffer.New(1024)
+	streamBuffer.Add(0, []byte(data), ts)
+	result := protocol.GetParserByProtocol(p).ParseStream(streamBuffer, messageType)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	return result.ParsedMessages[0]
+}
+
+func TestFilterHttpAndRedis(t *testing.T) {
+	httpReq := parse(t, bpf.AgentTrafficProtocolTKProtocolHTTP,
+		"GET /orders/1 HTTP/1.1\r\nHost: example.com\r\n\r\n", protocol.Request, 1_000_000_000)
+	httpResp := parse(t, bpf.AgentTrafficProtocolTKProtocolHTTP,
+		"HTTP/1.1 503 Service Unavailable\r\nTransfer-Encoding: chunked\r\n\r\n10\r\nupstream timeout\r\n0\r\n\r\n", protocol.Response, 1_300_000_000)
+	redisReq := parse(t, bpf.AgentTrafficProtocolTKProtocolRedis,
+		"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", protocol.Request, 1_000_000_000)
+	redisResp := parse(t, bpf.AgentTrafficProtocolTKProtocolRedis,
+		"$3\r\nbar\r\n", protocol.Response, 1_001_000_000)
+
+	cases := []struct {
+		expression string
+		http       bool
+		redis      bool
+	}{
+		{`http.status >= 500 && latency > 200ms`, true, false},
+		{`http.status >= 500 && latency > 1s`, false, false},
+		{`http.status != 200`, true, false},
+		{`http.path =~ "^/orders/" or redis.key == 'foo'`, true, true},
+		{`redis.cmd in ("GET", "MGET") and resp.size > 1MB`, false, false},
+		{`redis.cmd in ("GET", "MGET") and resp.size < 1KB`, false, true},
+		{`redis.cmd not in ("SET")`, false, true},
+		{`not (protocol == "http") && !failed`, false, true},
+		{`protocol == "http" && http.method != "POST" && req.size > 10`, true, false},
+		{`latency <= 1ms || http.host !~ "example"`, false, true},
+		{`http.resp.body =~ "timeout$" && http.req.body == ""`, true, false},
+	}
+	for _, c := range cases {
+		f, err := filter.New(c.expression, nil)
+		if !assert.NoError(t, err, c.expression) {
+			continue
+		}
+		assert.Equal(t, c.http, f.Filter(httpReq, httpResp), "http: %s", c.expression)
+		assert.Equal(t, c.redis, f.Filter(redisReq, redisResp), "redis: %s", c.expression)
+	}
+}
+
+func TestFilterWithDuration(t *testing.T) {
+	req := parse(t, bpf.AgentTrafficProtocolTKProtocolRedis, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", protocol.Request, 1_000_000_000)
+	resp := parse(t, bpf.AgentTrafficProtocolTKProtocolRedis, "$3\r\nbar\r\n", protocol.Response, 1_001_000_000)
+
+	f, err := filter.New(`latency > 200ms`, nil)
+	assert.NoError(t, err)
+	assert.False(t, f.Filter(req, resp))
+	assert.True(t, f.FilterWithDuration(req, resp, 300_000_000))
+	assert.False(t, f.FilterWithDuration(req, resp, 100_000_000))
+	// the duration is unknown
+	assert.False(t, f.FilterWithDuration(req, resp, -1))
+	not, err := filter.New(`!(latency > 200ms)`, nil)
+	assert.NoError(t, err)
+	assert.True(t, not.FilterWithDuration(req, resp, -1))
+}
+
+func TestFilterWrapsBase(t *testing.T) {
+	f, err := filter.New(`postgresql.rows > 10 || postgresql.error == "23505"`, postgresql.Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, bpf.AgentTrafficProtocolTKProtocolPGSQL, f.Protocol())
+	assert.True(t, f.FilterByProtocol(bpf.AgentTrafficProtocolTKProtocolPGSQL))
+	assert.False(t, f.FilterByProtocol(bpf.AgentTrafficProtocolTKProtocolHTTP))
+
+	req := &postgresql.Request{Kind: postgresql.KindQuery, Query: "insert into orders values (1)"}
+	assert.True(t, f.Filter(req, &postgresql.Response{Error: &postgresql.ErrorInfo{Code: "23505"}}))
+	assert.True(t, f.Filter(req, &postgresql.Response{Rows: 11}))
+	assert.False(t, f.Filter(req, &postgresql.Response{Rows: 1}))
+}
+
+func TestFilterCql(t *testing.T) {
+	req := &cql.Request{Opcode: cql.OpQuery, Query: "SELECT * FROM users", Table: "users", Consistency: "ONE"}
+	resp := &cql.Response{Opcode: cql.OpError, ErrorCode: 0x1200}
+	rows := &cql.Response{Opcode: cql.OpResult, ResultKind: cql.ResultRows, Keyspace: "shop", Table: "users"}
+
+	f, err := filter.New(`protocol == "cql" && cql.error == 4608`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(req, resp))
+	assert.False(t, f.Filter(req, rows))
+
+	f, err = filter.New(`cql.keyspace == "shop" && cql.table == "users" && cql.consistency in ("ONE", "TWO")`, nil)
+	assert.NoError(t, err)
+	assert.False(t, f.Filter(req, resp))
+	assert.True(t, f.Filter(req, rows))
+}
+
+func TestFilterAmqp(t *testing.T) {
+	get := &amqp.Message{Method: amqp.BasicGet, Queue: "orders"}
+	getOk := &amqp.Message{Method: amqp.BasicGetOk, Exchange: "shop", RoutingKey: "order.paid"}
+	closed := &amqp.Message{Method: amqp.ChannelClose, ReplyCode: 404, FailedMethod: amqp.BasicGet}
+
+	f, err := filter.New(`amqp.method == "basic.get" && amqp.exchange == "shop" && amqp.routing_key =~ "^order\\."`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(get, getOk))
+	assert.False(t, f.Filter(get, closed))
+
+	f, err = filter.New(`amqp.queue == "orders" && amqp.reply_code == 404`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(get, closed))
+	assert.False(t, f.Filter(get, getOk))
+}
+
+func TestFilterRpcMethods(t *testing.T) {
+	dubboReq := &dubbo.Request{Service: "demo.DemoService", Method: "sayHello"}
+	timeout := &dubbo.Response{StatusCode: dubbo.StatusServerTimeout}
+	f, err := filter.New(`dubbo.service == "demo.DemoService" && dubbo.status != "OK"`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(dubboReq, timeout))
+	assert.False(t, f.Filter(dubboReq, &dubbo.Response{StatusCode: dubbo.StatusOK}))
+
+	thriftReq := &thrift.Request{Service: "UserService", Method: "getUser"}
+	f, err = filter.New(`protocol == "thrift" && thrift.method =~ "^get"`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(thriftReq, &thrift.Response{}))
+	assert.False(t, f.Filter(dubboReq, timeout))
+}
+
+func TestFilterMqtt(t *testing.T) {
+	pub := &mqtt.Packet{Type: mqtt.Publish, QoS: 1, Topic: "sensors/1/temperature"}
+	sub := &mqtt.Packet{Type: mqtt.Subscribe, TopicFilters: []string{"sensors/#"}}
+	f, err := filter.New(`mqtt.topic =~ "^sensors/" && mqtt.reason_code >= 128`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(pub, &mqtt.Packet{Type: mqtt.PubAck, ReasonCodes: []byte{0x97}}))
+	assert.False(t, f.Filter(pub, &mqtt.Packet{Type: mqtt.PubAck}))
+	assert.True(t, f.Filter(sub, &mqtt.Packet{Type: mqtt.SubAck, ReasonCodes: []byte{0x00, 0x80}}))
+}
+
+func TestFilterZookeeper(t *testing.T) {
+	getData := &zookeeper.Request{Xid: 1, OpCode: zookeeper.OpGetData, Path: "/kafka/controller"}
+	f, err := filter.New(`zookeeper.path =~ "^/kafka" && zookeeper.err != "OK"`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(getData, &zookeeper.Response{Xid: 1, Err: zookeeper.ErrNoNode}))
+	assert.False(t, f.Filter(getData, &zookeeper.Response{Xid: 1}))
+	f, err = filter.New(`zookeeper.op == "multi" && zookeeper.path == "/kafka/b"`, nil)
+	assert.NoError(t, err)
+	multi := &zookeeper.Request{Xid: 2, OpCode: zookeeper.OpMulti, Ops: []zookeeper.MultiOp{
+		{OpCode: zookeeper.OpCheck, Path: "/kafka/a"},
+		{OpCode: zookeeper.OpSetData, Path: "/kafka/b"},
+	}}
+	assert.True(t, f.Filter(multi, &zookeeper.Response{Xid: 2}))
+}
+
+func TestFilterWebSocket(t *testing.T) {
+	f, err := filter.New(`websocket.opcode == "close" && websocket.close_code != 1000`, nil)
+	assert.NoError(t, err)
+	// a close frame of the server is not paired, the client side is empty
+	empty := &websocket.Message{Opcode: websocket.OpClose, Empty: true}
+	assert.True(t, f.Filter(empty, &websocket.Message{Opcode: websocket.OpClose, CloseCode: 1011}))
+	assert.False(t, f.Filter(empty, &websocket.Message{Opcode: websocket.OpClose, CloseCode: websocket.CloseNormal}))
+	f, err = filter.New(`websocket.id == "42"`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(&websocket.Message{Opcode: websocket.OpText, Id: "42"}, &websocket.Message{Opcode: websocket.OpText, Id: "42"}))
+	assert.False(t, f.Filter(&websocket.Message{Opcode: websocket.OpText}, &websocket.Message{Opcode: websocket.OpText, Empty: true}))
+}
+
+func TestFilterMysqlTransaction(t *testing.T) {
+	txn := &mysql.Transaction{FrameBase: protocol.NewFrameBase(1_000_000_000, 0, 0), Statements: 12, IdleNs: 3_000_000_000, Schema: "shop"}
+	end := &mysql.TransactionEnd{FrameBase: protocol.NewFrameBase(6_000_000_000, 0, 0), Outcome: mysql.TransactionRollback}
+
+	f, err := filter.New(`protocol == "mysql" && latency >= 5s && mysql.txn.idle > 2s && mysql.txn.statements >= 10`, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Filter(txn, end))
+
+	f, err = filter.New(`mysql.txn.outcome == "commit" || mysql.schema != "shop"`, nil)
+	assert.NoError(t, err)
+	assert.False(t, f.Filter(txn, end))
+}
+
+func TestInvalidExpressions(t *testing.T) {
+	cases := map[string]string{
+		``:                           "column 1: empty expression",
+		`http.stauts >= 500`:         `column 1: unknown field "http.stauts", can be: http.host, http.method, http.path, http.req.body, http.resp.body, http.status`,
+		`latency > 200`:              "column 11: latency is a duration, 200 needs a unit like 200ms",
+		`latency > 2days`:            "column 11: invalid duration 2days, the unit can be: ns, us, ms, s, m, h",
+		`http.status = 500`:          `column 13: unexpected "=", use "==" to compare`,
+		`http.status >= "500"`:       `column 16: http.status is a number, it can't be compared with "500"`,
+		`http.path > "/a"`:           `column 11: http.path is a string, it can't be compared with >`,
+		`http.status =~ "5.."`:       `column 13: http.status is a number, only strings can be matched with =~`,
+		`mysql.sql =~ "("`:           "column 14: invalid regular expression: error parsing regexp: missing closing ): `(`",
+		`(failed`:                    `column 8: expected ")" but got end of expression`,
+		`redis.cmd in ("GET" "SET")`: `column 21: expected "," or ")" but got "SET"`,
+		`failed http.status == 500`:  `column 8: unexpected "http.status", expressions are joined by "&&" or "||"`,
+		`redis.key == "foo`:          "column 14: unterminated string",
+	}
+	for expression, expected := range cases {
+		_, err := filter.New(expression, nil)
+		if assert.Error(t, err, expression) {
+			assert.Equal(t, expected, err.Error(), expression)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// column of the first character, starting from 1
+	pos int
+	// unquoted value of a string, the unit suffix of a number
+	value string
+	num   float64
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	if t.kind == tokString {
+		return t.text
+	}
+	return strconv.Quote(t.text)
+}
+
+// Error is returned for invalid expressions, Pos is the column where the
+// error is found.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos int, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!"}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: pos})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: pos})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, errorf(pos, "unterminated string")
+			}
+			text := string(runes[i : end+1])
+			value, err := unquote(text)
+			if err != nil {
+				return nil, errorf(pos, "invalid string %s", text)
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: pos, value: value})
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			num, err := strconv.ParseFloat(string(runes[i:end]), 64)
+			if err != nil {
+				return nil, errorf(pos, "invalid number %q", string(runes[i:end]))
+			}
+			unitStart := end
+			for end < len(runes) && unicode.IsLetter(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[i:end]), pos: pos,
+				num: num, value: strings.ToLower(string(runes[unitStart:end]))})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) ||
+				runes[end] == '_' || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[i:end]), pos: pos})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				if r == '=' {
+					return nil, errorf(pos, "unexpected \"=\", use \"==\" to compare")
+				}
+				return nil, errorf(pos, "unexpected %q", string(r))
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: pos})
+			i += len([]rune(op))
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(runes) + 1}), nil
+}
+
+// unquote accepts single quoted strings of any length besides Go's quoting.
+func unquote(text string) (string, error) {
+	if text[0] == '\'' {
+		text = "\"" + strings.ReplaceAll(strings.ReplaceAll(text[1:len(text)-1], "\\'", "'"), "\"", "\\\"") + "\""
+	}
+	return strconv.Unquote(text)
+}
@@ -0,0 +1,331 @@
+package filter
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"kyanos/agent/protocol"
+)
+
+// record is what an expression is evaluated against, duration is the total
+// duration in nanoseconds, negative if it is unknown.
+type record struct {
+	req      protocol.ParsedMessage
+	resp     protocol.ParsedMessage
+	duration float64
+}
+
+// node is a compiled boolean expression.
+type node interface {
+	eval(r record) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(r record) bool {
+	return n.left.eval(r) && n.right.eval(r)
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(r record) bool {
+	return n.left.eval(r) || n.right.eval(r)
+}
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(r record) bool {
+	return !n.operand.eval(r)
+}
+
+// compareNode compares a field with literals, it is false if the record
+// doesn't have the field whatever the operator is. Fields with several values
+// match if any of them matches.
+type compareNode struct {
+	field  field
+	op     string
+	values []any
+	regexp *regexp.Regexp
+}
+
+func (n compareNode) eval(r record) bool {
+	// latency is the only field without a getter
+	var value any = r.duration
+	ok := r.duration >= 0
+	if n.field.get != nil {
+		value, ok = n.field.get(r.req, r.resp)
+	}
+	if !ok {
+		return false
+	}
+	if values, ok := value.([]string); ok {
+		return slices.ContainsFunc(values, func(v string) bool { return n.match(v) })
+	}
+	return n.match(value)
+}
+
+func (n compareNode) match(value any) bool {
+	switch n.op {
+	case "=~":
+		return n.regexp.MatchString(value.(string))
+	case "!~":
+		return !n.regexp.MatchString(value.(string))
+	case "in":
+		return slices.Contains(n.values, value)
+	case "not in":
+		return !slices.Contains(n.values, value)
+	case "==":
+		return value == n.values[0]
+	case "!=":
+		return value != n.values[0]
+	}
+	v, literal := value.(float64), n.values[0].(float64)
+	switch n.op {
+	case "<":
+		return v < literal
+	case "<=":
+		return v <= literal
+	case ">":
+		return v > literal
+	default:
+		return v >= literal
+	}
+}
+
+var durationUnits = map[string]float64{
+	"ns": 1, "us": 1e3, "µs": 1e3, "ms": 1e6, "s": 1e9, "m": 60e9, "h": 3600e9,
+}
+
+var sizeUnits = map[string]float64{
+	"b": 1, "kb": 1 << 10, "mb": 1 << 20, "gb": 1 << 30,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether t is one of the operators or keywords, keywords are
+// case insensitive.
+func (t token) is(ops ...string) bool {
+	if t.kind != tokOp && t.kind != tokIdent {
+		return false
+	}
+	for _, op := range ops {
+		if t.kind == tokOp && t.text == op || t.kind == tokIdent && strings.EqualFold(t.text, op) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("||", "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("&&", "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().is("!", "not") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, errorf(t.pos, "expected \")\" but got %s", t)
+		}
+		return n, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	t := p.next()
+	if t.kind != tokIdent || t.is("and", "or", "not", "in", "true", "false") {
+		return nil, errorf(t.pos, "expected a field but got %s", t)
+	}
+	f, ok := fields[t.text]
+	if !ok {
+		return nil, errorf(t.pos, "unknown field %q, can be: %s", t.text, strings.Join(similarFields(t.text), ", "))
+	}
+
+	opToken := p.peek()
+	var op string
+	switch {
+	case opToken.is("==", "!=", "<", "<=", ">", ">=", "=~", "!~", "in"):
+		p.next()
+		op = strings.ToLower(opToken.text)
+	case opToken.is("not") && p.tokens[p.pos+1].is("in"):
+		p.next()
+		p.next()
+		op = "not in"
+	case f.typ == typeBool:
+		// a bool field alone is the same as comparing it with true
+		return compareNode{field: f, op: "==", values: []any{true}}, nil
+	default:
+		return nil, errorf(opToken.pos, "expected an operator after %s but got %s", t.text, opToken)
+	}
+
+	n := compareNode{field: f, op: op}
+	switch op {
+	case "<", "<=", ">", ">=":
+		if f.typ == typeString || f.typ == typeBool {
+			return nil, errorf(opToken.pos, "%s is a %s, it can't be compared with %s", t.text, f.typ, op)
+		}
+	case "=~", "!~":
+		if f.typ != typeString {
+			return nil, errorf(opToken.pos, "%s is a %s, only strings can be matched with %s", t.text, f.typ, op)
+		}
+		literal := p.next()
+		if literal.kind != tokString {
+			return nil, errorf(literal.pos, "expected a regular expression string but got %s", literal)
+		}
+		re, err := regexp.Compile(literal.value)
+		if err != nil {
+			return nil, errorf(literal.pos, "invalid regular expression: %v", err)
+		}
+		n.regexp = re
+		return n, nil
+	case "in", "not in":
+		if f.typ == typeBool {
+			return nil, errorf(opToken.pos, "%s is a bool, it can't be used with %s", t.text, op)
+		}
+		if lp := p.next(); lp.kind != tokLParen {
+			return nil, errorf(lp.pos, "expected \"(\" after %s but got %s", op, lp)
+		}
+		for {
+			value, err := p.parseLiteral(t.text, f)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, value)
+			sep := p.next()
+			if sep.kind == tokRParen {
+				return n, nil
+			}
+			if sep.kind != tokComma {
+				return nil, errorf(sep.pos, "expected \",\" or \")\" but got %s", sep)
+			}
+		}
+	}
+	value, err := p.parseLiteral(t.text, f)
+	if err != nil {
+		return nil, err
+	}
+	n.values = []any{value}
+	return n, nil
+}
+
+func (p *parser) parseLiteral(name string, f field) (any, error) {
+	t := p.next()
+	mismatch := func() error {
+		return errorf(t.pos, "%s is a %s, it can't be compared with %s", name, f.typ, t)
+	}
+	switch f.typ {
+	case typeString:
+		if t.kind != tokString {
+			return nil, mismatch()
+		}
+		return t.value, nil
+	case typeBool:
+		if !t.is("true", "false") {
+			return nil, mismatch()
+		}
+		return strings.EqualFold(t.text, "true"), nil
+	}
+	if t.kind != tokNumber {
+		return nil, mismatch()
+	}
+	switch f.typ {
+	case typeDuration:
+		if t.value == "" {
+			return nil, errorf(t.pos, "%s is a duration, %s needs a unit like %sms", name, t.text, t.text)
+		}
+		unit, ok := durationUnits[t.value]
+		if !ok {
+			return nil, errorf(t.pos, "invalid duration %s, the unit can be: ns, us, ms, s, m, h", t.text)
+		}
+		return t.num * unit, nil
+	case typeSize:
+		if t.value == "" {
+			return t.num, nil
+		}
+		unit, ok := sizeUnits[t.value]
+		if !ok {
+			return nil, errorf(t.pos, "invalid size %s, the unit can be: B, KB, MB, GB", t.text)
+		}
+		return t.num * unit, nil
+	default:
+		if t.value != "" {
+			return nil, errorf(t.pos, "%s is a number, %s can't have a unit", name, t.text)
+		}
+		return t.num, nil
+	}
+}
+
+func parse(expression string) (node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, errorf(1, "empty expression")
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorf(t.pos, "unexpected %s, expressions are joined by \"&&\" or \"||\"", t)
+	}
+	return n, nil
+}
@@ -78,6 +78,12 @@ func (h *HTTPStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messa
 	return -1
 }
 
+// httpReadIndex returns the number of bytes of buf consumed through
+// bufioReader, which reads from reader.
+func httpReadIndex(buf string, reader *strings.Reader, bufioReader *bufio.Reader) int {
+	return len(buf) - reader.Len() - bufioReader.Buffered()
+}
+
 func (h *HTTPStreamParser) ParseRequest(buf string, messageType MessageType, timestamp uint64, seq uint64) ParseResult {
 	reader := strings.NewReader(buf)
 	bufioReader := bufio.NewReader(reader)
@@ -93,38 +99,35 @@ func (h *HTTPStreamParser) ParseRequest(buf string, messageType MessageType, tim
 				ParseState: Invalid,
 			}
 		}
-	} else {
-		if req.ContentLength > 0 {
-			reqBody, err := io.ReadAll(req.Body)
-			if err != nil {
-				if err == io.EOF || err == io.ErrUnexpectedEOF {
-					return ParseResult{
-						ParseState: NeedsMoreData,
-					}
-				} else {
-					return ParseResult{
-						ParseState: Invalid,
-					}
-				}
+	}
+	headerEnd := httpReadIndex(buf, reader, bufioReader)
+	reqBody, trailer, err := readHttpBody(bufioReader, req.TransferEncoding, req.ContentLength)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ParseResult{
+				ParseState: NeedsMoreData,
 			}
-			if len(reqBody) != int(req.ContentLength) {
-				fmt.Print("!")
+		} else {
+			return ParseResult{
+				ParseState: Invalid,
 			}
 		}
-		readIndex := common.GetBufioReaderReadIndex(bufioReader)
-		parseResult.ReadBytes = readIndex
-		parseResult.ParsedMessages = []ParsedMessage{
-			&ParsedHttpRequest{
-				FrameBase: NewFrameBase(timestamp, readIndex, seq),
-				Host:      req.Host,
-				Method:    req.Method,
-				Path:      req.URL.Path,
-				buf:       []byte(buf[:readIndex]),
-			},
-		}
-		parseResult.ParseState = Success
-		return parseResult
 	}
+	readIndex := httpReadIndex(buf, reader, bufioReader)
+	parseResult.ReadBytes = readIndex
+	parseResult.ParsedMessages = []ParsedMessage{
+		&ParsedHttpRequest{
+			FrameBase: NewFrameBase(timestamp, readIndex, seq),
+			Host:      req.Host,
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Body:      decodeContent(reqBody, req.Header.Get("Content-Encoding")),
+			Trailer:   trailer,
+			buf:       []byte(buf[:headerEnd]),
+		},
+	}
+	parseResult.ParseState = Success
+	return parseResult
 }
 
 func (h *HTTPStreamParser) ParseResponse(buf string, messageType MessageType, timestamp uint64, seq uint64, streamBuffer *buffer.StreamBuffer) ParseResult {
@@ -136,30 +139,39 @@ func (h *HTTPStreamParser) ParseResponse(buf string, messageType MessageType, ti
 		return h.handleReadResponseError(err, buf, streamBuffer, messageType, timestamp, seq)
 	}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return h.handleReadBodyError(err, buf, streamBuffer, messageType, timestamp, seq)
-	}
-
-	readIndex := common.GetBufioReaderReadIndex(bufioReader)
-	if readIndex == 0 && len(respBody) > 0 {
-		readIndex = len(buf)
-	} else if readIndex == 0 {
-		return ParseResult{
-			ParseState: NeedsMoreData,
+	headerEnd := httpReadIndex(buf, reader, bufioReader)
+	var respBody []byte
+	var trailer http.Header
+	if httpBodyAllowed(resp.StatusCode) {
+		respBody, trailer, err = readHttpBody(bufioReader, resp.TransferEncoding, resp.ContentLength)
+		if err != nil {
+			return h.handleReadBodyError(err, resp.StatusCode, buf, streamBuffer, messageType, timestamp, seq)
 		}
 	}
+
+	readIndex := httpReadIndex(buf, reader, bufioReader)
 	parseResult.ReadBytes = readIndex
 	parseResult.ParsedMessages = []ParsedMessage{
 		&ParsedHttpResponse{
-			FrameBase: NewFrameBase(timestamp, readIndex, seq),
-			buf:       []byte(buf[:readIndex]),
+			FrameBase:  NewFrameBase(timestamp, readIndex, seq),
+			StatusCode: resp.StatusCode,
+			Upgrade:    upgradeOf(resp),
+			Body:       decodeContent(respBody, resp.Header.Get("Content-Encoding")),
+			Trailer:    trailer,
+			buf:        []byte(buf[:headerEnd]),
 		},
 	}
 	parseResult.ParseState = Success
 	return parseResult
 }
 
+func upgradeOf(resp *http.Response) string {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return ""
+	}
+	return strings.ToLower(resp.Header.Get("Upgrade"))
+}
+
 func (h *HTTPStreamParser) handleReadResponseError(err error, buf string, streamBuffer *buffer.StreamBuffer, messageType MessageType, timestamp uint64, seq uint64) ParseResult {
 	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		return ParseResult{
@@ -172,15 +184,16 @@ func (h *HTTPStreamParser) handleReadResponseError(err error, buf string, stream
 	}
 }
 
-func (h *HTTPStreamParser) handleReadBodyError(err error, buf string, streamBuffer *buffer.StreamBuffer, messageType MessageType, timestamp uint64, seq uint64) ParseResult {
+func (h *HTTPStreamParser) handleReadBodyError(err error, statusCode int, buf string, streamBuffer *buffer.StreamBuffer, messageType MessageType, timestamp uint64, seq uint64) ParseResult {
 	parseResult := ParseResult{}
 	boundary := h.FindBoundary(streamBuffer, messageType, 0)
 	if boundary > 0 {
 		parseResult.ReadBytes = boundary
 		parseResult.ParsedMessages = []ParsedMessage{
 			&ParsedHttpResponse{
-				FrameBase: NewFrameBase(timestamp, boundary, seq),
-				buf:       []byte(buf[:boundary]),
+				FrameBase:  NewFrameBase(timestamp, boundary, seq),
+				StatusCode: statusCode,
+				buf:        []byte(buf[:boundary]),
 			},
 		}
 		parseResult.ParseState = Success
@@ -191,8 +204,9 @@ func (h *HTTPStreamParser) handleReadBodyError(err error, buf string, streamBuff
 			parseResult.ReadBytes = fakeDataIdx + int(fakeDataSize) + fakeDataMarkLen
 			parseResult.ParsedMessages = []ParsedMessage{
 				&ParsedHttpResponse{
-					FrameBase: NewFrameBase(timestamp, parseResult.ReadBytes, seq),
-					buf:       []byte(buf[:parseResult.ReadBytes]),
+					FrameBase:  NewFrameBase(timestamp, parseResult.ReadBytes, seq),
+					StatusCode: statusCode,
+					buf:        []byte(buf[:parseResult.ReadBytes]),
 				},
 			}
 			parseResult.ParseState = Success
@@ -229,12 +243,29 @@ func (h *HTTPStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messag
 	}
 }
 
+// formatHttpMessage shows the decoded body in place of the wire body, the
+// trailers follow it as they do on the wire.
+func formatHttpMessage(header []byte, body []byte, trailer http.Header) string {
+	var b strings.Builder
+	b.Write(header)
+	b.Write(body)
+	if len(trailer) > 0 {
+		b.WriteString("\r\n")
+		trailer.Write(&b)
+	}
+	return b.String()
+}
+
 type ParsedHttpRequest struct {
 	FrameBase
 	Path   string
 	Host   string
 	Method string
+	// the body without the chunked framing and the Content-Encoding
+	Body    []byte
+	Trailer http.Header
 
+	// the header section, or the whole message if the body was not parsed
 	buf []byte
 }
 
@@ -247,7 +278,7 @@ func (req *ParsedHttpRequest) FormatToSummaryString() string {
 }
 
 func (req *ParsedHttpRequest) FormatToString() string {
-	return string(req.buf)
+	return formatHttpMessage(req.buf, req.Body, req.Trailer)
 }
 
 func (req *ParsedHttpRequest) IsReq() bool {
@@ -260,6 +291,14 @@ func (req *ParsedHttpRequest) StreamId() StreamId {
 
 type ParsedHttpResponse struct {
 	FrameBase
+	StatusCode int
+	// the protocol switched to with 101 Switching Protocols, in lower case
+	Upgrade string
+	// the body without the chunked framing and the Content-Encoding
+	Body    []byte
+	Trailer http.Header
+
+	// the header section, or the whole message if the body was not parsed
 	buf []byte
 }
 
@@ -271,7 +310,7 @@ func (resp *ParsedHttpResponse) Status() ResponseStatus {
 }
 
 func (resp *ParsedHttpResponse) FormatToString() string {
-	return string(resp.buf)
+	return formatHttpMessage(resp.buf, resp.Body, resp.Trailer)
 }
 func (resp *ParsedHttpResponse) IsReq() bool {
 	return false
@@ -0,0 +1,55 @@
+package http2
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+	"strings"
+)
+
+// GrpcFilter filters gRPC requests by service and method, a service matches
+// either its full name(package.Service) or its short name(Service).
+type GrpcFilter struct {
+	TargetServices []string
+	TargetMethods  []string
+}
+
+func (f GrpcFilter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	http2Req, ok := req.(*Request)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[GrpcFilter] cast to http2.Request failed: %v\n", req)
+		return false
+	}
+	if !http2Req.Grpc {
+		return false
+	}
+	if len(f.TargetServices) > 0 {
+		shortName := http2Req.GrpcService[strings.LastIndex(http2Req.GrpcService, ".")+1:]
+		if !slices.Contains(f.TargetServices, http2Req.GrpcService) && !slices.Contains(f.TargetServices, shortName) {
+			return false
+		}
+	}
+	if len(f.TargetMethods) > 0 && !slices.Contains(f.TargetMethods, http2Req.GrpcMethod) {
+		return false
+	}
+	return true
+}
+
+func (f GrpcFilter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolHTTP2
+}
+
+func (f GrpcFilter) FilterByRequest() bool {
+	return len(f.TargetServices) > 0 || len(f.TargetMethods) > 0
+}
+
+func (f GrpcFilter) FilterByResponse() bool {
+	return false
+}
+
+func (GrpcFilter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolHTTP2
+}
+
+var _ protocol.ProtocolFilter = GrpcFilter{}
@@ -0,0 +1,503 @@
+package http2
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// The default SETTINGS_MAX_FRAME_SIZE, only used to find a frame boundary.
+const kBoundaryMaxFrameLength int = 1 << 14
+
+// A stream without frames within kMaxStreamIdleNs is dropped if its request
+// hasn't ended, like a stream whose beginning was missed. Streams waiting for
+// their response, like long polls, are only dropped, oldest first, when there
+// are more than kMaxPendingStreams of them.
+const kMaxStreamIdleNs uint64 = 60 * 1000 * 1000 * 1000
+const kMaxPendingStreams = 1000
+
+var errInvalidPadding = errors.New("invalid padding")
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolHTTP2] = func() protocol.ProtocolStreamParser {
+		return NewHttp2StreamParser()
+	}
+}
+
+func NewHttp2StreamParser() *Http2StreamParser {
+	return &Http2StreamParser{
+		reqDecoder:  hpack.NewDecoder(kDefaultHeaderTableSize, nil),
+		respDecoder: hpack.NewDecoder(kDefaultHeaderTableSize, nil),
+	}
+}
+
+func (p *Http2StreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	if messageType == protocol.Unknown {
+		// HPACK decoding needs to know the direction, the frames can't be decoded
+		// before the role of the connection is known.
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: len(buf)}
+	}
+
+	if messageType == protocol.Request {
+		if len(buf) < len(kConnectionPreface) && strings.HasPrefix(kConnectionPreface, string(buf)) {
+			return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+		}
+		if bytes.HasPrefix(buf, []byte(kConnectionPreface)) {
+			return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: len(kConnectionPreface)}
+		}
+	}
+
+	if len(buf) < kFrameHeaderLength {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	length, frameType, flags, streamId := parseFrameHeader(buf)
+	readBytes := kFrameHeaderLength + length
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	payload := buf[kFrameHeaderLength:readBytes]
+	isReq := messageType == protocol.Request
+
+	pending := &p.respPending
+	decoder := p.respDecoder
+	if isReq {
+		pending = &p.reqPending
+		decoder = p.reqDecoder
+	}
+	// A header block must be followed by its CONTINUATION frames only.
+	if *pending != nil && frameType != FrameContinuation {
+		common.ProtocolParserLog.Debugf("[HTTP2] expect CONTINUATION of stream %d, but got %s", (*pending).streamId, frameType)
+		*pending = nil
+	}
+
+	switch frameType {
+	case FrameData:
+		if streamId == 0 {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		data, err := stripPadding(payload, flags)
+		if err != nil {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		return p.newFrameResult(streamBuffer, readBytes, &Frame{
+			Type:      frameType,
+			Flags:     flags,
+			streamId:  streamId,
+			Data:      append([]byte(nil), data...),
+			EndStream: flags&kFlagEndStream != 0,
+			isReq:     isReq,
+		})
+	case FrameHeaders, FramePushPromise:
+		if streamId == 0 {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		fragment, err := stripPadding(payload, flags)
+		if err != nil {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		if frameType == FrameHeaders && flags&kFlagPriority != 0 {
+			// stream dependency(4 bytes) + weight(1 byte)
+			if len(fragment) < 5 {
+				return protocol.ParseResult{ParseState: protocol.Invalid}
+			}
+			fragment = fragment[5:]
+		} else if frameType == FramePushPromise {
+			// promised stream id(4 bytes)
+			if len(fragment) < 4 {
+				return protocol.ParseResult{ParseState: protocol.Invalid}
+			}
+			fragment = fragment[4:]
+		}
+		block := &pendingHeaderBlock{
+			frameType: frameType,
+			streamId:  streamId,
+			flags:     flags,
+			block:     append([]byte(nil), fragment...),
+			byteSize:  readBytes,
+		}
+		if flags&kFlagEndHeaders == 0 {
+			*pending = block
+			return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+		}
+		return p.finishHeaderBlock(streamBuffer, readBytes, decoder, block, isReq)
+	case FrameContinuation:
+		if *pending == nil || (*pending).streamId != streamId {
+			*pending = nil
+			return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+		}
+		block := *pending
+		block.block = append(block.block, payload...)
+		block.byteSize += readBytes
+		if flags&kFlagEndHeaders == 0 {
+			return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+		}
+		*pending = nil
+		return p.finishHeaderBlock(streamBuffer, readBytes, decoder, block, isReq)
+	case FrameRSTStream:
+		if streamId == 0 {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		return p.newFrameResult(streamBuffer, readBytes, &Frame{
+			Type:      frameType,
+			Flags:     flags,
+			streamId:  streamId,
+			EndStream: true,
+			isReq:     isReq,
+		})
+	case FrameSettings:
+		if flags&kFlagAck == 0 {
+			// The header table size announced by one side limits the encoder of the other side.
+			if isReq {
+				p.applySettings(payload, p.respDecoder)
+			} else {
+				p.applySettings(payload, p.reqDecoder)
+			}
+		}
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	default:
+		// PRIORITY, PING, GOAWAY, WINDOW_UPDATE and unknown extension frames
+		// don't belong to any request.
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+}
+
+func parseFrameHeader(buf []byte) (int, FrameType, uint8, uint32) {
+	length := int(buf[0])<<16 | int(buf[1])<<8 | int(buf[2])
+	frameType := FrameType(buf[3])
+	flags := buf[4]
+	streamId := binary.BigEndian.Uint32(buf[5:9]) & 0x7fffffff
+	return length, frameType, flags, streamId
+}
+
+func stripPadding(payload []byte, flags uint8) ([]byte, error) {
+	if flags&kFlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) < 1 {
+		return nil, errInvalidPadding
+	}
+	padLength := int(payload[0])
+	if padLength >= len(payload) {
+		return nil, errInvalidPadding
+	}
+	return payload[1 : len(payload)-padLength], nil
+}
+
+func (p *Http2StreamParser) applySettings(payload []byte, decoder *hpack.Decoder) {
+	for i := 0; i+6 <= len(payload); i += 6 {
+		id := binary.BigEndian.Uint16(payload[i : i+2])
+		value := binary.BigEndian.Uint32(payload[i+2 : i+6])
+		if id == kSettingsHeaderTableSize {
+			decoder.SetAllowedMaxDynamicTableSize(value)
+		}
+	}
+}
+
+func (p *Http2StreamParser) finishHeaderBlock(streamBuffer *buffer.StreamBuffer, readBytes int, decoder *hpack.Decoder,
+	block *pendingHeaderBlock, isReq bool) protocol.ParseResult {
+	headers, err := decoder.DecodeFull(block.block)
+	if err != nil {
+		// The dynamic table may be out of sync if we missed some header blocks,
+		// keep the frame so the stream can still be matched.
+		common.ProtocolParserLog.Debugf("[HTTP2] decode header block of stream %d failed: %v", block.streamId, err)
+	}
+	if block.frameType == FramePushPromise {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	result := p.newFrameResult(streamBuffer, readBytes, &Frame{
+		Type:      FrameHeaders,
+		Flags:     block.flags,
+		streamId:  block.streamId,
+		Headers:   headers,
+		EndStream: block.flags&kFlagEndStream != 0,
+		isReq:     isReq,
+	})
+	if result.ParseState == protocol.Success {
+		frame := result.ParsedMessages[0].(*Frame)
+		frame.IncrByteSize(block.byteSize - readBytes)
+	}
+	return result
+}
+
+func (p *Http2StreamParser) newFrameResult(streamBuffer *buffer.StreamBuffer, readBytes int, frame *Frame) protocol.ParseResult {
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	frame.FrameBase = fb
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{frame},
+	}
+}
+
+func isFrameHeader(buf []byte) bool {
+	if len(buf) < kFrameHeaderLength {
+		return false
+	}
+	length, frameType, flags, streamId := parseFrameHeader(buf)
+	if length > kBoundaryMaxFrameLength || frameType > FrameContinuation {
+		return false
+	}
+	// The reserved bit must be unset.
+	if buf[5]&0x80 != 0 {
+		return false
+	}
+	switch frameType {
+	case FrameSettings, FramePing:
+		return streamId == 0 && flags&^kFlagAck == 0
+	case FrameGoAway:
+		return streamId == 0
+	case FrameWindowUpdate:
+		return true
+	default:
+		return streamId != 0
+	}
+}
+
+func (p *Http2StreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	if startPos == 0 && messageType == protocol.Request && bytes.HasPrefix(buf, []byte(kConnectionPreface)) {
+		return 0
+	}
+	for i := startPos; i+kFrameHeaderLength <= len(buf); i++ {
+		if !isFrameHeader(buf[i:]) {
+			continue
+		}
+		length, _, _, _ := parseFrameHeader(buf[i:])
+		next := i + kFrameHeaderLength + length
+		if next == len(buf) {
+			return i
+		}
+		if next < len(buf) && isFrameHeader(buf[next:]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isStreamEnded(queue protocol.ParsedMessageQueue) bool {
+	for _, each := range queue {
+		if each.(*Frame).EndStream {
+			return true
+		}
+	}
+	return false
+}
+
+// Match builds a record for every stream whose response has ended (END_STREAM
+// or RST_STREAM), streams are never reused in a connection so their queues are
+// removed once matched. Idle streams that can't be waiting for their response
+// are dropped.
+func (p *Http2StreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	latest := max(latestTimestamp(reqStreams), latestTimestamp(respStreams))
+	defer pruneIdleStreams(reqStreams, respStreams, latest)
+	records := []protocol.Record{}
+	for streamId, respQueue := range respStreams {
+		if len(*respQueue) == 0 || !isStreamEnded(*respQueue) {
+			continue
+		}
+		reqQueue, ok := reqStreams[streamId]
+		if !ok || len(*reqQueue) == 0 {
+			common.ProtocolParserLog.Debugf("[HTTP2] no request found for stream %d", streamId)
+			delete(respStreams, streamId)
+			continue
+		}
+		req := buildRequest(*reqQueue)
+		resp := buildResponse(*respQueue)
+		records = append(records, protocol.Record{
+			Req:            req,
+			Resp:           resp,
+			ResponseStatus: resp.Status(),
+		})
+		delete(reqStreams, streamId)
+		delete(respStreams, streamId)
+	}
+	return records
+}
+
+func lastTimestamp(queue *protocol.ParsedMessageQueue) uint64 {
+	if queue == nil || len(*queue) == 0 {
+		return 0
+	}
+	return (*queue)[len(*queue)-1].TimestampNs()
+}
+
+func latestTimestamp(streams map[protocol.StreamId]*protocol.ParsedMessageQueue) uint64 {
+	var latest uint64
+	for _, queue := range streams {
+		latest = max(latest, lastTimestamp(queue))
+	}
+	return latest
+}
+
+func pruneIdleStreams(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, latest uint64) {
+	lastActive := func(streamId protocol.StreamId) uint64 {
+		return max(lastTimestamp(reqStreams[streamId]), lastTimestamp(respStreams[streamId]))
+	}
+	// the request of a long poll or a server streaming call has ended, a
+	// bidirectional call has its response started
+	isWaiting := func(streamId protocol.StreamId) bool {
+		reqQueue := reqStreams[streamId]
+		if reqQueue == nil || len(*reqQueue) == 0 {
+			return false
+		}
+		respQueue := respStreams[streamId]
+		return isStreamEnded(*reqQueue) || (respQueue != nil && len(*respQueue) > 0)
+	}
+	drop := func(streamId protocol.StreamId) {
+		common.ProtocolParserLog.Debugf("[HTTP2] drop idle stream %d", streamId)
+		delete(reqStreams, streamId)
+		delete(respStreams, streamId)
+	}
+	for _, streams := range []map[protocol.StreamId]*protocol.ParsedMessageQueue{reqStreams, respStreams} {
+		for streamId := range streams {
+			if lastActive(streamId)+kMaxStreamIdleNs < latest && !isWaiting(streamId) {
+				drop(streamId)
+			}
+		}
+	}
+	if len(reqStreams) <= kMaxPendingStreams {
+		return
+	}
+	streamIds := slices.Collect(maps.Keys(reqStreams))
+	slices.SortFunc(streamIds, func(id1, id2 protocol.StreamId) int {
+		return cmp.Compare(lastActive(id1), lastActive(id2))
+	})
+	for _, streamId := range streamIds[:len(streamIds)-kMaxPendingStreams] {
+		drop(streamId)
+	}
+}
+
+func buildRequest(frames protocol.ParsedMessageQueue) *Request {
+	first := frames[0].(*Frame)
+	req := &Request{
+		FrameBase: first.FrameBase,
+		streamId:  first.streamId,
+	}
+	for idx, each := range frames {
+		frame := each.(*Frame)
+		if idx > 0 {
+			req.IncrByteSize(frame.ByteSize())
+		}
+		switch frame.Type {
+		case FrameHeaders:
+			for _, h := range frame.Headers {
+				switch h.Name {
+				case ":method":
+					req.Method = h.Value
+				case ":path":
+					req.Path = h.Value
+				case ":authority":
+					req.Authority = h.Value
+				case ":scheme":
+					req.Scheme = h.Value
+				}
+			}
+			req.Headers = append(req.Headers, frame.Headers...)
+		case FrameData:
+			req.Body = append(req.Body, frame.Data...)
+		}
+	}
+	req.Grpc = isGrpc(req.Headers)
+	if req.Grpc {
+		req.GrpcService, req.GrpcMethod = splitGrpcPath(req.Path)
+		req.GrpcMessage = parseGrpcMessages(req.Body)
+	}
+	return req
+}
+
+func buildResponse(frames protocol.ParsedMessageQueue) *Response {
+	first := frames[0].(*Frame)
+	resp := &Response{
+		FrameBase:  first.FrameBase,
+		streamId:   first.streamId,
+		GrpcStatus: kGrpcStatusNotSet,
+	}
+	headersSeen := false
+	for idx, each := range frames {
+		frame := each.(*Frame)
+		if idx > 0 {
+			resp.IncrByteSize(frame.ByteSize())
+		}
+		switch frame.Type {
+		case FrameHeaders:
+			if !headersSeen {
+				headersSeen = true
+				resp.Headers = frame.Headers
+			} else {
+				resp.Trailers = append(resp.Trailers, frame.Headers...)
+			}
+		case FrameData:
+			resp.Body = append(resp.Body, frame.Data...)
+		case FrameRSTStream:
+			resp.Reset = true
+		}
+	}
+	resp.StatusCode = headerValue(resp.Headers, ":status")
+	resp.Grpc = isGrpc(resp.Headers)
+	if resp.Grpc {
+		// Trailers-Only responses carry grpc-status in the headers.
+		fields := resp.Trailers
+		if headerValue(fields, "grpc-status") == "" {
+			fields = resp.Headers
+		}
+		if status, err := strconv.Atoi(headerValue(fields, "grpc-status")); err == nil {
+			resp.GrpcStatus = status
+		}
+		resp.GrpcMsg = headerValue(fields, "grpc-message")
+		resp.GrpcMessage = parseGrpcMessages(resp.Body)
+	}
+	return resp
+}
+
+func headerValue(headers []hpack.HeaderField, name string) string {
+	for _, h := range headers {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+func isGrpc(headers []hpack.HeaderField) bool {
+	return strings.HasPrefix(headerValue(headers, "content-type"), "application/grpc")
+}
+
+// splitGrpcPath splits "/package.Service/Method" into service and method.
+func splitGrpcPath(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) != 2 {
+		return path, ""
+	}
+	return parts[0], parts[1]
+}
+
+func parseGrpcMessages(body []byte) GrpcMessage {
+	result := GrpcMessage{}
+	for len(body) >= kGrpcMessageHeaderLength {
+		compressed := body[0] == 1
+		length := int(binary.BigEndian.Uint32(body[1:kGrpcMessageHeaderLength]))
+		result.Count++
+		result.Length += length
+		result.Compressed = result.Compressed || compressed
+		if len(body) < kGrpcMessageHeaderLength+length {
+			break
+		}
+		body = body[kGrpcMessageHeaderLength+length:]
+	}
+	return result
+}
@@ -0,0 +1,264 @@
+package http2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/http2"
+	"kyanos/agent/protocol/protocoltest"
+	"maps"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2/hpack"
+)
+
+const kPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+func frame(frameType http2.FrameType, flags uint8, streamId uint32, payload []byte) []byte {
+	header := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), byte(frameType), flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[5:], streamId)
+	return append(header, payload...)
+}
+
+func encodeHeaders(encoder *hpack.Encoder, buf *bytes.Buffer, fields ...string) []byte {
+	buf.Reset()
+	for i := 0; i+1 < len(fields); i += 2 {
+		encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+func grpcMessage(payload string) []byte {
+	msg := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(payload)))
+	return append(msg, payload...)
+}
+
+func concat(messages ...[]byte) []byte {
+	result := []byte{}
+	for _, each := range messages {
+		result = append(result, each...)
+	}
+	return result
+}
+
+func grpcRequest(encoder *hpack.Encoder, buf *bytes.Buffer, streamId uint32, path string) []byte {
+	headers := encodeHeaders(encoder, buf, ":method", "POST", ":scheme", "http", ":path", path,
+		":authority", "localhost:50051", "content-type", "application/grpc", "te", "trailers")
+	return concat(
+		frame(http2.FrameHeaders, 0x4, streamId, headers),
+		frame(http2.FrameData, 0x1, streamId, grpcMessage("world")),
+	)
+}
+
+func grpcResponse(encoder *hpack.Encoder, buf *bytes.Buffer, streamId uint32, grpcStatus string) []byte {
+	headers := encodeHeaders(encoder, buf, ":status", "200", "content-type", "application/grpc")
+	trailers := encodeHeaders(encoder, buf, "grpc-status", grpcStatus, "grpc-message", "")
+	return concat(
+		frame(http2.FrameHeaders, 0x4, streamId, headers),
+		frame(http2.FrameData, 0, streamId, grpcMessage("hello world")),
+		frame(http2.FrameHeaders, 0x5, streamId, trailers),
+	)
+}
+
+func TestParseGrpcUnary(t *testing.T) {
+	var reqBuf, respBuf bytes.Buffer
+	reqEncoder, respEncoder := hpack.NewEncoder(&reqBuf), hpack.NewEncoder(&respBuf)
+	parser := http2.NewHttp2StreamParser()
+
+	reqData := concat([]byte(kPreface), frame(http2.FrameSettings, 0, 0, nil),
+		grpcRequest(reqEncoder, &reqBuf, 1, "/helloworld.Greeter/SayHello"))
+	respData := concat(frame(http2.FrameSettings, 0, 0, nil), frame(http2.FrameSettings, 0x1, 0, nil),
+		grpcResponse(respEncoder, &respBuf, 1, "0"))
+	reqs := protocoltest.ParseAll(t, parser, reqData, protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, respData, protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	req := records[0].Req.(*http2.Request)
+	resp := records[0].Resp.(*http2.Response)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/helloworld.Greeter/SayHello", req.FullMethod())
+	assert.True(t, req.Grpc)
+	assert.Equal(t, "helloworld.Greeter", req.GrpcService)
+	assert.Equal(t, "SayHello", req.GrpcMethod)
+	assert.Equal(t, http2.GrpcMessage{Count: 1, Length: 5}, req.GrpcMessage)
+	assert.Equal(t, "200", resp.StatusCode)
+	assert.Equal(t, 0, resp.GrpcStatus)
+	assert.Equal(t, http2.GrpcMessage{Count: 1, Length: 11}, resp.GrpcMessage)
+	assert.Equal(t, protocol.SuccessStatus, records[0].ResponseStatus)
+	assert.Equal(t, uint64(10), req.TimestampNs())
+	assert.Equal(t, uint64(20), resp.TimestampNs())
+	assert.Empty(t, reqs)
+	assert.Empty(t, resps)
+}
+
+func TestParseGrpcError(t *testing.T) {
+	var reqBuf, respBuf bytes.Buffer
+	reqEncoder, respEncoder := hpack.NewEncoder(&reqBuf), hpack.NewEncoder(&respBuf)
+	parser := http2.NewHttp2StreamParser()
+
+	// Trailers-Only response
+	trailersOnly := encodeHeaders(respEncoder, &respBuf, ":status", "200", "content-type", "application/grpc",
+		"grpc-status", "12", "grpc-message", "unknown method")
+	reqs := protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, 3, "/helloworld.Greeter/Missing"), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, frame(http2.FrameHeaders, 0x5, 3, trailersOnly), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	resp := records[0].Resp.(*http2.Response)
+	assert.Equal(t, 12, resp.GrpcStatus)
+	assert.Equal(t, "unknown method", resp.GrpcMsg)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+}
+
+func TestParseContinuation(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := hpack.NewEncoder(&buf)
+	parser := http2.NewHttp2StreamParser()
+
+	block := encodeHeaders(encoder, &buf, ":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "example.com")
+	data := concat(
+		frame(http2.FrameHeaders, 0x1, 5, block[:4]),
+		frame(http2.FrameContinuation, 0, 5, block[4:8]),
+		frame(http2.FrameContinuation, 0x4, 5, block[8:]),
+	)
+	reqs := protocoltest.ParseAll(t, parser, data, protocol.Request, 10)
+	assert.Len(t, reqs, 1)
+	queue := *reqs[5]
+	assert.Len(t, queue, 1)
+	f := queue[0].(*http2.Frame)
+	assert.True(t, f.EndStream)
+	assert.Equal(t, len(data), f.ByteSize())
+	assert.Equal(t, hpack.HeaderField{Name: ":path", Value: "/index.html"}, f.Headers[2])
+}
+
+func TestMatchWaitsForEndStream(t *testing.T) {
+	var reqBuf, respBuf bytes.Buffer
+	reqEncoder, respEncoder := hpack.NewEncoder(&reqBuf), hpack.NewEncoder(&respBuf)
+	parser := http2.NewHttp2StreamParser()
+
+	reqs := protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, 1, "/helloworld.Greeter/SayHello"), protocol.Request, 10)
+	headers := encodeHeaders(respEncoder, &respBuf, ":status", "200", "content-type", "application/grpc")
+	resps := protocoltest.ParseAll(t, parser, frame(http2.FrameHeaders, 0x4, 1, headers), protocol.Response, 20)
+	assert.Empty(t, parser.Match(reqs, resps))
+	assert.Len(t, reqs, 1)
+	assert.Len(t, resps, 1)
+}
+
+func TestMatchRstStream(t *testing.T) {
+	var reqBuf bytes.Buffer
+	reqEncoder := hpack.NewEncoder(&reqBuf)
+	parser := http2.NewHttp2StreamParser()
+
+	reqs := protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, 1, "/helloworld.Greeter/SayHello"), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, frame(http2.FrameRSTStream, 0, 1, []byte{0, 0, 0, 8}), protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.True(t, records[0].Resp.(*http2.Response).Reset)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+}
+
+func TestUnknownMessageTypeIgnored(t *testing.T) {
+	parser := http2.NewHttp2StreamParser()
+	streamBuffer := buffer.New(10000)
+	streamBuffer.Add(1, frame(http2.FrameData, 0, 1, []byte("abc")), 10)
+	result := parser.ParseStream(streamBuffer, protocol.Unknown)
+	assert.Equal(t, protocol.Ignore, result.ParseState)
+	assert.Equal(t, 12, result.ReadBytes)
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := http2.NewHttp2StreamParser()
+	streamBuffer := buffer.New(10000)
+	data := concat([]byte("garbage"), frame(http2.FrameData, 0, 1, []byte("abc")), frame(http2.FrameWindowUpdate, 0, 0, []byte{0, 0, 0, 1}))
+	streamBuffer.Add(1, data, 10)
+	assert.Equal(t, 7, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+}
+
+func TestFindBoundaryPartialFrame(t *testing.T) {
+	parser := http2.NewHttp2StreamParser()
+	streamBuffer := buffer.New(10000)
+	// a DATA frame header of 12336 bytes without its payload
+	streamBuffer.Add(1, []byte{0x00, 0x30, 0x30, 0x00, 0x30, 0x30, 0x30, 0x30, 0x30}, 10)
+	assert.Equal(t, -1, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+
+	streamBuffer = buffer.New(10000)
+	data := frame(http2.FrameData, 0, 1, []byte("abc"))
+	data = append(data, frame(http2.FrameData, 0, 1, []byte("a partial frame"))[:12]...)
+	streamBuffer.Add(1, data, 10)
+	assert.Equal(t, 0, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+	for i := range data {
+		assert.NotPanics(t, func() { parser.FindBoundary(streamBuffer, protocol.Request, i) })
+	}
+}
+
+func TestMatchDropsIdleStreams(t *testing.T) {
+	var reqBuf, respBuf bytes.Buffer
+	reqEncoder, respEncoder := hpack.NewEncoder(&reqBuf), hpack.NewEncoder(&respBuf)
+	parser := http2.NewHttp2StreamParser()
+
+	// the request of stream 1 never ends, like a stream caught in the middle
+	reqs := protocoltest.ParseAll(t, parser, frame(http2.FrameData, 0, 1, grpcMessage("world")), protocol.Request, 10)
+	later := protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, 3, "/helloworld.Greeter/SayHello"), protocol.Request, 61*1000*1000*1000)
+	reqs[3] = later[3]
+	resps := protocoltest.ParseAll(t, parser, grpcResponse(respEncoder, &respBuf, 3, "0"), protocol.Response, 62*1000*1000*1000)
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.Empty(t, reqs)
+	assert.Empty(t, resps)
+}
+
+func TestMatchKeepsLongPolls(t *testing.T) {
+	var reqBuf, respBuf bytes.Buffer
+	reqEncoder, respEncoder := hpack.NewEncoder(&reqBuf), hpack.NewEncoder(&respBuf)
+	parser := http2.NewHttp2StreamParser()
+
+	reqs := protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, 1, "/helloworld.Greeter/Watch"), protocol.Request, 10)
+	later := protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, 3, "/helloworld.Greeter/SayHello"), protocol.Request, 61*1000*1000*1000)
+	reqs[3] = later[3]
+	resps := protocoltest.ParseAll(t, parser, grpcResponse(respEncoder, &respBuf, 3, "0"), protocol.Response, 62*1000*1000*1000)
+	assert.Len(t, parser.Match(reqs, resps), 1)
+	assert.Len(t, reqs, 1)
+
+	// stream 1 is answered after being idle longer than the limit
+	resps = protocoltest.ParseAll(t, parser, grpcResponse(respEncoder, &respBuf, 1, "0"), protocol.Response, 120*1000*1000*1000)
+	records := parser.Match(reqs, resps)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "/helloworld.Greeter/Watch", records[0].Req.(*http2.Request).Path)
+	}
+	assert.Empty(t, reqs)
+	assert.Empty(t, resps)
+}
+
+func TestMatchBoundsPendingStreams(t *testing.T) {
+	var reqBuf bytes.Buffer
+	reqEncoder := hpack.NewEncoder(&reqBuf)
+	parser := http2.NewHttp2StreamParser()
+
+	reqs := map[protocol.StreamId]*protocol.ParsedMessageQueue{}
+	for i := 0; i <= 1000; i++ {
+		streamId := uint32(2*i + 1)
+		maps.Copy(reqs, protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, streamId, "/helloworld.Greeter/Watch"), protocol.Request, uint64(10+i)))
+	}
+	assert.Empty(t, parser.Match(reqs, map[protocol.StreamId]*protocol.ParsedMessageQueue{}))
+	assert.Len(t, reqs, 1000)
+	assert.NotContains(t, reqs, protocol.StreamId(1))
+}
+
+func TestGrpcFilter(t *testing.T) {
+	var reqBuf bytes.Buffer
+	reqEncoder := hpack.NewEncoder(&reqBuf)
+	parser := http2.NewHttp2StreamParser()
+	reqs := protocoltest.ParseAll(t, parser, grpcRequest(reqEncoder, &reqBuf, 1, "/helloworld.Greeter/SayHello"), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, frame(http2.FrameRSTStream, 0, 1, []byte{0, 0, 0, 8}), protocol.Response, 20)
+	req := parser.Match(reqs, resps)[0].Req
+
+	assert.True(t, http2.GrpcFilter{}.Filter(req, nil))
+	assert.True(t, http2.GrpcFilter{TargetServices: []string{"Greeter"}}.Filter(req, nil))
+	assert.True(t, http2.GrpcFilter{TargetServices: []string{"helloworld.Greeter"}, TargetMethods: []string{"SayHello"}}.Filter(req, nil))
+	assert.False(t, http2.GrpcFilter{TargetServices: []string{"Other"}}.Filter(req, nil))
+	assert.False(t, http2.GrpcFilter{TargetMethods: []string{"SayBye"}}.Filter(req, nil))
+}
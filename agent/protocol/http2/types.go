@@ -0,0 +1,244 @@
+package http2
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// See https://httpwg.org/specs/rfc9113.html#FrameHeader.
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+var frameTypeNames = map[FrameType]string{
+	FrameData:         "DATA",
+	FrameHeaders:      "HEADERS",
+	FramePriority:     "PRIORITY",
+	FrameRSTStream:    "RST_STREAM",
+	FrameSettings:     "SETTINGS",
+	FramePushPromise:  "PUSH_PROMISE",
+	FramePing:         "PING",
+	FrameGoAway:       "GOAWAY",
+	FrameWindowUpdate: "WINDOW_UPDATE",
+	FrameContinuation: "CONTINUATION",
+}
+
+func (t FrameType) String() string {
+	if name, ok := frameTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_FRAME_TYPE_%d", uint8(t))
+}
+
+const (
+	kFlagEndStream  uint8 = 0x1
+	kFlagAck        uint8 = 0x1
+	kFlagEndHeaders uint8 = 0x4
+	kFlagPadded     uint8 = 0x8
+	kFlagPriority   uint8 = 0x20
+)
+
+const kSettingsHeaderTableSize uint16 = 0x1
+
+const kFrameHeaderLength int = 9
+
+const kConnectionPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const kDefaultHeaderTableSize uint32 = 4096
+
+// gRPC Length-Prefixed-Message: compressed flag(1 byte) + message length(4 bytes).
+const kGrpcMessageHeaderLength int = 5
+
+var _ protocol.ParsedMessage = &Frame{}
+
+// Frame is a HEADERS (with its CONTINUATIONs decoded), DATA or RST_STREAM frame
+// of a stream. Frames are queued by stream id and combined into Request and
+// Response by Match.
+type Frame struct {
+	protocol.FrameBase
+	Type      FrameType
+	Flags     uint8
+	streamId  uint32
+	Headers   []hpack.HeaderField
+	Data      []byte
+	EndStream bool
+	isReq     bool
+}
+
+func (f *Frame) FormatToString() string {
+	return fmt.Sprintf("base=[%s] type=[%s] stream=[%d] flags=[%#x] headers=[%v] data_len=[%d]",
+		f.FrameBase.String(), f.Type, f.streamId, f.Flags, f.Headers, len(f.Data))
+}
+
+func (f *Frame) IsReq() bool {
+	return f.isReq
+}
+
+func (f *Frame) StreamId() protocol.StreamId {
+	return protocol.StreamId(f.streamId)
+}
+
+// GrpcMessage describes the Length-Prefixed-Messages carried by DATA frames.
+type GrpcMessage struct {
+	// number of messages
+	Count int
+	// total length of the messages, not including the 5 bytes prefix
+	Length     int
+	Compressed bool
+}
+
+var _ protocol.ParsedMessage = &Request{}
+
+type Request struct {
+	protocol.FrameBase
+	streamId  uint32
+	Method    string
+	Path      string
+	Authority string
+	Scheme    string
+	Headers   []hpack.HeaderField
+	Body      []byte
+	// gRPC fields, only set when content-type is application/grpc
+	Grpc        bool
+	GrpcService string
+	GrpcMethod  string
+	GrpcMessage GrpcMessage
+}
+
+func (r *Request) FormatToString() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s HTTP/2 stream=%d\n", r.Method, r.Path, r.streamId)
+	writeHeaders(&sb, r.Headers)
+	if r.Grpc {
+		fmt.Fprintf(&sb, "\n[gRPC] service=%s method=%s messages=%d length=%d compressed=%v\n",
+			r.GrpcService, r.GrpcMethod, r.GrpcMessage.Count, r.GrpcMessage.Length, r.GrpcMessage.Compressed)
+	}
+	if len(r.Body) > 0 {
+		sb.WriteString("\n")
+		sb.Write(r.Body)
+	}
+	return sb.String()
+}
+
+func (r *Request) IsReq() bool {
+	return true
+}
+
+func (r *Request) StreamId() protocol.StreamId {
+	return protocol.StreamId(r.streamId)
+}
+
+// FullMethod returns the gRPC method in form of "/package.Service/Method".
+func (r *Request) FullMethod() string {
+	return r.Path
+}
+
+var _ protocol.ParsedMessage = &Response{}
+var _ protocol.StatusfulMessage = &Response{}
+
+const kGrpcStatusNotSet = -1
+
+type Response struct {
+	protocol.FrameBase
+	streamId   uint32
+	StatusCode string
+	Headers    []hpack.HeaderField
+	Trailers   []hpack.HeaderField
+	Body       []byte
+	// RST_STREAM was received before the stream ends normally.
+	Reset bool
+	// gRPC fields
+	Grpc        bool
+	GrpcStatus  int
+	GrpcMsg     string
+	GrpcMessage GrpcMessage
+}
+
+func (r *Response) FormatToString() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "HTTP/2 %s stream=%d\n", r.StatusCode, r.streamId)
+	writeHeaders(&sb, r.Headers)
+	if r.Grpc {
+		fmt.Fprintf(&sb, "\n[gRPC] status=%d message=%s messages=%d length=%d compressed=%v\n",
+			r.GrpcStatus, r.GrpcMsg, r.GrpcMessage.Count, r.GrpcMessage.Length, r.GrpcMessage.Compressed)
+	}
+	if r.Reset {
+		sb.WriteString("\n[RST_STREAM]\n")
+	}
+	if len(r.Body) > 0 {
+		sb.WriteString("\n")
+		sb.Write(r.Body)
+	}
+	if len(r.Trailers) > 0 {
+		sb.WriteString("\n")
+		writeHeaders(&sb, r.Trailers)
+	}
+	return sb.String()
+}
+
+func (r *Response) IsReq() bool {
+	return false
+}
+
+func (r *Response) StreamId() protocol.StreamId {
+	return protocol.StreamId(r.streamId)
+}
+
+func (r *Response) Status() protocol.ResponseStatus {
+	if r.Reset {
+		return protocol.FailStatus
+	}
+	if r.Grpc && r.GrpcStatus != kGrpcStatusNotSet {
+		if r.GrpcStatus == 0 {
+			return protocol.SuccessStatus
+		}
+		return protocol.FailStatus
+	}
+	if strings.HasPrefix(r.StatusCode, "4") || strings.HasPrefix(r.StatusCode, "5") {
+		return protocol.FailStatus
+	}
+	if r.StatusCode == "" {
+		return protocol.UnknownStatus
+	}
+	return protocol.SuccessStatus
+}
+
+func writeHeaders(sb *strings.Builder, headers []hpack.HeaderField) {
+	for _, h := range headers {
+		fmt.Fprintf(sb, "%s: %s\n", h.Name, h.Value)
+	}
+}
+
+var _ protocol.ProtocolStreamParser = &Http2StreamParser{}
+
+// pendingHeaderBlock holds a header block fragment until END_HEADERS is seen.
+type pendingHeaderBlock struct {
+	frameType FrameType
+	streamId  uint32
+	flags     uint8
+	block     []byte
+	byteSize  int
+}
+
+// Http2StreamParser keeps a HPACK decoder for each direction, since the
+// dynamic table is built from all header blocks of a connection.
+type Http2StreamParser struct {
+	reqDecoder  *hpack.Decoder
+	respDecoder *hpack.Decoder
+	reqPending  *pendingHeaderBlock
+	respPending *pendingHeaderBlock
+}
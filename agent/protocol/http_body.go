@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decoded bodies larger than this are truncated, so a small compressed body
+// can't make us hold a huge one
+const kMaxDecodedHttpBodySize = 1 << 20
+
+var errInvalidChunk = errors.New("invalid chunk")
+
+// readHttpLine reads a line of the chunked framing without the CRLF.
+func readHttpLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errInvalidChunk
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// readChunkedBody reads a chunked body including the trailer section, it
+// returns io.ErrUnexpectedEOF if the body is not complete yet.
+func readChunkedBody(r *bufio.Reader) (body []byte, trailer http.Header, err error) {
+	var b bytes.Buffer
+	for {
+		line, err := readHttpLine(r)
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		sizeStr, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseUint(strings.TrimSpace(sizeStr), 16, 63)
+		if err != nil {
+			return nil, nil, errInvalidChunk
+		}
+		if size == 0 {
+			break
+		}
+		// copy instead of allocating size bytes, the size may be garbage
+		if _, err := io.CopyN(&b, r, int64(size)); err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		line, err = readHttpLine(r)
+		if err != nil {
+			return nil, nil, unexpectedEOF(err)
+		} else if line != "" {
+			return nil, nil, errInvalidChunk
+		}
+	}
+	mimeHeader, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+	if len(mimeHeader) > 0 {
+		trailer = http.Header(mimeHeader)
+	}
+	return b.Bytes(), trailer, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readHttpBody reads a body framed by Content-Length or the chunked
+// Transfer-Encoding. A negative contentLength of a non chunked body means
+// the body ends when the connection is closed, so the rest of r is read.
+func readHttpBody(r *bufio.Reader, transferEncoding []string, contentLength int64) (body []byte, trailer http.Header, err error) {
+	if len(transferEncoding) > 0 && transferEncoding[0] == "chunked" {
+		return readChunkedBody(r)
+	}
+	if contentLength < 0 {
+		body, err = io.ReadAll(r)
+		return body, nil, err
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, r, contentLength); err != nil {
+		return nil, nil, unexpectedEOF(err)
+	}
+	return b.Bytes(), nil, nil
+}
+
+func httpBodyAllowed(statusCode int) bool {
+	return statusCode >= 200 && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+func newContentDecoder(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "br":
+		return brotli.NewReader(r), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, errors.ErrUnsupported
+	}
+}
+
+// decodeContent undoes the codings of a Content-Encoding header in the
+// reverse order they were applied. The body is returned as it is if a coding
+// is unknown or the body is not valid, for example because kyanos truncated
+// it.
+func decodeContent(body []byte, contentEncoding string) []byte {
+	if contentEncoding == "" || len(body) == 0 {
+		return body
+	}
+	codings := strings.Split(contentEncoding, ",")
+	decoded := body
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "identity" || coding == "" {
+			continue
+		}
+		var err error
+		if coding == "deflate" {
+			decoded, err = inflate(decoded)
+		} else {
+			var r io.Reader
+			r, err = newContentDecoder(coding, bytes.NewReader(decoded))
+			if err == nil {
+				decoded, err = io.ReadAll(io.LimitReader(r, kMaxDecodedHttpBodySize))
+				if closer, ok := r.(io.Closer); ok {
+					closer.Close()
+				}
+			}
+		}
+		if err != nil {
+			return body
+		}
+	}
+	return decoded
+}
+
+// inflate decodes a deflate coding, which should be zlib wrapped but is raw
+// deflate for some servers.
+func inflate(body []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		r = flate.NewReader(bytes.NewReader(body))
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, kMaxDecodedHttpBodySize))
+}
@@ -2,6 +2,8 @@ package protocol_test
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"kyanos/agent/buffer"
@@ -13,6 +15,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -164,6 +168,150 @@ func TestParseResponse(t *testing.T) {
 	assert.Equal(t, uint64(20), message.Seq())
 }
 
+func TestParseChunkedResponse(t *testing.T) {
+	chunked := "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: Grpc-Status\r\n" +
+		"\r\n" +
+		"7;ext=1\r\npixiela\r\n" +
+		"e\r\nbs is awesome!\r\n" +
+		"0\r\n" +
+		"Grpc-Status: 0\r\n" +
+		"\r\n"
+	httpMessage := chunked + httpRespMessage
+	buffer := buffer.New(1000)
+	buffer.Add(10, []byte(httpMessage), 10000)
+	parser := protocol.HTTPStreamParser{}
+
+	parseResult := parser.ParseResponse(httpMessage, protocol.Response, 10, 20, buffer)
+
+	assert.Equal(t, protocol.Success, parseResult.ParseState)
+	assert.Equal(t, len(chunked), parseResult.ReadBytes)
+	resp := parseResult.ParsedMessages[0].(*protocol.ParsedHttpResponse)
+	assert.Equal(t, len(chunked), resp.ByteSize())
+	assert.Equal(t, "pixielabs is awesome!", string(resp.Body))
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+	assert.Contains(t, resp.FormatToString(), "\r\n\r\npixielabs is awesome!\r\nGrpc-Status: 0\r\n")
+
+	for _, truncated := range []int{len(chunked) - 2, len(chunked) - 20, len(chunked) - 40} {
+		parseResult = parser.ParseResponse(chunked[:truncated], protocol.Response, 10, 20, buffer)
+		assert.Equal(t, protocol.NeedsMoreData, parseResult.ParseState, truncated)
+	}
+}
+
+func TestParseChunkedRequest(t *testing.T) {
+	chunked := "POST /upload HTTP/1.1\r\n" +
+		"Host: www.baidu.com\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n" +
+		"0\r\n" +
+		"\r\n"
+	httpMessage := chunked + "GET /abc HTTP/1.1\r\nHost: www.baidu.com\r\n\r\n"
+	parser := protocol.HTTPStreamParser{}
+
+	parseResult := parser.ParseRequest(httpMessage, protocol.Request, 10, 20)
+
+	assert.Equal(t, protocol.Success, parseResult.ParseState)
+	assert.Equal(t, len(chunked), parseResult.ReadBytes)
+	req := parseResult.ParsedMessages[0].(*protocol.ParsedHttpRequest)
+	assert.Equal(t, "hello", string(req.Body))
+	assert.Nil(t, req.Trailer)
+
+	parseResult = parser.ParseRequest(httpMessage[:len(chunked)-2], protocol.Request, 10, 20)
+	assert.Equal(t, protocol.NeedsMoreData, parseResult.ParseState)
+
+	parseResult = parser.ParseRequest(strings.Replace(chunked, "5\r\n", "xyz\r\n", 1), protocol.Request, 10, 20)
+	assert.Equal(t, protocol.Invalid, parseResult.ParseState)
+}
+
+func TestParseLargeResponse(t *testing.T) {
+	body := strings.Repeat("a", 10000)
+	large := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	httpMessage := large + httpRespMessage
+	buffer := buffer.New(100000)
+	buffer.Add(10, []byte(httpMessage), 10000)
+	parser := protocol.HTTPStreamParser{}
+
+	parseResult := parser.ParseResponse(httpMessage, protocol.Response, 10, 20, buffer)
+
+	assert.Equal(t, protocol.Success, parseResult.ParseState)
+	assert.Equal(t, len(large), parseResult.ReadBytes)
+	assert.Equal(t, body, string(parseResult.ParsedMessages[0].(*protocol.ParsedHttpResponse).Body))
+}
+
+func TestParseNoContentResponse(t *testing.T) {
+	noContent := "HTTP/1.1 204 No Content\r\n\r\n"
+	httpMessage := noContent + httpRespMessage
+	buffer := buffer.New(1000)
+	buffer.Add(10, []byte(httpMessage), 10000)
+	parser := protocol.HTTPStreamParser{}
+
+	parseResult := parser.ParseResponse(httpMessage, protocol.Response, 10, 20, buffer)
+
+	assert.Equal(t, protocol.Success, parseResult.ParseState)
+	assert.Equal(t, len(noContent), parseResult.ReadBytes)
+}
+
+func TestParseSwitchingProtocolsResponse(t *testing.T) {
+	switching := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: WebSocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n" +
+		"\r\n"
+	// the first frame of the server follows the handshake
+	httpMessage := switching + "\x81\x02hi"
+	buffer := buffer.New(1000)
+	buffer.Add(10, []byte(httpMessage), 10000)
+	parser := protocol.HTTPStreamParser{}
+
+	parseResult := parser.ParseResponse(httpMessage, protocol.Response, 10, 20, buffer)
+
+	assert.Equal(t, protocol.Success, parseResult.ParseState)
+	assert.Equal(t, len(switching), parseResult.ReadBytes)
+	assert.Equal(t, "websocket", parseResult.ParsedMessages[0].(*protocol.ParsedHttpResponse).Upgrade)
+}
+
+func TestParseCompressedResponse(t *testing.T) {
+	const plain = `{"message": "pixielabs is awesome!"}`
+	compress := map[string]func(w *bytes.Buffer) io.WriteCloser{
+		"gzip": func(w *bytes.Buffer) io.WriteCloser { return gzip.NewWriter(w) },
+		"br":   func(w *bytes.Buffer) io.WriteCloser { return brotli.NewWriter(w) },
+		"zstd": func(w *bytes.Buffer) io.WriteCloser {
+			encoder, _ := zstd.NewWriter(w)
+			return encoder
+		},
+	}
+	parser := protocol.HTTPStreamParser{}
+	for encoding, newWriter := range compress {
+		t.Run(encoding, func(t *testing.T) {
+			var compressed bytes.Buffer
+			w := newWriter(&compressed)
+			w.Write([]byte(plain))
+			w.Close()
+			httpMessage := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s",
+				encoding, compressed.Len(), compressed.String())
+			buffer := buffer.New(1000)
+			buffer.Add(10, []byte(httpMessage), 10000)
+
+			parseResult := parser.ParseResponse(httpMessage, protocol.Response, 10, 20, buffer)
+
+			assert.Equal(t, protocol.Success, parseResult.ParseState)
+			resp := parseResult.ParsedMessages[0].(*protocol.ParsedHttpResponse)
+			assert.Equal(t, len(httpMessage), resp.ByteSize())
+			assert.Equal(t, plain, string(resp.Body))
+			assert.True(t, strings.HasSuffix(resp.FormatToString(), "\r\n\r\n"+plain))
+		})
+	}
+
+	// kyanos may have truncated the body, it is shown as it is
+	httpMessage := "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: 5\r\n\r\nhello"
+	buffer := buffer.New(1000)
+	buffer.Add(10, []byte(httpMessage), 10000)
+	parseResult := parser.ParseResponse(httpMessage, protocol.Response, 10, 20, buffer)
+	assert.Equal(t, "hello", string(parseResult.ParsedMessages[0].(*protocol.ParsedHttpResponse).Body))
+}
+
 func TestHttpFilter_Filter(t *testing.T) {
 	type fields struct {
 		TargetPath       string
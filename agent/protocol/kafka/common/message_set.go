@@ -2,19 +2,35 @@ package common
 
 import (
 	"encoding/json"
+	"slices"
 )
 
-type RecordMessage struct {
+type RecordHeader struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
 }
 
+type RecordMessage struct {
+	OffsetDelta int32  `json:"offset_delta"`
+	Key         string `json:"key"`
+	// the first bytes of the value, at most RecordValuePreviewSize
+	Value     string         `json:"value"`
+	ValueSize int            `json:"value_size"`
+	Headers   []RecordHeader `json:"headers,omitempty"`
+}
+
+// RecordValuePreviewSize is the size of the value kept for a record.
+const RecordValuePreviewSize = 1024
+
 func (r RecordMessage) ToJSON() ([]byte, error) {
 	return json.Marshal(r)
 }
 
 type RecordBatch struct {
-	Records []RecordMessage `json:"records"`
+	BaseOffset int64 `json:"base_offset"`
+	// gzip, snappy, lz4 or zstd, empty if the batch is not compressed
+	Compression string          `json:"compression,omitempty"`
+	Records     []RecordMessage `json:"records"`
 }
 
 func (r RecordBatch) ToJSON() ([]byte, error) {
@@ -38,10 +54,14 @@ func (m MessageSet) ToJSON(omitRecordBatches bool) ([]byte, error) {
 }
 
 func (lhs RecordMessage) Equals(rhs RecordMessage) bool {
-	return lhs.Key == rhs.Key && lhs.Value == rhs.Value
+	return lhs.OffsetDelta == rhs.OffsetDelta && lhs.Key == rhs.Key && lhs.Value == rhs.Value &&
+		lhs.ValueSize == rhs.ValueSize && slices.Equal(lhs.Headers, rhs.Headers)
 }
 
 func (lhs RecordBatch) Equals(rhs RecordBatch) bool {
+	if lhs.BaseOffset != rhs.BaseOffset || lhs.Compression != rhs.Compression {
+		return false
+	}
 	if len(lhs.Records) != len(rhs.Records) {
 		return false
 	}
@@ -0,0 +1,93 @@
+package decoder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy/xerial"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+type compressionCodec int16
+
+const kCompressionCodecMask = 0x07
+
+const (
+	kCompressionNone compressionCodec = iota
+	kCompressionGzip
+	kCompressionSnappy
+	kCompressionLz4
+	kCompressionZstd
+)
+
+func (c compressionCodec) String() string {
+	switch c {
+	case kCompressionNone:
+		return "none"
+	case kCompressionGzip:
+		return "gzip"
+	case kCompressionSnappy:
+		return "snappy"
+	case kCompressionLz4:
+		return "lz4"
+	case kCompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", int16(c))
+	}
+}
+
+// The maximum size of the records of a decompressed batch, larger batches
+// are not decoded.
+const kMaxDecompressedSize = 64 << 20
+
+var errTooLarge = errors.New("decompressed records are too large")
+
+// decompress decompresses the records of a record batch.
+func decompress(codec compressionCodec, compressed []byte) ([]byte, error) {
+	switch codec {
+	case kCompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAllCapped(r)
+	case kCompressionSnappy:
+		// The Java client frames the snappy blocks like xerial snappy-java.
+		decompressed, err := xerial.Decode(compressed)
+		if err != nil {
+			return nil, err
+		}
+		if len(decompressed) > kMaxDecompressedSize {
+			return nil, errTooLarge
+		}
+		return decompressed, nil
+	case kCompressionLz4:
+		return readAllCapped(lz4.NewReader(bytes.NewReader(compressed)))
+	case kCompressionZstd:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(kMaxDecompressedSize))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(compressed, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression codec %s", codec)
+	}
+}
+
+func readAllCapped(r io.Reader) ([]byte, error) {
+	decompressed, err := io.ReadAll(io.LimitReader(r, kMaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > kMaxDecompressedSize {
+		return nil, errTooLarge
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,46 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func compressLz4(t *testing.T, data []byte, options ...lz4.Option) []byte {
+	var compressed bytes.Buffer
+	w := lz4.NewWriter(&compressed)
+	assert.NoError(t, w.Apply(options...))
+	_, err := w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return compressed.Bytes()
+}
+
+func TestDecompressLz4(t *testing.T) {
+	// repetitive enough for matches, longer than several 64KB blocks
+	var data []byte
+	for i := 0; len(data) < 300<<10; i++ {
+		data = fmt.Appendf(data, "record-%d:value-%d;", i%1000, i%7)
+	}
+	frame := compressLz4(t, data, lz4.BlockSizeOption(lz4.Block64Kb), lz4.BlockChecksumOption(true))
+	assert.Less(t, len(frame), len(data)/2)
+	result, err := decompress(kCompressionLz4, frame)
+	assert.NoError(t, err)
+	assert.Equal(t, data, result)
+
+	_, err = decompress(kCompressionLz4, frame[:len(frame)/2])
+	assert.Error(t, err)
+	corrupted := bytes.Clone(frame)
+	corrupted[len(corrupted)/2] ^= 0xff
+	_, err = decompress(kCompressionLz4, corrupted)
+	assert.Error(t, err)
+}
+
+func TestDecompressLz4TooLarge(t *testing.T) {
+	frame := compressLz4(t, make([]byte, kMaxDecompressedSize+1))
+	_, err := decompress(kCompressionLz4, frame)
+	assert.ErrorIs(t, err, errTooLarge)
+}
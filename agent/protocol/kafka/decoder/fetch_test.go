@@ -222,11 +222,11 @@ func TestExtractFetchRespV11(t *testing.T) {
 			"\xff\xff\xff\xff\xff\xff\xff\xff\x00\x00\x00\x01\x2a\x00\x00\x00\x01\x1e\x4d\x79\x20\x73\x65" +
 			"\x63\x6f\x6e\x64\x20\x65\x76\x65\x6e\x74\x00")
 
-	recordBatch1 := RecordBatch{Records: []RecordMessage{{Key: "", Value: ""}}}
-	recordBatch2 := RecordBatch{Records: []RecordMessage{{Key: "", Value: ""}}}
-	recordBatch3 := RecordBatch{Records: []RecordMessage{{Key: "", Value: ""}}}
-	recordBatch4 := RecordBatch{Records: []RecordMessage{{Key: "", Value: "My first event"}}}
-	recordBatch5 := RecordBatch{Records: []RecordMessage{{Key: "", Value: "My second event"}}}
+	recordBatch1 := RecordBatch{Records: []RecordMessage{{Key: "", Value: "", ValueSize: 0}}}
+	recordBatch2 := RecordBatch{BaseOffset: 1, Records: []RecordMessage{{Key: "", Value: "", ValueSize: 0}}}
+	recordBatch3 := RecordBatch{BaseOffset: 2, Records: []RecordMessage{{Key: "", Value: "", ValueSize: 0}}}
+	recordBatch4 := RecordBatch{BaseOffset: 3, Records: []RecordMessage{{Key: "", Value: "My first event", ValueSize: 14}}}
+	recordBatch5 := RecordBatch{BaseOffset: 4, Records: []RecordMessage{{Key: "", Value: "My second event", ValueSize: 15}}}
 	messageSet := MessageSet{
 		Size:          369,
 		RecordBatches: []RecordBatch{recordBatch1, recordBatch2, recordBatch3, recordBatch4, recordBatch5},
@@ -282,11 +282,11 @@ func TestExtractFetchRespV12(t *testing.T) {
 			"\x2a\x00\x00\x00\x01\x1e\x4d\x79\x20\x73\x65\x63\x6f\x6e\x64\x20\x65\x76\x65\x6e\x74\x00\x00" +
 			"\x00\x00")
 
-	recordBatch1 := RecordBatch{Records: []RecordMessage{{Key: "", Value: ""}}}
-	recordBatch2 := RecordBatch{Records: []RecordMessage{{Key: "", Value: ""}}}
-	recordBatch3 := RecordBatch{Records: []RecordMessage{{Key: "", Value: ""}}}
-	recordBatch4 := RecordBatch{Records: []RecordMessage{{Key: "", Value: "My first event"}}}
-	recordBatch5 := RecordBatch{Records: []RecordMessage{{Key: "", Value: "My second event"}}}
+	recordBatch1 := RecordBatch{Records: []RecordMessage{{Key: "", Value: "", ValueSize: 0}}}
+	recordBatch2 := RecordBatch{BaseOffset: 1, Records: []RecordMessage{{Key: "", Value: "", ValueSize: 0}}}
+	recordBatch3 := RecordBatch{BaseOffset: 2, Records: []RecordMessage{{Key: "", Value: "", ValueSize: 0}}}
+	recordBatch4 := RecordBatch{BaseOffset: 3, Records: []RecordMessage{{Key: "", Value: "My first event", ValueSize: 14}}}
+	recordBatch5 := RecordBatch{BaseOffset: 4, Records: []RecordMessage{{Key: "", Value: "My second event", ValueSize: 15}}}
 	messageSet := MessageSet{
 		Size:          369,
 		RecordBatches: []RecordBatch{recordBatch1, recordBatch2, recordBatch3, recordBatch4, recordBatch5},
@@ -2,9 +2,24 @@ package decoder
 
 import (
 	"errors"
+	"fmt"
 	"kyanos/agent/protocol/kafka/common"
 )
 
+func (pd *PacketDecoder) ExtractRecordHeader() (common.RecordHeader, error) {
+	var h common.RecordHeader
+	var err error
+	h.Key, err = pd.ExtractBytesZigZag()
+	if err != nil {
+		return h, err
+	}
+	h.Value, err = pd.ExtractBytesZigZag()
+	if err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
 func (pd *PacketDecoder) ExtractRecordMessage() (common.RecordMessage, error) {
 	var r common.RecordMessage
 	var err error
@@ -17,15 +32,17 @@ func (pd *PacketDecoder) ExtractRecordMessage() (common.RecordMessage, error) {
 		return r, err
 	}
 
+	// attributes
 	_, err = pd.ExtractInt8()
 	if err != nil {
 		return r, err
 	}
+	// timestampDelta
 	_, err = pd.ExtractVarlong()
 	if err != nil {
 		return r, err
 	}
-	_, err = pd.ExtractVarint()
+	r.OffsetDelta, err = pd.ExtractVarint()
 	if err != nil {
 		return r, err
 	}
@@ -37,6 +54,21 @@ func (pd *PacketDecoder) ExtractRecordMessage() (common.RecordMessage, error) {
 	if err != nil {
 		return r, err
 	}
+	r.ValueSize = len(r.Value)
+	if len(r.Value) > common.RecordValuePreviewSize {
+		r.Value = r.Value[:common.RecordValuePreviewSize]
+	}
+	headerCount, err := pd.ExtractVarint()
+	if err != nil {
+		return r, err
+	}
+	for i := int32(0); i < headerCount; i++ {
+		header, err := pd.ExtractRecordHeader()
+		if err != nil {
+			return r, err
+		}
+		r.Headers = append(r.Headers, header)
+	}
 
 	err = pd.JumpToOffset()
 	if err != nil {
@@ -45,13 +77,18 @@ func (pd *PacketDecoder) ExtractRecordMessage() (common.RecordMessage, error) {
 	return r, nil
 }
 
+// ExtractRecordBatch extracts a record batch of magic 2, the records of a
+// compressed batch are decompressed.
+// https://kafka.apache.org/documentation/#recordbatch
 func (pd *PacketDecoder) ExtractRecordBatch(offset *int32) (common.RecordBatch, error) {
 	const kBaseOffsetLength = 8
 	const kLengthLength = 4
+	// From partitionLeaderEpoch to the number of records.
+	const kBatchHeaderLength = 49
 
 	var r common.RecordBatch
 	var err error
-	_, err = pd.ExtractInt64()
+	r.BaseOffset, err = pd.ExtractInt64()
 	if err != nil {
 		return r, err
 	}
@@ -65,6 +102,7 @@ func (pd *PacketDecoder) ExtractRecordBatch(offset *int32) (common.RecordBatch,
 		return r, err
 	}
 
+	// partitionLeaderEpoch
 	_, err = pd.ExtractInt32()
 	if err != nil {
 		return r, err
@@ -80,18 +118,22 @@ func (pd *PacketDecoder) ExtractRecordBatch(offset *int32) (common.RecordBatch,
 		return r, errors.New("unknown magic in ExtractRecordBatch")
 	}
 
+	// crc
 	_, err = pd.ExtractInt32()
 	if err != nil {
 		return r, err
 	}
-	_, err = pd.ExtractInt16()
+	attributes, err := pd.ExtractInt16()
 	if err != nil {
 		return r, err
 	}
+	compression := compressionCodec(attributes & kCompressionCodecMask)
+	// lastOffsetDelta
 	_, err = pd.ExtractInt32()
 	if err != nil {
 		return r, err
 	}
+	// baseTimestamp, maxTimestamp and producerId
 	_, err = pd.ExtractInt64()
 	if err != nil {
 		return r, err
@@ -104,18 +146,51 @@ func (pd *PacketDecoder) ExtractRecordBatch(offset *int32) (common.RecordBatch,
 	if err != nil {
 		return r, err
 	}
+	// producerEpoch
 	_, err = pd.ExtractInt16()
 	if err != nil {
 		return r, err
 	}
+	// baseSequence
 	_, err = pd.ExtractInt32()
 	if err != nil {
 		return r, err
 	}
 
-	r.Records, err = ExtractRegularArray(pd.ExtractRecordMessage, pd)
-	if err != nil {
-		return r, err
+	if compression == kCompressionNone {
+		r.Records, err = ExtractRegularArray(pd.ExtractRecordMessage, pd)
+		if err != nil {
+			return r, err
+		}
+	} else {
+		r.Compression = compression.String()
+		count, err := pd.ExtractInt32()
+		if err != nil {
+			return r, err
+		}
+		if count < 0 {
+			return r, errors.New("number of records cannot be negative")
+		}
+		if length < kBatchHeaderLength {
+			return r, errors.New("record batch is shorter than its header")
+		}
+		compressed, err := ExtractBytesCore(int(length-kBatchHeaderLength), pd.binaryDecoder)
+		if err != nil {
+			return r, err
+		}
+		records, err := decompress(compression, []byte(compressed))
+		if err != nil {
+			return r, fmt.Errorf("failed to decompress %s record batch: %w", r.Compression, err)
+		}
+		recordsDecoder := NewPacketDecoder(records)
+		r.Records = make([]common.RecordMessage, 0, min(int(count), len(records)))
+		for i := int32(0); i < count; i++ {
+			record, err := recordsDecoder.ExtractRecordMessage()
+			if err != nil {
+				return r, err
+			}
+			r.Records = append(r.Records, record)
+		}
 	}
 	err = pd.JumpToOffset()
 	if err != nil {
@@ -1,12 +1,19 @@
 package decoder_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"strings"
 	"testing"
 
 	. "kyanos/agent/protocol/kafka/common"
 	"kyanos/agent/protocol/kafka/decoder"
 	. "kyanos/agent/protocol/kafka/decoder"
 
+	"github.com/klauspost/compress/snappy/xerial"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,7 +29,7 @@ func TestExtractRecordMessage(t *testing.T) {
 	}
 	{
 		input := []byte("\x28\x00\x00\x00\x06key\x1cMy first event\x00")
-		expectedResult := RecordMessage{Key: "key", Value: "My first event"}
+		expectedResult := RecordMessage{Key: "key", Value: "My first event", ValueSize: 14}
 		decoder := NewPacketDecoder(input)
 		result, err := decoder.ExtractRecordMessage()
 		assert.NoError(t, err)
@@ -36,7 +43,7 @@ func TestExtractRecordBatchV8(t *testing.T) {
 			"\x00\x00\x00\x00\x00\x00\x00\x01\x7a\xb2\x0a\x70\x1d\x00\x00\x01\x7a\xb2\x0a\x70\x1d\xff" +
 			"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x00\x00\x00\x01\x28\x00\x00\x00\x01" +
 			"\x1c\x4d\x79\x20\x66\x69\x72\x73\x74\x20\x65\x76\x65\x6e\x74\x00")
-	expectedResult := RecordBatch{Records: []RecordMessage{{Key: "", Value: "My first event"}}}
+	expectedResult := RecordBatch{Records: []RecordMessage{{Key: "", Value: "My first event", ValueSize: 14}}}
 	decoder := NewPacketDecoder(input)
 	decoder.SetAPIInfo(KProduce, 8)
 	var batchLength int32
@@ -52,7 +59,7 @@ func TestExtractRecordBatchV9(t *testing.T) {
 			"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x00\x00\x00\x01\x38\x00\x00\x00\x01" +
 			"\x2c\x54\x68\x69\x73\x20\x69\x73\x20\x6d\x79\x20\x66\x69\x72\x73\x74\x20\x65\x76\x65\x6e" +
 			"\x74\x00")
-	expectedResult := RecordBatch{Records: []RecordMessage{{Key: "", Value: "This is my first event"}}}
+	expectedResult := RecordBatch{Records: []RecordMessage{{Key: "", Value: "This is my first event", ValueSize: 22}}}
 	decoder := NewPacketDecoder(input)
 	decoder.SetAPIInfo(KProduce, 9)
 	var batchLength int32
@@ -60,3 +67,93 @@ func TestExtractRecordBatchV9(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResult, result)
 }
+
+func appendRecord(dst []byte, offsetDelta int64, key, value string, headers ...RecordHeader) []byte {
+	body := []byte{0}                   // attributes
+	body = binary.AppendVarint(body, 0) // timestampDelta
+	body = binary.AppendVarint(body, offsetDelta)
+	body = binary.AppendVarint(body, int64(len(key)))
+	body = append(body, key...)
+	body = binary.AppendVarint(body, int64(len(value)))
+	body = append(body, value...)
+	body = binary.AppendVarint(body, int64(len(headers)))
+	for _, header := range headers {
+		body = binary.AppendVarint(body, int64(len(header.Key)))
+		body = append(body, header.Key...)
+		body = binary.AppendVarint(body, int64(len(header.Value)))
+		body = append(body, header.Value...)
+	}
+	dst = binary.AppendVarint(dst, int64(len(body)))
+	return append(dst, body...)
+}
+
+func recordBatch(baseOffset int64, attributes int16, count int32, records []byte) []byte {
+	var batch []byte
+	batch = binary.BigEndian.AppendUint64(batch, uint64(baseOffset))
+	batch = binary.BigEndian.AppendUint32(batch, uint32(49+len(records)))
+	batch = binary.BigEndian.AppendUint32(batch, 0) // partitionLeaderEpoch
+	batch = append(batch, 2)                        // magic
+	batch = binary.BigEndian.AppendUint32(batch, 0) // crc
+	batch = binary.BigEndian.AppendUint16(batch, uint16(attributes))
+	batch = binary.BigEndian.AppendUint32(batch, uint32(count-1))
+	batch = binary.BigEndian.AppendUint64(batch, 0) // baseTimestamp
+	batch = binary.BigEndian.AppendUint64(batch, 0) // maxTimestamp
+	batch = binary.BigEndian.AppendUint64(batch, 0xffffffffffffffff)
+	batch = binary.BigEndian.AppendUint16(batch, 0xffff)
+	batch = binary.BigEndian.AppendUint32(batch, 0xffffffff)
+	batch = binary.BigEndian.AppendUint32(batch, uint32(count))
+	return append(batch, records...)
+}
+
+func TestExtractCompressedRecordBatch(t *testing.T) {
+	records := appendRecord(nil, 0, "", "My first event")
+	records = appendRecord(records, 1, "key", "poison", RecordHeader{Key: "trace-id", Value: "42"})
+	expectedRecords := []RecordMessage{
+		{Key: "", Value: "My first event", ValueSize: 14},
+		{OffsetDelta: 1, Key: "key", Value: "poison", ValueSize: 6, Headers: []RecordHeader{{Key: "trace-id", Value: "42"}}},
+	}
+
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	_, err := w.Write(records)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	var lz4Frame bytes.Buffer
+	lz4Writer := lz4.NewWriter(&lz4Frame)
+	_, err = lz4Writer.Write(records)
+	assert.NoError(t, err)
+	assert.NoError(t, lz4Writer.Close())
+	encoder, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	defer encoder.Close()
+
+	tests := []struct {
+		compression string
+		attributes  int16
+		compressed  []byte
+	}{
+		{"gzip", 1, gzipped.Bytes()},
+		{"snappy", 2, xerial.Encode(nil, records)},
+		{"lz4", 3, lz4Frame.Bytes()},
+		{"zstd", 4, encoder.EncodeAll(records, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.compression, func(t *testing.T) {
+			// the transactional bit is kept apart from the codec
+			decoder := NewPacketDecoder(recordBatch(7, tt.attributes|0x10, 2, tt.compressed))
+			decoder.SetAPIInfo(KProduce, 9)
+			var batchLength int32
+			result, err := decoder.ExtractRecordBatch(&batchLength)
+			assert.NoError(t, err)
+			assert.Equal(t, RecordBatch{BaseOffset: 7, Compression: tt.compression, Records: expectedRecords}, result)
+		})
+	}
+}
+
+func TestExtractRecordValuePreview(t *testing.T) {
+	value := strings.Repeat("v", RecordValuePreviewSize+1)
+	decoder := NewPacketDecoder(appendRecord(nil, 0, "", value))
+	result, err := decoder.ExtractRecordMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, RecordMessage{Value: value[:RecordValuePreviewSize], ValueSize: len(value)}, result)
+}
@@ -19,8 +19,8 @@ func TestExtractProduceReqV7(t *testing.T) {
 			"\x6C\x61\x2C\x20\x6D\x75\x6E\x64\x6F\x21\x00")
 	recordBatch := RecordBatch{
 		Records: []RecordMessage{
-			{Key: "", Value: "test"},
-			{Key: "", Value: "\xc2Hola, mundo!"},
+			{Key: "", Value: "test", ValueSize: 4},
+			{OffsetDelta: 1, Key: "", Value: "\xc2Hola, mundo!", ValueSize: 13},
 		},
 	}
 	messageSet := MessageSet{Size: 92, RecordBatches: []RecordBatch{recordBatch}}
@@ -49,7 +49,7 @@ func TestExtractProduceReqV8(t *testing.T) {
 			"\x4d\x79\x20\x66\x69\x72\x73\x74\x20\x65\x76\x65\x6e\x74\x00")
 	recordBatch := RecordBatch{
 		Records: []RecordMessage{
-			{Key: "", Value: "My first event"},
+			{Key: "", Value: "My first event", ValueSize: 14},
 		},
 	}
 	messageSet := MessageSet{Size: 70, RecordBatches: []RecordBatch{recordBatch}}
@@ -78,7 +78,7 @@ func TestExtractProduceReqV9(t *testing.T) {
 			"\x20\x66\x69\x72\x73\x74\x20\x65\x76\x65\x6e\x74\x00\x00\x00\x00")
 	recordBatch := RecordBatch{
 		Records: []RecordMessage{
-			{Key: "", Value: "This is my first event"},
+			{Key: "", Value: "This is my first event", ValueSize: 22},
 		},
 	}
 	messageSet := MessageSet{Size: 91, RecordBatches: []RecordBatch{recordBatch}}
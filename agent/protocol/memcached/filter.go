@@ -0,0 +1,54 @@
+package memcached
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+	"strings"
+)
+
+type Filter struct {
+	TargetCommands []string
+	TargetKeys     []string
+	KeyPrefix      string
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	memcachedReq, ok := req.(*Request)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[MemcachedFilter] cast to memcached.Request failed: %v\n", req)
+		return false
+	}
+	if len(f.TargetCommands) > 0 && !slices.ContainsFunc(f.TargetCommands, func(command string) bool {
+		return strings.EqualFold(command, memcachedReq.Command)
+	}) {
+		return false
+	}
+	// a multi-key get passes if any of its keys does
+	if len(f.TargetKeys) > 0 || f.KeyPrefix != "" {
+		return slices.ContainsFunc(memcachedReq.Keys, func(key string) bool {
+			return (len(f.TargetKeys) == 0 || slices.Contains(f.TargetKeys, key)) &&
+				strings.HasPrefix(key, f.KeyPrefix)
+		})
+	}
+	return true
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolMemcached
+}
+
+func (f Filter) FilterByRequest() bool {
+	return len(f.TargetCommands) > 0 || len(f.TargetKeys) > 0 || f.KeyPrefix != ""
+}
+
+func (f Filter) FilterByResponse() bool {
+	return false
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolMemcached
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,559 @@
+package memcached
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolMemcached] = func() protocol.ProtocolStreamParser {
+		return NewMemcachedStreamParser()
+	}
+}
+
+func NewMemcachedStreamParser() *MemcachedStreamParser {
+	return &MemcachedStreamParser{}
+}
+
+var crlf = []byte("\r\n")
+
+var responseWords = map[string]bool{
+	"VALUE":        true,
+	"END":          true,
+	"STAT":         true,
+	"STORED":       true,
+	"NOT_STORED":   true,
+	"EXISTS":       true,
+	"NOT_FOUND":    true,
+	"DELETED":      true,
+	"TOUCHED":      true,
+	"OK":           true,
+	"RESET":        true,
+	"VERSION":      true,
+	"ERROR":        true,
+	"CLIENT_ERROR": true,
+	"SERVER_ERROR": true,
+}
+
+func (p *MemcachedStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	if len(buf) == 0 {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	var message protocol.ParsedMessage
+	var readBytes int
+	var state protocol.ParseState
+	if buf[0] == kMagicRequest || buf[0] == kMagicResponse {
+		message, readBytes, state = p.parseBinary(buf, messageType)
+	} else {
+		message, readBytes, state = parseAscii(buf, messageType)
+	}
+	if state != protocol.Success {
+		return protocol.ParseResult{ParseState: state, ReadBytes: readBytes}
+	}
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[Memcached] failed to create FrameBase for %s", message.FormatToString())
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	switch message := message.(type) {
+	case *Request:
+		message.FrameBase = fb
+	case *Response:
+		message.FrameBase = fb
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// firstToken returns the first word of buf, and false if buf ends before
+// the word does.
+func firstToken(buf []byte) (string, bool) {
+	end := bytes.IndexAny(buf, " \r")
+	if end < 0 {
+		return string(buf), false
+	}
+	return string(buf[:end]), true
+}
+
+// canStartWith tells if an incomplete first word may become a command of
+// messageType.
+func canStartWith(word string, messageType protocol.MessageType) bool {
+	if messageType != protocol.Response {
+		for command := range asciiCommands {
+			if strings.HasPrefix(command, word) {
+				return true
+			}
+		}
+	}
+	if messageType != protocol.Request {
+		for response := range responseWords {
+			if strings.HasPrefix(response, word) {
+				return true
+			}
+		}
+		if len(word) <= 20 && isNumber(word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(word string) bool {
+	for _, c := range []byte(word) {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(word) > 0
+}
+
+// readLine returns the line starting at start without its CRLF, and the
+// position after the CRLF.
+func readLine(buf []byte, start int) ([]byte, int, protocol.ParseState) {
+	end := bytes.Index(buf[start:], crlf)
+	if end < 0 {
+		if len(buf)-start > kMaxLineLength {
+			return nil, 0, protocol.Invalid
+		}
+		return nil, 0, protocol.NeedsMoreData
+	}
+	if end > kMaxLineLength {
+		return nil, 0, protocol.Invalid
+	}
+	return buf[start : start+end], start + end + 2, protocol.Success
+}
+
+// readDataBlock reads a data block of size bytes and its CRLF at start.
+func readDataBlock(buf []byte, start int, size int) ([]byte, int, protocol.ParseState) {
+	if len(buf) < start+size+2 {
+		return nil, 0, protocol.NeedsMoreData
+	}
+	if !bytes.Equal(buf[start+size:start+size+2], crlf) {
+		return nil, 0, protocol.Invalid
+	}
+	return bytes.Clone(buf[start : start+min(size, kMaxValueKept)]), start + size + 2, protocol.Success
+}
+
+func parseAscii(buf []byte, messageType protocol.MessageType) (protocol.ParsedMessage, int, protocol.ParseState) {
+	word, complete := firstToken(buf)
+	if !complete {
+		if canStartWith(word, messageType) {
+			return nil, 0, protocol.NeedsMoreData
+		}
+		return nil, 0, protocol.Invalid
+	}
+	if _, ok := asciiCommands[word]; ok && messageType != protocol.Response {
+		return parseAsciiRequest(buf, word)
+	}
+	if (responseWords[word] || isNumber(word)) && messageType != protocol.Request {
+		return parseAsciiResponse(buf, word)
+	}
+	return nil, 0, protocol.Invalid
+}
+
+func validKeys(keys []string) bool {
+	for _, key := range keys {
+		if len(key) > 250 {
+			return false
+		}
+	}
+	return true
+}
+
+func parseAsciiRequest(buf []byte, command string) (protocol.ParsedMessage, int, protocol.ParseState) {
+	line, end, state := readLine(buf, 0)
+	if state != protocol.Success {
+		return nil, 0, state
+	}
+	args := strings.Fields(string(line))[1:]
+	req := &Request{Command: command}
+	kind := asciiCommands[command]
+	if kind != kindRetrieval && kind != kindGat && len(args) > 0 && args[len(args)-1] == "noreply" {
+		req.NoReply = true
+		args = args[:len(args)-1]
+	}
+	var err error
+	switch kind {
+	case kindStorage, kindCas:
+		// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+		if (kind == kindStorage && len(args) != 4) || (kind == kindCas && len(args) != 5) {
+			return nil, 0, protocol.Invalid
+		}
+		req.Keys = args[:1]
+		var flags uint64
+		if flags, err = strconv.ParseUint(args[1], 10, 32); err != nil {
+			return nil, 0, protocol.Invalid
+		}
+		req.Flags = uint32(flags)
+		if req.Exptime, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return nil, 0, protocol.Invalid
+		}
+		if req.ValueSize, err = strconv.Atoi(args[3]); err != nil || req.ValueSize < 0 || req.ValueSize > kMaxValueSize {
+			return nil, 0, protocol.Invalid
+		}
+		if kind == kindCas {
+			if req.Cas, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+				return nil, 0, protocol.Invalid
+			}
+		}
+		if req.Value, end, state = readDataBlock(buf, end, req.ValueSize); state != protocol.Success {
+			return nil, 0, state
+		}
+	case kindRetrieval:
+		// get <key>*
+		if len(args) == 0 {
+			return nil, 0, protocol.Invalid
+		}
+		req.Keys = args
+	case kindGat:
+		// gat <exptime> <key>*
+		if len(args) < 2 {
+			return nil, 0, protocol.Invalid
+		}
+		if req.Exptime, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+			return nil, 0, protocol.Invalid
+		}
+		req.Keys = args[1:]
+	case kindDelete:
+		// delete <key> [<time>] [noreply], the time is only accepted as 0
+		if len(args) == 0 || len(args) > 2 {
+			return nil, 0, protocol.Invalid
+		}
+		req.Keys = args[:1]
+	case kindArithmetic:
+		// incr <key> <value> [noreply]
+		if len(args) != 2 {
+			return nil, 0, protocol.Invalid
+		}
+		req.Keys = args[:1]
+		if req.Delta, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			return nil, 0, protocol.Invalid
+		}
+	case kindTouch:
+		// touch <key> <exptime> [noreply]
+		if len(args) != 2 {
+			return nil, 0, protocol.Invalid
+		}
+		req.Keys = args[:1]
+		if req.Exptime, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return nil, 0, protocol.Invalid
+		}
+	case kindQuit:
+		req.NoReply = true
+	}
+	if !validKeys(req.Keys) {
+		return nil, 0, protocol.Invalid
+	}
+	return req, end, protocol.Success
+}
+
+func parseAsciiResponse(buf []byte, word string) (protocol.ParsedMessage, int, protocol.ParseState) {
+	resp := &Response{Result: word}
+	switch word {
+	case "VALUE", "END":
+		// VALUE <key> <flags> <bytes> [<cas unique>]\r\n<data block>\r\n ... END\r\n
+		resp.Result = "END"
+		end := 0
+		for {
+			line, next, state := readLine(buf, end)
+			if state != protocol.Success {
+				return nil, 0, state
+			}
+			if string(line) == "END" {
+				return resp, next, protocol.Success
+			}
+			tokens := strings.Fields(string(line))
+			if len(tokens) < 4 || len(tokens) > 5 || tokens[0] != "VALUE" || !validKeys(tokens[1:2]) {
+				return nil, 0, protocol.Invalid
+			}
+			value := Value{Key: tokens[1]}
+			flags, err := strconv.ParseUint(tokens[2], 10, 32)
+			if err != nil {
+				return nil, 0, protocol.Invalid
+			}
+			value.Flags = uint32(flags)
+			if value.Size, err = strconv.Atoi(tokens[3]); err != nil || value.Size < 0 || value.Size > kMaxValueSize {
+				return nil, 0, protocol.Invalid
+			}
+			if len(tokens) == 5 {
+				if value.Cas, err = strconv.ParseUint(tokens[4], 10, 64); err != nil {
+					return nil, 0, protocol.Invalid
+				}
+			}
+			if value.Data, end, state = readDataBlock(buf, next, value.Size); state != protocol.Success {
+				return nil, 0, state
+			}
+			resp.Values = append(resp.Values, value)
+		}
+	case "STAT":
+		// STAT <name> <value>\r\n ... END\r\n
+		resp.Result = "END"
+		resp.Stats = make(map[string]string)
+		end := 0
+		for {
+			line, next, state := readLine(buf, end)
+			if state != protocol.Success {
+				return nil, 0, state
+			}
+			end = next
+			if string(line) == "END" {
+				return resp, end, protocol.Success
+			}
+			name, value, ok := strings.Cut(string(line), " ")
+			if !ok || name != "STAT" {
+				return nil, 0, protocol.Invalid
+			}
+			name, value, _ = strings.Cut(value, " ")
+			resp.Stats[name] = value
+		}
+	}
+	line, end, state := readLine(buf, 0)
+	if state != protocol.Success {
+		return nil, 0, state
+	}
+	_, rest, _ := strings.Cut(string(line), " ")
+	switch word {
+	case "VERSION":
+		resp.Version = rest
+	case "CLIENT_ERROR", "SERVER_ERROR":
+		resp.ErrorMessage = rest
+	default:
+		if rest != "" {
+			return nil, 0, protocol.Invalid
+		}
+		if isNumber(word) {
+			// the new value of incr and decr
+			counter, err := strconv.ParseUint(word, 10, 64)
+			if err != nil {
+				return nil, 0, protocol.Invalid
+			}
+			resp.Result = "COUNTER"
+			resp.Counter = counter
+		}
+	}
+	return resp, end, protocol.Success
+}
+
+type binaryHeader struct {
+	magic     byte
+	opcode    byte
+	keyLength int
+	extLength int
+	status    uint16
+	bodyLen   int
+	opaque    uint32
+	cas       uint64
+}
+
+func parseBinaryHeader(buf []byte, messageType protocol.MessageType) (binaryHeader, bool) {
+	header := binaryHeader{
+		magic:     buf[0],
+		opcode:    buf[1],
+		keyLength: int(binary.BigEndian.Uint16(buf[2:])),
+		extLength: int(buf[4]),
+		status:    binary.BigEndian.Uint16(buf[6:]),
+		bodyLen:   int(binary.BigEndian.Uint32(buf[8:])),
+		opaque:    binary.BigEndian.Uint32(buf[12:]),
+		cas:       binary.BigEndian.Uint64(buf[16:]),
+	}
+	if (messageType == protocol.Request && header.magic != kMagicRequest) ||
+		(messageType == protocol.Response && header.magic != kMagicResponse) {
+		return header, false
+	}
+	if _, ok := opcodes[header.opcode]; !ok {
+		return header, false
+	}
+	// the data type is reserved for future use
+	if buf[5] != 0 {
+		return header, false
+	}
+	if header.bodyLen > kMaxValueSize || header.keyLength+header.extLength > header.bodyLen {
+		return header, false
+	}
+	return header, true
+}
+
+func (p *MemcachedStreamParser) parseBinary(buf []byte, messageType protocol.MessageType) (protocol.ParsedMessage, int, protocol.ParseState) {
+	if len(buf) < kBinaryHeaderLength {
+		if len(buf) >= 2 {
+			if _, ok := opcodes[buf[1]]; !ok {
+				return nil, 0, protocol.Invalid
+			}
+		}
+		return nil, 0, protocol.NeedsMoreData
+	}
+	header, ok := parseBinaryHeader(buf, messageType)
+	if !ok {
+		return nil, 0, protocol.Invalid
+	}
+	readBytes := kBinaryHeaderLength + header.bodyLen
+	if len(buf) < readBytes {
+		return nil, 0, protocol.NeedsMoreData
+	}
+	body := buf[kBinaryHeaderLength:readBytes]
+	extras := body[:header.extLength]
+	key := string(body[header.extLength : header.extLength+header.keyLength])
+	value := body[header.extLength+header.keyLength:]
+	info := opcodes[header.opcode]
+
+	if header.magic == kMagicRequest {
+		req := &Request{
+			Binary:  true,
+			Command: info.command,
+			NoReply: info.quiet,
+			Opcode:  header.opcode,
+			Opaque:  header.opaque,
+			Cas:     header.cas,
+		}
+		if key != "" {
+			req.Keys = []string{key}
+		}
+		switch info.command {
+		case "set", "add", "replace":
+			// flags(4) expiration(4)
+			if len(extras) == 8 {
+				req.Flags = binary.BigEndian.Uint32(extras)
+				req.Exptime = int64(binary.BigEndian.Uint32(extras[4:]))
+			}
+			req.ValueSize = len(value)
+			req.Value = bytes.Clone(value[:min(len(value), kMaxValueKept)])
+		case "append", "prepend":
+			req.ValueSize = len(value)
+			req.Value = bytes.Clone(value[:min(len(value), kMaxValueKept)])
+		case "incr", "decr":
+			// delta(8) initial value(8) expiration(4)
+			if len(extras) == 20 {
+				req.Delta = binary.BigEndian.Uint64(extras)
+				req.Exptime = int64(binary.BigEndian.Uint32(extras[16:]))
+			}
+		case "touch", "gat":
+			if len(extras) == 4 {
+				req.Exptime = int64(binary.BigEndian.Uint32(extras))
+			}
+		}
+		return req, readBytes, protocol.Success
+	}
+
+	resp := &Response{
+		Binary:     true,
+		StatusCode: header.status,
+		Opcode:     header.opcode,
+		Opaque:     header.opaque,
+	}
+	resp.Result = statusNames[header.status]
+	if resp.Result == "" {
+		resp.Result = fmt.Sprintf("0x%04x", header.status)
+	}
+	if header.status != StatusNoError {
+		resp.ErrorMessage = string(value[:min(len(value), kMaxValueKept)])
+		return resp, readBytes, protocol.Success
+	}
+	switch info.command {
+	case "get", "gat":
+		// flags(4), the key is returned by getk and getkq only
+		item := Value{Key: key, Size: len(value), Cas: header.cas, Data: bytes.Clone(value[:min(len(value), kMaxValueKept)])}
+		if len(extras) == 4 {
+			item.Flags = binary.BigEndian.Uint32(extras)
+		}
+		resp.Values = []Value{item}
+	case "incr", "decr":
+		if len(value) == 8 {
+			resp.Result = "COUNTER"
+			resp.Counter = binary.BigEndian.Uint64(value)
+		}
+	case "version":
+		resp.Version = string(value)
+	case "stats":
+		// one response per stat, the last one has no key
+		if key != "" {
+			if p.binaryStats == nil {
+				p.binaryStats = make(map[string]string)
+			}
+			p.binaryStats[key] = string(value)
+			return nil, readBytes, protocol.Ignore
+		}
+		resp.Stats = p.binaryStats
+		p.binaryStats = nil
+	}
+	return resp, readBytes, protocol.Success
+}
+
+// FindBoundary looks for a binary header, or an ASCII command or reply at
+// the start of a line.
+func (p *MemcachedStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i < len(buf); i++ {
+		if buf[i] == kMagicRequest || buf[i] == kMagicResponse {
+			if len(buf)-i >= kBinaryHeaderLength {
+				if _, ok := parseBinaryHeader(buf[i:], messageType); ok {
+					return i
+				}
+			}
+			continue
+		}
+		if i > 0 && (i < 2 || !bytes.Equal(buf[i-2:i], crlf)) {
+			continue
+		}
+		word, complete := firstToken(buf[i:])
+		if !complete {
+			continue
+		}
+		if _, ok := asciiCommands[word]; ok && messageType != protocol.Response {
+			return i
+		}
+		if responseWords[word] && messageType != protocol.Request {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match pairs the requests and the responses in order, the server answers
+// the requests of a connection in the order they are sent. Requests sent
+// with noreply and quiet binary requests not answered are skipped, a
+// binary response answers the request with the same opcode and opaque.
+func (p *MemcachedStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	records := []protocol.Record{}
+	reqQueue, respQueue := reqStreams[0], respStreams[0]
+	if reqQueue == nil || respQueue == nil {
+		return records
+	}
+	for len(*respQueue) > 0 {
+		resp := (*respQueue)[0].(*Response)
+		*respQueue = (*respQueue)[1:]
+		for len(*reqQueue) > 0 && (*reqQueue)[0].TimestampNs() <= resp.TimestampNs() {
+			req := (*reqQueue)[0].(*Request)
+			*reqQueue = (*reqQueue)[1:]
+			if req.answeredBy(resp) {
+				records = append(records, protocol.Record{
+					Req:            req,
+					Resp:           resp,
+					ResponseStatus: resp.Status(),
+				})
+				break
+			}
+		}
+	}
+	return records
+}
+
+func (r *Request) answeredBy(resp *Response) bool {
+	if r.Binary != resp.Binary {
+		return false
+	}
+	if r.Binary {
+		return r.Opcode == resp.Opcode && r.Opaque == resp.Opaque
+	}
+	return !r.NoReply
+}
@@ -0,0 +1,179 @@
+package memcached_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/memcached"
+	"kyanos/agent/protocol/protocoltest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func binaryPacket(magic byte, opcode byte, status uint16, opaque uint32, extras []byte, key string, value []byte) []byte {
+	header := make([]byte, 24)
+	header[0] = magic
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(header[6:], status)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:], opaque)
+	packet := append(header, extras...)
+	packet = append(packet, key...)
+	return append(packet, value...)
+}
+
+func TestParseAsciiRequest(t *testing.T) {
+	parser := memcached.NewMemcachedStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, []byte("set user:1 5 300 5\r\nalice\r\n"+
+		"gets user:1 user:2\r\n"+
+		"cas user:1 5 300 3 42 noreply\r\nbob\r\n"+
+		"incr counter 10\r\n"+
+		"delete user:2\r\n"), protocol.Request, 10)
+	assert.Len(t, *reqs[0], 5)
+
+	set := (*reqs[0])[0].(*memcached.Request)
+	assert.Equal(t, "set", set.Command)
+	assert.Equal(t, []string{"user:1"}, set.Keys)
+	assert.Equal(t, uint32(5), set.Flags)
+	assert.Equal(t, int64(300), set.Exptime)
+	assert.Equal(t, []byte("alice"), set.Value)
+
+	gets := (*reqs[0])[1].(*memcached.Request)
+	assert.Equal(t, []string{"user:1", "user:2"}, gets.Keys)
+
+	cas := (*reqs[0])[2].(*memcached.Request)
+	assert.Equal(t, uint64(42), cas.Cas)
+	assert.True(t, cas.NoReply)
+
+	assert.Equal(t, uint64(10), (*reqs[0])[3].(*memcached.Request).Delta)
+	assert.Equal(t, []string{"user:2"}, (*reqs[0])[4].(*memcached.Request).Keys)
+}
+
+func TestParseIncomplete(t *testing.T) {
+	parser := memcached.NewMemcachedStreamParser()
+	for _, data := range []string{"se", "set user:1 5 300 5\r\nali", "VALUE user:1 0 5\r\nalice\r\n", "ge"} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, []byte(data), 1)
+		assert.Equal(t, protocol.NeedsMoreData, parser.ParseStream(streamBuffer, protocol.Unknown).ParseState, data)
+	}
+	for _, data := range []string{"GET / HTTP/1.1\r\n", "set user:1 5 300 5\r\nalice!\r\n", "*1\r\n$4\r\nPING\r\n"} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, []byte(data), 1)
+		assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Unknown).ParseState, data)
+	}
+}
+
+func TestMatchAscii(t *testing.T) {
+	parser := memcached.NewMemcachedStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, []byte("get user:1 user:2\r\n"+
+		"set user:3 0 0 1 noreply\r\nx\r\n"+
+		"get user:4\r\n"+
+		"incr counter 1\r\n"+
+		"cas user:1 0 0 3 41\r\nbob\r\n"), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, []byte("VALUE user:1 0 5\r\nalice\r\nEND\r\n"+
+		"END\r\n"+
+		"11\r\n"+
+		"EXISTS\r\n"), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 4)
+
+	get := records[0].Resp.(*memcached.Response)
+	assert.Equal(t, protocol.SuccessStatus, records[0].ResponseStatus)
+	assert.Len(t, get.Values, 1)
+	assert.Equal(t, "user:1", get.Values[0].Key)
+	assert.Equal(t, []byte("alice"), get.Values[0].Data)
+
+	// the noreply set is skipped
+	assert.Equal(t, []string{"user:4"}, records[1].Req.(*memcached.Request).Keys)
+	assert.Equal(t, protocol.MissStatus, records[1].ResponseStatus)
+
+	assert.Equal(t, uint64(11), records[2].Resp.(*memcached.Response).Counter)
+	assert.Equal(t, protocol.FailStatus, records[3].ResponseStatus)
+	assert.Empty(t, *reqs[0])
+	assert.Empty(t, *resps[0])
+}
+
+func TestParseAsciiStats(t *testing.T) {
+	parser := memcached.NewMemcachedStreamParser()
+	resps := protocoltest.ParseAll(t, parser, []byte("STAT pid 1\r\nSTAT version 1.6.21\r\nEND\r\nSERVER_ERROR out of memory storing object\r\n"), protocol.Response, 20)
+	assert.Len(t, *resps[0], 2)
+	stats := (*resps[0])[0].(*memcached.Response)
+	assert.Equal(t, map[string]string{"pid": "1", "version": "1.6.21"}, stats.Stats)
+	assert.Equal(t, protocol.SuccessStatus, stats.Status())
+	serverError := (*resps[0])[1].(*memcached.Response)
+	assert.Equal(t, "out of memory storing object", serverError.ErrorMessage)
+	assert.Equal(t, protocol.FailStatus, serverError.Status())
+}
+
+func TestMatchBinary(t *testing.T) {
+	parser := memcached.NewMemcachedStreamParser()
+	flags := []byte{0, 0, 0, 7}
+	reqs := protocoltest.ParseAll(t, parser, append(append(append(
+		// getkq user:1 and user:2 followed by a noop
+		binaryPacket(0x80, 0x0d, 0, 1, nil, "user:1", nil),
+		binaryPacket(0x80, 0x0d, 0, 2, nil, "user:2", nil)...),
+		binaryPacket(0x80, 0x0a, 0, 3, nil, "", nil)...),
+		binaryPacket(0x80, 0x01, 0, 4, append(flags, 0, 0, 0, 60), "user:3", []byte("carol"))...),
+		protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, append(append(
+		// user:1 is a miss, quiet gets answer hits only
+		binaryPacket(0x81, 0x0d, 0, 2, flags, "user:2", []byte("bob")),
+		binaryPacket(0x81, 0x0a, 0, 3, nil, "", nil)...),
+		binaryPacket(0x81, 0x01, memcached.StatusOutOfMemory, 4, nil, "", []byte("Out of memory"))...),
+		protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 3)
+	getkq := records[0].Req.(*memcached.Request)
+	assert.Equal(t, "get", getkq.Command)
+	assert.True(t, getkq.NoReply)
+	assert.Equal(t, []string{"user:2"}, getkq.Keys)
+	value := records[0].Resp.(*memcached.Response).Values[0]
+	assert.Equal(t, "user:2", value.Key)
+	assert.Equal(t, uint32(7), value.Flags)
+	assert.Equal(t, "noop", records[1].Req.(*memcached.Request).Command)
+
+	set := records[2].Req.(*memcached.Request)
+	assert.Equal(t, int64(60), set.Exptime)
+	assert.Equal(t, []byte("carol"), set.Value)
+	assert.Equal(t, "OUT_OF_MEMORY", records[2].Resp.(*memcached.Response).Result)
+	assert.Equal(t, protocol.FailStatus, records[2].ResponseStatus)
+}
+
+func TestParseBinaryStats(t *testing.T) {
+	parser := memcached.NewMemcachedStreamParser()
+	resps := protocoltest.ParseAll(t, parser, append(append(
+		binaryPacket(0x81, 0x10, 0, 9, nil, "pid", []byte("1")),
+		binaryPacket(0x81, 0x10, 0, 9, nil, "uptime", []byte("42"))...),
+		binaryPacket(0x81, 0x10, 0, 9, nil, "", nil)...), protocol.Response, 20)
+	assert.Len(t, *resps[0], 1)
+	assert.Equal(t, map[string]string{"pid": "1", "uptime": "42"}, (*resps[0])[0].(*memcached.Response).Stats)
+
+	resps = protocoltest.ParseAll(t, parser, binaryPacket(0x81, 0x00, memcached.StatusKeyNotFound, 1, nil, "", []byte("Not found")), protocol.Response, 30)
+	assert.Equal(t, protocol.MissStatus, (*resps[0])[0].(*memcached.Response).Status())
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := memcached.NewMemcachedStreamParser()
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, []byte("ice\r\nEND\r\nget user:1\r\n"), 1)
+	assert.Equal(t, 10, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+	assert.Equal(t, 5, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+
+	streamBuffer = buffer.New(1 << 20)
+	streamBuffer.Add(1, append([]byte{0x01, 0x80}, binaryPacket(0x80, 0x00, 0, 1, nil, "user:1", nil)...), 1)
+	assert.Equal(t, 2, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+}
+
+func TestFilter(t *testing.T) {
+	req := &memcached.Request{Command: "get", Keys: []string{"user:1", "session:2"}}
+	assert.True(t, memcached.Filter{}.Filter(req, nil))
+	assert.True(t, memcached.Filter{TargetCommands: []string{"GET"}, KeyPrefix: "session:"}.Filter(req, nil))
+	assert.True(t, memcached.Filter{TargetKeys: []string{"user:1"}}.Filter(req, nil))
+	assert.False(t, memcached.Filter{TargetKeys: []string{"user:1"}, KeyPrefix: "session:"}.Filter(req, nil))
+	assert.False(t, memcached.Filter{TargetCommands: []string{"set"}}.Filter(req, nil))
+}
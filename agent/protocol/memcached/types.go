@@ -0,0 +1,285 @@
+package memcached
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See https://github.com/memcached/memcached/blob/master/doc/protocol.txt
+// and https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped.
+
+// Keys are at most 250 bytes, the longest lines are multi-key gets.
+const kMaxLineLength = 64 << 10
+
+// Items are at most 1MB by default, the limit can be raised to 1GB.
+const kMaxValueSize = 64 << 20
+
+// Only the start of large values is kept for display.
+const kMaxValueKept = 1024
+
+const (
+	kMagicRequest  byte = 0x80
+	kMagicResponse byte = 0x81
+)
+
+// magic(1) opcode(1) key length(2) extras length(1) data type(1)
+// vbucket id or status(2) total body length(4) opaque(4) cas(8)
+const kBinaryHeaderLength = 24
+
+type commandKind int
+
+const (
+	// set, add, replace, append and prepend are followed by a data block
+	kindStorage commandKind = iota
+	kindCas
+	kindRetrieval
+	kindGat
+	kindDelete
+	kindArithmetic
+	kindTouch
+	kindOther
+	// quit closes the connection without reply
+	kindQuit
+)
+
+var asciiCommands = map[string]commandKind{
+	"set":       kindStorage,
+	"add":       kindStorage,
+	"replace":   kindStorage,
+	"append":    kindStorage,
+	"prepend":   kindStorage,
+	"cas":       kindCas,
+	"get":       kindRetrieval,
+	"gets":      kindRetrieval,
+	"gat":       kindGat,
+	"gats":      kindGat,
+	"delete":    kindDelete,
+	"incr":      kindArithmetic,
+	"decr":      kindArithmetic,
+	"touch":     kindTouch,
+	"stats":     kindOther,
+	"version":   kindOther,
+	"flush_all": kindOther,
+	"verbosity": kindOther,
+	"quit":      kindQuit,
+}
+
+type opcodeInfo struct {
+	// the name of the ASCII command doing the same
+	command string
+	// quiet commands are answered only on a get hit or on an error
+	quiet bool
+}
+
+var opcodes = map[byte]opcodeInfo{
+	0x00: {command: "get"},
+	0x01: {command: "set"},
+	0x02: {command: "add"},
+	0x03: {command: "replace"},
+	0x04: {command: "delete"},
+	0x05: {command: "incr"},
+	0x06: {command: "decr"},
+	0x07: {command: "quit"},
+	0x08: {command: "flush_all"},
+	0x09: {command: "get", quiet: true},
+	0x0a: {command: "noop"},
+	0x0b: {command: "version"},
+	0x0c: {command: "get"},
+	0x0d: {command: "get", quiet: true},
+	0x0e: {command: "append"},
+	0x0f: {command: "prepend"},
+	0x10: {command: "stats"},
+	0x11: {command: "set", quiet: true},
+	0x12: {command: "add", quiet: true},
+	0x13: {command: "replace", quiet: true},
+	0x14: {command: "delete", quiet: true},
+	0x15: {command: "incr", quiet: true},
+	0x16: {command: "decr", quiet: true},
+	0x17: {command: "quit", quiet: true},
+	0x18: {command: "flush_all", quiet: true},
+	0x19: {command: "append", quiet: true},
+	0x1a: {command: "prepend", quiet: true},
+	0x1b: {command: "verbosity"},
+	0x1c: {command: "touch"},
+	0x1d: {command: "gat"},
+	0x1e: {command: "gat", quiet: true},
+	0x20: {command: "sasl_list_mechs"},
+	0x21: {command: "sasl_auth"},
+	0x22: {command: "sasl_step"},
+}
+
+// The status of binary responses.
+const (
+	StatusNoError       uint16 = 0x0000
+	StatusKeyNotFound   uint16 = 0x0001
+	StatusKeyExists     uint16 = 0x0002
+	StatusValueTooLarge uint16 = 0x0003
+	StatusInvalidArgs   uint16 = 0x0004
+	StatusNotStored     uint16 = 0x0005
+	StatusNonNumeric    uint16 = 0x0006
+	StatusAuthError     uint16 = 0x0020
+	StatusUnknownCmd    uint16 = 0x0081
+	StatusOutOfMemory   uint16 = 0x0082
+)
+
+var statusNames = map[uint16]string{
+	StatusNoError:       "NO_ERROR",
+	StatusKeyNotFound:   "NOT_FOUND",
+	StatusKeyExists:     "EXISTS",
+	StatusValueTooLarge: "VALUE_TOO_LARGE",
+	StatusInvalidArgs:   "INVALID_ARGUMENTS",
+	StatusNotStored:     "NOT_STORED",
+	StatusNonNumeric:    "NON_NUMERIC",
+	StatusAuthError:     "AUTH_ERROR",
+	StatusUnknownCmd:    "UNKNOWN_COMMAND",
+	StatusOutOfMemory:   "OUT_OF_MEMORY",
+}
+
+var _ protocol.ParsedMessage = &Request{}
+
+type Request struct {
+	protocol.FrameBase
+	Binary bool
+	// the ASCII command name, binary opcodes are named after the ASCII
+	// command doing the same
+	Command string
+	Keys    []string
+	Flags   uint32
+	Exptime int64
+	// incr and decr
+	Delta uint64
+	Cas   uint64
+	// noreply of the ASCII protocol and the quiet binary opcodes
+	NoReply bool
+	Opcode  byte
+	Opaque  uint32
+	// the data block of storage commands
+	ValueSize int
+	Value     []byte
+}
+
+func (r *Request) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] command=[%s]", r.FrameBase.String(), r.Command)
+	if len(r.Keys) > 0 {
+		fmt.Fprintf(&b, " keys=[%s]", strings.Join(r.Keys, " "))
+	}
+	switch r.Command {
+	case "incr", "decr":
+		fmt.Fprintf(&b, " delta=[%d]", r.Delta)
+	case "cas":
+		fmt.Fprintf(&b, " cas=[%d]", r.Cas)
+	}
+	if r.NoReply {
+		b.WriteString(" noreply=[true]")
+	}
+	if r.Binary {
+		fmt.Fprintf(&b, " opcode=[0x%02x] opaque=[%d]", r.Opcode, r.Opaque)
+	}
+	if r.ValueSize > 0 {
+		fmt.Fprintf(&b, " flags=[%d] exptime=[%d] value_size=[%d] value=[%s]", r.Flags, r.Exptime, r.ValueSize, r.Value)
+	}
+	return b.String()
+}
+
+func (r *Request) IsReq() bool {
+	return true
+}
+
+func (r *Request) StreamId() protocol.StreamId {
+	return 0
+}
+
+// Value is an item returned by get, gets and gat.
+type Value struct {
+	Key   string
+	Flags uint32
+	Size  int
+	Cas   uint64
+	Data  []byte
+}
+
+var _ protocol.ParsedMessage = &Response{}
+var _ protocol.StatusfulMessage = &Response{}
+
+type Response struct {
+	protocol.FrameBase
+	Binary bool
+	// the reply line of the ASCII protocol like STORED or NOT_FOUND, and the
+	// name of the status of binary responses. END ends the items of get and
+	// the stats, COUNTER is the new value of incr and decr.
+	Result string
+	// the message of CLIENT_ERROR and SERVER_ERROR, and the error message
+	// of failed binary responses
+	ErrorMessage string
+	Values       []Value
+	Counter      uint64
+	// stats, and the version
+	Stats      map[string]string
+	Version    string
+	StatusCode uint16
+	Opcode     byte
+	Opaque     uint32
+}
+
+func (r *Response) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] result=[%s]", r.FrameBase.String(), r.Result)
+	if r.ErrorMessage != "" {
+		fmt.Fprintf(&b, " error=[%s]", r.ErrorMessage)
+	}
+	for _, value := range r.Values {
+		fmt.Fprintf(&b, " value=[key=%s flags=%d size=%d", value.Key, value.Flags, value.Size)
+		if value.Cas != 0 {
+			fmt.Fprintf(&b, " cas=%d", value.Cas)
+		}
+		fmt.Fprintf(&b, " data=%s]", value.Data)
+	}
+	if r.Result == "COUNTER" {
+		fmt.Fprintf(&b, " counter=[%d]", r.Counter)
+	}
+	if r.Version != "" {
+		fmt.Fprintf(&b, " version=[%s]", r.Version)
+	}
+	if len(r.Stats) > 0 {
+		fmt.Fprintf(&b, " stats=[%d]", len(r.Stats))
+	}
+	if r.Binary {
+		fmt.Fprintf(&b, " opcode=[0x%02x] opaque=[%d]", r.Opcode, r.Opaque)
+	}
+	return b.String()
+}
+
+func (r *Response) IsReq() bool {
+	return false
+}
+
+func (r *Response) StreamId() protocol.StreamId {
+	return 0
+}
+
+// Status is a miss when a get returns no item or the key of a delete,
+// incr, decr or touch is not found. Storage commands not applied, like a
+// cas whose item was changed, fail.
+func (r *Response) Status() protocol.ResponseStatus {
+	switch r.Result {
+	case "END", "NOT_FOUND":
+		if len(r.Values) == 0 && len(r.Stats) == 0 {
+			return protocol.MissStatus
+		}
+	case "ERROR", "CLIENT_ERROR", "SERVER_ERROR", "NOT_STORED", "EXISTS":
+		return protocol.FailStatus
+	}
+	if r.Binary && r.StatusCode != StatusNoError {
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+var _ protocol.ProtocolStreamParser = &MemcachedStreamParser{}
+
+type MemcachedStreamParser struct {
+	// the stats of a binary stat response, sent as one packet per stat
+	binaryStats map[string]string
+}
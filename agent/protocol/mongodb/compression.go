@@ -0,0 +1,57 @@
+package mongodb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompress decompresses the message of an OP_COMPRESSED, which must be
+// uncompressedSize bytes long once decompressed.
+func decompress(compressorId uint8, compressed []byte, uncompressedSize int) ([]byte, error) {
+	body, err := decompressBody(compressorId, compressed, uncompressedSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) != uncompressedSize {
+		return nil, fmt.Errorf("decompressed %d bytes, expected %d", len(body), uncompressedSize)
+	}
+	return body, nil
+}
+
+func decompressBody(compressorId uint8, compressed []byte, uncompressedSize int) ([]byte, error) {
+	switch compressorId {
+	case kCompressorNoop:
+		return compressed, nil
+	case kCompressorSnappy:
+		// check the length before allocating the buffer
+		n, err := snappy.DecodedLen(compressed)
+		if err != nil {
+			return nil, err
+		}
+		if n != uncompressedSize {
+			return nil, fmt.Errorf("snappy length %d, expected %d", n, uncompressedSize)
+		}
+		return snappy.Decode(nil, compressed)
+	case kCompressorZlib:
+		r, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(io.LimitReader(r, int64(uncompressedSize)+1))
+	case kCompressorZstd:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(kMaxMessageSize))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(compressed, make([]byte, 0, uncompressedSize))
+	default:
+		return nil, fmt.Errorf("unknown compressor %d", compressorId)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"kyanos/agent/protocol"
 	. "kyanos/agent/protocol"
 	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -15,10 +18,33 @@ var _ ProtocolStreamParser = &MongoDBStreamParser{}
 var _ ParsedMessage = &MongoDBFrame{}
 
 type MongoDBFilter struct {
+	// the commands, like find or aggregate, compared case-insensitively
+	Commands    []string
+	Databases   []string
+	Collections []string
 }
 
 // Filter implements protocol.ProtocolFilter.
 func (m *MongoDBFilter) Filter(req ParsedMessage, resp ParsedMessage) bool {
+	if !m.FilterByRequest() {
+		return true
+	}
+	frame, ok := req.(*MongoDBFrame)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[MongoDBFilter] cast to MongoDBFrame failed: %v\n", req)
+		return false
+	}
+	if len(m.Commands) > 0 && !slices.ContainsFunc(m.Commands, func(command string) bool {
+		return strings.EqualFold(command, frame.Command)
+	}) {
+		return false
+	}
+	if len(m.Databases) > 0 && !slices.Contains(m.Databases, frame.Database) {
+		return false
+	}
+	if len(m.Collections) > 0 && !slices.Contains(m.Collections, frame.Collection) {
+		return false
+	}
 	return true
 }
 
@@ -29,12 +55,12 @@ func (m *MongoDBFilter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
 
 // FilterByRequest implements protocol.ProtocolFilter.
 func (m *MongoDBFilter) FilterByRequest() bool {
-	return true
+	return len(m.Commands) > 0 || len(m.Databases) > 0 || len(m.Collections) > 0
 }
 
 // FilterByResponse implements protocol.ProtocolFilter.
 func (m *MongoDBFilter) FilterByResponse() bool {
-	return true
+	return false
 }
 
 func (MongoDBFilter) Protocol() bpf.AgentTrafficProtocolT {
@@ -68,6 +94,14 @@ type MongoDBFrame struct {
 	IsHandshake     bool
 	Consumed        bool
 	isReq           bool
+
+	// Fields of a request, taken from its command document
+	// The name of the command, the first key of the document, like find or aggregate.
+	Command    string
+	Database   string
+	Collection string
+	// The compressor of an OP_COMPRESSED message, like snappy, zlib or zstd.
+	Compressor string
 }
 
 // FormatToString implements protocol.ParsedMessage.
@@ -91,6 +125,21 @@ func (m *MongoDBFrame) StreamId() StreamId {
 	return StreamId(m.ResponseTo)
 }
 
+// ClassName returns the command of a request and its namespace, like
+// find shop.orders.
+func (m *MongoDBFrame) ClassName() string {
+	switch {
+	case m.Command == "":
+		return m.OpMsgType
+	case m.Collection != "":
+		return m.Command + " " + m.Database + "." + m.Collection
+	case m.Database != "":
+		return m.Command + " " + m.Database
+	default:
+		return m.Command
+	}
+}
+
 func (m *MongoDBFrame) ByteSize() int {
 	return int(m.Length)
 }
@@ -164,8 +213,8 @@ func (m *MongoDBStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, mes
 	}
 
 	// Parser will ignore Op Codes that have been deprecated/removed from version 5.0 onwards as well
-	// as kOPCompressed and kReserved which are not supported by the parser yet.
-	if opCode != kOPMsg {
+	// as kReserved, except OP_QUERY and OP_REPLY which drivers still use for the handshake.
+	if opCode != kOPMsg && opCode != kOPCompressed && opCode != kOPQuery && opCode != kOPReply {
 		decoder.RemovePrefix(length - 16)
 		result.ReadBytes = int(length)
 		result.ParseState = Ignore
@@ -184,6 +233,9 @@ func (m *MongoDBStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, mes
 
 	result.ParseState = ProcessPayload(decoder, mongoDBFrame)
 	result.ReadBytes = decoder.ReadBytes()
+	if result.ParseState == Ignore {
+		return result
+	}
 	frameBase, ok := CreateFrameBase(streamBuffer, result.ReadBytes)
 	if !ok {
 		return ParseResult{
@@ -329,11 +381,16 @@ func init() {
 }
 
 func ProcessPayload(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseState {
+	bodyLength := mongoDBFrame.Length - int32(kHeaderLength)
 	switch mongoDBFrame.OpCode {
 	case kOPMsg:
-		return ProcessOpMsg(decoder, mongoDBFrame)
+		return processOpMsg(decoder, mongoDBFrame, bodyLength)
+	case kOPQuery:
+		return processOpQuery(decoder, mongoDBFrame, bodyLength)
+	case kOPReply:
+		return processOpReply(decoder, mongoDBFrame, bodyLength)
 	case kOPCompressed:
-		return Ignore
+		return ProcessOpCompressed(decoder, mongoDBFrame)
 	case kReserved:
 		return Ignore
 	default:
@@ -342,6 +399,12 @@ func ProcessPayload(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseSta
 }
 
 func ProcessOpMsg(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseState {
+	return processOpMsg(decoder, mongoDBFrame, mongoDBFrame.Length-int32(kHeaderLength))
+}
+
+// processOpMsg parses the body of an OP_MSG, which is bodyLength bytes long
+// after the header.
+func processOpMsg(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame, bodyLength int32) ParseState {
 	flagBits, err := ExtractLEInt[uint32](decoder)
 	if err != nil {
 		return Invalid
@@ -365,7 +428,7 @@ func ProcessOpMsg(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseState
 	}
 
 	// Get the section(s) data from the buffer.
-	allSectionsLength := mongoDBFrame.Length - int32(kHeaderAndFlagSize) - int32(checksumBytes)
+	allSectionsLength := bodyLength - int32(kHeaderAndFlagSize-kHeaderLength) - int32(checksumBytes)
 	for allSectionsLength > 0 {
 		var section Section
 		section.kind, err = ExtractLEInt[uint8](decoder)
@@ -454,17 +517,12 @@ func ProcessOpMsg(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseState
 					mongoDBFrame.OpMsgType = opMsgType
 					mongoDBFrame.IsHandshake = true
 				default:
-					// The frame is a response message, find the "ok" key and its value.
-					if okValue, ok := doc["ok"]; ok {
-						switch v := okValue.(type) {
-						case map[string]interface{}:
-							for key, value := range v {
-								mongoDBFrame.OpMsgType = fmt.Sprintf("ok: {%s: %v}", key, value)
-								break
-							}
-						case float64:
-							mongoDBFrame.OpMsgType = fmt.Sprintf("ok: %d", int(v))
-						}
+					if _, ok := doc["$db"]; ok && mongoDBFrame.isReq {
+						// The other commands of a request, like aggregate or getMore.
+						mongoDBFrame.OpMsgType = opMsgType
+					} else if okType, ok := responseType(doc); ok {
+						// The frame is a response message.
+						mongoDBFrame.OpMsgType = okType
 					} else {
 						return Invalid
 					}
@@ -472,6 +530,9 @@ func ProcessOpMsg(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseState
 				}
 			}
 
+			if section.kind == kSectionKindZero && mongoDBFrame.isReq {
+				setCommand(mongoDBFrame, bsonDoc)
+			}
 			section.Documents = append(section.Documents, string(jsonDoc))
 			remainingSectionLength -= documentLength
 		}
@@ -488,6 +549,202 @@ func ProcessOpMsg(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseState
 	return Success
 }
 
+// responseType returns the "ok" key of a response document and its value.
+func responseType(doc map[string]interface{}) (string, bool) {
+	okValue, ok := doc[kOk]
+	if !ok {
+		return "", false
+	}
+	switch v := okValue.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			return fmt.Sprintf("ok: {%s: %v}", key, value), true
+		}
+	case float64:
+		return fmt.Sprintf("ok: %d", int(v)), true
+	}
+	return "", true
+}
+
+// setCommand sets the command of a request, its database and its
+// collection, from the command document.
+func setCommand(mongoDBFrame *MongoDBFrame, doc bson.D) {
+	if len(doc) == 0 {
+		return
+	}
+	mongoDBFrame.Command = doc[0].Key
+	if collection, ok := doc[0].Value.(string); ok {
+		mongoDBFrame.Collection = collection
+	}
+	for _, element := range doc[1:] {
+		switch element.Key {
+		case "$db":
+			mongoDBFrame.Database, _ = element.Value.(string)
+		case "collection":
+			// getMore names the collection of the cursor there.
+			if mongoDBFrame.Collection == "" {
+				mongoDBFrame.Collection, _ = element.Value.(string)
+			}
+		}
+	}
+}
+
+// extractDocument extracts a BSON document and converts it to a JSON string.
+func extractDocument(decoder *BinaryDecoder) (bson.D, string, error) {
+	documentLength := LEndianBytesToInt[int32](decoder)
+	if documentLength < int32(kSectionLengthSize) || documentLength > kMaxBSONObjSize {
+		return nil, "", fmt.Errorf("invalid document length %d", documentLength)
+	}
+	body, err := decoder.ExtractString(int(documentLength))
+	if err != nil {
+		return nil, "", err
+	}
+	var bsonDoc bson.D
+	if err := bson.Unmarshal([]byte(body), &bsonDoc); err != nil {
+		return nil, "", err
+	}
+	jsonDoc, err := bson.MarshalExtJSON(bsonDoc, true, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return bsonDoc, string(jsonDoc), nil
+}
+
+// processOpQuery parses the body of a legacy OP_QUERY, which drivers still
+// send for the handshake:
+//
+//	int32     flags
+//	cstring   fullCollectionName, like db.collection or db.$cmd
+//	int32     numberToSkip
+//	int32     numberToReturn
+//	document  query
+//	document  returnFieldsSelector, optional
+func processOpQuery(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame, bodyLength int32) ParseState {
+	start := decoder.ReadBytes()
+	if _, err := ExtractLEInt[uint32](decoder); err != nil {
+		return Invalid
+	}
+	namespace, err := decoder.ExtractStringUntil("\x00")
+	if err != nil {
+		return Invalid
+	}
+	if _, err := decoder.ExtractString(8); err != nil {
+		return Invalid
+	}
+	var section Section
+	for int32(decoder.ReadBytes()-start) < bodyLength {
+		bsonDoc, jsonDoc, err := extractDocument(decoder)
+		if err != nil {
+			return Invalid
+		}
+		if len(section.Documents) == 0 {
+			database, collection, _ := strings.Cut(namespace, ".")
+			if collection == "$cmd" {
+				// A command, like isMaster on admin.$cmd.
+				setCommand(mongoDBFrame, bsonDoc)
+			} else {
+				mongoDBFrame.Command = kFind
+				mongoDBFrame.Collection = collection
+			}
+			mongoDBFrame.Database = database
+			mongoDBFrame.OpMsgType = mongoDBFrame.Command
+			switch mongoDBFrame.Command {
+			case kHello, kIsMaster, kIsMasterAlternate:
+				mongoDBFrame.IsHandshake = true
+			}
+		}
+		section.Documents = append(section.Documents, jsonDoc)
+	}
+	if int32(decoder.ReadBytes()-start) != bodyLength || len(section.Documents) == 0 {
+		return Invalid
+	}
+	mongoDBFrame.Sections = append(mongoDBFrame.Sections, section)
+	return Success
+}
+
+// processOpReply parses the body of a legacy OP_REPLY:
+//
+//	int32     responseFlags
+//	int64     cursorID
+//	int32     startingFrom
+//	int32     numberReturned
+//	document* documents
+func processOpReply(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame, bodyLength int32) ParseState {
+	start := decoder.ReadBytes()
+	if _, err := decoder.ExtractString(20); err != nil {
+		return Invalid
+	}
+	var section Section
+	for int32(decoder.ReadBytes()-start) < bodyLength {
+		_, jsonDoc, err := extractDocument(decoder)
+		if err != nil {
+			return Invalid
+		}
+		if len(section.Documents) == 0 {
+			var doc map[string]interface{}
+			if err := json.Unmarshal([]byte(jsonDoc), &doc); err != nil {
+				return Invalid
+			}
+			mongoDBFrame.OpMsgType, _ = responseType(doc)
+		}
+		section.Documents = append(section.Documents, jsonDoc)
+	}
+	if int32(decoder.ReadBytes()-start) != bodyLength {
+		return Invalid
+	}
+	mongoDBFrame.Sections = append(mongoDBFrame.Sections, section)
+	return Success
+}
+
+// ProcessOpCompressed decompresses an OP_COMPRESSED and parses the message
+// it wraps:
+//
+//	int32    originalOpcode
+//	int32    uncompressedSize
+//	uint8    compressorId
+//	char*    compressedMessage
+func ProcessOpCompressed(decoder *BinaryDecoder, mongoDBFrame *MongoDBFrame) ParseState {
+	originalOpCode, err := ExtractLEInt[int32](decoder)
+	if err != nil {
+		return Invalid
+	}
+	uncompressedSize, err := ExtractLEInt[int32](decoder)
+	if err != nil || uncompressedSize < 0 || uncompressedSize > kMaxMessageSize {
+		return Invalid
+	}
+	compressorId, err := ExtractLEInt[uint8](decoder)
+	if err != nil {
+		return Invalid
+	}
+	compressed, err := decoder.ExtractString(int(mongoDBFrame.Length) - int(kHeaderLength) - kCompressedHeaderSize)
+	if err != nil {
+		return Invalid
+	}
+	compressor, ok := compressorNames[compressorId]
+	if !ok {
+		return Invalid
+	}
+	body, err := decompress(compressorId, []byte(compressed), int(uncompressedSize))
+	if err != nil {
+		common.ProtocolParserLog.Debugf("[mongodb] failed to decompress %s message: %v", compressor, err)
+		return Invalid
+	}
+
+	mongoDBFrame.OpCode = originalOpCode
+	mongoDBFrame.Compressor = compressor
+	bodyDecoder := NewBinaryDecoder(body)
+	switch originalOpCode {
+	case kOPMsg:
+		return processOpMsg(bodyDecoder, mongoDBFrame, uncompressedSize)
+	case kOPQuery:
+		return processOpQuery(bodyDecoder, mongoDBFrame, uncompressedSize)
+	case kOPReply:
+		return processOpReply(bodyDecoder, mongoDBFrame, uncompressedSize)
+	default:
+		return Ignore
+	}
+}
+
 func NewMongoDBStreamParser() *MongoDBStreamParser {
 	return &MongoDBStreamParser{
 		State: &State{
@@ -2,6 +2,9 @@ package mongodb_test
 
 import (
 	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
 	"fmt"
 	"kyanos/agent/buffer"
 	"kyanos/agent/protocol"
@@ -14,7 +17,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 var mongoDBNeedMoreHeaderData []byte = []byte{
@@ -61,8 +67,8 @@ var mongoDBUnsupportedType []byte = []byte{
 	0x82, 0xb7, 0x31, 0x44,
 	// response to
 	0x00, 0x00, 0x00, 0x00,
-	// op code (2004, not supported in newer versions)
-	0xd4, 0x07, 0x00, 0x00,
+	// op code (2005, not supported in newer versions)
+	0xd5, 0x07, 0x00, 0x00,
 	// flag bits
 	0x00, 0x00,
 }
@@ -960,3 +966,107 @@ func TestVerifyHandshakingMessages(t *testing.T) {
 	assert.True(t, len(resps) == 0)
 	assert.True(t, len(MongoDBStreamParser.StreamOrder) == 0)
 }
+
+func mongoDBMessage(requestId int32, responseTo int32, opCode int32, body []byte) []byte {
+	message := binary.LittleEndian.AppendUint32(nil, uint32(16+len(body)))
+	message = binary.LittleEndian.AppendUint32(message, uint32(requestId))
+	message = binary.LittleEndian.AppendUint32(message, uint32(responseTo))
+	message = binary.LittleEndian.AppendUint32(message, uint32(opCode))
+	return append(message, body...)
+}
+
+func opMsgBody(t *testing.T, doc bson.D) []byte {
+	document, err := bson.Marshal(doc)
+	assert.NoError(t, err)
+	// flag bits and a kind 0 section
+	return append([]byte{0, 0, 0, 0, 0}, document...)
+}
+
+func parseMongoDBMessage(t *testing.T, message []byte, messageType protocol.MessageType) *MongoDBFrame {
+	streamBuffer := buffer.New(10000)
+	streamBuffer.Add(1, message, 1)
+	parseResult := NewMongoDBStreamParser().ParseStream(streamBuffer, messageType)
+	assert.Equal(t, protocol.Success, parseResult.ParseState)
+	assert.Equal(t, len(message), parseResult.ReadBytes)
+	return parseResult.ParsedMessages[0].(*MongoDBFrame)
+}
+
+func TestParseCommandNamespace(t *testing.T) {
+	aggregate := opMsgBody(t, bson.D{{Key: "aggregate", Value: "orders"}, {Key: "pipeline", Value: bson.A{}}, {Key: "$db", Value: "shop"}})
+	frame := parseMongoDBMessage(t, mongoDBMessage(1, 0, 2013, aggregate), protocol.Request)
+	assert.Equal(t, "aggregate", frame.OpMsgType)
+	assert.Equal(t, "aggregate", frame.Command)
+	assert.Equal(t, "shop", frame.Database)
+	assert.Equal(t, "orders", frame.Collection)
+
+	assert.Equal(t, "aggregate shop.orders", frame.ClassName())
+	assert.True(t, (&MongoDBFilter{Commands: []string{"Aggregate"}, Databases: []string{"shop"}}).Filter(frame, nil))
+	assert.False(t, (&MongoDBFilter{Commands: []string{"find"}}).Filter(frame, nil))
+	assert.False(t, (&MongoDBFilter{Collections: []string{"users"}}).Filter(frame, nil))
+
+	getMore := opMsgBody(t, bson.D{{Key: "getMore", Value: int64(42)}, {Key: "collection", Value: "orders"}, {Key: "$db", Value: "shop"}})
+	frame = parseMongoDBMessage(t, mongoDBMessage(2, 0, 2013, getMore), protocol.Request)
+	assert.Equal(t, "getMore", frame.Command)
+	assert.Equal(t, "orders", frame.Collection)
+	assert.True(t, (&MongoDBFilter{Collections: []string{"orders"}}).Filter(frame, nil))
+
+	// a request without $db is not a command
+	streamBuffer := buffer.New(10000)
+	streamBuffer.Add(1, mongoDBMessage(3, 0, 2013, opMsgBody(t, bson.D{{Key: "aggregate", Value: "orders"}})), 1)
+	assert.Equal(t, protocol.Invalid, NewMongoDBStreamParser().ParseStream(streamBuffer, protocol.Request).ParseState)
+}
+
+func TestParseOpCompressed(t *testing.T) {
+	body := opMsgBody(t, bson.D{{Key: "find", Value: "orders"}, {Key: "filter", Value: bson.D{{Key: "paid", Value: false}}}, {Key: "$db", Value: "shop"}})
+	zlibBody := &bytes.Buffer{}
+	w := zlib.NewWriter(zlibBody)
+	w.Write(body)
+	w.Close()
+	encoder, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+
+	compressed := map[uint8][]byte{
+		0: body,
+		1: snappy.Encode(nil, body),
+		2: zlibBody.Bytes(),
+		3: encoder.EncodeAll(body, nil),
+	}
+	for compressorId, compressor := range map[uint8]string{0: "noop", 1: "snappy", 2: "zlib", 3: "zstd"} {
+		payload := binary.LittleEndian.AppendUint32(nil, 2013)
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(len(body)))
+		payload = append(payload, compressorId)
+		payload = append(payload, compressed[compressorId]...)
+		message := mongoDBMessage(7, 0, 2012, payload)
+
+		frame := parseMongoDBMessage(t, message, protocol.Request)
+		assert.Equal(t, compressor, frame.Compressor)
+		assert.Equal(t, int32(2013), frame.OpCode)
+		assert.Equal(t, int32(len(message)), frame.Length)
+		assert.Equal(t, "find", frame.Command)
+		assert.Equal(t, "shop", frame.Database)
+		assert.Equal(t, "orders", frame.Collection)
+	}
+}
+
+func TestParseOpQueryAndOpReply(t *testing.T) {
+	isMaster, err := bson.Marshal(bson.D{{Key: "isMaster", Value: int32(1)}, {Key: "compression", Value: bson.A{"zstd"}}})
+	assert.NoError(t, err)
+	query := append([]byte{0, 0, 0, 0}, "admin.$cmd\x00"...)
+	query = append(query, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff)
+	frame := parseMongoDBMessage(t, mongoDBMessage(5, 0, 2004, append(query, isMaster...)), protocol.Request)
+	assert.Equal(t, "isMaster", frame.Command)
+	assert.Equal(t, "admin", frame.Database)
+	assert.True(t, frame.IsHandshake)
+
+	legacyFind := append([]byte{0, 0, 0, 0}, "shop.orders\x00"...)
+	legacyFind = append(legacyFind, make([]byte, 8)...)
+	filter, _ := bson.Marshal(bson.D{{Key: "paid", Value: false}})
+	frame = parseMongoDBMessage(t, mongoDBMessage(6, 0, 2004, append(legacyFind, filter...)), protocol.Request)
+	assert.Equal(t, "find", frame.Command)
+	assert.Equal(t, "orders", frame.Collection)
+
+	reply, _ := bson.Marshal(bson.D{{Key: "ismaster", Value: true}, {Key: "ok", Value: 1.0}})
+	frame = parseMongoDBMessage(t, mongoDBMessage(8, 5, 1, append(make([]byte, 20), reply...)), protocol.Response)
+	assert.Equal(t, "ok: {$numberDouble: 1.0}", frame.OpMsgType)
+	assert.Len(t, frame.Sections[0].Documents, 1)
+}
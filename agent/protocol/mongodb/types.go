@@ -69,6 +69,27 @@ const (
 // Max BSON object size in bytes
 const kMaxBSONObjSize = 16000000
 
+// Max message size in bytes
+const kMaxMessageSize = 48000000
+
+// The size of originalOpcode, uncompressedSize and compressorId of an OP_COMPRESSED.
+const kCompressedHeaderSize = 9
+
+// Compressor ids of OP_COMPRESSED
+const (
+	kCompressorNoop   uint8 = 0
+	kCompressorSnappy uint8 = 1
+	kCompressorZlib   uint8 = 2
+	kCompressorZstd   uint8 = 3
+)
+
+var compressorNames = map[uint8]string{
+	kCompressorNoop:   "noop",
+	kCompressorSnappy: "snappy",
+	kCompressorZlib:   "zlib",
+	kCompressorZstd:   "zstd",
+}
+
 type State struct {
 	StreamOrder []StreamOrderPair
 }
@@ -0,0 +1,52 @@
+package mqtt
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+)
+
+type Filter struct {
+	// topics of the publishes and the subscriptions, the wildcards + and #
+	// can be used
+	TargetTopics []string
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	mqttReq, ok := req.(*Packet)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[MQTTFilter] cast to mqtt.Packet failed: %v\n", req)
+		return false
+	}
+	if len(f.TargetTopics) == 0 {
+		return true
+	}
+	topics := mqttReq.TopicFilters
+	if mqttReq.Topic != "" {
+		topics = []string{mqttReq.Topic}
+	}
+	return slices.ContainsFunc(f.TargetTopics, func(pattern string) bool {
+		return slices.ContainsFunc(topics, func(topic string) bool {
+			return MatchTopic(pattern, topic)
+		})
+	})
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolMQTT
+}
+
+func (f Filter) FilterByRequest() bool {
+	return len(f.TargetTopics) > 0
+}
+
+func (f Filter) FilterByResponse() bool {
+	return false
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolMQTT
+}
+
+var _ protocol.ProtocolFilter = Filter{}
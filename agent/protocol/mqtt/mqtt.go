@@ -0,0 +1,398 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolMQTT] = func() protocol.ProtocolStreamParser {
+		return NewMqttStreamParser()
+	}
+}
+
+func NewMqttStreamParser() *MqttStreamParser {
+	return &MqttStreamParser{
+		topicAliases: make(map[uint16]string),
+		unreleased:   make(map[uint16]string),
+	}
+}
+
+// A request not acknowledged within kMaxResponseWaitNs is dropped.
+const kMaxResponseWaitNs uint64 = 60 * 1000 * 1000 * 1000
+
+// The remaining length can encode 256MB, brokers limit the packets to a
+// few MB by default.
+const kMaxPacketSize = 64 << 20
+
+// Only the beginning of the payload of PUBLISH is kept.
+const kMaxPayloadKept = 1024
+
+var errNotEnoughData = errors.New("not enough data")
+var errInvalid = errors.New("invalid packet")
+
+func (d direction) accepts(messageType protocol.MessageType) bool {
+	switch messageType {
+	case protocol.Request:
+		return d&fromClient != 0
+	case protocol.Response:
+		return d&fromServer != 0
+	}
+	return true
+}
+
+type fixedHeader struct {
+	packetType PacketType
+	flags      byte
+	// the length of the fixed header itself
+	length    int
+	remaining int
+}
+
+func validFlags(packetType PacketType, flags byte) bool {
+	switch packetType {
+	case Publish:
+		return (flags>>1)&0x03 != 0x03
+	case PubRel, Subscribe, Unsubscribe:
+		return flags == 0x02
+	}
+	return flags == 0
+}
+
+// parseFixedHeader reads the packet type, the flags and the remaining
+// length, and checks them against messageType.
+func parseFixedHeader(buf []byte, messageType protocol.MessageType) (fixedHeader, protocol.ParseState) {
+	var h fixedHeader
+	if len(buf) == 0 {
+		return h, protocol.NeedsMoreData
+	}
+	h.packetType = PacketType(buf[0] >> 4)
+	h.flags = buf[0] & 0x0f
+	dir, ok := packetDirections[h.packetType]
+	if !ok || !dir.accepts(messageType) || !validFlags(h.packetType, h.flags) {
+		return h, protocol.Invalid
+	}
+	// the remaining length is a variable byte integer of at most 4 bytes
+	for i := 1; ; i++ {
+		if i > 4 {
+			return h, protocol.Invalid
+		}
+		if i >= len(buf) {
+			return h, protocol.NeedsMoreData
+		}
+		h.remaining |= int(buf[i]&0x7f) << (7 * (i - 1))
+		if buf[i]&0x80 == 0 {
+			h.length = i + 1
+			break
+		}
+	}
+	if h.remaining > kMaxPacketSize {
+		return h, protocol.Invalid
+	}
+	switch h.packetType {
+	case PingReq, PingResp:
+		if h.remaining != 0 {
+			return h, protocol.Invalid
+		}
+	case ConnAck, PubAck, PubRec, PubRel, PubComp, Subscribe, SubAck, Unsubscribe, UnsubAck:
+		if h.remaining < 2 {
+			return h, protocol.Invalid
+		}
+	case Connect, Publish:
+		// the protocol name and the topic are not empty
+		if h.remaining < 3 {
+			return h, protocol.Invalid
+		}
+	}
+	return h, protocol.Success
+}
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errNotEnoughData
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) readByte() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) readUint16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) readVarint() int {
+	var value int
+	for i := 0; i < 4; i++ {
+		b := d.readByte()
+		value |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return value
+		}
+	}
+	if d.err == nil {
+		d.err = errInvalid
+	}
+	return 0
+}
+
+// readBinary reads binary data or a UTF-8 string, both are prefixed by a
+// two bytes length.
+func (d *decoder) readBinary() []byte {
+	return d.take(int(d.readUint16()))
+}
+
+func (d *decoder) readString() string {
+	return string(d.readBinary())
+}
+
+type properties struct {
+	topicAlias   uint16
+	reasonString string
+}
+
+// readProperties reads the properties of MQTT 5, only the topic alias and
+// the reason string are kept.
+func (d *decoder) readProperties() properties {
+	var props properties
+	pd := &decoder{buf: d.take(d.readVarint())}
+	if d.err != nil {
+		return props
+	}
+	for len(pd.buf) > 0 && pd.err == nil {
+		switch id := pd.readVarint(); id {
+		// byte
+		case 0x01, 0x17, 0x19, 0x24, 0x25, 0x28, 0x29, 0x2a:
+			pd.take(1)
+		// two byte integer
+		case 0x23:
+			props.topicAlias = pd.readUint16()
+		case 0x13, 0x21, 0x22:
+			pd.take(2)
+		// four byte integer
+		case 0x02, 0x11, 0x18, 0x27:
+			pd.take(4)
+		// the subscription identifier, a variable byte integer
+		case 0x0b:
+			pd.readVarint()
+		// UTF-8 string
+		case 0x1f:
+			props.reasonString = pd.readString()
+		case 0x03, 0x08, 0x12, 0x15, 0x1a, 0x1c:
+			pd.readBinary()
+		// binary data
+		case 0x09, 0x16:
+			pd.readBinary()
+		// the user property, a string pair
+		case 0x26:
+			pd.readBinary()
+			pd.readBinary()
+		default:
+			pd.err = errInvalid
+		}
+	}
+	d.err = pd.err
+	return props
+}
+
+func (p *MqttStreamParser) isVersion5() bool {
+	return p.version == kProtocolLevel5
+}
+
+func (p *MqttStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	h, state := parseFixedHeader(buf, messageType)
+	if state != protocol.Success {
+		return protocol.ParseResult{ParseState: state}
+	}
+	readBytes := h.length + h.remaining
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+
+	packet := &Packet{Type: h.packetType}
+	if messageType == protocol.Unknown {
+		_, packet.isReq = requestTypes[h.packetType]
+	} else {
+		packet.isReq = messageType == protocol.Request
+	}
+	d := &decoder{buf: buf[h.length:readBytes]}
+	p.decode(d, packet, h)
+	if d.err != nil {
+		common.ProtocolParserLog.Debugf("[MQTT] failed to decode %s: %v", h.packetType, d.err)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	// the publishes delivered by the server are acknowledged in the other
+	// direction, they can't be matched like the requests of the client
+	var answered bool
+	if packet.isReq {
+		_, answered = requestTypes[packet.Type]
+		answered = answered && (packet.Type != Publish || packet.QoS > 0)
+	} else {
+		_, answered = responseTypes[packet.Type]
+	}
+	if !answered {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[MQTT] failed to create FrameBase for %s", h.packetType)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	packet.FrameBase = fb
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{packet},
+	}
+}
+
+func (p *MqttStreamParser) decode(d *decoder, packet *Packet, h fixedHeader) {
+	switch packet.Type {
+	case Connect:
+		name := d.readString()
+		if d.err == nil && name != "MQTT" && name != "MQIsdp" {
+			d.err = errInvalid
+			return
+		}
+		packet.ProtocolLevel = d.readByte()
+		flags := d.readByte()
+		packet.CleanStart = flags&0x02 != 0
+		packet.KeepAlive = d.readUint16()
+		if packet.ProtocolLevel == kProtocolLevel5 {
+			d.readProperties()
+		}
+		packet.ClientId = d.readString()
+		if d.err == nil {
+			// a new connection starts without aliases
+			p.version = packet.ProtocolLevel
+			clear(p.topicAliases)
+		}
+	case ConnAck:
+		packet.SessionPresent = d.readByte()&0x01 != 0
+		packet.ReasonCodes = []byte{d.readByte()}
+		// the CONNACK of MQTT 5 has properties after the reason code
+		if p.version == 0 && h.remaining > 2 {
+			p.version = kProtocolLevel5
+		}
+		if p.isVersion5() {
+			packet.ReasonString = d.readProperties().reasonString
+		}
+	case Publish:
+		packet.Dup = h.flags&0x08 != 0
+		packet.QoS = (h.flags >> 1) & 0x03
+		packet.Retain = h.flags&0x01 != 0
+		packet.Topic = d.readString()
+		if packet.QoS > 0 {
+			packet.PacketId = d.readUint16()
+		}
+		if p.isVersion5() {
+			props := d.readProperties()
+			// the aliases are set by each side for the topics it sends
+			if props.topicAlias != 0 && packet.isReq {
+				if packet.Topic == "" {
+					packet.Topic = p.topicAliases[props.topicAlias]
+				} else {
+					p.topicAliases[props.topicAlias] = packet.Topic
+				}
+			}
+		}
+		payload := d.buf
+		packet.Payload = bytes.Clone(payload[:min(len(payload), kMaxPayloadKept)])
+		packet.PayloadSize = len(payload)
+		d.buf = nil
+		if d.err == nil && packet.isReq && packet.QoS == 2 {
+			p.unreleased[packet.PacketId] = packet.Topic
+		}
+	case PubAck, PubRec, PubRel, PubComp:
+		packet.PacketId = d.readUint16()
+		// the reason code and the properties of MQTT 5 may be omitted
+		if len(d.buf) > 0 {
+			packet.ReasonCodes = []byte{d.readByte()}
+		}
+		if len(d.buf) > 0 {
+			packet.ReasonString = d.readProperties().reasonString
+		}
+		switch {
+		case packet.Type == PubRel && packet.isReq:
+			packet.Topic = p.unreleased[packet.PacketId]
+			delete(p.unreleased, packet.PacketId)
+		case packet.Type == PubRec && !packet.isReq && packet.Status() == protocol.FailStatus:
+			// the publish is not released after a failed PUBREC
+			delete(p.unreleased, packet.PacketId)
+		}
+	case Subscribe, Unsubscribe:
+		packet.PacketId = d.readUint16()
+		if p.isVersion5() {
+			d.readProperties()
+		}
+		for len(d.buf) > 0 && d.err == nil {
+			packet.TopicFilters = append(packet.TopicFilters, d.readString())
+			if packet.Type == Subscribe {
+				// the requested QoS, and the subscription options of MQTT 5
+				d.readByte()
+			}
+		}
+	case SubAck, UnsubAck:
+		packet.PacketId = d.readUint16()
+		if p.isVersion5() {
+			packet.ReasonString = d.readProperties().reasonString
+		}
+		// the UNSUBACK of 3.1.1 has no payload
+		packet.ReasonCodes = bytes.Clone(d.buf)
+		d.buf = nil
+	}
+}
+
+// FindBoundary looks for a valid fixed header of messageType, followed by
+// the end of the buffer or another valid fixed header.
+func (p *MqttStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i < len(buf); i++ {
+		h, state := parseFixedHeader(buf[i:], messageType)
+		if state != protocol.Success {
+			continue
+		}
+		next := i + h.length + h.remaining
+		if next == len(buf) {
+			return i
+		}
+		if next < len(buf) {
+			if _, state := parseFixedHeader(buf[next:], messageType); state != protocol.Invalid {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Match pairs the requests and the acknowledgements by packet id, and
+// CONNECT and PINGREQ in order.
+func (p *MqttStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	return protocol.MatchByStreamId(reqStreams, respStreams, kMaxResponseWaitNs)
+}
@@ -0,0 +1,225 @@
+package mqtt_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/mqtt"
+	"kyanos/agent/protocol/protocoltest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func packet(first byte, body []byte) []byte {
+	data := []byte{first}
+	for remaining := len(body); ; {
+		b := byte(remaining & 0x7f)
+		remaining >>= 7
+		if remaining > 0 {
+			data = append(data, b|0x80)
+			continue
+		}
+		data = append(data, b)
+		break
+	}
+	return append(data, body...)
+}
+
+func str(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+func connect(level byte, clientId string) []byte {
+	body := append(str("MQTT"), level, 0x02, 0, 60)
+	if level == 5 {
+		// no properties
+		body = append(body, 0)
+	}
+	return packet(0x10, append(body, str(clientId)...))
+}
+
+func publish(qos byte, topic string, packetId uint16, properties []byte, payload string) []byte {
+	body := str(topic)
+	if qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, packetId)
+	}
+	body = append(body, properties...)
+	return packet(0x30|qos<<1, append(body, payload...))
+}
+
+func ack(first byte, packetId uint16, rest ...byte) []byte {
+	return packet(first, append(binary.BigEndian.AppendUint16(nil, packetId), rest...))
+}
+
+func TestMatchConnectAndPublish(t *testing.T) {
+	parser := mqtt.NewMqttStreamParser()
+	data := connect(4, "gateway-1")
+	// QoS 0 is never acknowledged
+	data = append(data, publish(0, "sensors/1/temperature", 0, nil, "20.5")...)
+	data = append(data, publish(1, "sensors/1/temperature", 10, nil, "21.0")...)
+	data = append(data, publish(1, "sensors/2/temperature", 11, nil, "19.0")...)
+	data = append(data, 0xc0, 0x00)
+	reqs := protocoltest.ParseAll(t, parser, data, protocol.Request, 10)
+	assert.Len(t, reqs, 4)
+
+	conn := (*reqs[protocol.StreamId(uint64(mqtt.Connect)<<16)])[0].(*mqtt.Packet)
+	assert.Equal(t, "gateway-1", conn.ClientId)
+	assert.Equal(t, byte(4), conn.ProtocolLevel)
+	assert.Equal(t, uint16(60), conn.KeepAlive)
+	assert.True(t, conn.CleanStart)
+
+	resp := []byte{0x20, 0x02, 0x00, 0x00}
+	resp = append(resp, ack(0x40, 11)...)
+	resp = append(resp, ack(0x40, 10)...)
+	resp = append(resp, 0xd0, 0x00)
+	resps := protocoltest.ParseAll(t, parser, resp, protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 4)
+	topics := map[string]protocol.ResponseStatus{}
+	for _, record := range records {
+		req := record.Request().(*mqtt.Packet)
+		topics[req.ClassName()] = record.ResponseStatus
+	}
+	assert.Equal(t, map[string]protocol.ResponseStatus{
+		"CONNECT":               protocol.SuccessStatus,
+		"sensors/1/temperature": protocol.SuccessStatus,
+		"sensors/2/temperature": protocol.SuccessStatus,
+		"PINGREQ":               protocol.SuccessStatus,
+	}, topics)
+	assert.Empty(t, reqs)
+}
+
+func TestMatchQoS2(t *testing.T) {
+	parser := mqtt.NewMqttStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, publish(2, "orders/new", 7, nil, "{}"), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, ack(0x50, 7), protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.Equal(t, mqtt.PubRec, records[0].Response().(*mqtt.Packet).Type)
+
+	// the release is matched with PUBCOMP and carries the topic
+	reqs = protocoltest.ParseAll(t, parser, ack(0x62, 7), protocol.Request, 30)
+	resps = protocoltest.ParseAll(t, parser, ack(0x70, 7), protocol.Response, 40)
+	records = parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	rel := records[0].Request().(*mqtt.Packet)
+	assert.Equal(t, mqtt.PubRel, rel.Type)
+	assert.Equal(t, "orders/new", rel.Topic)
+	assert.Equal(t, mqtt.PubComp, records[0].Response().(*mqtt.Packet).Type)
+}
+
+func TestIgnoreDeliveries(t *testing.T) {
+	parser := mqtt.NewMqttStreamParser()
+	// the broker delivers a QoS 1 message which the client acknowledges
+	resps := protocoltest.ParseAll(t, parser, publish(1, "commands/1", 3, nil, "reboot"), protocol.Response, 10)
+	reqs := protocoltest.ParseAll(t, parser, ack(0x40, 3), protocol.Request, 20)
+	assert.Empty(t, resps)
+	assert.Empty(t, reqs)
+}
+
+func TestMatchSubscribe(t *testing.T) {
+	parser := mqtt.NewMqttStreamParser()
+	body := binary.BigEndian.AppendUint16(nil, 5)
+	body = append(body, str("sensors/+/temperature")...)
+	body = append(body, 1)
+	body = append(body, str("$SYS/#")...)
+	body = append(body, 0)
+	reqs := protocoltest.ParseAll(t, parser, packet(0x82, body), protocol.Request, 10)
+	// the second subscription is refused
+	resps := protocoltest.ParseAll(t, parser, ack(0x90, 5, 0x01, 0x80), protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	sub := records[0].Request().(*mqtt.Packet)
+	assert.Equal(t, []string{"sensors/+/temperature", "$SYS/#"}, sub.TopicFilters)
+	assert.Equal(t, []byte{0x01, 0x80}, records[0].Response().(*mqtt.Packet).ReasonCodes)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+}
+
+func TestMqtt5(t *testing.T) {
+	parser := mqtt.NewMqttStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, connect(5, "device"), protocol.Request, 10)
+	// session present, success and a receive maximum property
+	resps := protocoltest.ParseAll(t, parser, packet(0x20, []byte{0x01, 0x00, 0x03, 0x21, 0x00, 0x0a}), protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.True(t, records[0].Response().(*mqtt.Packet).SessionPresent)
+
+	// the first publish sets the topic alias 1, the second one uses it
+	data := publish(1, "sensors/1/humidity", 1, []byte{0x03, 0x23, 0x00, 0x01}, "40")
+	data = append(data, publish(1, "", 2, []byte{0x03, 0x23, 0x00, 0x01}, "41")...)
+	reqs = protocoltest.ParseAll(t, parser, data, protocol.Request, 30)
+	// a quota exceeded reason code with a reason string
+	reason := append([]byte{0x97, 0x09, 0x1f}, str("quota!")...)
+	resp := append(ack(0x40, 1, 0x00), ack(0x40, 2, reason...)...)
+	resps = protocoltest.ParseAll(t, parser, resp, protocol.Response, 40)
+	records = parser.Match(reqs, resps)
+	assert.Len(t, records, 2)
+	for _, record := range records {
+		req := record.Request().(*mqtt.Packet)
+		assert.Equal(t, "sensors/1/humidity", req.Topic)
+		if req.PacketId == 2 {
+			assert.Equal(t, protocol.FailStatus, record.ResponseStatus)
+			assert.Equal(t, "quota!", record.Response().(*mqtt.Packet).ReasonString)
+		} else {
+			assert.Equal(t, protocol.SuccessStatus, record.ResponseStatus)
+		}
+	}
+}
+
+func TestParseIncomplete(t *testing.T) {
+	parser := mqtt.NewMqttStreamParser()
+	data := publish(1, "sensors/1/temperature", 10, nil, "21.0")
+	for _, n := range []int{1, 2, len(data) - 1} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, data[:n], 1)
+		assert.Equal(t, protocol.NeedsMoreData, parser.ParseStream(streamBuffer, protocol.Request).ParseState, n)
+	}
+	for _, data := range [][]byte{
+		[]byte("GET / HTTP/1.1\r\n\r\n"),
+		{0x36, 0x05},
+		{0x80, 0x00},
+		{0xc0, 0x01, 0x00},
+		{0x20, 0x02, 0x00, 0x00},
+		{0x30, 0xff, 0xff, 0xff, 0xff},
+	} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, data, 1)
+		assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Request).ParseState, data)
+	}
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := mqtt.NewMqttStreamParser()
+	data := append([]byte{'{', '}', 0x40}, ack(0x40, 1)...)
+	data = append(data, 0xd0, 0x00)
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 1)
+	assert.Equal(t, 3, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+	assert.Equal(t, -1, parser.FindBoundary(streamBuffer, protocol.Request, 2))
+}
+
+func TestMatchTopic(t *testing.T) {
+	assert.True(t, mqtt.MatchTopic("sensors/+/temperature", "sensors/1/temperature"))
+	assert.False(t, mqtt.MatchTopic("sensors/+/temperature", "sensors/1/2/temperature"))
+	assert.True(t, mqtt.MatchTopic("sensors/#", "sensors"))
+	assert.True(t, mqtt.MatchTopic("sensors/#", "sensors/1/humidity"))
+	assert.True(t, mqtt.MatchTopic("#", "sensors/1"))
+	assert.False(t, mqtt.MatchTopic("#", "$SYS/broker/uptime"))
+	assert.True(t, mqtt.MatchTopic("$SYS/#", "$SYS/broker/uptime"))
+	assert.False(t, mqtt.MatchTopic("sensors", "sensors/1"))
+}
+
+func TestFilter(t *testing.T) {
+	pub := &mqtt.Packet{Type: mqtt.Publish, Topic: "sensors/1/temperature"}
+	sub := &mqtt.Packet{Type: mqtt.Subscribe, TopicFilters: []string{"orders/#", "sensors/+/temperature"}}
+	ping := &mqtt.Packet{Type: mqtt.PingReq}
+	assert.True(t, mqtt.Filter{}.Filter(ping, nil))
+	f := mqtt.Filter{TargetTopics: []string{"sensors/#"}}
+	assert.True(t, f.Filter(pub, nil))
+	assert.True(t, f.Filter(sub, nil))
+	assert.False(t, f.Filter(ping, nil))
+	assert.False(t, mqtt.Filter{TargetTopics: []string{"orders/new"}}.Filter(pub, nil))
+	assert.True(t, f.FilterByRequest())
+}
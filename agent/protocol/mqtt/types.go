@@ -0,0 +1,237 @@
+package mqtt
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/mqtt-v3.1.1.html and
+// https://docs.oasis-open.org/mqtt/mqtt/v5.0/mqtt-v5.0.html.
+
+type PacketType byte
+
+const (
+	Connect     PacketType = 1
+	ConnAck     PacketType = 2
+	Publish     PacketType = 3
+	PubAck      PacketType = 4
+	PubRec      PacketType = 5
+	PubRel      PacketType = 6
+	PubComp     PacketType = 7
+	Subscribe   PacketType = 8
+	SubAck      PacketType = 9
+	Unsubscribe PacketType = 10
+	UnsubAck    PacketType = 11
+	PingReq     PacketType = 12
+	PingResp    PacketType = 13
+	Disconnect  PacketType = 14
+	// MQTT 5 only
+	Auth PacketType = 15
+)
+
+var packetTypeNames = map[PacketType]string{
+	Connect:     "CONNECT",
+	ConnAck:     "CONNACK",
+	Publish:     "PUBLISH",
+	PubAck:      "PUBACK",
+	PubRec:      "PUBREC",
+	PubRel:      "PUBREL",
+	PubComp:     "PUBCOMP",
+	Subscribe:   "SUBSCRIBE",
+	SubAck:      "SUBACK",
+	Unsubscribe: "UNSUBSCRIBE",
+	UnsubAck:    "UNSUBACK",
+	PingReq:     "PINGREQ",
+	PingResp:    "PINGRESP",
+	Disconnect:  "DISCONNECT",
+	Auth:        "AUTH",
+}
+
+func (t PacketType) String() string {
+	if name, ok := packetTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", t)
+}
+
+type direction int
+
+const (
+	fromClient direction = 1 << iota
+	fromServer
+)
+
+var packetDirections = map[PacketType]direction{
+	Connect:     fromClient,
+	ConnAck:     fromServer,
+	Publish:     fromClient | fromServer,
+	PubAck:      fromClient | fromServer,
+	PubRec:      fromClient | fromServer,
+	PubRel:      fromClient | fromServer,
+	PubComp:     fromClient | fromServer,
+	Subscribe:   fromClient,
+	SubAck:      fromServer,
+	Unsubscribe: fromClient,
+	UnsubAck:    fromServer,
+	PingReq:     fromClient,
+	PingResp:    fromServer,
+	Disconnect:  fromClient | fromServer,
+	Auth:        fromClient | fromServer,
+}
+
+// requestTypes are the packets of a client which the server answers, the
+// acknowledgements are in the stream of the packet they answer.
+var requestTypes = map[PacketType]PacketType{
+	Connect:     ConnAck,
+	Publish:     PubAck,
+	PubRel:      PubComp,
+	Subscribe:   SubAck,
+	Unsubscribe: UnsubAck,
+	PingReq:     PingResp,
+}
+
+var responseTypes = map[PacketType]PacketType{
+	ConnAck:  Connect,
+	PubAck:   Publish,
+	PubRec:   Publish,
+	PubComp:  PubRel,
+	SubAck:   Subscribe,
+	UnsubAck: Unsubscribe,
+	PingResp: PingReq,
+}
+
+// The protocol level of CONNECT is 3 for 3.1, 4 for 3.1.1 and 5 for 5.0.
+const kProtocolLevel5 byte = 5
+
+var _ protocol.ParsedMessage = &Packet{}
+var _ protocol.StatusfulMessage = &Packet{}
+
+type Packet struct {
+	protocol.FrameBase
+	Type     PacketType
+	Dup      bool
+	QoS      byte
+	Retain   bool
+	PacketId uint16
+	// the topic of PUBLISH, and of the QoS 2 PUBLISH a PUBREL releases
+	Topic string
+	// the beginning of the payload, and its full size
+	Payload     []byte
+	PayloadSize int
+	// CONNECT
+	ProtocolLevel byte
+	ClientId      string
+	KeepAlive     uint16
+	CleanStart    bool
+	// CONNACK
+	SessionPresent bool
+	// the return code of CONNACK, the return codes of SUBACK and the reason
+	// codes of the acknowledgements of MQTT 5
+	ReasonCodes []byte
+	// the topic filters of SUBSCRIBE and UNSUBSCRIBE
+	TopicFilters []string
+	// the reason string property of MQTT 5
+	ReasonString string
+	isReq        bool
+}
+
+func (p *Packet) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] type=[%s]", p.FrameBase.String(), p.Type)
+	if p.PacketId != 0 {
+		fmt.Fprintf(&b, " packet_id=[%d]", p.PacketId)
+	}
+	switch p.Type {
+	case Connect:
+		fmt.Fprintf(&b, " level=[%d] client_id=[%s] keep_alive=[%d] clean_start=[%t]", p.ProtocolLevel, p.ClientId, p.KeepAlive, p.CleanStart)
+	case ConnAck:
+		fmt.Fprintf(&b, " session_present=[%t]", p.SessionPresent)
+	case Publish:
+		fmt.Fprintf(&b, " topic=[%s] qos=[%d] retain=[%t] dup=[%t]", p.Topic, p.QoS, p.Retain, p.Dup)
+	case PubRel:
+		fmt.Fprintf(&b, " topic=[%s]", p.Topic)
+	case Subscribe, Unsubscribe:
+		fmt.Fprintf(&b, " topic_filters=[%s]", strings.Join(p.TopicFilters, ", "))
+	}
+	if len(p.ReasonCodes) > 0 {
+		codes := make([]string, len(p.ReasonCodes))
+		for i, code := range p.ReasonCodes {
+			codes[i] = fmt.Sprintf("0x%02x", code)
+		}
+		fmt.Fprintf(&b, " reason_codes=[%s]", strings.Join(codes, ", "))
+	}
+	if p.ReasonString != "" {
+		fmt.Fprintf(&b, " reason=[%s]", p.ReasonString)
+	}
+	if p.Type == Publish {
+		fmt.Fprintf(&b, " payload_size=[%d] payload=[%s]", p.PayloadSize, p.Payload)
+	}
+	return b.String()
+}
+
+// ClassName is the topic of a PUBLISH, so the latency of the acknowledgements
+// is grouped by topic, and the type of the other packets.
+func (p *Packet) ClassName() string {
+	if p.Type == Publish {
+		return p.Topic
+	}
+	return p.Type.String()
+}
+
+func (p *Packet) IsReq() bool {
+	return p.isReq
+}
+
+// StreamId is the type of the request and the packet id, CONNECT and
+// PINGREQ have no packet id and are answered in order.
+func (p *Packet) StreamId() protocol.StreamId {
+	requestType := p.Type
+	if !p.isReq {
+		requestType = responseTypes[p.Type]
+	}
+	return protocol.StreamId(uint64(requestType)<<16 | uint64(p.PacketId))
+}
+
+// Status fails when the connection is refused or a reason code is 0x80 or
+// above, which includes the failure return code of SUBACK in 3.1.1.
+func (p *Packet) Status() protocol.ResponseStatus {
+	for _, code := range p.ReasonCodes {
+		if code >= 0x80 || (p.Type == ConnAck && code != 0) {
+			return protocol.FailStatus
+		}
+	}
+	return protocol.SuccessStatus
+}
+
+var _ protocol.ProtocolStreamParser = &MqttStreamParser{}
+
+type MqttStreamParser struct {
+	// the protocol level of the connection, 3.1.1 is assumed until a
+	// CONNECT or CONNACK is seen
+	version byte
+	// the topic aliases of MQTT 5 set by the client
+	topicAliases map[uint16]string
+	// the topics of the QoS 2 publishes which are not released yet
+	unreleased map[uint16]string
+}
+
+// MatchTopic reports whether topic matches filter, in which + matches a
+// single level and a trailing # matches any number of levels. The wildcards
+// don't match the first level of the topics starting with $, like $SYS.
+func MatchTopic(filter string, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" && i == len(filterLevels)-1 {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
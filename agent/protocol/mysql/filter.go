@@ -3,12 +3,73 @@ package mysql
 import (
 	"kyanos/agent/protocol"
 	"kyanos/bpf"
+	"kyanos/common"
+	"regexp"
+	"slices"
 )
 
 type MysqlFilter struct {
+	TargetSqlReg *regexp.Regexp
+	// the names of the commands, like query or stmt_execute
+	TargetCommands []string
+	ErrorOnly      bool
+	// the rows returned or affected at least
+	MinRows int
+	Schema  string
+	// only the records of the transactions
+	Transactions bool
 }
 
 func (m MysqlFilter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	if txn, ok := req.(*Transaction); ok && m.FilterByRequest() {
+		if m.TargetSqlReg != nil && !slices.ContainsFunc(txn.Digests, m.TargetSqlReg.MatchString) {
+			return false
+		}
+		if len(m.TargetCommands) > 0 {
+			return false
+		}
+		if m.Schema != "" && txn.Schema != m.Schema {
+			return false
+		}
+	} else if m.FilterByRequest() {
+		if m.Transactions {
+			return false
+		}
+		mysqlReq, ok := req.(*MysqlPacket)
+		if !ok {
+			common.ProtocolParserLog.Warnf("[MysqlFilter] cast to MysqlPacket failed: %v\n", req)
+			return false
+		}
+		if m.TargetSqlReg != nil && !m.TargetSqlReg.MatchString(mysqlReq.Statement()) {
+			return false
+		}
+		if len(m.TargetCommands) > 0 && !slices.Contains(m.TargetCommands, mysqlReq.Command()) {
+			return false
+		}
+		if m.Schema != "" && mysqlReq.Schema() != m.Schema {
+			return false
+		}
+	}
+	if end, ok := resp.(*TransactionEnd); ok && m.FilterByResponse() {
+		if m.ErrorOnly && end.Status() != protocol.FailStatus {
+			return false
+		}
+		if end.Rows < m.MinRows {
+			return false
+		}
+	} else if m.FilterByResponse() {
+		mysqlResp, ok := resp.(*MysqlResponse)
+		if !ok {
+			common.ProtocolParserLog.Warnf("[MysqlFilter] cast to MysqlResponse failed: %v\n", resp)
+			return false
+		}
+		if m.ErrorOnly && mysqlResp.Status() != protocol.FailStatus {
+			return false
+		}
+		if mysqlResp.Rows < m.MinRows {
+			return false
+		}
+	}
 	return true
 }
 
@@ -17,11 +78,11 @@ func (m MysqlFilter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
 }
 
 func (m MysqlFilter) FilterByRequest() bool {
-	return false
+	return m.TargetSqlReg != nil || len(m.TargetCommands) > 0 || m.Schema != "" || m.Transactions
 }
 
 func (m MysqlFilter) FilterByResponse() bool {
-	return false
+	return m.ErrorOnly || m.MinRows > 0
 }
 
 func (MysqlFilter) Protocol() bpf.AgentTrafficProtocolT {
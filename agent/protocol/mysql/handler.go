@@ -141,6 +141,7 @@ func processRequestWithBasicResponse(reqPacket *MysqlPacket, stringReq bool,
 		FrameBase: respPacket.FrameBase,
 	}
 	if isOkPacket(respPacket) || isEOFPacketAll(respPacket) {
+		record.Resp.(*MysqlResponse).setServerStatus(respPacket)
 		return Success
 	}
 
@@ -165,6 +166,7 @@ func HandleResultsetResponse(reqPacket *MysqlPacket, binaryResultset bool, multi
 	record.Resp = response
 	if multiResultset && isOkPacket(firstResp) {
 		response.RespStatus = Ok
+		response.setServerStatus(firstResp)
 		return Success
 	}
 
@@ -268,6 +270,7 @@ func HandleResultsetResponse(reqPacket *MysqlPacket, binaryResultset bool, multi
 		response.Msg += ", "
 	}
 	response.Msg += fmt.Sprintf("Resultset rows = %d", len(results))
+	response.Rows += len(results)
 	if MoreResultsExist(lastPacket) {
 		return HandleResultsetResponse(reqPacket, binaryResultset, true, respView, record)
 	}
@@ -277,6 +280,7 @@ func HandleResultsetResponse(reqPacket *MysqlPacket, binaryResultset bool, multi
 
 	response.RespStatus = Ok
 	response.SetTimeStamp(lastPacket.TimestampNs())
+	response.setServerStatus(lastPacket)
 	return Success
 }
 
@@ -448,6 +452,7 @@ func (p *MysqlParser) HandleStmtExecuteRequest(req *MysqlPacket, record *Record)
 		return Invalid
 	}
 	record.Req = &(*req)
+	record.Req.(*MysqlPacket).cmd = int(kStmtExecute)
 	stmt_id, _ := common.LEndianBytesToKInt[int32]([]byte(req.msg)[kStmtIDStartOffset:], kStmtIDBytes)
 	stmt, ok := p.PreparedStatements[int(stmt_id)]
 	if !ok {
@@ -524,10 +529,15 @@ func handleOkMessage(respPackets []ParsedMessage, record *Record) ParseState {
 		common.ProtocolParserLog.Warnln("Insufficient number of bytes for an OK packet.")
 		return Invalid
 	}
+	offset := 1
+	affectedRows, _ := processLengthEncodedInt(resp.msg, &offset)
 	record.Resp = &MysqlResponse{
-		FrameBase: resp.FrameBase,
-		Msg:       "OK",
+		FrameBase:  resp.FrameBase,
+		RespStatus: Ok,
+		Msg:        "OK",
+		Rows:       int(affectedRows),
 	}
+	record.Resp.(*MysqlResponse).setServerStatus(resp)
 	if len(respPackets) > 1 {
 		common.ProtocolParserLog.Warningf("Did not expect additional packets after OK packet [num_extra_packets=%d].",
 			len(respPackets)-1)
@@ -570,8 +580,10 @@ func handleErrMessage(respPackets []ParsedMessage, record *Record) ParseState {
 			FrameBase: mysqlResp.FrameBase,
 		}
 	}
+	errorCode, _ := common.LEndianBytesToKInt[int32]([]byte(mysqlResp.msg[kErrorCodePos:]), kErrorCodeSize)
+	record.Resp.(*MysqlResponse).RespStatus = Err
 	record.Resp.(*MysqlResponse).Msg = mysqlResp.msg[kErrorMessagePos:]
-	common.LEndianBytesToKInt[int32]([]byte(mysqlResp.msg[kErrorCodePos:]), kErrorCodeSize)
+	record.Resp.(*MysqlResponse).ErrorCode = int(errorCode)
 	if len(respPackets) > 1 {
 		common.ProtocolParserLog.Warnf("Did not expect additional packets after error packet [num_extra_packets=%d].",
 			len(respPackets)-1)
@@ -6,6 +6,8 @@ import (
 	. "kyanos/agent/protocol"
 	"kyanos/bpf"
 	"kyanos/common"
+	"regexp"
+	"strings"
 )
 
 func init() {
@@ -104,8 +106,11 @@ func (m *MysqlParser) Match(reqStreams map[StreamId]*ParsedMessageQueue, respStr
 			common.ProtocolParserLog.Debugf("Didn't have enough response packets, but doesn't appear to be partial either. "+
 				"[cmd=%v, cmd_msg=%s resp_packets=%d]", command, reqPacket.msg[1:], len(respPacketsView))
 		} else if state == Success {
+			m.trackSchema(&record)
 			records = append(records, record)
-
+			if txn := m.trackTransaction(record); txn != nil {
+				records = append(records, *txn)
+			}
 		}
 
 		*reqStream = (*reqStream)[1:]
@@ -117,6 +122,25 @@ func (m *MysqlParser) Match(reqStreams map[StreamId]*ParsedMessageQueue, respStr
 	return records
 }
 
+var useStatementReg = regexp.MustCompile("(?is)^\\s*use\\s+(`[^`]+`|[^\\s;]+)\\s*;?\\s*$")
+
+// trackSchema sets the schema of the request, and follows the default
+// database when COM_INIT_DB or USE succeeds.
+func (m *MysqlParser) trackSchema(record *Record) {
+	req := record.Req.(*MysqlPacket)
+	if resp, ok := record.Resp.(*MysqlResponse); ok && resp.RespStatus != Err {
+		switch command(req.cmd) {
+		case kInitDB:
+			m.State.schema = req.msg
+		case kQuery:
+			if match := useStatementReg.FindStringSubmatch(req.msg); match != nil {
+				m.State.schema = strings.Trim(match[1], "`")
+			}
+		}
+	}
+	req.schema = m.State.schema
+}
+
 func syncRespQueue(reqPacket *MysqlPacket, respStream *ParsedMessageQueue) {
 	for len(*respStream) != 0 && (*respStream)[0].TimestampNs() < reqPacket.TimestampNs() {
 		*respStream = (*respStream)[1:]
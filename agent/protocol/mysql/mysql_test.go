@@ -0,0 +1,188 @@
+package mysql
+
+import (
+	"kyanos/agent/protocol"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	okPacket       = "\x00\x00\x00\x02\x00\x00\x00"
+	threeRowsOk    = "\x00\x03\x00\x02\x00\x00\x00"
+	duplicateEntry = "\xff\x26\x04#23000Duplicate entry '1' for key 'PRIMARY'"
+)
+
+func packet(ts uint64, seqId byte, msg string, isReq bool) *MysqlPacket {
+	return &MysqlPacket{
+		FrameBase: protocol.NewFrameBase(ts, len(msg)+kPacketHeaderLength, 0),
+		seqId:     seqId,
+		msg:       msg,
+		isReq:     isReq,
+	}
+}
+
+func matchExchanges(parser *MysqlParser, exchanges [][2]string) []protocol.Record {
+	reqs, resps := &protocol.ParsedMessageQueue{}, &protocol.ParsedMessageQueue{}
+	for i, exchange := range exchanges {
+		ts := uint64(i+1) * 10
+		*reqs = append(*reqs, packet(ts, 0, exchange[0], true))
+		*resps = append(*resps, packet(ts+5, 1, exchange[1], false))
+	}
+	return parser.Match(
+		map[protocol.StreamId]*protocol.ParsedMessageQueue{0: reqs},
+		map[protocol.StreamId]*protocol.ParsedMessageQueue{0: resps},
+	)
+}
+
+func TestMatchTracksSchema(t *testing.T) {
+	parser := &MysqlParser{State: &State{PreparedStatements: make(map[int]PreparedStatement)}}
+	records := matchExchanges(parser, [][2]string{
+		{"\x03SELECT 1", okPacket},
+		{"\x03USE `shop`", okPacket},
+		{"\x03UPDATE orders SET paid = 1", threeRowsOk},
+		{"\x02missing", "\xff\x19\x04#42000Unknown database 'missing'"},
+		{"\x03INSERT INTO orders VALUES (1)", duplicateEntry},
+		{"\x02billing", okPacket},
+		{"\x03SELECT 1", okPacket},
+	})
+
+	assert.Len(t, records, 7)
+	schemas := make([]string, len(records))
+	for i, record := range records {
+		schemas[i] = record.Req.(*MysqlPacket).Schema()
+	}
+	// a failed COM_INIT_DB keeps the default database
+	assert.Equal(t, []string{"", "shop", "shop", "shop", "shop", "billing", "billing"}, schemas)
+	assert.Equal(t, "query", records[2].Req.(*MysqlPacket).Command())
+	assert.Equal(t, "init_db", records[5].Req.(*MysqlPacket).Command())
+	assert.Equal(t, 3, records[2].Resp.(*MysqlResponse).Rows)
+	assert.Equal(t, protocol.SuccessStatus, records[2].Resp.(*MysqlResponse).Status())
+	insert := records[4].Resp.(*MysqlResponse)
+	assert.Equal(t, protocol.FailStatus, insert.Status())
+	assert.Equal(t, 1062, insert.ErrorCode)
+	assert.Equal(t, "Duplicate entry '1' for key 'PRIMARY'", insert.Msg)
+}
+
+func TestMysqlFilter(t *testing.T) {
+	parser := &MysqlParser{State: &State{PreparedStatements: make(map[int]PreparedStatement)}}
+	records := matchExchanges(parser, [][2]string{
+		{"\x03use shop;", okPacket},
+		{"\x03SELECT 1", okPacket},
+		{"\x03UPDATE orders SET paid = 1", threeRowsOk},
+		{"\x03UPDATE orders SET id = 1", duplicateEntry},
+	})
+	assert.Len(t, records, 4)
+
+	matches := func(f MysqlFilter) []bool {
+		result := make([]bool, len(records))
+		for i, record := range records {
+			result[i] = f.Filter(record.Req, record.Resp)
+		}
+		return result
+	}
+	assert.Equal(t, []bool{false, false, true, true}, matches(MysqlFilter{TargetSqlReg: regexp.MustCompile("^(?i)update ")}))
+	assert.Equal(t, []bool{false, false, false, true}, matches(MysqlFilter{TargetSqlReg: regexp.MustCompile("^(?i)update "), ErrorOnly: true}))
+	assert.Equal(t, []bool{false, false, true, false}, matches(MysqlFilter{MinRows: 1}))
+	assert.Equal(t, []bool{true, true, true, true}, matches(MysqlFilter{TargetCommands: []string{"query"}, Schema: "shop"}))
+	assert.Equal(t, []bool{false, false, false, false}, matches(MysqlFilter{TargetCommands: []string{"stmt_execute"}}))
+}
+
+func TestDigest(t *testing.T) {
+	query := &MysqlPacket{msg: "SELECT * FROM orders WHERE id IN (1, 2) AND user = 'bob'", cmd: int(kQuery), isReq: true}
+	assert.Equal(t, "select * from orders where id in (?+) and user = ?", query.Digest())
+
+	params := []StmtExecuteParam{{ColType: kLongLong, value: "7"}, {ColType: kVarString, value: "paid"}}
+	execute := &MysqlPacket{msg: CombinePrepareExecute("UPDATE orders SET status = ? WHERE id = ?", params), cmd: int(kStmtExecute), isReq: true}
+	assert.Equal(t, "update orders set status = ? where id = ?", execute.Digest())
+	assert.Equal(t, "UPDATE orders SET status = ? WHERE id = ?", execute.SqlText())
+
+	// the statement was prepared before kyanos started
+	unknown := &MysqlPacket{msg: "Execute stmt_id=3.", cmd: int(kStmtExecute), isReq: true}
+	assert.Equal(t, "stmt_execute", unknown.Digest())
+	ping := &MysqlPacket{msg: "\x0e", cmd: int(kPing), isReq: true}
+	assert.Equal(t, "ping", ping.Digest())
+}
+
+func TestMatchTransactions(t *testing.T) {
+	const (
+		inTransOk       = "\x00\x00\x00\x03\x00\x00\x00"
+		inTransTwoRows  = "\x00\x02\x00\x03\x00\x00\x00"
+		noAutocommitOk  = "\x00\x00\x00\x00\x00\x00\x00"
+		noAutocommitTxn = "\x00\x01\x00\x01\x00\x00\x00"
+		deadlock        = "\xff\xbd\x04#40001Deadlock found when trying to get lock"
+	)
+	parser := &MysqlParser{State: &State{PreparedStatements: make(map[int]PreparedStatement)}}
+	records := matchExchanges(parser, [][2]string{
+		{"\x03SELECT 1", okPacket},
+		{"\x03BEGIN", inTransOk},
+		{"\x03UPDATE orders SET paid = 1 WHERE id = 1", inTransTwoRows},
+		{"\x03INSERT INTO payments VALUES (1)", inTransOk},
+		{"\x03UPDATE orders SET paid = 1 WHERE id = 2", inTransOk},
+		{"\x03COMMIT", okPacket},
+		{"\x03SET autocommit = 0", noAutocommitOk},
+		{"\x03DELETE FROM carts WHERE id = 3", noAutocommitTxn},
+		{"\x03ROLLBACK TO SAVEPOINT a", noAutocommitTxn},
+		{"\x03ROLLBACK", noAutocommitOk},
+		{"\x03START TRANSACTION", inTransOk},
+		{"\x03UPDATE stock SET n = n - 1", deadlock},
+		{"\x03SELECT 1", okPacket},
+	})
+
+	var txns []protocol.Record
+	for _, record := range records {
+		if _, ok := record.Req.(*Transaction); ok {
+			txns = append(txns, record)
+		}
+	}
+	assert.Len(t, records, 16)
+	assert.Len(t, txns, 3)
+
+	committed, end := txns[0].Req.(*Transaction), txns[0].Resp.(*TransactionEnd)
+	assert.Equal(t, uint64(20), committed.TimestampNs())
+	assert.Equal(t, uint64(65), end.TimestampNs())
+	assert.Equal(t, 3, committed.Statements)
+	// 5ns between each response and the next request
+	assert.Equal(t, uint64(20), committed.IdleNs)
+	assert.Equal(t, []string{"update orders set paid = ? where id = ?", "insert into payments values (?+)"}, committed.Digests)
+	assert.Equal(t, TransactionCommit, end.Outcome)
+	assert.Equal(t, 2, end.Rows)
+	assert.Equal(t, protocol.SuccessStatus, end.Status())
+
+	// autocommit is off, the transaction begins with the first statement
+	rolledBack := txns[1].Req.(*Transaction)
+	assert.Equal(t, uint64(80), rolledBack.TimestampNs())
+	assert.Equal(t, 2, rolledBack.Statements)
+	assert.Equal(t, TransactionRollback, rolledBack.Outcome)
+	assert.Equal(t, protocol.FailStatus, txns[1].Resp.(*TransactionEnd).Status())
+
+	assert.Equal(t, TransactionDeadlock, txns[2].Req.(*Transaction).Outcome)
+	assert.Equal(t, "transaction (deadlock)", txns[2].Req.(*Transaction).Digest())
+
+	filter := MysqlFilter{Transactions: true, ErrorOnly: true}
+	assert.False(t, filter.Filter(records[2].Req, records[2].Resp))
+	assert.False(t, filter.Filter(txns[0].Req, txns[0].Resp))
+	assert.True(t, filter.Filter(txns[2].Req, txns[2].Resp))
+	assert.True(t, MysqlFilter{TargetSqlReg: regexp.MustCompile("^insert ")}.Filter(txns[0].Req, txns[0].Resp))
+}
+
+func TestTrackTransactionWithoutStatusFlags(t *testing.T) {
+	parser := &MysqlParser{State: &State{PreparedStatements: make(map[int]PreparedStatement)}}
+	track := func(ts uint64, statement string) *protocol.Record {
+		req := packet(ts, 0, statement, true)
+		req.cmd = int(kQuery)
+		resp := &MysqlResponse{FrameBase: protocol.NewFrameBase(ts+5, 0, 0), RespStatus: Ok}
+		return parser.trackTransaction(protocol.Record{Req: req, Resp: resp})
+	}
+	assert.Nil(t, track(10, "begin work"))
+	assert.Nil(t, track(20, "update t set a = 1"))
+	txn := track(30, "begin")
+	assert.Equal(t, TransactionImplicitCommit, txn.Req.(*Transaction).Outcome)
+	assert.Equal(t, 1, txn.Req.(*Transaction).Statements)
+	assert.Nil(t, track(40, "SELECT 1"))
+	txn = track(50, "SET @@session.autocommit = ON")
+	assert.Equal(t, TransactionCommit, txn.Req.(*Transaction).Outcome)
+	assert.Equal(t, uint64(30), txn.Req.(*Transaction).TimestampNs())
+	assert.Nil(t, track(60, "update t set a = 2"))
+}
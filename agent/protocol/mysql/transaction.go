@@ -0,0 +1,276 @@
+package mysql
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	. "kyanos/agent/protocol"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The outcomes of a transaction.
+const (
+	TransactionCommit         = "commit"
+	TransactionImplicitCommit = "implicit commit"
+	TransactionRollback       = "rollback"
+	TransactionDeadlock       = "deadlock"
+	TransactionDisconnect     = "disconnect"
+)
+
+// https://dev.mysql.com/doc/dev/mysql-server/latest/mysql__com_8h.html
+const (
+	kServerStatusInTrans    uint16 = 0x0001
+	kServerStatusAutocommit uint16 = 0x0002
+)
+
+// ER_LOCK_DEADLOCK, the server rolls back the transaction.
+const kErrLockDeadlock = 1213
+
+// The number of distinct statement digests kept for a transaction.
+const kMaxTransactionDigests = 20
+
+var _ ParsedMessage = &Transaction{}
+var _ ParsedMessage = &TransactionEnd{}
+var _ StatusfulMessage = &TransactionEnd{}
+var _ SyntheticMessage = &Transaction{}
+var _ SyntheticMessage = &TransactionEnd{}
+
+// Transaction is the request of a synthetic record spanning a transaction,
+// its timestamp is the one of BEGIN, or of the first statement when
+// autocommit is off.
+type Transaction struct {
+	FrameBase
+	// the statements between BEGIN and COMMIT or ROLLBACK
+	Statements int
+	// the time between a response and the next request of the transaction
+	IdleNs uint64
+	Schema string
+	// the distinct digests of the statements, in order
+	Digests []string
+	Outcome string
+}
+
+func (t *Transaction) FormatToString() string {
+	digests := strings.Join(t.Digests, "; ")
+	if len(t.Digests) == kMaxTransactionDigests {
+		digests += "; ..."
+	}
+	return fmt.Sprintf("base=[%s] transaction statements=[%d] idle=[%s] schema=[%s] digests=[%s]",
+		t.FrameBase.String(), t.Statements, time.Duration(t.IdleNs), t.Schema, digests)
+}
+
+func (t *Transaction) IsReq() bool {
+	return true
+}
+
+func (t *Transaction) StreamId() StreamId {
+	return 0
+}
+
+func (t *Transaction) IsSynthetic() bool {
+	return true
+}
+
+// Digest groups the transactions by outcome.
+func (t *Transaction) Digest() string {
+	return fmt.Sprintf("transaction (%s)", t.Outcome)
+}
+
+// TransactionEnd is the response of a transaction record, its timestamp is
+// the one of the response which ended the transaction.
+type TransactionEnd struct {
+	FrameBase
+	Outcome string
+	// the rows returned or affected by the statements
+	Rows int
+}
+
+func (t *TransactionEnd) FormatToString() string {
+	return fmt.Sprintf("base=[%s] outcome=[%s] rows=[%d]", t.FrameBase.String(), t.Outcome, t.Rows)
+}
+
+func (t *TransactionEnd) IsReq() bool {
+	return false
+}
+
+func (t *TransactionEnd) StreamId() StreamId {
+	return 0
+}
+
+func (t *TransactionEnd) IsSynthetic() bool {
+	return true
+}
+
+func (t *TransactionEnd) Status() ResponseStatus {
+	if t.Outcome == TransactionCommit || t.Outcome == TransactionImplicitCommit {
+		return SuccessStatus
+	}
+	return FailStatus
+}
+
+type transactionStatement int
+
+const (
+	txnNone transactionStatement = iota
+	txnStatement
+	txnBegin
+	txnCommit
+	txnRollback
+	txnAutocommitOn
+	txnAutocommitOff
+)
+
+var autocommitReg = regexp.MustCompile("(?i)^\\s*set\\s+(?:@@session\\.|@@local\\.|@@|session\\s+|local\\s+)?autocommit\\s*(?:=|:=)\\s*'?(\\w+)")
+
+// transactionStatementOf tells whether a request is a SQL statement, and
+// whether it begins or ends a transaction.
+func transactionStatementOf(req *MysqlPacket) transactionStatement {
+	statement := req.Statement()
+	switch command(req.cmd) {
+	case kQuery:
+	case kStmtExecute:
+		statement = preparedStatementOf(statement)
+	default:
+		return txnNone
+	}
+	if match := autocommitReg.FindStringSubmatch(statement); match != nil {
+		switch strings.ToLower(match[1]) {
+		case "1", "on", "true":
+			return txnAutocommitOn
+		default:
+			return txnAutocommitOff
+		}
+	}
+	words := strings.Fields(protocol.SqlDigest(statement))
+	if len(words) == 0 {
+		return txnStatement
+	}
+	switch {
+	case words[0] == "begin" && (len(words) == 1 || words[1] == "work"):
+		return txnBegin
+	case words[0] == "start" && len(words) > 1 && words[1] == "transaction":
+		return txnBegin
+	case words[0] == "commit":
+		return txnCommit
+	case words[0] == "rollback" && !slices.Contains(words, "to"):
+		// ROLLBACK TO SAVEPOINT keeps the transaction
+		return txnRollback
+	}
+	return txnStatement
+}
+
+func (s transactionStatement) outcome() string {
+	switch s {
+	case txnCommit, txnAutocommitOn:
+		return TransactionCommit
+	case txnRollback:
+		return TransactionRollback
+	default:
+		return TransactionImplicitCommit
+	}
+}
+
+func (t *Transaction) add(req *MysqlPacket, resp *MysqlResponse, end *TransactionEnd) {
+	t.Statements++
+	end.Rows += resp.Rows
+	digest := req.Digest()
+	if len(t.Digests) < kMaxTransactionDigests && !slices.Contains(t.Digests, digest) {
+		t.Digests = append(t.Digests, digest)
+	}
+}
+
+// trackTransaction follows the transaction of the connection, with the
+// status flags of the OK and EOF packets when they are present and with
+// the statements otherwise. It returns the record of the transaction once
+// the record ends it.
+func (m *MysqlParser) trackTransaction(record Record) *Record {
+	req := record.Req.(*MysqlPacket)
+	resp, ok := record.Resp.(*MysqlResponse)
+	if !ok {
+		return nil
+	}
+	state := m.State
+	defer func() {
+		state.lastRespTs = resp.TimestampNs()
+	}()
+
+	var closed *Record
+	begin := func() {
+		state.txn = &Transaction{FrameBase: NewFrameBase(req.TimestampNs(), 0, 0), Schema: req.Schema()}
+		state.txnEnd = &TransactionEnd{}
+	}
+	add := func() {
+		state.txn.add(req, resp, state.txnEnd)
+	}
+	end := func(outcome string) {
+		state.txn.Outcome = outcome
+		state.txnEnd.Outcome = outcome
+		state.txnEnd.FrameBase = NewFrameBase(resp.TimestampNs(), 0, 0)
+		closed = &Record{Req: state.txn, Resp: state.txnEnd}
+		state.txn, state.txnEnd = nil, nil
+	}
+
+	kind := transactionStatementOf(req)
+	if state.txn != nil {
+		if req.TimestampNs() > state.lastRespTs {
+			state.txn.IdleNs += req.TimestampNs() - state.lastRespTs
+		}
+		if kind == txnStatement {
+			add()
+		}
+	}
+
+	switch cmd := command(req.cmd); {
+	case cmd == kQuit || cmd == kResetConnection || cmd == kChangeUser:
+		// the server rolls back the transaction of the session
+		if state.txn != nil {
+			end(TransactionDisconnect)
+		}
+		state.autocommitOff = false
+	case resp.ErrorCode == kErrLockDeadlock:
+		if state.txn != nil {
+			end(TransactionDeadlock)
+		}
+	case resp.hasServerStatus:
+		inTrans := resp.serverStatus&kServerStatusInTrans != 0
+		state.autocommitOff = resp.serverStatus&kServerStatusAutocommit == 0
+		// BEGIN and COMMIT AND CHAIN end the transaction and begin another
+		if state.txn != nil && (!inTrans || kind == txnBegin || kind == txnCommit || kind == txnRollback) {
+			end(kind.outcome())
+		}
+		if inTrans && state.txn == nil {
+			begin()
+			if kind == txnStatement {
+				add()
+			}
+		}
+	case resp.RespStatus == Err:
+	default:
+		switch kind {
+		case txnBegin:
+			if state.txn != nil {
+				end(TransactionImplicitCommit)
+			}
+			begin()
+		case txnCommit, txnRollback:
+			if state.txn != nil {
+				end(kind.outcome())
+			}
+		case txnAutocommitOn:
+			state.autocommitOff = false
+			if state.txn != nil {
+				end(TransactionCommit)
+			}
+		case txnAutocommitOff:
+			state.autocommitOff = true
+		case txnStatement:
+			if state.txn == nil && state.autocommitOff {
+				begin()
+				add()
+			}
+		}
+	}
+	return closed
+}
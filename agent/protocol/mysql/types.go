@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"kyanos/agent/protocol"
 	. "kyanos/agent/protocol"
+	"slices"
 )
 
 const kMaxPacketLength int = (1 << 24) - 1
@@ -108,6 +109,18 @@ type MysqlResponse struct {
 	protocol.FrameBase
 	RespStatus
 	Msg string
+	// the rows of the resultsets, or the rows affected by a statement
+	// answered with an OK packet
+	Rows int
+	// the error code of an ERR packet, like 1062 for a duplicate entry
+	ErrorCode int
+	// the status flags of the closing OK or EOF packet
+	serverStatus    uint16
+	hasServerStatus bool
+}
+
+func (m *MysqlResponse) setServerStatus(packet *MysqlPacket) {
+	m.serverStatus, m.hasServerStatus = serverStatusOf(packet)
 }
 
 func (m *MysqlResponse) Status() ResponseStatus {
@@ -212,6 +225,60 @@ const (
 	kGeometrybyte   byte = 0xff
 )
 
+var commandNames = map[command]string{
+	kSleep:            "sleep",
+	kQuit:             "quit",
+	kInitDB:           "init_db",
+	kQuery:            "query",
+	kFieldList:        "field_list",
+	kCreateDB:         "create_db",
+	kDropDB:           "drop_db",
+	kRefresh:          "refresh",
+	kShutdown:         "shutdown",
+	kStatistics:       "statistics",
+	kProcessInfo:      "process_info",
+	kConnect:          "connect",
+	kProcessKill:      "process_kill",
+	kDebug:            "debug",
+	kPing:             "ping",
+	kTime:             "time",
+	kDelayedInsert:    "delayed_insert",
+	kChangeUser:       "change_user",
+	kBinlogDump:       "binlog_dump",
+	kTableDump:        "table_dump",
+	kConnectOut:       "connect_out",
+	kRegisterSlave:    "register_slave",
+	kStmtPrepare:      "stmt_prepare",
+	kStmtExecute:      "stmt_execute",
+	kStmtSendLongData: "stmt_send_long_data",
+	kStmtClose:        "stmt_close",
+	kStmtReset:        "stmt_reset",
+	kSetOption:        "set_option",
+	kStmtFetch:        "stmt_fetch",
+	kDaemon:           "daemon",
+	kBinlogDumpGTID:   "binlog_dump_gtid",
+	kResetConnection:  "reset_connection",
+}
+
+// String returns the name of the command without COM_ in lower case, like
+// query for COM_QUERY.
+func (c command) String() string {
+	if name, ok := commandNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(c))
+}
+
+// CommandNames returns the names of the commands, sorted.
+func CommandNames() []string {
+	names := make([]string, 0, len(commandNames))
+	for _, name := range commandNames {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
 func parseCommand(b byte) (command, bool) {
 	if b >= byte(kSleep) && b <= byte(kResetConnection) {
 		return command(b), true
@@ -271,6 +338,14 @@ const kPayloadLengthLength int = 3
 type State struct {
 	PreparedStatements map[int]PreparedStatement
 	active             bool
+	// the default database of the connection, changed by COM_INIT_DB and
+	// USE, empty until one of them is seen
+	schema string
+	// the open transaction, nil outside of a transaction
+	txn           *Transaction
+	txnEnd        *TransactionEnd
+	lastRespTs    uint64
+	autocommitOff bool
 }
 type ParseOptions struct {
 	dumpResponse  bool
@@ -284,10 +359,11 @@ var _ ParsedMessage = &MysqlPacket{}
 
 type MysqlPacket struct {
 	FrameBase
-	seqId byte
-	msg   string
-	cmd   int
-	isReq bool
+	seqId  byte
+	msg    string
+	cmd    int
+	isReq  bool
+	schema string
 }
 
 func (m *MysqlPacket) FormatToSummaryString() string {
@@ -306,6 +382,55 @@ func (req *MysqlPacket) StreamId() StreamId {
 	return 0
 }
 
+// Statement returns the SQL text of COM_QUERY, COM_STMT_PREPARE and
+// COM_STMT_EXECUTE requests, it is empty for other commands.
+func (m *MysqlPacket) Statement() string {
+	if !m.isReq {
+		return ""
+	}
+	switch command(m.cmd) {
+	case kQuery, kStmtPrepare, kStmtExecute:
+		return m.msg
+	default:
+		return ""
+	}
+}
+
+// SqlText returns the SQL text of a request without the parameters, that is
+// the prepared statement for COM_STMT_EXECUTE.
+func (m *MysqlPacket) SqlText() string {
+	statement := m.Statement()
+	if command(m.cmd) == kStmtExecute {
+		statement = preparedStatementOf(statement)
+	}
+	return statement
+}
+
+// Command returns the name of the command of a request, like query or
+// stmt_execute.
+func (m *MysqlPacket) Command() string {
+	if !m.isReq {
+		return ""
+	}
+	return command(m.cmd).String()
+}
+
+// Schema returns the default database of the connection when the request
+// was sent, it is empty if neither COM_INIT_DB nor USE was seen.
+func (m *MysqlPacket) Schema() string {
+	return m.schema
+}
+
+// Digest returns the fingerprint of the SQL text, that of the prepared
+// statement for COM_STMT_EXECUTE, or the command if there is no SQL text.
+func (m *MysqlPacket) Digest() string {
+	statement := m.SqlText()
+	if statement == "" {
+		return m.Command()
+	}
+	return protocol.SqlDigest(statement)
+}
+
 type MysqlRequestPacket struct {
 	MysqlPacket
 	cmd byte
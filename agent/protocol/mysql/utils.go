@@ -3,6 +3,7 @@ package mysql
 import (
 	"fmt"
 	"kyanos/common"
+	"strings"
 	"unsafe"
 )
 
@@ -72,6 +73,24 @@ func isOkPacket(packet *MysqlPacket) bool {
 	return false
 }
 
+// serverStatusOf returns the status flags of an OK or EOF packet.
+func serverStatusOf(packet *MysqlPacket) (uint16, bool) {
+	if isEOFPacket(packet, true) {
+		const kEOFPacketStatusPos int = 3
+		return uint16(packet.msg[kEOFPacketStatusPos]) | uint16(packet.msg[kEOFPacketStatusPos+1])<<8, true
+	}
+	if !isOkPacket(packet) {
+		return 0, false
+	}
+	pos := 1
+	_, ok1 := processLengthEncodedInt(packet.msg, &pos)
+	_, ok2 := processLengthEncodedInt(packet.msg, &pos)
+	if !ok1 || !ok2 || len(packet.msg) < pos+2 {
+		return 0, false
+	}
+	return uint16(packet.msg[pos]) | uint16(packet.msg[pos+1])<<8, true
+}
+
 func isStmtPrepareOKPacket(packet *MysqlPacket) bool {
 	return len(packet.msg) == 12 && packet.msg[0] == 0 && packet.msg[9] == 0
 }
@@ -218,6 +237,17 @@ func CombinePrepareExecute(stmt_prepare_request string, params []StmtExecutePara
 	return result
 }
 
+// preparedStatementOf returns the statement combined with its parameters
+// by CombinePrepareExecute, or an empty string if the statement was not
+// prepared on the connection.
+func preparedStatementOf(combined string) string {
+	end := strings.LastIndex(combined, "] params=[")
+	if !strings.HasPrefix(combined, "query=[") || end < 0 {
+		return ""
+	}
+	return combined[len("query=["):end]
+}
+
 func MoreResultsExist(packet *MysqlPacket) bool {
 	const kServerMoreResultsExistFlag int8 = 0x8
 	if isOkPacket(packet) {
@@ -0,0 +1,45 @@
+package nats
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+)
+
+type Filter struct {
+	// subjects of the requests, the wildcards * and > can be used
+	TargetSubjects []string
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	natsReq, ok := req.(*NatsMessage)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[NATSFilter] cast to NatsMessage failed: %v\n", req)
+		return false
+	}
+	if len(f.TargetSubjects) > 0 && !slices.ContainsFunc(f.TargetSubjects, func(pattern string) bool {
+		return MatchSubject(pattern, natsReq.Subject)
+	}) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolNATS
+}
+
+func (f Filter) FilterByRequest() bool {
+	return len(f.TargetSubjects) > 0
+}
+
+func (f Filter) FilterByResponse() bool {
+	return false
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolNATS
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,273 @@
+package nats
+
+import (
+	"bytes"
+	"cmp"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolNATS] = func() protocol.ProtocolStreamParser {
+		return NewNatsStreamParser()
+	}
+}
+
+func NewNatsStreamParser() *NatsStreamParser {
+	return &NatsStreamParser{}
+}
+
+// A request not answered within kMaxReplyWaitNs is dropped, the clients
+// give up on a request after a few seconds.
+const kMaxReplyWaitNs uint64 = 60 * 1000 * 1000 * 1000
+
+var crlf = []byte("\r\n")
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// parseOp returns the operation at the start of buf, and false if buf
+// doesn't start with an operation of messageType. An incomplete operation
+// name is fine when buf has no more data.
+func parseOp(buf []byte, messageType protocol.MessageType) (string, bool) {
+	end := 0
+	for end < len(buf) && !isSpace(buf[end]) && buf[end] != '\r' {
+		end++
+	}
+	name := strings.ToUpper(string(buf[:end]))
+	if end == len(buf) {
+		if len(name) == 0 {
+			return "", true
+		}
+		for op, dir := range opDirections {
+			if strings.HasPrefix(op, name) && dir.accepts(messageType) {
+				return "", true
+			}
+		}
+		return "", false
+	}
+	dir, ok := opDirections[name]
+	return name, ok && dir.accepts(messageType)
+}
+
+func (d direction) accepts(messageType protocol.MessageType) bool {
+	switch messageType {
+	case protocol.Request:
+		return d&fromClient != 0
+	case protocol.Response:
+		return d&fromServer != 0
+	}
+	return true
+}
+
+type msgArgs struct {
+	subject     string
+	sid         string
+	replyTo     string
+	headerBytes int
+	totalBytes  int
+}
+
+// parseMsgArgs parses the arguments of PUB, HPUB, MSG and HMSG:
+//
+//	PUB <subject> [reply-to] <#bytes>
+//	HPUB <subject> [reply-to] <#header bytes> <#total bytes>
+//	MSG <subject> <sid> [reply-to] <#bytes>
+//	HMSG <subject> <sid> [reply-to] <#header bytes> <#total bytes>
+func parseMsgArgs(op string, args []string) (msgArgs, bool) {
+	var result msgArgs
+	sizes := 1
+	if op == OpHPub || op == OpHMsg {
+		sizes = 2
+	}
+	required := 1 + sizes
+	if op == OpMsg || op == OpHMsg {
+		required++
+	}
+	if len(args) != required && len(args) != required+1 {
+		return result, false
+	}
+	result.subject, args = args[0], args[1:]
+	if op == OpMsg || op == OpHMsg {
+		result.sid, args = args[0], args[1:]
+	}
+	if len(args) > sizes {
+		result.replyTo, args = args[0], args[1:]
+	}
+	var err error
+	if result.totalBytes, err = strconv.Atoi(args[sizes-1]); err != nil {
+		return result, false
+	}
+	if sizes == 2 {
+		if result.headerBytes, err = strconv.Atoi(args[0]); err != nil {
+			return result, false
+		}
+	}
+	if result.headerBytes < 0 || result.headerBytes > result.totalBytes || result.totalBytes > kMaxPayloadSize {
+		return result, false
+	}
+	return result, true
+}
+
+// parseHeaders parses the header block of HPUB and HMSG, which starts with
+// the version line and an optional status.
+func parseHeaders(block []byte, message *NatsMessage) bool {
+	lines := strings.Split(string(block), "\r\n")
+	if !strings.HasPrefix(lines[0], kHeaderVersion) {
+		return false
+	}
+	if status := strings.TrimSpace(strings.TrimPrefix(lines[0], kHeaderVersion)); status != "" {
+		code, description, _ := strings.Cut(status, " ")
+		if len(code) != 3 {
+			return false
+		}
+		var err error
+		if message.StatusCode, err = strconv.Atoi(code); err != nil {
+			return false
+		}
+		message.StatusDescription = strings.TrimSpace(description)
+	}
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return false
+		}
+		if message.Headers == nil {
+			message.Headers = make(map[string][]string)
+		}
+		key = strings.TrimSpace(key)
+		message.Headers[key] = append(message.Headers[key], strings.TrimSpace(value))
+	}
+	return true
+}
+
+func (p *NatsStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	op, ok := parseOp(buf, messageType)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	maxLineLength := kMaxControlLineLength
+	if op == OpInfo || op == OpConnect {
+		maxLineLength = kMaxJsonLineLength
+	}
+	lineEnd := bytes.Index(buf[:min(len(buf), maxLineLength)], crlf)
+	if lineEnd < 0 {
+		if len(buf) >= maxLineLength {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	readBytes := lineEnd + len(crlf)
+	if op != OpPub && op != OpHPub && op != OpMsg && op != OpHMsg {
+		// the other operations answer no request
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	args, ok := parseMsgArgs(op, strings.Fields(string(buf[len(op):lineEnd])))
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	payloadStart := readBytes
+	readBytes += args.totalBytes + len(crlf)
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	if !bytes.Equal(buf[readBytes-len(crlf):readBytes], crlf) {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	isReq := op == OpPub || op == OpHPub
+	if isReq && args.replyTo == "" {
+		// a publish without a reply subject gets no response
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	message := &NatsMessage{
+		Op:      op,
+		Subject: args.subject,
+		ReplyTo: args.replyTo,
+		Sid:     args.sid,
+		Payload: bytes.Clone(buf[payloadStart+args.headerBytes : payloadStart+args.totalBytes]),
+		isReq:   isReq,
+	}
+	if args.headerBytes > 0 && !parseHeaders(buf[payloadStart:payloadStart+args.headerBytes], message) {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[NATS] failed to create FrameBase for %s %s", op, args.subject)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	message.FrameBase = fb
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// FindBoundary looks for an operation of messageType at the start of a
+// line.
+func (p *NatsStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i < len(buf); i++ {
+		if i > 0 && (i < 2 || !bytes.Equal(buf[i-2:i], crlf)) {
+			continue
+		}
+		if op, ok := parseOp(buf[i:], messageType); ok && op != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match pairs a request with the first message delivered to its reply
+// subject after it. The other messages delivered to the connection are
+// not replies, they are dropped.
+func (p *NatsStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	records := []protocol.Record{}
+	var latest uint64
+	for _, reqQueue := range reqStreams {
+		if len(*reqQueue) > 0 {
+			latest = max(latest, (*reqQueue)[len(*reqQueue)-1].TimestampNs())
+		}
+	}
+	for streamId, respQueue := range respStreams {
+		reqQueue := reqStreams[streamId]
+		for _, resp := range *respQueue {
+			latest = max(latest, resp.TimestampNs())
+			if reqQueue == nil || len(*reqQueue) == 0 || (*reqQueue)[0].TimestampNs() > resp.TimestampNs() {
+				continue
+			}
+			records = append(records, protocol.Record{
+				Req:            (*reqQueue)[0],
+				Resp:           resp,
+				ResponseStatus: resp.(*NatsMessage).Status(),
+			})
+			*reqQueue = (*reqQueue)[1:]
+		}
+		delete(respStreams, streamId)
+	}
+	for streamId, reqQueue := range reqStreams {
+		for len(*reqQueue) > 0 && (*reqQueue)[0].TimestampNs()+kMaxReplyWaitNs < latest {
+			common.ProtocolParserLog.Debugf("[NATS] no reply to %s", (*reqQueue)[0].(*NatsMessage).ReplyTo)
+			*reqQueue = (*reqQueue)[1:]
+		}
+		if len(*reqQueue) == 0 {
+			delete(reqStreams, streamId)
+		}
+	}
+	slices.SortFunc(records, func(r1, r2 protocol.Record) int {
+		return cmp.Compare(r1.Req.TimestampNs(), r2.Req.TimestampNs())
+	})
+	return records
+}
@@ -0,0 +1,134 @@
+package nats_test
+
+import (
+	"fmt"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/nats"
+	"kyanos/agent/protocol/protocoltest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(parser protocol.ProtocolStreamParser, data string, messageType protocol.MessageType, ts uint64) protocol.ParseResult {
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, []byte(data), ts)
+	return parser.ParseStream(streamBuffer, messageType)
+}
+
+// parseAll parses all messages of data and returns them in queues keyed by
+// stream id.
+func TestParsePub(t *testing.T) {
+	parser := nats.NewNatsStreamParser()
+
+	result := parse(parser, "PUB orders.create _INBOX.abc.1 5\r\nhello\r\n", protocol.Request, 10)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	assert.Equal(t, 41, result.ReadBytes)
+	req := result.ParsedMessages[0].(*nats.NatsMessage)
+	assert.Equal(t, nats.OpPub, req.Op)
+	assert.Equal(t, "orders.create", req.Subject)
+	assert.Equal(t, "_INBOX.abc.1", req.ReplyTo)
+	assert.Equal(t, "hello", string(req.Payload))
+	assert.True(t, req.IsReq())
+
+	// nothing to match without a reply subject
+	result = parse(parser, "pub orders.created 2\r\nok\r\n", protocol.Request, 10)
+	assert.Equal(t, protocol.Ignore, result.ParseState)
+	assert.Equal(t, 26, result.ReadBytes)
+
+	result = parse(parser, "PUB orders.create _INBOX.abc.1 5\r\nhel", protocol.Request, 10)
+	assert.Equal(t, protocol.NeedsMoreData, result.ParseState)
+	result = parse(parser, "PUB orders.cre", protocol.Request, 10)
+	assert.Equal(t, protocol.NeedsMoreData, result.ParseState)
+	result = parse(parser, "PUB orders.create 5\r\nhelloo\r\n", protocol.Request, 10)
+	assert.Equal(t, protocol.Invalid, result.ParseState)
+	result = parse(parser, "MSG orders.create 1 5\r\nhello\r\n", protocol.Request, 10)
+	assert.Equal(t, protocol.Invalid, result.ParseState)
+}
+
+func TestParseHMsgNoResponders(t *testing.T) {
+	parser := nats.NewNatsStreamParser()
+
+	header := "NATS/1.0 503 No Responders\r\nNats-Trace: 1\r\n\r\n"
+	result := parse(parser, fmt.Sprintf("HMSG _INBOX.abc.1 2 %d %d\r\n%s\r\n", len(header), len(header), header), protocol.Response, 20)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	resp := result.ParsedMessages[0].(*nats.NatsMessage)
+	assert.Equal(t, nats.OpHMsg, resp.Op)
+	assert.Equal(t, "2", resp.Sid)
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, "No Responders", resp.StatusDescription)
+	assert.Equal(t, []string{"1"}, resp.Headers["Nats-Trace"])
+	assert.Empty(t, resp.Payload)
+	assert.Equal(t, protocol.FailStatus, resp.Status())
+}
+
+func TestMatchReply(t *testing.T) {
+	parser := nats.NewNatsStreamParser()
+
+	reqs := protocoltest.ParseAll(t, parser, "CONNECT {\"verbose\":false}\r\nPING\r\n"+
+		"SUB _INBOX.abc.* 1\r\n"+
+		"PUB orders.create _INBOX.abc.1 2\r\n{}\r\n"+
+		"PUB orders.get _INBOX.abc.2 2\r\n42\r\n"+
+		"PUB orders.created 2\r\n{}\r\n", protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, "INFO {\"server_id\":\"x\"}\r\nPONG\r\n"+
+		"MSG orders.created 3 2\r\n{}\r\n"+
+		"MSG _INBOX.abc.2 1 3\r\n{1}\r\n"+
+		"+OK\r\n"+
+		"MSG _INBOX.abc.1 1 2\r\nok\r\n", protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 2)
+	for _, record := range records {
+		req := record.Req.(*nats.NatsMessage)
+		assert.Equal(t, req.ReplyTo, record.Resp.(*nats.NatsMessage).Subject)
+	}
+	assert.Empty(t, reqs)
+	assert.Empty(t, resps)
+}
+
+func TestMatchDropsExpiredRequests(t *testing.T) {
+	parser := nats.NewNatsStreamParser()
+
+	reqs := protocoltest.ParseAll(t, parser, "PUB svc.a _INBOX.1 0\r\n\r\n", protocol.Request, 1)
+	later := protocoltest.ParseAll(t, parser, "PUB svc.b _INBOX.2 0\r\n\r\n", protocol.Request, 120*1000*1000*1000)
+	for streamId, queue := range later {
+		reqs[streamId] = queue
+	}
+	resps := protocoltest.ParseAll(t, parser, "MSG _INBOX.1 1 0\r\n\r\n", protocol.Response, 120*1000*1000*1000+1)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "svc.a", records[0].Req.(*nats.NatsMessage).Subject)
+	assert.Len(t, reqs, 1)
+
+	records = parser.Match(reqs, protocoltest.ParseAll(t, parser, "MSG orders 1 0\r\n\r\n", protocol.Response, 240*1000*1000*1000))
+	assert.Empty(t, records)
+	assert.Empty(t, reqs)
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := nats.NewNatsStreamParser()
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, []byte("llo\r\nMSG a 1 2\r\nhi\r\n"), 1)
+	assert.Equal(t, 5, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+	assert.Equal(t, -1, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+}
+
+func TestMatchSubject(t *testing.T) {
+	assert.True(t, nats.MatchSubject("orders.create", "orders.create"))
+	assert.True(t, nats.MatchSubject("orders.*", "orders.create"))
+	assert.False(t, nats.MatchSubject("orders.*", "orders.create.v2"))
+	assert.True(t, nats.MatchSubject("orders.>", "orders.create.v2"))
+	assert.False(t, nats.MatchSubject("orders.>", "orders"))
+	assert.False(t, nats.MatchSubject("orders", "orders.create"))
+}
+
+func TestFilter(t *testing.T) {
+	req := parse(nats.NewNatsStreamParser(), "PUB orders.create _INBOX.1 0\r\n\r\n", protocol.Request, 1).ParsedMessages[0]
+
+	assert.True(t, nats.Filter{}.Filter(req, nil))
+	assert.False(t, nats.Filter{}.FilterByRequest())
+	assert.True(t, nats.Filter{TargetSubjects: []string{"users.*", "orders.*"}}.Filter(req, nil))
+	assert.False(t, nats.Filter{TargetSubjects: []string{"orders"}}.Filter(req, nil))
+}
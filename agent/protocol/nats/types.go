@@ -0,0 +1,156 @@
+package nats
+
+import (
+	"fmt"
+	"hash/fnv"
+	"kyanos/agent/protocol"
+	"sort"
+	"strings"
+)
+
+// See https://docs.nats.io/reference/reference-protocols/nats-protocol.
+
+const (
+	OpConnect = "CONNECT"
+	OpPub     = "PUB"
+	OpHPub    = "HPUB"
+	OpSub     = "SUB"
+	OpUnsub   = "UNSUB"
+	OpInfo    = "INFO"
+	OpMsg     = "MSG"
+	OpHMsg    = "HMSG"
+	OpOk      = "+OK"
+	OpErr     = "-ERR"
+	OpPing    = "PING"
+	OpPong    = "PONG"
+)
+
+type direction int
+
+const (
+	fromClient direction = 1 << iota
+	fromServer
+)
+
+var opDirections = map[string]direction{
+	OpConnect: fromClient,
+	OpPub:     fromClient,
+	OpHPub:    fromClient,
+	OpSub:     fromClient,
+	OpUnsub:   fromClient,
+	OpInfo:    fromServer,
+	OpMsg:     fromServer,
+	OpHMsg:    fromServer,
+	OpOk:      fromServer,
+	OpErr:     fromServer,
+	OpPing:    fromClient | fromServer,
+	OpPong:    fromClient | fromServer,
+}
+
+// The default max_control_line of nats-server, it doesn't apply to the JSON
+// of INFO and CONNECT which grows with the routes of a cluster.
+const kMaxControlLineLength = 4096
+const kMaxJsonLineLength = 1 << 20
+
+// max_payload defaults to 1MB and nats-server warns above 8MB, leave some
+// room for servers configured with a larger one.
+const kMaxPayloadSize = 64 << 20
+
+const kHeaderVersion = "NATS/1.0"
+
+var _ protocol.ParsedMessage = &NatsMessage{}
+var _ protocol.StatusfulMessage = &NatsMessage{}
+
+// NatsMessage is a PUB or HPUB sent by a client with a reply subject, or a
+// MSG or HMSG delivered by the server.
+type NatsMessage struct {
+	protocol.FrameBase
+	Op      string
+	Subject string
+	// the subject the reply to a request is sent to, an _INBOX subject for
+	// the request-reply of the clients
+	ReplyTo string
+	// subscription id of MSG and HMSG
+	Sid     string
+	Headers map[string][]string
+	// from the header version line, like "NATS/1.0 503 No Responders"
+	StatusCode        int
+	StatusDescription string
+	Payload           []byte
+	isReq             bool
+}
+
+func (m *NatsMessage) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] op=[%s] subject=[%s]", m.FrameBase.String(), m.Op, m.Subject)
+	if m.Sid != "" {
+		fmt.Fprintf(&b, " sid=[%s]", m.Sid)
+	}
+	if m.ReplyTo != "" {
+		fmt.Fprintf(&b, " reply=[%s]", m.ReplyTo)
+	}
+	if m.StatusCode != 0 {
+		fmt.Fprintf(&b, " status=[%d %s]", m.StatusCode, m.StatusDescription)
+	}
+	if len(m.Headers) > 0 {
+		keys := make([]string, 0, len(m.Headers))
+		for key := range m.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		headers := make([]string, 0, len(keys))
+		for _, key := range keys {
+			headers = append(headers, key+": "+strings.Join(m.Headers[key], ", "))
+		}
+		fmt.Fprintf(&b, " headers=[%s]", strings.Join(headers, "; "))
+	}
+	fmt.Fprintf(&b, " payload=[%s]", m.Payload)
+	return b.String()
+}
+
+func (m *NatsMessage) IsReq() bool {
+	return m.isReq
+}
+
+// StreamId of a request is its reply subject and that of a delivered
+// message is its subject, so a reply is in the stream of its request.
+func (m *NatsMessage) StreamId() protocol.StreamId {
+	if m.isReq {
+		return subjectStreamId(m.ReplyTo)
+	}
+	return subjectStreamId(m.Subject)
+}
+
+func (m *NatsMessage) Status() protocol.ResponseStatus {
+	if m.StatusCode >= 500 {
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+func subjectStreamId(subject string) protocol.StreamId {
+	h := fnv.New64a()
+	h.Write([]byte(subject))
+	return protocol.StreamId(h.Sum64())
+}
+
+var _ protocol.ProtocolStreamParser = &NatsStreamParser{}
+
+type NatsStreamParser struct {
+}
+
+// MatchSubject reports whether subject matches pattern, in which * matches
+// a single token and a trailing > matches one or more tokens.
+func MatchSubject(pattern string, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+	for i, token := range patternTokens {
+		if token == ">" && i == len(patternTokens)-1 {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
@@ -0,0 +1,55 @@
+package postgresql
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"regexp"
+)
+
+type Filter struct {
+	TargetSqlReg *regexp.Regexp
+	ErrorOnly    bool
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	if f.TargetSqlReg != nil {
+		pgReq, ok := req.(*Request)
+		if !ok {
+			common.ProtocolParserLog.Warnf("[PostgreSQLFilter] cast to Request failed: %v\n", req)
+			return false
+		}
+		if !f.TargetSqlReg.MatchString(pgReq.Query) {
+			return false
+		}
+	}
+	if f.ErrorOnly {
+		pgResp, ok := resp.(*Response)
+		if !ok {
+			common.ProtocolParserLog.Warnf("[PostgreSQLFilter] cast to Response failed: %v\n", resp)
+			return false
+		}
+		if pgResp.Error == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolPGSQL
+}
+
+func (f Filter) FilterByRequest() bool {
+	return f.TargetSqlReg != nil
+}
+
+func (f Filter) FilterByResponse() bool {
+	return f.ErrorOnly
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolPGSQL
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,508 @@
+package postgresql
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"strings"
+)
+
+var errNegativeCount = errors.New("negative count")
+var errInvalidLength = errors.New("invalid length")
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolPGSQL] = func() protocol.ProtocolStreamParser {
+		return NewPostgreSQLStreamParser()
+	}
+}
+
+func NewPostgreSQLStreamParser() *PostgreSQLStreamParser {
+	return &PostgreSQLStreamParser{
+		State: &State{
+			PreparedStatements: make(map[string]string),
+			Portals:            make(map[string]string),
+		},
+	}
+}
+
+func (p *PostgreSQLStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	if len(buf) == 0 {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+
+	// The server answers SSLRequest/GSSENCRequest with a single untagged byte.
+	if messageType == protocol.Response && p.encryptionRequested && (buf[0] == 'S' || buf[0] == 'N' || buf[0] == 'G') {
+		p.encryptionRequested = false
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: 1}
+	}
+
+	if messageType != protocol.Response && isStartupPacket(buf) {
+		return p.parseStartupPacket(streamBuffer, buf)
+	}
+
+	if len(buf) < kTagAndLengthSize {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	tag := buf[0]
+	if messageType == protocol.Unknown {
+		messageType = guessMessageType(tag)
+	}
+	if !isValidTag(tag, messageType) {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	length := int32(binary.BigEndian.Uint32(buf[1:kTagAndLengthSize]))
+	if length < 4 || length > kMaxMessageLength {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	readBytes := int(length) + 1
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[PostgreSQL] failed to create FrameBase for tag=%c", tag)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	message := &RegularMessage{
+		FrameBase: fb,
+		Tag:       tag,
+		Payload:   string(buf[kTagAndLengthSize:readBytes]),
+		isReq:     messageType == protocol.Request,
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// isStartupPacket checks whether buf starts with an untagged startup packet:
+// length(4 bytes) + protocol version or request code(4 bytes).
+func isStartupPacket(buf []byte) bool {
+	if len(buf) < 8 {
+		return false
+	}
+	length := int32(binary.BigEndian.Uint32(buf[0:4]))
+	if length < 8 || length > kMaxStartupPacketLength {
+		return false
+	}
+	code := int32(binary.BigEndian.Uint32(buf[4:8]))
+	return code == kProtocolVersion3 || code == kSSLRequest || code == kCancelRequest || code == kGSSENCRequest
+}
+
+func (p *PostgreSQLStreamParser) parseStartupPacket(streamBuffer *buffer.StreamBuffer, buf []byte) protocol.ParseResult {
+	length := int(binary.BigEndian.Uint32(buf[0:4]))
+	if len(buf) < length {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	code := int32(binary.BigEndian.Uint32(buf[4:8]))
+	switch code {
+	case kSSLRequest, kGSSENCRequest:
+		p.encryptionRequested = true
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: length}
+	case kCancelRequest:
+		// CancelRequest is sent on a new connection and never answered.
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: length}
+	}
+
+	fb, ok := protocol.CreateFrameBase(streamBuffer, length)
+	if !ok {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: length}
+	}
+	message := &RegularMessage{
+		FrameBase: fb,
+		Tag:       kStartup,
+		Payload:   string(buf[8:length]),
+		isReq:     true,
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      length,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// FindBoundary looks for a valid tag whose message is either the last one in
+// the buffer or followed by another valid tag.
+func (p *PostgreSQLStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	if startPos == 0 && messageType == protocol.Request && isStartupPacket(buf) {
+		return 0
+	}
+	for i := startPos; i+kTagAndLengthSize <= len(buf); i++ {
+		tagMessageType := messageType
+		if tagMessageType == protocol.Unknown {
+			tagMessageType = guessMessageType(buf[i])
+		}
+		if !isValidTag(buf[i], tagMessageType) {
+			continue
+		}
+		length := int32(binary.BigEndian.Uint32(buf[i+1 : i+kTagAndLengthSize]))
+		if length < 4 || length > kMaxMessageLength {
+			continue
+		}
+		next := i + 1 + int(length)
+		if next == len(buf) || (next < len(buf) && isValidTag(buf[next], tagMessageType)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match pairs requests and responses in order. Each simple query, startup
+// message and Sync terminated extended query batch is answered with exactly
+// one ReadyForQuery, so pipelined batches are paired by their position.
+func (p *PostgreSQLStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	reqStream, ok1 := reqStreams[0]
+	respStream, ok2 := respStreams[0]
+	if !ok1 || !ok2 {
+		return []protocol.Record{}
+	}
+	records := []protocol.Record{}
+	for {
+		// Terminate is never answered.
+		for len(*reqStream) > 0 && (*reqStream)[0].(*RegularMessage).Tag == kTerminate {
+			*reqStream = (*reqStream)[1:]
+		}
+
+		respEnd := findReadyForQuery(*respStream)
+		if respEnd == -1 {
+			break
+		}
+		readyTs := (*respStream)[respEnd].TimestampNs()
+		if len(*reqStream) == 0 || (*reqStream)[0].TimestampNs() > readyTs {
+			common.ProtocolParserLog.Debugf("[PostgreSQL] no request found for response batch, ready_ts=%d", readyTs)
+			*respStream = (*respStream)[respEnd+1:]
+			continue
+		}
+		reqEnd := findRequestEnd(*reqStream, readyTs)
+		if reqEnd == -1 {
+			break
+		}
+
+		req := p.buildRequest((*reqStream)[:reqEnd+1])
+		resp := buildResponse((*respStream)[:respEnd+1])
+		records = append(records, protocol.Record{
+			Req:            req,
+			Resp:           resp,
+			ResponseStatus: resp.Status(),
+		})
+		*reqStream = (*reqStream)[reqEnd+1:]
+		*respStream = (*respStream)[respEnd+1:]
+	}
+	return records
+}
+
+func findReadyForQuery(stream protocol.ParsedMessageQueue) int {
+	for i, msg := range stream {
+		if msg.(*RegularMessage).Tag == kReadyForQuery {
+			return i
+		}
+	}
+	return -1
+}
+
+// findRequestEnd returns the index of the last message of the request which
+// is answered by the ReadyForQuery at readyTs, or -1 if it is not complete yet.
+func findRequestEnd(stream protocol.ParsedMessageQueue, readyTs uint64) int {
+	head := stream[0].(*RegularMessage)
+	switch head.Tag {
+	case kStartup, kQuery, kFunctionCall:
+		// Password and COPY messages are sent after the head message but
+		// before the server finishes with ReadyForQuery.
+		end := 0
+		for end+1 < len(stream) {
+			next := stream[end+1].(*RegularMessage)
+			if next.TimestampNs() > readyTs {
+				break
+			}
+			if next.Tag != kPassword && next.Tag != kCopyData && next.Tag != kCopyDone && next.Tag != kCopyFail {
+				break
+			}
+			end++
+		}
+		return end
+	default:
+		for i, msg := range stream {
+			if msg.TimestampNs() > readyTs {
+				// Requests after the ReadyForQuery are already seen, the Sync
+				// of this batch must have been lost.
+				return i - 1
+			}
+			if msg.(*RegularMessage).Tag == kSync {
+				return i
+			}
+		}
+		return -1
+	}
+}
+
+func (p *PostgreSQLStreamParser) buildRequest(messages protocol.ParsedMessageQueue) *Request {
+	first := messages[0].(*RegularMessage)
+	req := &Request{
+		FrameBase: first.FrameBase,
+		Tags:      make([]byte, 0, len(messages)),
+	}
+	queries := []string{}
+	for idx, each := range messages {
+		msg := each.(*RegularMessage)
+		if idx > 0 {
+			req.IncrByteSize(msg.ByteSize())
+		}
+		if msg.Tag != kStartup {
+			req.Tags = append(req.Tags, msg.Tag)
+		}
+		decoder := protocol.NewBinaryDecoder([]byte(msg.Payload))
+		switch msg.Tag {
+		case kStartup:
+			req.Kind = KindStartup
+			req.StartupParams = parseStartupParams(decoder)
+		case kQuery:
+			req.Kind = KindQuery
+			query, _ := decoder.ExtractStringUntil("\x00")
+			queries = append(queries, query)
+		case kFunctionCall:
+			req.Kind = KindFunctionCall
+		case kParse:
+			req.Kind = KindExtendedQuery
+			name, err1 := decoder.ExtractStringUntil("\x00")
+			query, err2 := decoder.ExtractStringUntil("\x00")
+			if err1 == nil && err2 == nil {
+				p.PreparedStatements[name] = query
+			}
+		case kBind:
+			req.Kind = KindExtendedQuery
+			portal, stmt, params, err := parseBind(decoder)
+			if err != nil {
+				common.ProtocolParserLog.Debugf("[PostgreSQL] failed to parse Bind: %v", err)
+				continue
+			}
+			p.Portals[portal] = stmt
+			req.Statement = stmt
+			req.Params = params
+		case kExecute:
+			req.Kind = KindExtendedQuery
+			portal, err := decoder.ExtractStringUntil("\x00")
+			if err != nil {
+				continue
+			}
+			stmt := p.Portals[portal]
+			req.Statement = stmt
+			if query, ok := p.PreparedStatements[stmt]; ok {
+				queries = append(queries, query)
+			}
+		case kClose:
+			req.Kind = KindExtendedQuery
+			kind, err := decoder.ExtractByte()
+			if err != nil {
+				continue
+			}
+			name, err := decoder.ExtractStringUntil("\x00")
+			if err != nil {
+				continue
+			}
+			if kind == 'S' {
+				delete(p.PreparedStatements, name)
+			} else {
+				delete(p.Portals, name)
+			}
+		default:
+			if req.Kind == "" {
+				req.Kind = KindExtendedQuery
+			}
+		}
+	}
+	// A batch only containing Parse (like PREPARE), show the prepared SQL.
+	if len(queries) == 0 && req.Kind == KindExtendedQuery {
+		for _, each := range messages {
+			msg := each.(*RegularMessage)
+			if msg.Tag == kParse {
+				decoder := protocol.NewBinaryDecoder([]byte(msg.Payload))
+				name, _ := decoder.ExtractStringUntil("\x00")
+				query, _ := decoder.ExtractStringUntil("\x00")
+				req.Statement = name
+				queries = append(queries, query)
+			}
+		}
+	}
+	req.Query = strings.Join(queries, "; ")
+	return req
+}
+
+func parseStartupParams(decoder *protocol.BinaryDecoder) map[string]string {
+	params := make(map[string]string)
+	for decoder.RemainingBytes() > 0 {
+		key, err := decoder.ExtractStringUntil("\x00")
+		if err != nil || key == "" {
+			break
+		}
+		value, err := decoder.ExtractStringUntil("\x00")
+		if err != nil {
+			break
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// Bind: portal(string) statement(string) int16 format codes, int16 params
+// count, each param is int32 length(-1 means NULL) followed by the value.
+func parseBind(decoder *protocol.BinaryDecoder) (string, string, []string, error) {
+	portal, err := decoder.ExtractStringUntil("\x00")
+	if err != nil {
+		return "", "", nil, err
+	}
+	stmt, err := decoder.ExtractStringUntil("\x00")
+	if err != nil {
+		return "", "", nil, err
+	}
+	numFormatCodes, err := protocol.ExtractBEInt[int16](decoder)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if numFormatCodes < 0 {
+		return "", "", nil, errNegativeCount
+	}
+	formatCodes := make([]int16, 0, numFormatCodes)
+	for i := 0; i < int(numFormatCodes); i++ {
+		code, err := protocol.ExtractBEInt[int16](decoder)
+		if err != nil {
+			return "", "", nil, err
+		}
+		formatCodes = append(formatCodes, code)
+	}
+	numParams, err := protocol.ExtractBEInt[int16](decoder)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if numParams < 0 {
+		return "", "", nil, errNegativeCount
+	}
+	params := make([]string, 0, numParams)
+	for i := 0; i < int(numParams); i++ {
+		length, err := protocol.ExtractBEInt[int32](decoder)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if length == -1 {
+			params = append(params, "NULL")
+			continue
+		}
+		if length < 0 {
+			return "", "", nil, errInvalidLength
+		}
+		value, err := decoder.ExtractString(int(length))
+		if err != nil {
+			return "", "", nil, err
+		}
+		var format int16
+		if len(formatCodes) == 1 {
+			format = formatCodes[0]
+		} else if i < len(formatCodes) {
+			format = formatCodes[i]
+		}
+		if format == 0 {
+			params = append(params, value)
+		} else {
+			params = append(params, "0x"+hex.EncodeToString([]byte(value)))
+		}
+	}
+	return portal, stmt, params, nil
+}
+
+func buildResponse(messages protocol.ParsedMessageQueue) *Response {
+	first := messages[0].(*RegularMessage)
+	resp := &Response{
+		FrameBase: first.FrameBase,
+		Tags:      make([]byte, 0, len(messages)),
+	}
+	for idx, each := range messages {
+		msg := each.(*RegularMessage)
+		if idx > 0 {
+			resp.IncrByteSize(msg.ByteSize())
+		}
+		if msg.Tag != kDataRow || resp.Rows == 0 {
+			resp.Tags = append(resp.Tags, msg.Tag)
+		}
+		decoder := protocol.NewBinaryDecoder([]byte(msg.Payload))
+		switch msg.Tag {
+		case kCommandComplete:
+			tag, _ := decoder.ExtractStringUntil("\x00")
+			resp.CommandTags = append(resp.CommandTags, tag)
+		case kDataRow:
+			resp.Rows++
+		case kRowDescription:
+			columns, err := parseRowDescription(decoder)
+			if err != nil {
+				common.ProtocolParserLog.Debugf("[PostgreSQL] failed to parse RowDescription: %v", err)
+				continue
+			}
+			resp.Columns = columns
+		case kErrorResponse:
+			resp.Error = parseErrorFields(decoder)
+		case kNoticeResponse:
+			resp.Notices = append(resp.Notices, parseErrorFields(decoder).String())
+		}
+	}
+	return resp
+}
+
+// RowDescription: int16 fields count, each field is name(string) followed by
+// table oid(4) column attr(2) type oid(4) type size(2) type modifier(4) format(2).
+func parseRowDescription(decoder *protocol.BinaryDecoder) ([]string, error) {
+	numFields, err := protocol.ExtractBEInt[int16](decoder)
+	if err != nil {
+		return nil, err
+	}
+	if numFields < 0 {
+		return nil, errNegativeCount
+	}
+	columns := make([]string, 0, numFields)
+	for i := 0; i < int(numFields); i++ {
+		name, err := decoder.ExtractStringUntil("\x00")
+		if err != nil {
+			break
+		}
+		columns = append(columns, name)
+		if _, err := decoder.ExtractString(18); err != nil {
+			break
+		}
+	}
+	return columns, nil
+}
+
+// ErrorResponse and NoticeResponse: a list of type(1 byte) + value(string),
+// terminated by a zero byte.
+func parseErrorFields(decoder *protocol.BinaryDecoder) *ErrorInfo {
+	info := &ErrorInfo{}
+	for {
+		fieldType, err := decoder.ExtractByte()
+		if err != nil || fieldType == 0 {
+			break
+		}
+		value, err := decoder.ExtractStringUntil("\x00")
+		if err != nil {
+			break
+		}
+		switch fieldType {
+		case 'S':
+			info.Severity = value
+		case 'V':
+			// Non-localized severity, preferred over 'S'.
+			info.Severity = value
+		case 'C':
+			info.Code = value
+		case 'M':
+			info.Message = value
+		case 'D':
+			info.Detail = value
+		}
+	}
+	return info
+}
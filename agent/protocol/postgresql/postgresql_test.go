@@ -0,0 +1,236 @@
+package postgresql_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/postgresql"
+	"kyanos/agent/protocol/protocoltest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func regularMessage(tag byte, payload ...[]byte) []byte {
+	body := []byte{}
+	for _, each := range payload {
+		body = append(body, each...)
+	}
+	msg := []byte{tag, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(body)+4))
+	return append(msg, body...)
+}
+
+func cstring(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func int16Bytes(v int16) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(v))
+}
+
+func int32Bytes(v int32) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(v))
+}
+
+func startupMessage(params ...string) []byte {
+	body := int32Bytes(196608)
+	for _, p := range params {
+		body = append(body, cstring(p)...)
+	}
+	body = append(body, 0)
+	return append(int32Bytes(int32(len(body)+4)), body...)
+}
+
+var (
+	kQueryMessage     = regularMessage('Q', cstring("select 1"))
+	kRowDescription   = regularMessage('T', int16Bytes(1), cstring("?column?"), make([]byte, 18))
+	kDataRow          = regularMessage('D', int16Bytes(1), int32Bytes(1), []byte("1"))
+	kSelectComplete   = regularMessage('C', cstring("SELECT 1"))
+	kReadyForQuery    = regularMessage('Z', []byte("I"))
+	kParseMessage     = regularMessage('P', cstring("stmt1"), cstring("select * from t where id = $1"), int16Bytes(0))
+	kBindMessage      = regularMessage('B', cstring(""), cstring("stmt1"), int16Bytes(0), int16Bytes(1), int32Bytes(2), []byte("42"), int16Bytes(0))
+	kExecuteMessage   = regularMessage('E', cstring(""), int32Bytes(0))
+	kSyncMessage      = regularMessage('S')
+	kParseComplete    = regularMessage('1')
+	kBindComplete     = regularMessage('2')
+	kErrorResponse    = regularMessage('E', cstring("SERROR"), cstring("VERROR"), cstring("C42P01"), cstring("Mrelation \"t\" does not exist"), []byte{0})
+	kAuthenticationOk = regularMessage('R', int32Bytes(0))
+)
+
+func concat(messages ...[]byte) []byte {
+	result := []byte{}
+	for _, each := range messages {
+		result = append(result, each...)
+	}
+	return result
+}
+
+func TestParseRegularMessage(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	streamBuffer := buffer.New(10000)
+	streamBuffer.Add(1, kQueryMessage, 10)
+	result := parser.ParseStream(streamBuffer, protocol.Request)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	assert.Equal(t, len(kQueryMessage), result.ReadBytes)
+	msg := result.ParsedMessages[0].(*postgresql.RegularMessage)
+	assert.Equal(t, byte('Q'), msg.Tag)
+	assert.Equal(t, "select 1\x00", msg.Payload)
+	assert.True(t, msg.IsReq())
+}
+
+func TestParseNeedsMoreData(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	streamBuffer := buffer.New(10000)
+	streamBuffer.Add(1, kQueryMessage[:len(kQueryMessage)-2], 10)
+	result := parser.ParseStream(streamBuffer, protocol.Request)
+	assert.Equal(t, protocol.NeedsMoreData, result.ParseState)
+}
+
+func TestParseInvalidTag(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	streamBuffer := buffer.New(10000)
+	streamBuffer.Add(1, regularMessage('z', cstring("abc")), 10)
+	result := parser.ParseStream(streamBuffer, protocol.Request)
+	assert.Equal(t, protocol.Invalid, result.ParseState)
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	streamBuffer := buffer.New(10000)
+	data := concat([]byte{0x01, 0x02, 0x03}, kQueryMessage, kQueryMessage)
+	streamBuffer.Add(1, data, 10)
+	assert.Equal(t, 3, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+}
+
+func TestMatchSimpleQuery(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, kQueryMessage, protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, concat(kRowDescription, kDataRow, kSelectComplete, kReadyForQuery), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Equal(t, 1, len(records))
+	req := records[0].Req.(*postgresql.Request)
+	resp := records[0].Resp.(*postgresql.Response)
+	assert.Equal(t, postgresql.KindQuery, req.Kind)
+	assert.Equal(t, "select 1", req.Query)
+	assert.Equal(t, len(kQueryMessage), req.ByteSize())
+	assert.Equal(t, []string{"SELECT 1"}, resp.CommandTags)
+	assert.Equal(t, []string{"?column?"}, resp.Columns)
+	assert.Equal(t, 1, resp.Rows)
+	assert.Equal(t, protocol.SuccessStatus, resp.Status())
+	assert.Empty(t, *reqs[0])
+	assert.Empty(t, *resps[0])
+}
+
+func TestMatchPipelinedExtendedQuery(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	batch1 := concat(kParseMessage, kBindMessage, kExecuteMessage, kSyncMessage)
+	// the second batch reuses the prepared statement without Parse
+	batch2 := concat(kBindMessage, kExecuteMessage, kSyncMessage)
+	reqs := protocoltest.ParseAll(t, parser, concat(batch1, batch2), protocol.Request, 10)
+	respBatch1 := concat(kParseComplete, kBindComplete, kDataRow, kSelectComplete, kReadyForQuery)
+	respBatch2 := concat(kBindComplete, kErrorResponse, kReadyForQuery)
+	resps := protocoltest.ParseAll(t, parser, concat(respBatch1, respBatch2), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Equal(t, 2, len(records))
+
+	req1 := records[0].Req.(*postgresql.Request)
+	assert.Equal(t, postgresql.KindExtendedQuery, req1.Kind)
+	assert.Equal(t, "select * from t where id = $1", req1.Query)
+	assert.Equal(t, "stmt1", req1.Statement)
+	assert.Equal(t, []string{"42"}, req1.Params)
+	assert.Equal(t, len(batch1), req1.ByteSize())
+	assert.Equal(t, protocol.SuccessStatus, records[0].ResponseStatus)
+	assert.Equal(t, len(respBatch1), records[0].Resp.ByteSize())
+
+	req2 := records[1].Req.(*postgresql.Request)
+	assert.Equal(t, "select * from t where id = $1", req2.Query)
+	resp2 := records[1].Resp.(*postgresql.Response)
+	assert.Equal(t, protocol.FailStatus, records[1].ResponseStatus)
+	assert.Equal(t, "42P01", resp2.Error.Code)
+	assert.Equal(t, "relation \"t\" does not exist", resp2.Error.Message)
+}
+
+func TestMatchMalformedBindAndRowDescription(t *testing.T) {
+	tests := []struct {
+		name string
+		bind []byte
+	}{
+		{"negative format codes", regularMessage('B', cstring(""), cstring("stmt1"), int16Bytes(-1))},
+		{"negative params", regularMessage('B', cstring(""), cstring("stmt1"), int16Bytes(0), int16Bytes(-2))},
+		{"negative param length", regularMessage('B', cstring(""), cstring("stmt1"), int16Bytes(0), int16Bytes(1), int32Bytes(-2))},
+	}
+	rowDescription := regularMessage('T', int16Bytes(-1))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := postgresql.NewPostgreSQLStreamParser()
+			reqs := protocoltest.ParseAll(t, parser, concat(tt.bind, kExecuteMessage, kSyncMessage), protocol.Request, 10)
+			resps := protocoltest.ParseAll(t, parser, concat(kBindComplete, rowDescription, kDataRow, kSelectComplete, kReadyForQuery), protocol.Response, 20)
+
+			var records []protocol.Record
+			assert.NotPanics(t, func() {
+				records = parser.Match(reqs, resps)
+			})
+			assert.Equal(t, 1, len(records))
+			assert.Empty(t, records[0].Req.(*postgresql.Request).Params)
+			assert.Empty(t, records[0].Resp.(*postgresql.Response).Columns)
+			assert.Equal(t, 1, records[0].Resp.(*postgresql.Response).Rows)
+		})
+	}
+}
+
+func TestMatchWaitsForSync(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, concat(kParseMessage, kBindMessage), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, concat(kParseComplete, kBindComplete), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Equal(t, 0, len(records))
+	assert.Len(t, *reqs[0], 2)
+	assert.Len(t, *resps[0], 2)
+}
+
+func TestMatchStartup(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, startupMessage("user", "postgres", "database", "test"), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, concat(kAuthenticationOk, kReadyForQuery), protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Equal(t, 1, len(records))
+	req := records[0].Req.(*postgresql.Request)
+	assert.Equal(t, postgresql.KindStartup, req.Kind)
+	assert.Equal(t, map[string]string{"user": "postgres", "database": "test"}, req.StartupParams)
+}
+
+func TestSSLRequestIgnored(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	sslRequest := concat(int32Bytes(8), int32Bytes(80877103))
+	reqs := protocoltest.ParseAll(t, parser, sslRequest, protocol.Request, 10)
+	assert.Empty(t, reqs)
+	resps := protocoltest.ParseAll(t, parser, concat([]byte("N"), kAuthenticationOk), protocol.Response, 20)
+	assert.Len(t, *resps[0], 1)
+}
+
+func TestFilter(t *testing.T) {
+	parser := postgresql.NewPostgreSQLStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, concat(kQueryMessage, kQueryMessage), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, concat(kSelectComplete, kReadyForQuery, kErrorResponse, kReadyForQuery), protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+	assert.Equal(t, 2, len(records))
+
+	filter := postgresql.Filter{TargetSqlReg: regexp.MustCompile("^select")}
+	assert.True(t, filter.FilterByRequest())
+	assert.False(t, filter.FilterByResponse())
+	assert.True(t, filter.Filter(records[0].Req, nil))
+	filter = postgresql.Filter{TargetSqlReg: regexp.MustCompile("^update")}
+	assert.False(t, filter.Filter(records[0].Req, nil))
+
+	filter = postgresql.Filter{ErrorOnly: true}
+	assert.False(t, filter.FilterByRequest())
+	assert.True(t, filter.FilterByResponse())
+	assert.False(t, filter.Filter(nil, records[0].Resp))
+	assert.True(t, filter.Filter(nil, records[1].Resp))
+}
@@ -0,0 +1,250 @@
+package postgresql
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See https://www.postgresql.org/docs/current/protocol-message-formats.html.
+
+// Frontend (client -> server) message tags.
+const (
+	kQuery        byte = 'Q'
+	kParse        byte = 'P'
+	kBind         byte = 'B'
+	kExecute      byte = 'E'
+	kDescribe     byte = 'D'
+	kClose        byte = 'C'
+	kSync         byte = 'S'
+	kFlush        byte = 'H'
+	kFunctionCall byte = 'F'
+	kPassword     byte = 'p'
+	kCopyData     byte = 'd'
+	kCopyDone     byte = 'c'
+	kCopyFail     byte = 'f'
+	kTerminate    byte = 'X'
+)
+
+// Backend (server -> client) message tags.
+const (
+	kAuthentication       byte = 'R'
+	kBackendKeyData       byte = 'K'
+	kBindComplete         byte = '2'
+	kCloseComplete        byte = '3'
+	kCommandComplete      byte = 'C'
+	kCopyInResponse       byte = 'G'
+	kCopyOutResponse      byte = 'H'
+	kCopyBothResponse     byte = 'W'
+	kDataRow              byte = 'D'
+	kEmptyQueryResponse   byte = 'I'
+	kErrorResponse        byte = 'E'
+	kFunctionCallResponse byte = 'V'
+	kNegotiateProtocol    byte = 'v'
+	kNoData               byte = 'n'
+	kNoticeResponse       byte = 'N'
+	kNotificationResponse byte = 'A'
+	kParameterDescription byte = 't'
+	kParameterStatus      byte = 'S'
+	kParseComplete        byte = '1'
+	kPortalSuspended      byte = 's'
+	kReadyForQuery        byte = 'Z'
+	kRowDescription       byte = 'T'
+	kBackendCopyData      byte = 'd'
+	kBackendCopyDone      byte = 'c'
+)
+
+// kStartup is not a real tag, the startup message (and SSLRequest/CancelRequest)
+// carries no tag byte. It is used to mark RegularMessage built from them.
+const kStartup byte = 0
+
+// Protocol version codes carried by the untagged startup packets.
+const (
+	kProtocolVersion3 int32 = 196608
+	kCancelRequest    int32 = 80877102
+	kSSLRequest       int32 = 80877103
+	kGSSENCRequest    int32 = 80877104
+)
+
+// tag(1 byte) + length(4 bytes)
+const kTagAndLengthSize int = 5
+
+// Startup packets are small, anything bigger is not a startup packet.
+const kMaxStartupPacketLength int32 = 10000
+
+// The server refuses messages larger than 1GB.
+const kMaxMessageLength int32 = 1 << 30
+
+var frontendTags = map[byte]struct{}{
+	kQuery: {}, kParse: {}, kBind: {}, kExecute: {}, kDescribe: {}, kClose: {}, kSync: {},
+	kFlush: {}, kFunctionCall: {}, kPassword: {}, kCopyData: {}, kCopyDone: {}, kCopyFail: {}, kTerminate: {},
+}
+
+var backendTags = map[byte]struct{}{
+	kAuthentication: {}, kBackendKeyData: {}, kBindComplete: {}, kCloseComplete: {}, kCommandComplete: {},
+	kCopyInResponse: {}, kCopyOutResponse: {}, kCopyBothResponse: {}, kDataRow: {}, kEmptyQueryResponse: {},
+	kErrorResponse: {}, kFunctionCallResponse: {}, kNegotiateProtocol: {}, kNoData: {}, kNoticeResponse: {},
+	kNotificationResponse: {}, kParameterDescription: {}, kParameterStatus: {}, kParseComplete: {},
+	kPortalSuspended: {}, kReadyForQuery: {}, kRowDescription: {}, kBackendCopyData: {}, kBackendCopyDone: {},
+}
+
+func isValidTag(tag byte, messageType protocol.MessageType) bool {
+	var ok bool
+	if messageType == protocol.Request {
+		_, ok = frontendTags[tag]
+	} else {
+		_, ok = backendTags[tag]
+	}
+	return ok
+}
+
+// guessMessageType is used before the role of the connection is known, tags
+// used by both sides are treated as responses since they are more common.
+func guessMessageType(tag byte) protocol.MessageType {
+	if _, ok := backendTags[tag]; ok {
+		return protocol.Response
+	}
+	return protocol.Request
+}
+
+var _ protocol.ParsedMessage = &RegularMessage{}
+
+// RegularMessage is a single message on the wire. They are combined into
+// Request and Response by the parser's Match.
+type RegularMessage struct {
+	protocol.FrameBase
+	Tag     byte
+	Payload string
+	isReq   bool
+}
+
+func (m *RegularMessage) FormatToString() string {
+	return fmt.Sprintf("base=[%s] tag=[%c] payload=[%s]", m.FrameBase.String(), m.Tag, m.Payload)
+}
+
+func (m *RegularMessage) IsReq() bool {
+	return m.isReq
+}
+
+func (m *RegularMessage) StreamId() protocol.StreamId {
+	return 0
+}
+
+type RequestKind string
+
+const (
+	KindStartup       RequestKind = "Startup"
+	KindQuery         RequestKind = "Query"
+	KindExtendedQuery RequestKind = "ExtendedQuery"
+	KindFunctionCall  RequestKind = "FunctionCall"
+)
+
+var _ protocol.ParsedMessage = &Request{}
+
+// Request is a simple query, a startup message, or an extended query batch
+// terminated by a Sync message.
+type Request struct {
+	protocol.FrameBase
+	Kind RequestKind
+	// SQL text of the request, statements executed in one extended query batch are joined by "; ".
+	Query string
+	// Name of the prepared statement, empty for the unnamed statement.
+	Statement string
+	// Parameters of the last Bind message in the batch.
+	Params        []string
+	StartupParams map[string]string
+	Tags          []byte
+}
+
+func (r *Request) FormatToString() string {
+	if r.Kind == KindStartup {
+		return fmt.Sprintf("base=[%s] kind=[%s] params=[%v]", r.FrameBase.String(), r.Kind, r.StartupParams)
+	}
+	return fmt.Sprintf("base=[%s] kind=[%s] statement=[%s] query=[%s] params=[%s] messages=[%s]",
+		r.FrameBase.String(), r.Kind, r.Statement, r.Query, strings.Join(r.Params, ", "), string(r.Tags))
+}
+
+// Digest returns the fingerprint of the SQL text, or the kind of the
+// request if there is none.
+func (r *Request) Digest() string {
+	if r.Query == "" {
+		return string(r.Kind)
+	}
+	return protocol.SqlDigest(r.Query)
+}
+
+func (r *Request) IsReq() bool {
+	return true
+}
+
+func (r *Request) StreamId() protocol.StreamId {
+	return 0
+}
+
+// ErrorInfo holds the fields of an ErrorResponse or NoticeResponse.
+type ErrorInfo struct {
+	Severity string
+	Code     string
+	Message  string
+	Detail   string
+}
+
+func (e *ErrorInfo) String() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s %s: %s (%s)", e.Severity, e.Code, e.Message, e.Detail)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Severity, e.Code, e.Message)
+}
+
+var _ protocol.ParsedMessage = &Response{}
+var _ protocol.StatusfulMessage = &Response{}
+
+// Response holds all messages the server sent until ReadyForQuery.
+type Response struct {
+	protocol.FrameBase
+	CommandTags []string
+	Columns     []string
+	Rows        int
+	Error       *ErrorInfo
+	Notices     []string
+	Tags        []byte
+}
+
+func (r *Response) Status() protocol.ResponseStatus {
+	if r.Error != nil {
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+func (r *Response) FormatToString() string {
+	if r.Error != nil {
+		return fmt.Sprintf("base=[%s] error=[%s] messages=[%s]", r.FrameBase.String(), r.Error.String(), string(r.Tags))
+	}
+	return fmt.Sprintf("base=[%s] command=[%s] columns=[%s] rows=[%d] notices=[%s] messages=[%s]",
+		r.FrameBase.String(), strings.Join(r.CommandTags, ", "), strings.Join(r.Columns, ", "), r.Rows,
+		strings.Join(r.Notices, "; "), string(r.Tags))
+}
+
+func (r *Response) IsReq() bool {
+	return false
+}
+
+func (r *Response) StreamId() protocol.StreamId {
+	return 0
+}
+
+var _ protocol.ProtocolStreamParser = &PostgreSQLStreamParser{}
+
+type State struct {
+	// prepared statement name -> SQL text
+	PreparedStatements map[string]string
+	// portal name -> prepared statement name
+	Portals map[string]string
+	// set after SSLRequest/GSSENCRequest, the server answers with a single byte
+	encryptionRequested bool
+}
+
+type PostgreSQLStreamParser struct {
+	*State
+}
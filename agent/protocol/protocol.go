@@ -149,9 +149,29 @@ type ProtocolFilter interface {
 	Protocol() bpf.AgentTrafficProtocolT
 }
 
+// DurationFilter is implemented by the filters that depend on the total
+// duration of a record, which is only known once the record is analyzed.
+// FilterWithDuration is called then instead of Filter, duration is in
+// nanoseconds and negative if it is unknown.
+type DurationFilter interface {
+	FilterWithDuration(req ParsedMessage, resp ParsedMessage, duration float64) bool
+}
+
 type StatusfulMessage interface {
 	Status() ResponseStatus
 }
+
+// SyntheticMessage is implemented by the messages of records built from other
+// records, like a transaction, they have no bytes on the wire of their own.
+type SyntheticMessage interface {
+	IsSynthetic() bool
+}
+
+func IsSyntheticRecord(r Record) bool {
+	synthetic, ok := r.Req.(SyntheticMessage)
+	return ok && synthetic.IsSynthetic()
+}
+
 type ResponseStatus int8
 
 const (
@@ -159,4 +179,6 @@ const (
 	SuccessStatus
 	FailStatus
 	UnknownStatus
+	// the requested cache item doesn't exist, it is not a failure
+	MissStatus
 )
@@ -0,0 +1,37 @@
+// Package protocoltest has helpers for the tests of the protocol parsers.
+package protocoltest
+
+import (
+	"testing"
+
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ParseAll parses data received at ts as messages of messageType until it is
+// consumed, and groups the messages by stream id like the connection does
+// before Match.
+func ParseAll[D ~string | ~[]byte](t testing.TB, parser protocol.ProtocolStreamParser, data D, messageType protocol.MessageType, ts uint64) map[protocol.StreamId]*protocol.ParsedMessageQueue {
+	t.Helper()
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, []byte(data), ts)
+	streams := make(map[protocol.StreamId]*protocol.ParsedMessageQueue)
+	for !streamBuffer.IsEmpty() {
+		result := parser.ParseStream(streamBuffer, messageType)
+		if !assert.Contains(t, []protocol.ParseState{protocol.Success, protocol.Ignore}, result.ParseState) {
+			break
+		}
+		for _, message := range result.ParsedMessages {
+			queue, ok := streams[message.StreamId()]
+			if !ok {
+				queue = &protocol.ParsedMessageQueue{}
+				streams[message.StreamId()] = queue
+			}
+			*queue = append(*queue, message)
+		}
+		streamBuffer.RemovePrefix(result.ReadBytes)
+	}
+	return streams
+}
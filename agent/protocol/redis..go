@@ -17,8 +17,19 @@ const (
 	kIntegerMarker      = ':'
 	kBulkStringsMarker  = '$'
 	kArrayMarker        = '*'
-	kTerminalSequence   = "\r\n"
-	kNullSize           = -1
+	// RESP3, enabled by HELLO 3
+	kNullMarker           = '_'
+	kDoubleMarker         = ','
+	kBooleanMarker        = '#'
+	kBlobErrorMarker      = '!'
+	kVerbatimStringMarker = '='
+	kBigNumberMarker      = '('
+	kMapMarker            = '%'
+	kSetMarker            = '~'
+	kAttributeMarker      = '|'
+	kPushMarker           = '>'
+	kTerminalSequence     = "\r\n"
+	kNullSize             = -1
 )
 
 var redisCommandsMap map[string][]string
@@ -315,6 +326,11 @@ var _ ParsedMessage = &RedisMessage{}
 var _ StatusfulMessage = &RedisMessage{}
 
 type RedisStreamParser struct {
+	// the subscribe or unsubscribe confirmations still expected for the
+	// last subscription command, one per channel, -1 if it had no channel
+	// and the confirmations go on until no subscription is left
+	pendingConfirmations int
+	pendingKind          string
 }
 type RedisMessage struct {
 	FrameBase
@@ -322,6 +338,32 @@ type RedisMessage struct {
 	command string
 	isReq   bool
 	status  ResponseStatus
+	// push is set for the messages the server sends without a request, the
+	// RESP3 push messages and the messages a RESP2 subscriber receives
+	push bool
+	// the first element of a push or a pub/sub array in lower case, like
+	// message, invalidate or subscribe
+	kind string
+	// the number of subscriptions left, ending a subscription confirmation
+	subscriptions int
+}
+
+// The kinds of the messages a subscriber receives, and of the confirmations
+// of the subscription commands, one per channel.
+var (
+	pubsubMessageKinds      = []string{"message", "pmessage", "smessage"}
+	subscriptionConfirmKind = []string{"subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ssubscribe", "sunsubscribe"}
+)
+
+func (m *RedisMessage) isConfirmation() bool {
+	return slices.Contains(subscriptionConfirmKind, m.kind)
+}
+
+// IsPush returns whether the message was sent by the server without a
+// request. The request of the record of a push message is made up, it has
+// no size.
+func (m *RedisMessage) IsPush() bool {
+	return m.push
 }
 
 func (m *RedisMessage) Status() ResponseStatus {
@@ -357,6 +399,12 @@ func (m *RedisMessage) Payload() string {
 }
 
 func (m *RedisMessage) FormatToString() string {
+	if m.push && m.isReq {
+		return fmt.Sprintf("base=[%s] server push, %s channel=[%s]", m.FrameBase.String(), m.command, m.payload)
+	}
+	if m.kind != "" && !m.isReq {
+		return fmt.Sprintf("base=[%s] %s payload=[%s]", m.FrameBase.String(), m.kind, m.payload)
+	}
 	return fmt.Sprintf("base=[%s] command=[%s] payload=[%s]", m.FrameBase.String(), m.command, m.payload)
 }
 
@@ -373,19 +421,88 @@ func (r *RedisStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, mess
 			typeMarker == kArrayMarker {
 			return startPos
 		}
+		// RESP3 replies are mostly maps, sets and pushes
+		if typeMarker == kMapMarker || typeMarker == kSetMarker ||
+			typeMarker == kPushMarker || typeMarker == kAttributeMarker {
+			return startPos
+		}
 
 	}
 	return -1
 }
 
+// Match pairs each reply with the latest request sent before it. The push
+// messages are records of their own, and the confirmations of a subscription
+// command after the first one belong to the record of the command.
 func (r *RedisStreamParser) Match(reqStreams map[StreamId]*ParsedMessageQueue, respStreams map[StreamId]*ParsedMessageQueue) []Record {
-	reqStream, ok1 := reqStreams[0]
-	respStream, ok2 := respStreams[0]
-	if !ok1 || !ok2 {
-		return []Record{}
+	records := []Record{}
+	respStream, ok := respStreams[0]
+	if !ok {
+		return records
+	}
+	reqStream, ok := reqStreams[0]
+	if !ok {
+		reqStream = &ParsedMessageQueue{}
+		reqStreams[0] = reqStream
+	}
+	var req *RedisMessage
+	for len(*respStream) > 0 {
+		resp := (*respStream)[0].(*RedisMessage)
+		if len(*reqStream) > 0 && (*reqStream)[0].TimestampNs() < resp.TimestampNs() {
+			req = (*reqStream)[0].(*RedisMessage)
+			*reqStream = (*reqStream)[1:]
+			continue
+		}
+		*respStream = (*respStream)[1:]
+		switch {
+		case resp.push:
+			records = append(records, Record{Req: pushRequest(resp), Resp: resp, ResponseStatus: SuccessStatus})
+		case resp.isConfirmation() && r.pendingConfirmations != 0 && resp.kind == r.pendingKind:
+			r.pendingConfirmations--
+			if r.pendingConfirmations < 0 && resp.subscriptions == 0 {
+				r.pendingConfirmations = 0
+			}
+		case req != nil:
+			records = append(records, Record{Req: req, Resp: resp, ResponseStatus: resp.Status()})
+			if resp.isConfirmation() {
+				r.expectConfirmations(req, resp)
+			}
+			req = nil
+		}
+	}
+	if req != nil {
+		*reqStream = slices.Insert(*reqStream, 0, ParsedMessage(req))
+	}
+	return records
+}
+
+// expectConfirmations counts the confirmations left after the first one for
+// a subscription command.
+func (r *RedisStreamParser) expectConfirmations(req *RedisMessage, resp *RedisMessage) {
+	r.pendingKind = resp.kind
+	if channels := len(strings.Fields(req.payload)); channels > 0 {
+		r.pendingConfirmations = channels - 1
+	} else if resp.subscriptions > 0 {
+		r.pendingConfirmations = -1
+	} else {
+		r.pendingConfirmations = 0
 	}
-	return matchByTimestamp(reqStream, respStream)
 }
+
+// pushRequest makes up the request of the record of a push message, its
+// command is the kind of the message and its payload the channel or the
+// pattern.
+func pushRequest(resp *RedisMessage) *RedisMessage {
+	channel, _, _ := strings.Cut(resp.payload, " ")
+	return &RedisMessage{
+		FrameBase: NewFrameBase(resp.TimestampNs(), 0, 0),
+		command:   strings.ToUpper(resp.kind),
+		payload:   channel,
+		isReq:     true,
+		push:      true,
+	}
+}
+
 func ParseSize(decoder *BinaryDecoder) (int, error) {
 	str, err := decoder.ExtractStringUntil(kTerminalSequence)
 	if err != nil {
@@ -440,6 +557,31 @@ func ParseArray(decoder *BinaryDecoder, timestamp uint64, seq uint64) (*RedisMes
 			payload:   "[NULL]",
 		}, nil
 	}
+	msgSlice, err := parseElements(decoder, size, timestamp, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &RedisMessage{
+		FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), uint64(decoder.readBytes)),
+		isReq:     true,
+	}
+	cmd, payload := getCmdAndArgs(msgSlice)
+	ret.command = cmd
+	ret.payload = payload
+	if cmd == "" {
+		ret.payload = joinPayloads(msgSlice)
+	}
+	// the messages a RESP2 subscriber receives are arrays as well
+	setPubsubKind(ret, msgSlice)
+	if slices.Contains(pubsubMessageKinds, ret.kind) {
+		ret.push = true
+	}
+
+	return ret, nil
+}
+
+func parseElements(decoder *BinaryDecoder, size int, timestamp uint64, seq uint64) ([]ParsedMessage, error) {
 	msgSlice := make([]ParsedMessage, 0)
 	for i := 0; i < size; i++ {
 		_msg, err := ParseMessage(decoder, timestamp, seq)
@@ -448,15 +590,60 @@ func ParseArray(decoder *BinaryDecoder, timestamp uint64, seq uint64) (*RedisMes
 		}
 		msgSlice = append(msgSlice, _msg)
 	}
+	return msgSlice, nil
+}
 
-	ret := &RedisMessage{
-		FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), uint64(decoder.readBytes)),
-		isReq:     true,
+func joinPayloads(msgSlice []ParsedMessage) string {
+	payloads := make([]string, len(msgSlice))
+	for i, each := range msgSlice {
+		payloads[i] = convertParsedMessageToRedisMessage(each).payload
 	}
-	cmd, payload := getCmdAndArgs(msgSlice)
-	ret.command = cmd
-	ret.payload = payload
+	return strings.Join(payloads, " ")
+}
+
+// setPubsubKind sets the kind of a pub/sub message or of a subscription
+// confirmation, whose payload is what follows the kind.
+func setPubsubKind(m *RedisMessage, msgSlice []ParsedMessage) {
+	if len(msgSlice) == 0 {
+		return
+	}
+	kind := strings.ToLower(convertParsedMessageToRedisMessage(msgSlice[0]).payload)
+	if !slices.Contains(pubsubMessageKinds, kind) && !slices.Contains(subscriptionConfirmKind, kind) && !m.push {
+		return
+	}
+	m.kind = kind
+	m.payload = joinPayloads(msgSlice[1:])
+	if m.isConfirmation() && len(msgSlice) == 3 {
+		m.subscriptions, _ = strconv.Atoi(convertParsedMessageToRedisMessage(msgSlice[2]).payload)
+	}
+}
 
+// ParseAggregate parses the RESP3 maps, sets and pushes. A map has a key and
+// a value per element.
+func ParseAggregate(decoder *BinaryDecoder, typeMarker byte, timestamp uint64, seq uint64) (*RedisMessage, error) {
+	size, err := ParseSize(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if typeMarker == kMapMarker {
+		size *= 2
+	}
+	msgSlice, err := parseElements(decoder, size, timestamp, seq)
+	if err != nil {
+		return nil, err
+	}
+	ret := &RedisMessage{
+		FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), seq),
+		payload:   joinPayloads(msgSlice),
+		status:    SuccessStatus,
+	}
+	if typeMarker == kPushMarker {
+		// the confirmations of the subscription commands are replies even
+		// though they are sent as pushes
+		ret.push = true
+		setPubsubKind(ret, msgSlice)
+		ret.push = !ret.isConfirmation()
+	}
 	return ret, nil
 }
 
@@ -556,6 +743,71 @@ func ParseMessage(decoder *BinaryDecoder, timestamp uint64, seq uint64) (ParsedM
 		}, nil
 	case kArrayMarker:
 		return ParseArray(decoder, timestamp, seq)
+	case kNullMarker:
+		if _, err := decoder.ExtractStringUntil(kTerminalSequence); err != nil {
+			return nil, err
+		}
+		return &RedisMessage{
+			FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), seq),
+			payload:   "<NULL>",
+			status:    SuccessStatus,
+		}, nil
+	case kDoubleMarker, kBigNumberMarker:
+		str, err := decoder.ExtractStringUntil(kTerminalSequence)
+		if err != nil {
+			return nil, err
+		}
+		return &RedisMessage{
+			FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), seq),
+			payload:   str,
+			status:    SuccessStatus,
+		}, nil
+	case kBooleanMarker:
+		str, err := decoder.ExtractStringUntil(kTerminalSequence)
+		if err != nil {
+			return nil, err
+		}
+		if str != "t" && str != "f" {
+			return nil, common.NewInvalidArgument(fmt.Sprintf("Redis boolean must be t or f, got '%s'", str))
+		}
+		return &RedisMessage{
+			FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), seq),
+			payload:   strconv.FormatBool(str == "t"),
+			status:    SuccessStatus,
+		}, nil
+	case kBlobErrorMarker:
+		str, err := ParseBulkString(decoder, timestamp, seq)
+		if err != nil {
+			return nil, err
+		}
+		return &RedisMessage{
+			FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), seq),
+			payload:   "-" + str,
+			status:    FailStatus,
+		}, nil
+	case kVerbatimStringMarker:
+		str, err := ParseBulkString(decoder, timestamp, seq)
+		if err != nil {
+			return nil, err
+		}
+		// the string starts with its format, like txt: or mkd:
+		if len(str) >= 4 && str[3] == ':' {
+			str = str[4:]
+		}
+		return &RedisMessage{
+			FrameBase: NewFrameBase(timestamp, int(decoder.readBytes), seq),
+			payload:   str,
+			status:    SuccessStatus,
+		}, nil
+	case kMapMarker, kSetMarker, kPushMarker:
+		return ParseAggregate(decoder, typeMarker, timestamp, seq)
+	case kAttributeMarker:
+		// the attributes, like the popularity of keys, precede the reply and
+		// are not shown
+		if _, err := ParseAggregate(decoder, kMapMarker, timestamp, seq); err != nil {
+			return nil, err
+		}
+		return ParseMessage(decoder, timestamp, seq)
 	default:
 		return nil, common.NewInvalidArgument(fmt.Sprintf("Unexpected Redis type marker char (displayed as integer): %d", typeMarker))
 	}
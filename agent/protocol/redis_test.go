@@ -0,0 +1,124 @@
+package protocol_test
+
+import (
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type redisMessage struct {
+	ts   uint64
+	data string
+}
+
+// parseRedis parses each message in a stream buffer of its own, sent at its
+// timestamp.
+func parseRedis(t *testing.T, parser *protocol.RedisStreamParser, messageType protocol.MessageType, messages ...redisMessage) map[protocol.StreamId]*protocol.ParsedMessageQueue {
+	queue := &protocol.ParsedMessageQueue{}
+	for _, message := range messages {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, []byte(message.data), message.ts)
+		result := parser.ParseStream(streamBuffer, messageType)
+		assert.Equal(t, protocol.Success, result.ParseState, message.data)
+		assert.Equal(t, len(message.data), result.ReadBytes, message.data)
+		*queue = append(*queue, result.ParsedMessages...)
+	}
+	return map[protocol.StreamId]*protocol.ParsedMessageQueue{0: queue}
+}
+
+func TestParseRESP3Types(t *testing.T) {
+	cases := map[string]string{
+		"%2\r\n+server\r\n+redis\r\n+proto\r\n:3\r\n": "server redis proto 3",
+		"~2\r\n$1\r\na\r\n,1.5\r\n":                   "a 1.5",
+		"#t\r\n":                                      "true",
+		"_\r\n":                                       "<NULL>",
+		"(3492890328409238509324850943850943825024385\r\n": "3492890328409238509324850943850943825024385",
+		"=15\r\ntxt:Some string\r\n":                       "Some string",
+		// the attributes are not shown
+		"|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.19\r\n*1\r\n:2039123\r\n": "2039123",
+	}
+	for data, payload := range cases {
+		streams := parseRedis(t, &protocol.RedisStreamParser{}, protocol.Response, redisMessage{10, data})
+		resp := (*streams[0])[0].(*protocol.RedisMessage)
+		assert.Equal(t, payload, resp.Payload(), data)
+	}
+
+	streams := parseRedis(t, &protocol.RedisStreamParser{}, protocol.Response, redisMessage{10, "!21\r\nSYNTAX invalid syntax\r\n"})
+	resp := (*streams[0])[0].(*protocol.RedisMessage)
+	assert.Equal(t, "-SYNTAX invalid syntax", resp.Payload())
+	assert.Equal(t, protocol.FailStatus, resp.Status())
+}
+
+func TestMatchRESP3Push(t *testing.T) {
+	parser := &protocol.RedisStreamParser{}
+	reqs := parseRedis(t, parser, protocol.Request,
+		redisMessage{10, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"},
+	)
+	resps := parseRedis(t, parser, protocol.Response,
+		// the invalidation of a key cached by the client comes before the reply
+		redisMessage{20, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nbar\r\n"},
+		redisMessage{30, "$1\r\n1\r\n"},
+	)
+	records := parser.Match(reqs, resps)
+
+	assert.Len(t, records, 2)
+	push := records[0].Req.(*protocol.RedisMessage)
+	assert.True(t, push.IsPush())
+	assert.Equal(t, "INVALIDATE", push.Command())
+	assert.Equal(t, "bar", push.Payload())
+	assert.Equal(t, 0, push.ByteSize())
+	assert.Equal(t, "GET", records[1].Req.(*protocol.RedisMessage).Command())
+	assert.Equal(t, "1", records[1].Resp.(*protocol.RedisMessage).Payload())
+}
+
+func TestMatchRESP2Subscriber(t *testing.T) {
+	parser := &protocol.RedisStreamParser{}
+	reqs := parseRedis(t, parser, protocol.Request,
+		redisMessage{10, "*3\r\n$9\r\nSUBSCRIBE\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		redisMessage{50, "*1\r\n$11\r\nUNSUBSCRIBE\r\n"},
+	)
+	resps := parseRedis(t, parser, protocol.Response,
+		redisMessage{20, "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n"},
+		redisMessage{21, "*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n"},
+		redisMessage{30, "*3\r\n$7\r\nmessage\r\n$1\r\nb\r\n$5\r\nhello\r\n"},
+		redisMessage{60, "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:1\r\n"},
+		redisMessage{61, "*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:0\r\n"},
+		redisMessage{70, "*3\r\n$7\r\nmessage\r\n$1\r\nb\r\n$5\r\nlater\r\n"},
+	)
+	records := parser.Match(reqs, resps)
+
+	assert.Len(t, records, 4)
+	assert.Equal(t, "SUBSCRIBE", records[0].Req.(*protocol.RedisMessage).Command())
+	assert.False(t, records[0].Req.(*protocol.RedisMessage).IsPush())
+	assert.Equal(t, "MESSAGE", records[1].Req.(*protocol.RedisMessage).Command())
+	assert.Equal(t, "b", records[1].Req.(*protocol.RedisMessage).Payload())
+	assert.Equal(t, "b hello", records[1].Resp.(*protocol.RedisMessage).Payload())
+	assert.Equal(t, "UNSUBSCRIBE", records[2].Req.(*protocol.RedisMessage).Command())
+	// the channel was unsubscribed, but the message was sent before
+	assert.True(t, records[3].Req.(*protocol.RedisMessage).IsPush())
+	assert.Empty(t, *resps[0])
+}
+
+func TestMatchRESP3SubscriptionConfirmations(t *testing.T) {
+	parser := &protocol.RedisStreamParser{}
+	reqs := parseRedis(t, parser, protocol.Request,
+		redisMessage{10, "*3\r\n$10\r\nPSUBSCRIBE\r\n$2\r\na*\r\n$2\r\nb*\r\n"},
+		redisMessage{40, "*1\r\n$4\r\nPING\r\n"},
+	)
+	resps := parseRedis(t, parser, protocol.Response,
+		redisMessage{20, ">3\r\n$10\r\npsubscribe\r\n$2\r\na*\r\n:1\r\n"},
+		redisMessage{21, ">3\r\n$10\r\npsubscribe\r\n$2\r\nb*\r\n:2\r\n"},
+		redisMessage{30, ">4\r\n$8\r\npmessage\r\n$2\r\na*\r\n$2\r\nab\r\n$2\r\nhi\r\n"},
+		redisMessage{50, "+PONG\r\n"},
+	)
+	records := parser.Match(reqs, resps)
+
+	assert.Len(t, records, 3)
+	assert.Equal(t, "PSUBSCRIBE", records[0].Req.(*protocol.RedisMessage).Command())
+	assert.Equal(t, "PMESSAGE", records[1].Req.(*protocol.RedisMessage).Command())
+	assert.Equal(t, "a*", records[1].Req.(*protocol.RedisMessage).Payload())
+	assert.Equal(t, "PING", records[2].Req.(*protocol.RedisMessage).Command())
+	assert.Equal(t, "PONG", records[2].Resp.(*protocol.RedisMessage).Payload())
+}
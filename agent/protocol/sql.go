@@ -0,0 +1,164 @@
+package protocol
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The lists of values after IN and VALUES, once their literals are replaced.
+var (
+	inListRegex     = regexp.MustCompile(`\bin ?\((?:\?, )*\?\)`)
+	valuesListRegex = regexp.MustCompile(`\bvalues? ?\((?:\?, )*\?\)(?:, ?\((?:\?, )*\?\))*`)
+)
+
+// SqlDigest returns the fingerprint of a SQL statement, the statements which
+// only differ in their values have the same digest. The string and number
+// literals and the $1 placeholders are replaced with ?, the lists of values
+// of IN and VALUES are collapsed to (?+), the comments are removed and the
+// text which is not quoted is in lower case.
+func SqlDigest(statement string) string {
+	var b strings.Builder
+	space := false
+	write := func(token string) {
+		// no space after ( and before , or )
+		if space && b.Len() > 0 && token != "," && token != ")" && !strings.HasSuffix(b.String(), "(") {
+			b.WriteByte(' ')
+		}
+		space = token == ","
+		b.WriteString(token)
+	}
+	literal := func() {
+		// the sign of a number after an operator belongs to the number
+		if s := b.String(); !space && (strings.HasSuffix(s, "-") || strings.HasSuffix(s, "+")) {
+			before := strings.TrimRight(s[:len(s)-1], " ")
+			if before == "" || strings.ContainsAny(before[len(before)-1:], "=<>(,*/%+-") {
+				b.Reset()
+				b.WriteString(before)
+				space = len(before) < len(s)-1
+			}
+		}
+		write("?")
+	}
+
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			i++
+		case strings.HasPrefix(statement[i:], "--"):
+			i = skipUntil(statement, i+2, "\n")
+			space = true
+		case strings.HasPrefix(statement[i:], "/*"):
+			i = skipUntil(statement, i+2, "*/")
+			space = true
+		case c == '\'':
+			i = skipQuoted(statement, i)
+			literal()
+		case c == '"' || c == '`':
+			// quoted identifiers are kept as they are
+			end := skipQuoted(statement, i)
+			write(statement[i:end])
+			i = end
+		case c == '$' && i+1 < len(statement) && isDigit(statement[i+1]):
+			i = skipWhile(statement, i+1, isDigit)
+			literal()
+		case c == '$' && dollarQuoteTag(statement[i:]) != "":
+			tag := dollarQuoteTag(statement[i:])
+			i = skipUntil(statement, i+len(tag), tag)
+			literal()
+		case isDigit(c) || (c == '.' && i+1 < len(statement) && isDigit(statement[i+1])):
+			i = skipNumber(statement, i)
+			literal()
+		case isIdentifierChar(c):
+			end := skipWhile(statement, i, isIdentifierChar)
+			word := strings.ToLower(statement[i:end])
+			// X'1F', B'01', N'text' and the charset introducers like
+			// _utf8mb4'text' are literals
+			if end < len(statement) && statement[end] == '\'' && (word == "x" || word == "b" || word == "n" || word[0] == '_') {
+				i = skipQuoted(statement, end)
+				literal()
+				continue
+			}
+			write(word)
+			i = end
+		default:
+			write(string(c))
+			i++
+		}
+	}
+	digest := strings.TrimRight(b.String(), "; ")
+	digest = inListRegex.ReplaceAllString(digest, "in (?+)")
+	return valuesListRegex.ReplaceAllString(digest, "values (?+)")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z')
+}
+
+func skipWhile(s string, i int, f func(byte) bool) int {
+	for i < len(s) && f(s[i]) {
+		i++
+	}
+	return i
+}
+
+// skipUntil returns the position after the end sentinel, or the end of s.
+func skipUntil(s string, i int, end string) int {
+	if idx := strings.Index(s[i:], end); idx >= 0 {
+		return i + idx + len(end)
+	}
+	return len(s)
+}
+
+// skipQuoted returns the position after the quoted text at i, the quote is
+// escaped by doubling it or by a backslash.
+func skipQuoted(s string, i int) int {
+	quote := s[i]
+	for i++; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '\'':
+			i++
+		case s[i] == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// skipNumber returns the position after a number like 42, 3.14, 1e-5 or
+// 0x1F.
+func skipNumber(s string, i int) int {
+	hex := strings.HasPrefix(s[i:], "0x") || strings.HasPrefix(s[i:], "0X")
+	for i++; i < len(s); i++ {
+		c := s[i]
+		if (c == '+' || c == '-') && !hex && (s[i-1] == 'e' || s[i-1] == 'E') {
+			continue
+		}
+		if c != '.' && !isIdentifierChar(c) {
+			break
+		}
+	}
+	return i
+}
+
+// dollarQuoteTag returns the tag opening a PostgreSQL dollar-quoted string,
+// like $$ or $body$, or an empty string.
+func dollarQuoteTag(s string) string {
+	end := strings.IndexByte(s[1:], '$')
+	if end < 0 {
+		return ""
+	}
+	for _, c := range []byte(s[1 : end+1]) {
+		if !isIdentifierChar(c) || c == '$' {
+			return ""
+		}
+	}
+	return s[:end+2]
+}
@@ -0,0 +1,28 @@
+package protocol_test
+
+import (
+	"kyanos/agent/protocol"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqlDigest(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM users WHERE id = 42":                                "select * from users where id = ?",
+		"select *\n  from users\twhere id=7 ;":                             "select * from users where id=?",
+		"SELECT name FROM users WHERE email = 'a''b@example.com' LIMIT 10": "select name from users where email = ? limit ?",
+		`SELECT 'it\'s' FROM dual`:                                         "select ? from dual",
+		"SELECT * FROM t WHERE id IN (1, 2, 3) AND k IN ('a')":             "select * from t where id in (?+) and k in (?+)",
+		"SELECT * FROM t WHERE id IN ( 1 ,2,3 )":                           "select * from t where id in (?+)",
+		"INSERT INTO orders (id, total) VALUES (1, 9.5), (2, -1e3)":        "insert into orders (id, total) values (?+)",
+		"UPDATE t SET a = -1, b = a - 1 WHERE c > +2":                      "update t set a = ?, b = a - ? where c > ?",
+		"SELECT `Order`.id FROM `Order` WHERE t1.x = 0x1F /* hint */":      "select `Order`.id from `Order` where t1.x = ?",
+		"SELECT X'1F', _utf8mb4'text' -- comment\nFROM t":                  "select ?, ? from t",
+		`SELECT "Name" FROM "Users" WHERE id = $1 AND body = $$a 'b'$$`:    `select "Name" from "Users" where id = ? and body = ?`,
+		"SELECT * FROM t WHERE id IN (SELECT id FROM u WHERE x = 1)":       "select * from t where id in (select id from u where x = ?)",
+	}
+	for statement, digest := range cases {
+		assert.Equal(t, digest, protocol.SqlDigest(statement), statement)
+	}
+}
@@ -0,0 +1,47 @@
+package thrift
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+)
+
+type Filter struct {
+	// the services are only known to clients using TMultiplexedProtocol
+	TargetServices []string
+	TargetMethods  []string
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	thriftReq, ok := req.(*Request)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[ThriftFilter] cast to thrift.Request failed: %v\n", req)
+		return false
+	}
+	if len(f.TargetServices) > 0 && !slices.Contains(f.TargetServices, thriftReq.Service) {
+		return false
+	}
+	if len(f.TargetMethods) > 0 && !slices.Contains(f.TargetMethods, thriftReq.Method) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolThrift
+}
+
+func (f Filter) FilterByRequest() bool {
+	return len(f.TargetServices) > 0 || len(f.TargetMethods) > 0
+}
+
+func (f Filter) FilterByResponse() bool {
+	return false
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolThrift
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,321 @@
+package thrift
+
+import (
+	"encoding/binary"
+	"errors"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"strings"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolThrift] = func() protocol.ProtocolStreamParser {
+		return NewThriftStreamParser()
+	}
+}
+
+func NewThriftStreamParser() *ThriftStreamParser {
+	return &ThriftStreamParser{}
+}
+
+// A request not answered within kMaxResponseWaitNs is dropped.
+const kMaxResponseWaitNs uint64 = 60 * 1000 * 1000 * 1000
+
+// Method names are identifiers, a longer name is not a message header.
+const kMaxNameLength = 1024
+
+var errNotEnoughData = errors.New("not enough data")
+var errInvalid = errors.New("invalid message")
+
+// decoder reads the fields of the binary or the compact protocol, the
+// first error is kept and all reads after it return zero values.
+type decoder struct {
+	buf     []byte
+	compact bool
+	err     error
+	// the id of the previous field of the compact protocol, the field
+	// headers hold the delta to it
+	lastFieldId int16
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 {
+		d.err = errInvalid
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errNotEnoughData
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) readByte() byte {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readI16() int16 {
+	if d.compact {
+		return int16(zigzag(d.readVarint()))
+	}
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) readI32() int32 {
+	if d.compact {
+		return int32(zigzag(d.readVarint()))
+	}
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) readVarint() uint64 {
+	var value uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b := d.readByte()
+		if d.err != nil {
+			return 0
+		}
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value
+		}
+	}
+	d.err = errInvalid
+	return 0
+}
+
+func zigzag(n uint64) int64 {
+	return int64(n>>1) ^ -int64(n&1)
+}
+
+func (d *decoder) readString(maxLength int) string {
+	var length int
+	if d.compact {
+		length = int(d.readVarint())
+	} else {
+		length = int(d.readI32())
+	}
+	if length > maxLength {
+		d.err = errInvalid
+		return ""
+	}
+	return string(d.take(length))
+}
+
+// readFieldHeader returns the type and the id of the next field of a
+// struct, the type is 0 at the end of the struct.
+func (d *decoder) readFieldHeader() (byte, int16) {
+	if !d.compact {
+		fieldType := d.readByte()
+		if fieldType == kBinaryTypeStop {
+			return fieldType, 0
+		}
+		return fieldType, d.readI16()
+	}
+	b := d.readByte()
+	fieldType := b & 0x0f
+	if fieldType == kCompactTypeStop {
+		return fieldType, 0
+	}
+	if delta := int16(b >> 4); delta != 0 {
+		d.lastFieldId += delta
+	} else {
+		d.lastFieldId = d.readI16()
+	}
+	return fieldType, d.lastFieldId
+}
+
+type messageHeader struct {
+	messageType MessageType
+	name        string
+	seqId       int32
+	compact     bool
+}
+
+// readMessageHeader reads the header of a message of the strict binary
+// protocol or the compact protocol.
+func (d *decoder) readMessageHeader() messageHeader {
+	var header messageHeader
+	first := d.readByte()
+	switch first {
+	case kBinaryVersionHigh:
+		rest := d.take(3)
+		if rest == nil {
+			return header
+		}
+		if rest[0] != kBinaryVersionLow || rest[1] != 0 {
+			d.err = errInvalid
+			return header
+		}
+		header.messageType = MessageType(rest[2])
+		header.name = d.readString(kMaxNameLength)
+		header.seqId = d.readI32()
+	case kCompactProtocolId:
+		d.compact = true
+		header.compact = true
+		b := d.readByte()
+		if d.err == nil && b&kCompactVersionMask != kCompactVersion {
+			d.err = errInvalid
+			return header
+		}
+		header.messageType = MessageType(b >> kCompactTypeShift)
+		// the sequence id is a varint without zigzag encoding
+		header.seqId = int32(d.readVarint())
+		header.name = d.readString(kMaxNameLength)
+	default:
+		d.err = errInvalid
+	}
+	if d.err == nil && (header.messageType < MessageCall || header.messageType > MessageOneway) {
+		d.err = errInvalid
+	}
+	return header
+}
+
+// validFrameStart tells if buf may start with a frame of messageType, buf
+// holds at least the frame size and the first two bytes of the message.
+func validFrameStart(buf []byte, messageType protocol.MessageType) bool {
+	size := binary.BigEndian.Uint32(buf)
+	if size < 2 || size > kMaxFrameSize {
+		return false
+	}
+	var t MessageType
+	switch buf[kFrameSizeLength] {
+	case kBinaryVersionHigh:
+		if len(buf) < kFrameSizeLength+4 {
+			return buf[kFrameSizeLength+1] == kBinaryVersionLow
+		}
+		if buf[kFrameSizeLength+1] != kBinaryVersionLow || buf[kFrameSizeLength+2] != 0 {
+			return false
+		}
+		t = MessageType(buf[kFrameSizeLength+3])
+	case kCompactProtocolId:
+		b := buf[kFrameSizeLength+1]
+		if b&kCompactVersionMask != kCompactVersion {
+			return false
+		}
+		t = MessageType(b >> kCompactTypeShift)
+	default:
+		return false
+	}
+	if t < MessageCall || t > MessageOneway {
+		return false
+	}
+	return messageType == protocol.Unknown || (messageType == protocol.Request) == t.isRequest()
+}
+
+func (p *ThriftStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	if len(buf) < kFrameSizeLength+2 {
+		// the frame size starts with zero bytes
+		if len(buf) > 0 && buf[0] > kMaxFrameSize>>24 {
+			return protocol.ParseResult{ParseState: protocol.Invalid}
+		}
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	if !validFrameStart(buf, messageType) {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	readBytes := kFrameSizeLength + int(binary.BigEndian.Uint32(buf))
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	d := &decoder{buf: buf[kFrameSizeLength:readBytes]}
+	header := d.readMessageHeader()
+	if d.err != nil {
+		return protocol.ParseResult{ParseState: protocol.Invalid}
+	}
+	if header.messageType == MessageOneway {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	var message protocol.ParsedMessage
+	if header.messageType == MessageCall {
+		req := &Request{Type: header.messageType, Method: header.name, SeqId: header.seqId, Compact: header.compact}
+		if service, method, ok := strings.Cut(header.name, ":"); ok {
+			req.Service, req.Method = service, method
+		}
+		message = req
+	} else {
+		resp := &Response{Type: header.messageType, Method: header.name, SeqId: header.seqId, Compact: header.compact}
+		decodeResult(d, resp)
+		message = resp
+	}
+
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[Thrift] failed to create FrameBase for %s", header.name)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	switch message := message.(type) {
+	case *Request:
+		message.FrameBase = fb
+	case *Response:
+		message.FrameBase = fb
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// decodeResult decodes the field set in the result struct of a reply, or
+// the message and the type of a TApplicationException.
+func decodeResult(d *decoder, resp *Response) {
+	if resp.Type == MessageReply {
+		_, resp.ResultField = d.readFieldHeader()
+		return
+	}
+	stringType, i32Type := kBinaryTypeString, kBinaryTypeI32
+	if d.compact {
+		stringType, i32Type = kCompactTypeBinary, kCompactTypeI32
+	}
+	// message(1) and type(2) are written in order, there are no other fields
+	for d.err == nil {
+		fieldType, fieldId := d.readFieldHeader()
+		switch {
+		case fieldId == 1 && fieldType == stringType:
+			resp.ExceptionMessage = d.readString(kMaxFrameSize)
+		case fieldId == 2 && fieldType == i32Type:
+			resp.ExceptionType = d.readI32()
+		default:
+			return
+		}
+	}
+}
+
+// FindBoundary looks for a frame size followed by a message header.
+func (p *ThriftStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i+kFrameSizeLength+4 <= len(buf); i++ {
+		if validFrameStart(buf[i:], messageType) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match pairs the requests and responses by sequence id, asynchronous
+// clients send requests without waiting for the previous responses.
+func (p *ThriftStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	return protocol.MatchByStreamId(reqStreams, respStreams, kMaxResponseWaitNs)
+}
@@ -0,0 +1,172 @@
+package thrift_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/protocoltest"
+	"kyanos/agent/protocol/thrift"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func frame(message []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(message))), message...)
+}
+
+// binaryMessage encodes the header of the strict binary protocol followed
+// by the fields of the struct.
+func binaryMessage(messageType thrift.MessageType, name string, seqId int32, fields []byte) []byte {
+	message := []byte{0x80, 0x01, 0x00, byte(messageType)}
+	message = binary.BigEndian.AppendUint32(message, uint32(len(name)))
+	message = append(message, name...)
+	message = binary.BigEndian.AppendUint32(message, uint32(seqId))
+	return frame(append(message, fields...))
+}
+
+func compactMessage(messageType thrift.MessageType, name string, seqId uint32, fields []byte) []byte {
+	message := []byte{0x82, byte(messageType)<<5 | 1}
+	message = binary.AppendUvarint(message, uint64(seqId))
+	message = binary.AppendUvarint(message, uint64(len(name)))
+	message = append(message, name...)
+	return frame(append(message, fields...))
+}
+
+func TestParseBinaryCall(t *testing.T) {
+	parser := thrift.NewThriftStreamParser()
+	// a string argument with id 1, the oneway call is not recorded
+	args := []byte{11, 0, 1, 0, 0, 0, 2, 'i', 'd', 0}
+	data := binaryMessage(thrift.MessageCall, "UserService:getUser", 5, args)
+	data = append(data, binaryMessage(thrift.MessageOneway, "ping", 6, []byte{0})...)
+	data = append(data, binaryMessage(thrift.MessageCall, "getUser", 7, args)...)
+	reqs := protocoltest.ParseAll(t, parser, data, protocol.Request, 10)
+	assert.Len(t, reqs, 2)
+
+	multiplexed := (*reqs[5])[0].(*thrift.Request)
+	assert.Equal(t, "UserService", multiplexed.Service)
+	assert.Equal(t, "getUser", multiplexed.Method)
+	assert.Equal(t, "UserService/getUser", multiplexed.FullMethod())
+	assert.False(t, multiplexed.Compact)
+
+	plain := (*reqs[7])[0].(*thrift.Request)
+	assert.Equal(t, "", plain.Service)
+	assert.Equal(t, "getUser", plain.FullMethod())
+}
+
+func TestMatchBinary(t *testing.T) {
+	parser := thrift.NewThriftStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, binaryMessage(thrift.MessageCall, "getUser", 1, []byte{0}), protocol.Request, 10)
+	reqs2 := protocoltest.ParseAll(t, parser, binaryMessage(thrift.MessageCall, "getUser", 2, []byte{0}), protocol.Request, 11)
+	reqs3 := protocoltest.ParseAll(t, parser, binaryMessage(thrift.MessageCall, "delete", 3, []byte{0}), protocol.Request, 12)
+	reqs[2], reqs[3] = reqs2[2], reqs3[3]
+
+	// the success i32 field 0, a declared exception in field 1 and a
+	// TApplicationException, answered out of order
+	data := binaryMessage(thrift.MessageReply, "getUser", 2, []byte{12, 0, 1, 0, 0})
+	data = append(data, binaryMessage(thrift.MessageReply, "getUser", 1, []byte{8, 0, 0, 0, 0, 0, 42, 0})...)
+	exception := []byte{11, 0, 1, 0, 0, 0, 4, 'o', 'o', 'p', 's', 8, 0, 2, 0, 0, 0, 1, 0}
+	data = append(data, binaryMessage(thrift.MessageException, "delete", 3, exception)...)
+	resps := protocoltest.ParseAll(t, parser, data, protocol.Response, 20)
+
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 3)
+	assert.Equal(t, int32(1), records[0].Request().(*thrift.Request).SeqId)
+	assert.Equal(t, protocol.SuccessStatus, records[0].ResponseStatus)
+	assert.Equal(t, int16(0), records[0].Response().(*thrift.Response).ResultField)
+
+	assert.Equal(t, int32(2), records[1].Request().(*thrift.Request).SeqId)
+	assert.Equal(t, protocol.FailStatus, records[1].ResponseStatus)
+	assert.Equal(t, int16(1), records[1].Response().(*thrift.Response).ResultField)
+
+	resp := records[2].Response().(*thrift.Response)
+	assert.Equal(t, thrift.MessageException, resp.Type)
+	assert.Equal(t, "oops", resp.ExceptionMessage)
+	assert.Equal(t, int32(1), resp.ExceptionType)
+	assert.Equal(t, protocol.FailStatus, records[2].ResponseStatus)
+	assert.Empty(t, resps)
+	assert.Empty(t, reqs)
+}
+
+func TestMatchCompact(t *testing.T) {
+	parser := thrift.NewThriftStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, compactMessage(thrift.MessageCall, "Calculator:add", 300, []byte{0x15, 0x02, 0x15, 0x04, 0}), protocol.Request, 10)
+	req := (*reqs[300])[0].(*thrift.Request)
+	assert.True(t, req.Compact)
+	assert.Equal(t, "Calculator/add", req.FullMethod())
+
+	// field 0 is written with its id as the delta can't be 0
+	data := compactMessage(thrift.MessageReply, "Calculator:add", 300, []byte{0x05, 0x00, 0x06, 0})
+	resps := protocoltest.ParseAll(t, parser, data, protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.Equal(t, protocol.SuccessStatus, records[0].ResponseStatus)
+
+	reqs = protocoltest.ParseAll(t, parser, compactMessage(thrift.MessageCall, "Calculator:div", 301, []byte{0}), protocol.Request, 30)
+	// a declared exception in field 1, written as a delta
+	data = compactMessage(thrift.MessageReply, "Calculator:div", 301, []byte{0x1c, 0})
+	resps = protocoltest.ParseAll(t, parser, data, protocol.Response, 40)
+	records = parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	assert.Equal(t, int16(1), records[0].Response().(*thrift.Response).ResultField)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+
+	reqs = protocoltest.ParseAll(t, parser, compactMessage(thrift.MessageCall, "Calculator:mod", 302, []byte{0}), protocol.Request, 50)
+	exception := []byte{0x18, 4, 'o', 'o', 'p', 's', 0x15, 0x02, 0}
+	resps = protocoltest.ParseAll(t, parser, compactMessage(thrift.MessageException, "Calculator:mod", 302, exception), protocol.Response, 60)
+	records = parser.Match(reqs, resps)
+	assert.Len(t, records, 1)
+	resp := records[0].Response().(*thrift.Response)
+	assert.Equal(t, "oops", resp.ExceptionMessage)
+	assert.Equal(t, int32(1), resp.ExceptionType)
+}
+
+func TestParseIncomplete(t *testing.T) {
+	parser := thrift.NewThriftStreamParser()
+	data := binaryMessage(thrift.MessageCall, "getUser", 1, []byte{0})
+	for _, n := range []int{1, 5, len(data) - 1} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, data[:n], 1)
+		assert.Equal(t, protocol.NeedsMoreData, parser.ParseStream(streamBuffer, protocol.Request).ParseState, n)
+	}
+	for _, data := range [][]byte{
+		[]byte("GET / HTTP/1.1\r\n\r\n"),
+		binaryMessage(thrift.MessageReply, "getUser", 1, []byte{0}),
+		frame([]byte{0x80, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}),
+		frame([]byte{0x80, 0x01, 0x00, 0x01, 0x7f, 0, 0, 0}),
+	} {
+		streamBuffer := buffer.New(1 << 20)
+		streamBuffer.Add(1, data, 1)
+		assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Request).ParseState, data)
+	}
+}
+
+func TestMatchDropsExpiredRequests(t *testing.T) {
+	parser := thrift.NewThriftStreamParser()
+	reqs := protocoltest.ParseAll(t, parser, binaryMessage(thrift.MessageCall, "getUser", 1, []byte{0}), protocol.Request, 10)
+	later := protocoltest.ParseAll(t, parser, binaryMessage(thrift.MessageCall, "getUser", 2, []byte{0}), protocol.Request, 10+61*1000*1000*1000)
+	reqs[2] = later[2]
+	records := parser.Match(reqs, map[protocol.StreamId]*protocol.ParsedMessageQueue{})
+	assert.Empty(t, records)
+	assert.Len(t, reqs, 1)
+	assert.Contains(t, reqs, protocol.StreamId(2))
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := thrift.NewThriftStreamParser()
+	data := append([]byte{0x80, 0x01, 0x00}, binaryMessage(thrift.MessageReply, "getUser", 1, []byte{0})...)
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 1)
+	assert.Equal(t, 3, parser.FindBoundary(streamBuffer, protocol.Response, 0))
+	assert.Equal(t, -1, parser.FindBoundary(streamBuffer, protocol.Request, 0))
+}
+
+func TestFilter(t *testing.T) {
+	req := &thrift.Request{Service: "UserService", Method: "getUser"}
+	assert.True(t, thrift.Filter{}.Filter(req, nil))
+	assert.True(t, thrift.Filter{TargetServices: []string{"UserService"}}.Filter(req, nil))
+	assert.False(t, thrift.Filter{TargetServices: []string{"OrderService"}}.Filter(req, nil))
+	assert.True(t, thrift.Filter{TargetMethods: []string{"getUser"}}.Filter(req, nil))
+	assert.False(t, thrift.Filter{TargetMethods: []string{"getUser"}}.Filter(&thrift.Request{Method: "delete"}, nil))
+	assert.False(t, thrift.Filter{}.FilterByRequest())
+}
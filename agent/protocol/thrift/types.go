@@ -0,0 +1,183 @@
+package thrift
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See https://github.com/apache/thrift/blob/master/doc/specs/thrift-binary-protocol.md
+// and https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md.
+// Only the messages sent with the framed transport are parsed, each one is
+// preceded by its size.
+
+const kFrameSizeLength = 4
+
+// TFramedTransport refuses frames larger than 16MB by default.
+const kMaxFrameSize = 16 << 20
+
+const (
+	// the strict binary protocol starts a message with 0x80 0x01 0x00 and
+	// the message type
+	kBinaryVersionHigh byte = 0x80
+	kBinaryVersionLow  byte = 0x01
+
+	kCompactProtocolId  byte = 0x82
+	kCompactVersion     byte = 1
+	kCompactVersionMask byte = 0x1f
+	kCompactTypeShift        = 5
+)
+
+type MessageType byte
+
+const (
+	MessageCall      MessageType = 1
+	MessageReply     MessageType = 2
+	MessageException MessageType = 3
+	MessageOneway    MessageType = 4
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MessageCall:
+		return "CALL"
+	case MessageReply:
+		return "REPLY"
+	case MessageException:
+		return "EXCEPTION"
+	case MessageOneway:
+		return "ONEWAY"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", t)
+}
+
+func (t MessageType) isRequest() bool {
+	return t == MessageCall || t == MessageOneway
+}
+
+// The types of the fields of the binary protocol.
+const (
+	kBinaryTypeStop   byte = 0
+	kBinaryTypeI32    byte = 8
+	kBinaryTypeString byte = 11
+)
+
+// The types of the fields of the compact protocol.
+const (
+	kCompactTypeStop   byte = 0
+	kCompactTypeI32    byte = 5
+	kCompactTypeBinary byte = 8
+)
+
+var _ protocol.ParsedMessage = &Request{}
+
+type Request struct {
+	protocol.FrameBase
+	Type MessageType
+	// set by TMultiplexedProtocol, which prefixes the method with the
+	// service name and a colon
+	Service string
+	Method  string
+	SeqId   int32
+	Compact bool
+}
+
+// FullMethod returns service/method, or the method if the service is not
+// known.
+func (r *Request) FullMethod() string {
+	if r.Service == "" {
+		return r.Method
+	}
+	return r.Service + "/" + r.Method
+}
+
+func encodingName(compact bool) string {
+	if compact {
+		return "compact"
+	}
+	return "binary"
+}
+
+func (r *Request) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] type=[%s] protocol=[%s]", r.FrameBase.String(), r.Type, encodingName(r.Compact))
+	if r.Service != "" {
+		fmt.Fprintf(&b, " service=[%s]", r.Service)
+	}
+	fmt.Fprintf(&b, " method=[%s] seqid=[%d]", r.Method, r.SeqId)
+	return b.String()
+}
+
+func (r *Request) IsReq() bool {
+	return true
+}
+
+func (r *Request) StreamId() protocol.StreamId {
+	return protocol.StreamId(uint32(r.SeqId))
+}
+
+// The types of TApplicationException.
+var applicationExceptionTypes = map[int32]string{
+	0:  "UNKNOWN",
+	1:  "UNKNOWN_METHOD",
+	2:  "INVALID_MESSAGE_TYPE",
+	3:  "WRONG_METHOD_NAME",
+	4:  "BAD_SEQUENCE_ID",
+	5:  "MISSING_RESULT",
+	6:  "INTERNAL_ERROR",
+	7:  "PROTOCOL_ERROR",
+	8:  "INVALID_TRANSFORM",
+	9:  "INVALID_PROTOCOL",
+	10: "UNSUPPORTED_CLIENT_TYPE",
+}
+
+var _ protocol.ParsedMessage = &Response{}
+var _ protocol.StatusfulMessage = &Response{}
+
+type Response struct {
+	protocol.FrameBase
+	Type    MessageType
+	Method  string
+	SeqId   int32
+	Compact bool
+	// the id of the field set in the result struct of a reply, 0 is the
+	// return value and the others are the exceptions declared by the method
+	ResultField int16
+	// the TApplicationException of an EXCEPTION message
+	ExceptionMessage string
+	ExceptionType    int32
+}
+
+func (r *Response) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s] type=[%s] protocol=[%s] method=[%s] seqid=[%d]", r.FrameBase.String(), r.Type, encodingName(r.Compact), r.Method, r.SeqId)
+	switch {
+	case r.Type == MessageException:
+		fmt.Fprintf(&b, " exception=[%s %s]", applicationExceptionTypes[r.ExceptionType], r.ExceptionMessage)
+	case r.ResultField > 0:
+		fmt.Fprintf(&b, " declared_exception=[field %d]", r.ResultField)
+	}
+	return b.String()
+}
+
+func (r *Response) IsReq() bool {
+	return false
+}
+
+func (r *Response) StreamId() protocol.StreamId {
+	return protocol.StreamId(uint32(r.SeqId))
+}
+
+// Status fails on a TApplicationException, and when the method throws one
+// of the exceptions it declares.
+func (r *Response) Status() protocol.ResponseStatus {
+	if r.Type == MessageException || r.ResultField > 0 {
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+var _ protocol.ProtocolStreamParser = &ThriftStreamParser{}
+
+type ThriftStreamParser struct {
+}
@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+)
+
+// Filter keeps the messages of the upgraded connections and the handshakes
+// which upgraded them, the other HTTP records are dropped.
+type Filter struct {
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	if httpResp, ok := resp.(*protocol.ParsedHttpResponse); ok {
+		return httpResp.Upgrade == "websocket"
+	}
+	_, ok := req.(*Message)
+	return ok
+}
+
+// FilterByProtocol accepts HTTP too, a connection is HTTP until it is
+// upgraded.
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolWebSocket || p == bpf.AgentTrafficProtocolTKProtocolHTTP
+}
+
+func (f Filter) FilterByRequest() bool {
+	return true
+}
+
+func (f Filter) FilterByResponse() bool {
+	return true
+}
+
+// Protocol is HTTP, the protocol the kernel infers for the connections before
+// they are upgraded.
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolHTTP
+}
+
+var _ protocol.ProtocolFilter = Filter{}
@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See https://www.rfc-editor.org/rfc/rfc6455. The connection starts as an
+// HTTP/1.1 request answered with 101 Switching Protocols, the frames follow
+// on the same connection.
+
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xa
+)
+
+var opcodeNames = map[Opcode]string{
+	OpContinuation: "continuation",
+	OpText:         "text",
+	OpBinary:       "binary",
+	OpClose:        "close",
+	OpPing:         "ping",
+	OpPong:         "pong",
+}
+
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", byte(op))
+}
+
+func (op Opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// The close codes of section 7.4.1, 1000 is a normal closure and 1001 an
+// endpoint going away.
+const (
+	CloseNormal    uint16 = 1000
+	CloseGoingAway uint16 = 1001
+)
+
+var closeCodeNames = map[uint16]string{
+	1000: "normal closure",
+	1001: "going away",
+	1002: "protocol error",
+	1003: "unsupported data",
+	1005: "no status received",
+	1006: "abnormal closure",
+	1007: "invalid payload data",
+	1008: "policy violation",
+	1009: "message too big",
+	1010: "mandatory extension",
+	1011: "internal error",
+	1012: "service restart",
+	1013: "try again later",
+	1014: "bad gateway",
+	1015: "TLS handshake",
+}
+
+func CloseCodeName(code uint16) string {
+	if name, ok := closeCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", code)
+}
+
+var _ protocol.ParsedMessage = &Message{}
+var _ protocol.StatusfulMessage = &Message{}
+
+// Message is a data message, which may be sent in several frames, or a
+// control frame.
+type Message struct {
+	protocol.FrameBase
+	Opcode Opcode
+	// the frames the message was sent in, more than one if it is fragmented
+	Frames int
+	// the frames of the client are masked, those of the server are not
+	Masked bool
+	// RSV1 of the first frame, set when permessage-deflate compressed the
+	// payload
+	Compressed  bool
+	PayloadSize int
+	// the beginning of the unmasked payload
+	Payload []byte
+	// the status code and the reason of a close frame, 0 if it has none
+	CloseCode   uint16
+	CloseReason string
+	// the value of the id field of a JSON text message
+	Id string
+	// Empty stands for the other side of a message which is not paired,
+	// it has no size
+	Empty bool
+	isReq bool
+}
+
+// emptyMessage returns the other side of the record of a message which is
+// not paired.
+func emptyMessage(m *Message) *Message {
+	return &Message{
+		FrameBase: protocol.NewFrameBase(m.TimestampNs(), 0, 0),
+		Opcode:    m.Opcode,
+		Empty:     true,
+		isReq:     !m.isReq,
+	}
+}
+
+// Sender returns client or server.
+func (m *Message) Sender() string {
+	if m.isReq {
+		return "client"
+	}
+	return "server"
+}
+
+func (m *Message) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s]", m.FrameBase.String())
+	if m.Empty {
+		fmt.Fprintf(&b, " none, the %s message is not paired", m.Opcode)
+		return b.String()
+	}
+	fmt.Fprintf(&b, " sender=[%s] opcode=[%s] frames=[%d] masked=[%t]", m.Sender(), m.Opcode, m.Frames, m.Masked)
+	if m.Compressed {
+		b.WriteString(" compressed=[true]")
+	}
+	if m.Id != "" {
+		fmt.Fprintf(&b, " id=[%s]", m.Id)
+	}
+	if m.Opcode == OpClose && m.CloseCode != 0 {
+		fmt.Fprintf(&b, " close_code=[%d %s] reason=[%s]", m.CloseCode, CloseCodeName(m.CloseCode), m.CloseReason)
+	}
+	fmt.Fprintf(&b, " payload_size=[%d]", m.PayloadSize)
+	if m.Opcode != OpBinary && !m.Compressed && len(m.Payload) > 0 {
+		fmt.Fprintf(&b, " payload=[%s]", m.Payload)
+	}
+	return b.String()
+}
+
+func (m *Message) IsReq() bool {
+	return m.isReq
+}
+
+// StreamId is 0 for the messages which are not paired, and the hash of the
+// id otherwise.
+func (m *Message) StreamId() protocol.StreamId {
+	if m.Id == "" {
+		return kUnpairedStreamId
+	}
+	return protocol.StreamId(hashId(m.Id))
+}
+
+// Status fails on a close frame whose code is not a normal closure or an
+// endpoint going away.
+func (m *Message) Status() protocol.ResponseStatus {
+	if m.Opcode == OpClose && m.CloseCode != 0 && m.CloseCode != CloseNormal && m.CloseCode != CloseGoingAway {
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+var _ protocol.ProtocolStreamParser = &WebSocketStreamParser{}
+
+type WebSocketStreamParser struct {
+	// the id field of the JSON text messages by which a message of the
+	// client is paired with the reply of the server, no pairing if empty
+	idField []string
+	// the data messages being reassembled from their fragments, by sender
+	fragmented map[bool]*fragmentedMessage
+	// the latest timestamp of the messages, the messages of the client
+	// waiting for a reply are shown alone once they are too old
+	latest uint64
+}
@@ -0,0 +1,317 @@
+package websocket
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+	"strings"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolWebSocket] = func() protocol.ProtocolStreamParser {
+		return NewWebSocketStreamParser(IdField)
+	}
+}
+
+// IdField is the field of the JSON text messages, like id or a dotted path
+// like meta.requestId, by which a message of the client is paired with the
+// reply of the server. The messages are not paired if it is empty.
+var IdField string
+
+func NewWebSocketStreamParser(idField string) *WebSocketStreamParser {
+	parser := &WebSocketStreamParser{fragmented: make(map[bool]*fragmentedMessage)}
+	if idField != "" {
+		parser.idField = strings.Split(idField, ".")
+	}
+	return parser
+}
+
+// A message of the client not replied within kMaxResponseWaitNs is shown
+// alone.
+const kMaxResponseWaitNs uint64 = 60 * 1000 * 1000 * 1000
+
+const kUnpairedStreamId protocol.StreamId = 0
+
+// The payload length can encode 2^63 bytes, the frames larger than
+// kMaxFrameSize are not parsed.
+const kMaxFrameSize = 16 << 20
+
+const kMaxControlPayload = 125
+
+// Only the beginning of the payload is kept, and only the text messages up
+// to kMaxIdPayload bytes are searched for the id.
+const (
+	kMaxPayloadKept = 1024
+	kMaxIdPayload   = 64 << 10
+)
+
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	opcode Opcode
+	masked bool
+	mask   [4]byte
+	// the length of the header including the masking key
+	length        int
+	payloadLength int
+}
+
+// parseFrameHeader checks the header of a frame sent by the client if
+// messageType is Request, by the server if it is Response, or by either.
+func parseFrameHeader(buf []byte, messageType protocol.MessageType) (frameHeader, protocol.ParseState) {
+	var h frameHeader
+	if len(buf) < 2 {
+		return h, protocol.NeedsMoreData
+	}
+	h.fin = buf[0]&0x80 != 0
+	h.rsv1 = buf[0]&0x40 != 0
+	h.opcode = Opcode(buf[0] & 0x0f)
+	h.masked = buf[1]&0x80 != 0
+	// RSV2 and RSV3 are not used by any registered extension
+	if _, known := opcodeNames[h.opcode]; !known || buf[0]&0x30 != 0 {
+		return h, protocol.Invalid
+	}
+	// the client masks all its frames and the server none
+	if (messageType == protocol.Request && !h.masked) || (messageType == protocol.Response && h.masked) {
+		return h, protocol.Invalid
+	}
+	payloadLength := uint64(buf[1] & 0x7f)
+	h.length = 2
+	// the payload length is encoded in the fewest bytes
+	switch payloadLength {
+	case 126:
+		if len(buf) < 4 {
+			return h, protocol.NeedsMoreData
+		}
+		payloadLength = uint64(binary.BigEndian.Uint16(buf[2:]))
+		if payloadLength < 126 {
+			return h, protocol.Invalid
+		}
+		h.length = 4
+	case 127:
+		if len(buf) < 10 {
+			return h, protocol.NeedsMoreData
+		}
+		payloadLength = binary.BigEndian.Uint64(buf[2:])
+		if payloadLength <= 0xffff || payloadLength > kMaxFrameSize {
+			return h, protocol.Invalid
+		}
+		h.length = 10
+	}
+	if h.opcode.isControl() && (!h.fin || h.rsv1 || payloadLength > kMaxControlPayload) {
+		return h, protocol.Invalid
+	}
+	if h.masked {
+		if len(buf) < h.length+4 {
+			return h, protocol.NeedsMoreData
+		}
+		copy(h.mask[:], buf[h.length:])
+		h.length += 4
+	}
+	h.payloadLength = int(payloadLength)
+	return h, protocol.Success
+}
+
+// unmask returns a copy of the first n bytes of payload without the mask.
+func unmask(payload []byte, h frameHeader, n int) []byte {
+	data := bytes.Clone(payload[:min(n, len(payload))])
+	if h.masked {
+		for i := range data {
+			data[i] ^= h.mask[i%4]
+		}
+	}
+	return data
+}
+
+// fragmentedMessage is a data message whose final frame is not parsed yet.
+type fragmentedMessage struct {
+	message *Message
+	// the unmasked payload, up to kMaxIdPayload bytes
+	data      []byte
+	truncated bool
+}
+
+func (f *fragmentedMessage) add(payload []byte, h frameHeader) {
+	f.message.Frames++
+	f.message.PayloadSize += h.payloadLength
+	n := kMaxIdPayload - len(f.data)
+	if h.payloadLength > n {
+		f.truncated = true
+	}
+	f.data = append(f.data, unmask(payload, h, n)...)
+}
+
+func (p *WebSocketStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	h, state := parseFrameHeader(buf, messageType)
+	if state != protocol.Success {
+		return protocol.ParseResult{ParseState: state}
+	}
+	readBytes := h.length + h.payloadLength
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	payload := buf[h.length:readBytes]
+	isReq := h.masked
+
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[WebSocket] failed to create FrameBase for %s frame", h.opcode)
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+
+	// control frames may be sent between the fragments of a message
+	if h.opcode.isControl() {
+		message := &Message{FrameBase: fb, Opcode: h.opcode, Frames: 1, Masked: h.masked, PayloadSize: h.payloadLength, isReq: isReq}
+		data := unmask(payload, h, kMaxControlPayload)
+		if h.opcode == OpClose && len(data) >= 2 {
+			message.CloseCode = binary.BigEndian.Uint16(data)
+			message.CloseReason = string(data[2:])
+		} else if h.opcode != OpClose {
+			message.Payload = data
+		}
+		return protocol.ParseResult{
+			ParseState:     protocol.Success,
+			ReadBytes:      readBytes,
+			ParsedMessages: []protocol.ParsedMessage{message},
+		}
+	}
+
+	pending := p.fragmented[isReq]
+	if h.opcode == OpContinuation {
+		if pending == nil {
+			// the beginning of the message was not captured
+			return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+		}
+		pending.message.IncrByteSize(readBytes)
+	} else {
+		// a message whose final frame was lost is dropped
+		pending = &fragmentedMessage{
+			message: &Message{FrameBase: fb, Opcode: h.opcode, Masked: h.masked, Compressed: h.rsv1, isReq: isReq},
+		}
+		p.fragmented[isReq] = pending
+	}
+	pending.add(payload, h)
+	if !h.fin {
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	delete(p.fragmented, isReq)
+
+	message := pending.message
+	message.Payload = pending.data[:min(len(pending.data), kMaxPayloadKept)]
+	if message.Opcode == OpText && !message.Compressed && !pending.truncated && len(p.idField) > 0 {
+		message.Id = jsonId(pending.data, p.idField)
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+// jsonId returns the string or number at path in a JSON object, or an empty
+// string.
+func jsonId(data []byte, path []string) string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return ""
+	}
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
+
+func hashId(id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	// 0 is the stream of the messages which are not paired
+	return max(h.Sum64(), 1)
+}
+
+// FindBoundary looks for a frame header which is followed by the end of the
+// buffer or another frame header.
+func (p *WebSocketStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i+2 <= len(buf); i++ {
+		h, state := parseFrameHeader(buf[i:], messageType)
+		if state != protocol.Success {
+			continue
+		}
+		end := i + h.length + h.payloadLength
+		if end == len(buf) {
+			return i
+		}
+		if end < len(buf) {
+			if _, next := parseFrameHeader(buf[end:], messageType); next != protocol.Invalid {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func unpairedRecord(message *Message) protocol.Record {
+	record := protocol.Record{Req: message, Resp: emptyMessage(message), ResponseStatus: message.Status()}
+	if !message.isReq {
+		record.Req, record.Resp = record.Resp, record.Req
+	}
+	return record
+}
+
+// Match pairs a message of the client with the reply of the server which has
+// the same id. Each of the other messages, sent by the client or pushed by
+// the server, is a record of its own whose other side is empty.
+func (p *WebSocketStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	records := []protocol.Record{}
+	for streamId, respQueue := range respStreams {
+		reqQueue := reqStreams[streamId]
+		for _, resp := range *respQueue {
+			p.latest = max(p.latest, resp.TimestampNs())
+			if streamId != kUnpairedStreamId && reqQueue != nil && len(*reqQueue) > 0 && (*reqQueue)[0].TimestampNs() <= resp.TimestampNs() {
+				records = append(records, protocol.Record{Req: (*reqQueue)[0], Resp: resp, ResponseStatus: resp.(*Message).Status()})
+				*reqQueue = (*reqQueue)[1:]
+				continue
+			}
+			records = append(records, unpairedRecord(resp.(*Message)))
+		}
+		delete(respStreams, streamId)
+	}
+	for _, reqQueue := range reqStreams {
+		if len(*reqQueue) > 0 {
+			p.latest = max(p.latest, (*reqQueue)[len(*reqQueue)-1].TimestampNs())
+		}
+	}
+	for streamId, reqQueue := range reqStreams {
+		for len(*reqQueue) > 0 && (streamId == kUnpairedStreamId || (*reqQueue)[0].TimestampNs()+kMaxResponseWaitNs < p.latest) {
+			records = append(records, unpairedRecord((*reqQueue)[0].(*Message)))
+			*reqQueue = (*reqQueue)[1:]
+		}
+		if len(*reqQueue) == 0 {
+			delete(reqStreams, streamId)
+		}
+	}
+	slices.SortFunc(records, func(r1, r2 protocol.Record) int {
+		return cmp.Compare(r1.Req.TimestampNs(), r2.Req.TimestampNs())
+	})
+	return records
+}
@@ -0,0 +1,217 @@
+package websocket_test
+
+import (
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/protocoltest"
+	"kyanos/agent/protocol/websocket"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func messages(streams map[protocol.StreamId]*protocol.ParsedMessageQueue) []*websocket.Message {
+	var result []*websocket.Message
+	for _, queue := range streams {
+		for _, message := range *queue {
+			result = append(result, message.(*websocket.Message))
+		}
+	}
+	return result
+}
+
+var mask = [4]byte{0x37, 0xfa, 0x21, 0x3d}
+
+// frame encodes a frame, masked if it is sent by the client.
+func frame(fin bool, opcode websocket.Opcode, payload []byte, client bool) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	var maskBit byte
+	if client {
+		maskBit = 0x80
+	}
+	buf := []byte{b0}
+	switch {
+	case len(payload) < 126:
+		buf = append(buf, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+	if !client {
+		return append(buf, payload...)
+	}
+	buf = append(buf, mask[:]...)
+	for i, c := range payload {
+		buf = append(buf, c^mask[i%4])
+	}
+	return buf
+}
+
+func closeFrame(code uint16, reason string, client bool) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return frame(true, websocket.OpClose, append(payload, reason...), client)
+}
+
+func TestParseMaskedAndUnmaskedText(t *testing.T) {
+	parser := websocket.NewWebSocketStreamParser("")
+	reqs := messages(protocoltest.ParseAll(t, parser, frame(true, websocket.OpText, []byte("Hello"), true), protocol.Request, 10))
+	resps := messages(protocoltest.ParseAll(t, parser, frame(true, websocket.OpText, []byte("Hello"), false), protocol.Response, 20))
+
+	assert.Len(t, reqs, 1)
+	assert.True(t, reqs[0].IsReq())
+	assert.True(t, reqs[0].Masked)
+	assert.Equal(t, "Hello", string(reqs[0].Payload))
+	assert.Equal(t, 11, reqs[0].ByteSize())
+	assert.Len(t, resps, 1)
+	assert.False(t, resps[0].IsReq())
+	assert.Equal(t, "Hello", string(resps[0].Payload))
+	assert.Equal(t, 7, resps[0].ByteSize())
+}
+
+func TestParseFragmentedMessageWithPing(t *testing.T) {
+	data := frame(false, websocket.OpText, []byte("Hel"), false)
+	data = append(data, frame(true, websocket.OpPing, []byte("p"), false)...)
+	data = append(data, frame(true, websocket.OpContinuation, []byte("lo"), false)...)
+	parser := websocket.NewWebSocketStreamParser("")
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 10)
+
+	var parsed []*websocket.Message
+	for !streamBuffer.IsEmpty() {
+		result := parser.ParseStream(streamBuffer, protocol.Response)
+		for _, message := range result.ParsedMessages {
+			parsed = append(parsed, message.(*websocket.Message))
+		}
+		streamBuffer.RemovePrefix(result.ReadBytes)
+	}
+
+	assert.Len(t, parsed, 2)
+	assert.Equal(t, websocket.OpPing, parsed[0].Opcode)
+	assert.Equal(t, websocket.OpText, parsed[1].Opcode)
+	assert.Equal(t, 2, parsed[1].Frames)
+	assert.Equal(t, 5, parsed[1].PayloadSize)
+	assert.Equal(t, "Hello", string(parsed[1].Payload))
+	assert.Equal(t, len(data)-3, parsed[1].ByteSize())
+}
+
+func TestParseExtendedPayloadLength(t *testing.T) {
+	for _, size := range []int{200, 70000} {
+		payload := make([]byte, size)
+		parser := websocket.NewWebSocketStreamParser("")
+		parsed := messages(protocoltest.ParseAll(t, parser, frame(true, websocket.OpBinary, payload, true), protocol.Request, 10))
+		assert.Len(t, parsed, 1)
+		assert.Equal(t, size, parsed[0].PayloadSize)
+		assert.LessOrEqual(t, len(parsed[0].Payload), 1024)
+	}
+}
+
+func TestParseNonMinimalLength(t *testing.T) {
+	// a payload of 5 bytes encoded in the 16-bit length
+	data := append([]byte{0x81, 126, 0, 5}, "Hello"...)
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 10)
+	result := websocket.NewWebSocketStreamParser("").ParseStream(streamBuffer, protocol.Response)
+	assert.Equal(t, protocol.Invalid, result.ParseState)
+}
+
+func TestParseMaskingByDirection(t *testing.T) {
+	parser := websocket.NewWebSocketStreamParser("")
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, frame(true, websocket.OpText, []byte("Hello"), false), 10)
+	assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Request).ParseState)
+
+	streamBuffer = buffer.New(1 << 20)
+	streamBuffer.Add(1, frame(true, websocket.OpText, []byte("Hello"), true), 10)
+	assert.Equal(t, protocol.Invalid, parser.ParseStream(streamBuffer, protocol.Response).ParseState)
+}
+
+func TestCloseCodeStatus(t *testing.T) {
+	parser := websocket.NewWebSocketStreamParser("")
+	resps := protocoltest.ParseAll(t, parser, closeFrame(1011, "boom", false), protocol.Response, 20)
+	reqs := protocoltest.ParseAll(t, parser, closeFrame(websocket.CloseNormal, "", true), protocol.Request, 10)
+	records := parser.Match(reqs, resps)
+
+	assert.Len(t, records, 2)
+	assert.Equal(t, uint16(websocket.CloseNormal), records[0].Req.(*websocket.Message).CloseCode)
+	assert.Equal(t, protocol.SuccessStatus, records[0].ResponseStatus)
+	assert.Equal(t, "boom", records[1].Resp.(*websocket.Message).CloseReason)
+	assert.Equal(t, protocol.FailStatus, records[1].ResponseStatus)
+}
+
+func TestMatchUnpairedMessages(t *testing.T) {
+	parser := websocket.NewWebSocketStreamParser("")
+	reqs := protocoltest.ParseAll(t, parser, frame(true, websocket.OpText, []byte("subscribe"), true), protocol.Request, 10)
+	resps := protocoltest.ParseAll(t, parser, frame(true, websocket.OpText, []byte("tick"), false), protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+
+	assert.Len(t, records, 2)
+	assert.Equal(t, "subscribe", string(records[0].Req.(*websocket.Message).Payload))
+	assert.True(t, records[0].Resp.(*websocket.Message).Empty)
+	assert.Equal(t, 0, records[0].Resp.ByteSize())
+	assert.True(t, records[1].Req.(*websocket.Message).Empty)
+	assert.Equal(t, 0, records[1].Req.ByteSize())
+	assert.Equal(t, "tick", string(records[1].Resp.(*websocket.Message).Payload))
+	assert.Empty(t, reqs)
+	assert.Empty(t, resps)
+}
+
+func TestMatchById(t *testing.T) {
+	parser := websocket.NewWebSocketStreamParser("meta.requestId")
+	data := frame(true, websocket.OpText, []byte(`{"meta":{"requestId":7},"op":"get"}`), true)
+	data = append(data, frame(true, websocket.OpText, []byte(`{"meta":{"requestId":"8"},"op":"get"}`), true)...)
+	reqs := protocoltest.ParseAll(t, parser, data, protocol.Request, 10)
+	data = frame(true, websocket.OpText, []byte(`{"meta":{"requestId":"8"},"result":1}`), false)
+	data = append(data, frame(true, websocket.OpText, []byte(`{"event":"tick"}`), false)...)
+	resps := protocoltest.ParseAll(t, parser, data, protocol.Response, 20)
+	records := parser.Match(reqs, resps)
+
+	assert.Len(t, records, 2)
+	var paired, pushed protocol.Record
+	for _, record := range records {
+		if record.Req.(*websocket.Message).Empty {
+			pushed = record
+		} else {
+			paired = record
+		}
+	}
+	assert.Equal(t, "8", paired.Req.(*websocket.Message).Id)
+	assert.Equal(t, "8", paired.Resp.(*websocket.Message).Id)
+	assert.Contains(t, string(pushed.Resp.(*websocket.Message).Payload), "tick")
+	// the request 7 waits for its reply
+	assert.Len(t, reqs, 1)
+}
+
+func TestMatchFlushesStaleRequests(t *testing.T) {
+	parser := websocket.NewWebSocketStreamParser("id")
+	reqs := protocoltest.ParseAll(t, parser, frame(true, websocket.OpText, []byte(`{"id":1}`), true), protocol.Request, 10)
+	resps := map[protocol.StreamId]*protocol.ParsedMessageQueue{}
+	assert.Empty(t, parser.Match(reqs, resps))
+
+	later := uint64(10 + 61*1000*1000*1000)
+	resps = protocoltest.ParseAll(t, parser, frame(true, websocket.OpText, []byte(`{"id":2}`), false), protocol.Response, later)
+	records := parser.Match(reqs, resps)
+
+	assert.Len(t, records, 2)
+	assert.Equal(t, "1", records[0].Req.(*websocket.Message).Id)
+	assert.True(t, records[0].Resp.(*websocket.Message).Empty)
+	assert.True(t, records[1].Req.(*websocket.Message).Empty)
+	assert.Empty(t, reqs)
+}
+
+func TestFindBoundary(t *testing.T) {
+	data := []byte("garbage")
+	start := len(data)
+	data = append(data, frame(true, websocket.OpText, []byte("Hello"), false)...)
+	data = append(data, frame(true, websocket.OpBinary, []byte("world"), false)...)
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 10)
+
+	assert.Equal(t, start, websocket.NewWebSocketStreamParser("").FindBoundary(streamBuffer, protocol.Response, 0))
+}
@@ -0,0 +1,44 @@
+package zookeeper
+
+import (
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+	"strings"
+)
+
+type Filter struct {
+	PathPrefix string
+}
+
+func (f Filter) Filter(req protocol.ParsedMessage, resp protocol.ParsedMessage) bool {
+	zookeeperReq, ok := req.(*Request)
+	if !ok {
+		common.ProtocolParserLog.Warnf("[ZookeeperFilter] cast to zookeeper.Request failed: %v\n", req)
+		return false
+	}
+	// a multi passes if any of its operations does, a notification by the
+	// path of its watch
+	return slices.ContainsFunc(zookeeperReq.Paths(), func(path string) bool {
+		return strings.HasPrefix(path, f.PathPrefix)
+	})
+}
+
+func (f Filter) FilterByProtocol(p bpf.AgentTrafficProtocolT) bool {
+	return p == bpf.AgentTrafficProtocolTKProtocolZooKeeper
+}
+
+func (f Filter) FilterByRequest() bool {
+	return f.PathPrefix != ""
+}
+
+func (f Filter) FilterByResponse() bool {
+	return false
+}
+
+func (Filter) Protocol() bpf.AgentTrafficProtocolT {
+	return bpf.AgentTrafficProtocolTKProtocolZooKeeper
+}
+
+var _ protocol.ProtocolFilter = Filter{}
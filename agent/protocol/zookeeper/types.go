@@ -0,0 +1,356 @@
+package zookeeper
+
+import (
+	"fmt"
+	"kyanos/agent/protocol"
+	"strings"
+)
+
+// See zookeeper-jute/src/main/resources/zookeeper.jute and ZooDefs.OpCode of
+// Apache ZooKeeper. Every packet is preceded by its length, requests start
+// with the xid and the op code, replies with the xid, the zxid and the error
+// code. The connect request and response have no header.
+
+const kLengthFieldSize = 4
+
+// jute.maxbuffer is 1MB by default and can be raised on both sides.
+const kMaxPacketLength = 16 << 20
+
+// The xids the client uses for the packets which are not operations, the
+// others count up from 1.
+const (
+	kXidConnect      int32 = 0
+	kXidNotification int32 = -1
+	kXidPing         int32 = -2
+	kXidAuth         int32 = -4
+	kXidSetWatches   int32 = -8
+)
+
+type OpCode int32
+
+const (
+	OpNotification         OpCode = 0
+	OpCreate               OpCode = 1
+	OpDelete               OpCode = 2
+	OpExists               OpCode = 3
+	OpGetData              OpCode = 4
+	OpSetData              OpCode = 5
+	OpGetACL               OpCode = 6
+	OpSetACL               OpCode = 7
+	OpGetChildren          OpCode = 8
+	OpSync                 OpCode = 9
+	OpPing                 OpCode = 11
+	OpGetChildren2         OpCode = 12
+	OpCheck                OpCode = 13
+	OpMulti                OpCode = 14
+	OpCreate2              OpCode = 15
+	OpReconfig             OpCode = 16
+	OpCheckWatches         OpCode = 17
+	OpRemoveWatches        OpCode = 18
+	OpCreateContainer      OpCode = 19
+	OpDeleteContainer      OpCode = 20
+	OpCreateTTL            OpCode = 21
+	OpMultiRead            OpCode = 22
+	OpAuth                 OpCode = 100
+	OpSetWatches           OpCode = 101
+	OpSasl                 OpCode = 102
+	OpGetEphemerals        OpCode = 103
+	OpGetAllChildrenNumber OpCode = 104
+	OpSetWatches2          OpCode = 105
+	OpAddWatch             OpCode = 106
+	OpWhoAmI               OpCode = 107
+	OpCreateSession        OpCode = -10
+	OpCloseSession         OpCode = -11
+	// the op code of a failed operation in the result of multi
+	OpError OpCode = -1
+)
+
+var opCodeNames = map[OpCode]string{
+	OpNotification:         "notification",
+	OpCreate:               "create",
+	OpDelete:               "delete",
+	OpExists:               "exists",
+	OpGetData:              "getData",
+	OpSetData:              "setData",
+	OpGetACL:               "getACL",
+	OpSetACL:               "setACL",
+	OpGetChildren:          "getChildren",
+	OpSync:                 "sync",
+	OpPing:                 "ping",
+	OpGetChildren2:         "getChildren2",
+	OpCheck:                "check",
+	OpMulti:                "multi",
+	OpCreate2:              "create2",
+	OpReconfig:             "reconfig",
+	OpCheckWatches:         "checkWatches",
+	OpRemoveWatches:        "removeWatches",
+	OpCreateContainer:      "createContainer",
+	OpDeleteContainer:      "deleteContainer",
+	OpCreateTTL:            "createTTL",
+	OpMultiRead:            "multiRead",
+	OpAuth:                 "auth",
+	OpSetWatches:           "setWatches",
+	OpSasl:                 "sasl",
+	OpGetEphemerals:        "getEphemerals",
+	OpGetAllChildrenNumber: "getAllChildrenNumber",
+	OpSetWatches2:          "setWatches2",
+	OpAddWatch:             "addWatch",
+	OpWhoAmI:               "whoAmI",
+	OpCreateSession:        "createSession",
+	OpCloseSession:         "closeSession",
+}
+
+func (op OpCode) String() string {
+	if name, ok := opCodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int32(op))
+}
+
+func (op OpCode) isCreate() bool {
+	return op == OpCreate || op == OpCreate2 || op == OpCreateContainer || op == OpCreateTTL
+}
+
+// The error codes of KeeperException.Code.
+const (
+	ErrOk     int32 = 0
+	ErrNoNode int32 = -101
+)
+
+var errorNames = map[int32]string{
+	0:    "OK",
+	-1:   "SYSTEMERROR",
+	-2:   "RUNTIMEINCONSISTENCY",
+	-3:   "DATAINCONSISTENCY",
+	-4:   "CONNECTIONLOSS",
+	-5:   "MARSHALLINGERROR",
+	-6:   "UNIMPLEMENTED",
+	-7:   "OPERATIONTIMEOUT",
+	-8:   "BADARGUMENTS",
+	-13:  "NEWCONFIGNOQUORUM",
+	-14:  "RECONFIGINPROGRESS",
+	-15:  "UNKNOWNSESSION",
+	-100: "APIERROR",
+	-101: "NONODE",
+	-102: "NOAUTH",
+	-103: "BADVERSION",
+	-104: "NOCHILDRENFOREPHEMERALS",
+	-105: "NODEEXISTS",
+	-106: "NOTEMPTY",
+	-107: "SESSIONEXPIRED",
+	-108: "INVALIDCALLBACK",
+	-109: "INVALIDACL",
+	-110: "AUTHFAILED",
+	-111: "SESSIONMOVED",
+	-112: "NOTREADONLY",
+	-113: "EPHEMERALONLOCALSESSION",
+	-114: "NOWATCHER",
+	-115: "RECONFIGDISABLED",
+	-116: "SESSIONCLOSEDREQUIRESASLAUTH",
+	-117: "QUOTAEXCEEDED",
+	-118: "THROTTLEDOP",
+}
+
+func ErrorName(err int32) string {
+	if name, ok := errorNames[err]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", err)
+}
+
+var eventTypeNames = map[int32]string{
+	-1: "None",
+	1:  "NodeCreated",
+	2:  "NodeDeleted",
+	3:  "NodeDataChanged",
+	4:  "NodeChildrenChanged",
+	5:  "DataWatchRemoved",
+	6:  "ChildWatchRemoved",
+	7:  "PersistentWatchRemoved",
+}
+
+var keeperStateNames = map[int32]string{
+	0:    "Disconnected",
+	3:    "SyncConnected",
+	4:    "AuthFailed",
+	5:    "ConnectedReadOnly",
+	6:    "SaslAuthenticated",
+	7:    "Closed",
+	-112: "Expired",
+}
+
+func nameOf(names map[int32]string, value int32) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%d)", value)
+}
+
+// MultiOp is an operation of multi or multiRead.
+type MultiOp struct {
+	OpCode OpCode
+	Path   string
+}
+
+var _ protocol.ParsedMessage = &Request{}
+
+type Request struct {
+	protocol.FrameBase
+	Xid    int32
+	OpCode OpCode
+	Path   string
+	// the data written by create and setData
+	DataSize int
+	Watch    bool
+	// the expected version of delete, setData and check, -1 matches any
+	Version int32
+	// the CreateMode of the create operations
+	CreateFlags int32
+	Ops         []MultiOp
+	// the connect request
+	ProtocolVersion int32
+	LastZxidSeen    int64
+	Timeout         int32
+	SessionId       int64
+	// a watch notification pushed by the server, it is not sent by the
+	// client and has no size
+	Push bool
+}
+
+// Paths returns the path of the operation, or the paths of the operations
+// of multi.
+func (r *Request) Paths() []string {
+	if len(r.Ops) == 0 {
+		if r.Path == "" {
+			return nil
+		}
+		return []string{r.Path}
+	}
+	paths := make([]string, len(r.Ops))
+	for i, op := range r.Ops {
+		paths[i] = op.Path
+	}
+	return paths
+}
+
+func (r *Request) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s]", r.FrameBase.String())
+	switch {
+	case r.Push:
+		fmt.Fprintf(&b, " server push, watch notification path=[%s]", r.Path)
+		return b.String()
+	case r.Xid == kXidConnect:
+		fmt.Fprintf(&b, " connect protocol_version=[%d] session_id=[0x%x] timeout=[%dms] last_zxid_seen=[0x%x]", r.ProtocolVersion, r.SessionId, r.Timeout, r.LastZxidSeen)
+		return b.String()
+	}
+	fmt.Fprintf(&b, " xid=[%d] op=[%s]", r.Xid, r.OpCode)
+	if r.Path != "" {
+		fmt.Fprintf(&b, " path=[%s]", r.Path)
+	}
+	switch {
+	case r.OpCode.isCreate():
+		fmt.Fprintf(&b, " flags=[%d] data_size=[%d]", r.CreateFlags, r.DataSize)
+	case r.OpCode == OpSetData:
+		fmt.Fprintf(&b, " version=[%d] data_size=[%d]", r.Version, r.DataSize)
+	case r.OpCode == OpDelete || r.OpCode == OpCheck:
+		fmt.Fprintf(&b, " version=[%d]", r.Version)
+	case r.OpCode == OpExists || r.OpCode == OpGetData || r.OpCode == OpGetChildren || r.OpCode == OpGetChildren2:
+		fmt.Fprintf(&b, " watch=[%t]", r.Watch)
+	}
+	if len(r.Ops) > 0 {
+		ops := make([]string, len(r.Ops))
+		for i, op := range r.Ops {
+			ops[i] = op.OpCode.String() + " " + op.Path
+		}
+		fmt.Fprintf(&b, " ops=[%s]", strings.Join(ops, ", "))
+	}
+	return b.String()
+}
+
+func (r *Request) IsReq() bool {
+	return true
+}
+
+func (r *Request) StreamId() protocol.StreamId {
+	return protocol.StreamId(uint32(r.Xid))
+}
+
+var _ protocol.ParsedMessage = &Response{}
+var _ protocol.StatusfulMessage = &Response{}
+
+type Response struct {
+	protocol.FrameBase
+	Xid  int32
+	Zxid int64
+	Err  int32
+	// the op code of the request, known if the request was parsed before
+	OpCode OpCode
+	// the path created by the create operations
+	Path string
+	// the data returned by getData
+	DataSize int
+	// the number of children returned by getChildren
+	NumChildren int
+	// the connect response, a timeout of 0 means the session expired
+	Timeout   int32
+	SessionId int64
+	// the watch notification
+	EventType   int32
+	KeeperState int32
+	EventPath   string
+}
+
+func (r *Response) FormatToString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "base=[%s]", r.FrameBase.String())
+	switch r.Xid {
+	case kXidConnect:
+		fmt.Fprintf(&b, " connect session_id=[0x%x] timeout=[%dms]", r.SessionId, r.Timeout)
+		return b.String()
+	case kXidNotification:
+		fmt.Fprintf(&b, " watch notification event=[%s] state=[%s] path=[%s]", nameOf(eventTypeNames, r.EventType), nameOf(keeperStateNames, r.KeeperState), r.EventPath)
+		return b.String()
+	}
+	fmt.Fprintf(&b, " xid=[%d] zxid=[0x%x] err=[%s]", r.Xid, r.Zxid, ErrorName(r.Err))
+	switch {
+	case r.Path != "":
+		fmt.Fprintf(&b, " path=[%s]", r.Path)
+	case r.OpCode == OpGetData:
+		fmt.Fprintf(&b, " data_size=[%d]", r.DataSize)
+	case r.OpCode == OpGetChildren || r.OpCode == OpGetChildren2:
+		fmt.Fprintf(&b, " children=[%d]", r.NumChildren)
+	}
+	return b.String()
+}
+
+func (r *Response) IsReq() bool {
+	return false
+}
+
+func (r *Response) StreamId() protocol.StreamId {
+	return protocol.StreamId(uint32(r.Xid))
+}
+
+// Status fails on an error code, or when the session of a connect expired.
+// exists returns no stat rather than an error for a missing node, it is a
+// miss.
+func (r *Response) Status() protocol.ResponseStatus {
+	switch {
+	case r.Xid == kXidConnect && r.Timeout <= 0:
+		return protocol.FailStatus
+	case r.Err == ErrNoNode && r.OpCode == OpExists:
+		return protocol.MissStatus
+	case r.Err != ErrOk:
+		return protocol.FailStatus
+	}
+	return protocol.SuccessStatus
+}
+
+var _ protocol.ProtocolStreamParser = &ZookeeperStreamParser{}
+
+type ZookeeperStreamParser struct {
+	// the op codes of the requests waiting for their replies, the body of
+	// a reply depends on the op code
+	pendingOps map[int32]OpCode
+}
@@ -0,0 +1,385 @@
+package zookeeper
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/bpf"
+	"kyanos/common"
+	"slices"
+)
+
+func init() {
+	protocol.ParsersMap[bpf.AgentTrafficProtocolTKProtocolZooKeeper] = func() protocol.ProtocolStreamParser {
+		return NewZookeeperStreamParser()
+	}
+}
+
+func NewZookeeperStreamParser() *ZookeeperStreamParser {
+	return &ZookeeperStreamParser{pendingOps: make(map[int32]OpCode)}
+}
+
+// A request not answered within kMaxResponseWaitNs is dropped.
+const kMaxResponseWaitNs uint64 = 60 * 1000 * 1000 * 1000
+
+// The op codes of the requests whose replies are lost are forgotten once
+// there are kMaxPendingOps of them.
+const kMaxPendingOps = 4096
+
+// The request header holds the xid and the op code, the reply header the
+// xid, the zxid and the error code.
+const (
+	kRequestHeaderSize = 8
+	kReplyHeaderSize   = 16
+)
+
+// The connect request is the protocol version, the last zxid seen, the
+// timeout, the session id and the password, the connect response has no
+// last zxid seen. Both may end with the read only flag.
+const (
+	kConnectRequestSize  = 28
+	kConnectResponseSize = 20
+	kMaxPasswordLength   = 16
+)
+
+var errNotEnoughData = errors.New("not enough data")
+var errInvalid = errors.New("invalid packet")
+
+// decoder reads the jute encoding, the first error is kept and all reads
+// after it return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 {
+		d.err = errInvalid
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errNotEnoughData
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) readInt() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) readLong() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) readBool() bool {
+	b := d.take(1)
+	return b != nil && b[0] != 0
+}
+
+// readBuffer skips a buffer and returns its length, -1 is a null buffer.
+func (d *decoder) readBuffer() int {
+	length := d.readInt()
+	if length <= 0 {
+		return 0
+	}
+	d.take(int(length))
+	return int(length)
+}
+
+func (d *decoder) readString() string {
+	length := d.readInt()
+	if length <= 0 {
+		return ""
+	}
+	return string(d.take(int(length)))
+}
+
+// skipACL skips a vector of ACL, the permissions and the scheme and id of
+// the identity.
+func (d *decoder) skipACL() {
+	count := d.readInt()
+	for i := int32(0); i < count && d.err == nil; i++ {
+		d.readInt()
+		d.readString()
+		d.readString()
+	}
+}
+
+func validRequestHeader(xid int32, op OpCode) bool {
+	switch xid {
+	case kXidPing:
+		return op == OpPing
+	case kXidAuth:
+		return op == OpAuth
+	case kXidSetWatches:
+		return op == OpSetWatches || op == OpSetWatches2
+	}
+	_, known := opCodeNames[op]
+	return xid > 0 && known && op != OpNotification
+}
+
+func validReplyXid(xid int32) bool {
+	return xid > 0 || xid == kXidNotification || xid == kXidPing || xid == kXidAuth || xid == kXidSetWatches
+}
+
+// checkPacketStart tells if buf starts with a packet sent by the client or
+// by the server, the connect packets are recognized by their length.
+func checkPacketStart(buf []byte, isReq bool) protocol.ParseState {
+	if len(buf) < kLengthFieldSize+4 {
+		// the length of a packet starts with zero bytes
+		if len(buf) > 0 && buf[0] > kMaxPacketLength>>24 {
+			return protocol.Invalid
+		}
+		return protocol.NeedsMoreData
+	}
+	length := int(binary.BigEndian.Uint32(buf))
+	xid := int32(binary.BigEndian.Uint32(buf[kLengthFieldSize:]))
+	if xid == kXidConnect {
+		minSize := kConnectResponseSize
+		if isReq {
+			minSize = kConnectRequestSize
+		}
+		if length < minSize || length > minSize+kMaxPasswordLength+1 {
+			return protocol.Invalid
+		}
+		return protocol.Success
+	}
+	if length > kMaxPacketLength {
+		return protocol.Invalid
+	}
+	if isReq {
+		if length < kRequestHeaderSize {
+			return protocol.Invalid
+		}
+		if len(buf) < kLengthFieldSize+kRequestHeaderSize {
+			return protocol.NeedsMoreData
+		}
+		op := OpCode(binary.BigEndian.Uint32(buf[kLengthFieldSize+4:]))
+		if !validRequestHeader(xid, op) {
+			return protocol.Invalid
+		}
+		return protocol.Success
+	}
+	if length < kReplyHeaderSize || !validReplyXid(xid) {
+		return protocol.Invalid
+	}
+	if len(buf) < kLengthFieldSize+kReplyHeaderSize {
+		return protocol.NeedsMoreData
+	}
+	if _, known := errorNames[int32(binary.BigEndian.Uint32(buf[kLengthFieldSize+12:]))]; !known {
+		return protocol.Invalid
+	}
+	return protocol.Success
+}
+
+func (p *ZookeeperStreamParser) ParseStream(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType) protocol.ParseResult {
+	buf := streamBuffer.Head().Buffer()
+	isReq := messageType != protocol.Response
+	state := checkPacketStart(buf, isReq)
+	if state == protocol.Invalid && messageType == protocol.Unknown {
+		isReq = false
+		state = checkPacketStart(buf, isReq)
+	}
+	if state != protocol.Success {
+		return protocol.ParseResult{ParseState: state}
+	}
+	readBytes := kLengthFieldSize + int(binary.BigEndian.Uint32(buf))
+	if len(buf) < readBytes {
+		return protocol.ParseResult{ParseState: protocol.NeedsMoreData}
+	}
+	d := &decoder{buf: buf[kLengthFieldSize:readBytes]}
+
+	// the header is valid and the body is framed, a body which can't be
+	// decoded only loses the details of the operation
+	var message protocol.ParsedMessage
+	if isReq {
+		req := &Request{}
+		p.decodeRequest(d, req)
+		message = req
+	} else {
+		resp := &Response{}
+		p.decodeResponse(d, resp)
+		message = resp
+	}
+
+	fb, ok := protocol.CreateFrameBase(streamBuffer, readBytes)
+	if !ok {
+		common.ProtocolParserLog.Debugf("[ZooKeeper] failed to create FrameBase for %s", message.FormatToString())
+		return protocol.ParseResult{ParseState: protocol.Ignore, ReadBytes: readBytes}
+	}
+	switch message := message.(type) {
+	case *Request:
+		message.FrameBase = fb
+	case *Response:
+		message.FrameBase = fb
+	}
+	return protocol.ParseResult{
+		ParseState:     protocol.Success,
+		ReadBytes:      readBytes,
+		ParsedMessages: []protocol.ParsedMessage{message},
+	}
+}
+
+func (p *ZookeeperStreamParser) decodeRequest(d *decoder, req *Request) {
+	req.Xid = d.readInt()
+	if req.Xid == kXidConnect {
+		req.ProtocolVersion = req.Xid
+		req.LastZxidSeen = d.readLong()
+		req.Timeout = d.readInt()
+		req.SessionId = d.readLong()
+		d.readBuffer()
+		return
+	}
+	req.OpCode = OpCode(d.readInt())
+	if req.Xid > 0 {
+		if len(p.pendingOps) >= kMaxPendingOps {
+			clear(p.pendingOps)
+		}
+		p.pendingOps[req.Xid] = req.OpCode
+	}
+	if req.OpCode != OpMulti && req.OpCode != OpMultiRead {
+		decodeOperation(d, req)
+		return
+	}
+	for d.err == nil {
+		op := OpCode(d.readInt())
+		done := d.readBool()
+		d.readInt()
+		if done || d.err != nil {
+			return
+		}
+		subReq := &Request{OpCode: op}
+		decodeOperation(d, subReq)
+		req.Ops = append(req.Ops, MultiOp{OpCode: op, Path: subReq.Path})
+	}
+}
+
+// decodeOperation decodes the body of the request of an operation.
+func decodeOperation(d *decoder, req *Request) {
+	switch req.OpCode {
+	case OpCreate, OpCreate2, OpCreateContainer, OpCreateTTL:
+		req.Path = d.readString()
+		req.DataSize = d.readBuffer()
+		d.skipACL()
+		req.CreateFlags = d.readInt()
+		if req.OpCode == OpCreateTTL {
+			d.readLong()
+		}
+	case OpDelete, OpCheck:
+		req.Path = d.readString()
+		req.Version = d.readInt()
+	case OpExists, OpGetData, OpGetChildren, OpGetChildren2:
+		req.Path = d.readString()
+		req.Watch = d.readBool()
+	case OpSetData:
+		req.Path = d.readString()
+		req.DataSize = d.readBuffer()
+		req.Version = d.readInt()
+	case OpSetACL:
+		req.Path = d.readString()
+		d.skipACL()
+		req.Version = d.readInt()
+	case OpGetACL, OpSync, OpDeleteContainer, OpGetEphemerals, OpGetAllChildrenNumber,
+		OpCheckWatches, OpRemoveWatches, OpAddWatch:
+		req.Path = d.readString()
+	}
+}
+
+func (p *ZookeeperStreamParser) decodeResponse(d *decoder, resp *Response) {
+	resp.Xid = d.readInt()
+	switch resp.Xid {
+	case kXidConnect:
+		resp.Timeout = d.readInt()
+		resp.SessionId = d.readLong()
+		d.readBuffer()
+		return
+	case kXidPing:
+		resp.OpCode = OpPing
+	case kXidAuth:
+		resp.OpCode = OpAuth
+	case kXidSetWatches:
+		resp.OpCode = OpSetWatches
+	case kXidNotification:
+		resp.OpCode = OpNotification
+	default:
+		if op, ok := p.pendingOps[resp.Xid]; ok {
+			resp.OpCode = op
+			delete(p.pendingOps, resp.Xid)
+		}
+	}
+	resp.Zxid = d.readLong()
+	resp.Err = d.readInt()
+	if resp.Err != ErrOk {
+		return
+	}
+	switch {
+	case resp.Xid == kXidNotification:
+		resp.EventType = d.readInt()
+		resp.KeeperState = d.readInt()
+		resp.EventPath = d.readString()
+	case resp.OpCode.isCreate():
+		resp.Path = d.readString()
+	case resp.OpCode == OpGetData:
+		resp.DataSize = d.readBuffer()
+	case resp.OpCode == OpGetChildren || resp.OpCode == OpGetChildren2:
+		resp.NumChildren = max(int(d.readInt()), 0)
+	}
+}
+
+// FindBoundary looks for the length of a packet followed by a valid header.
+func (p *ZookeeperStreamParser) FindBoundary(streamBuffer *buffer.StreamBuffer, messageType protocol.MessageType, startPos int) int {
+	buf := streamBuffer.Head().Buffer()
+	for i := startPos; i+kLengthFieldSize+kRequestHeaderSize <= len(buf); i++ {
+		if messageType != protocol.Response && checkPacketStart(buf[i:], true) == protocol.Success {
+			return i
+		}
+		if messageType != protocol.Request && checkPacketStart(buf[i:], false) == protocol.Success {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match pairs the requests and the replies by xid. The watch notifications
+// are pushed by the server without a request, each one becomes a record of
+// its own whose request marks the push and has no size.
+func (p *ZookeeperStreamParser) Match(reqStreams map[protocol.StreamId]*protocol.ParsedMessageQueue, respStreams map[protocol.StreamId]*protocol.ParsedMessageQueue) []protocol.Record {
+	notificationStream := (&Response{Xid: kXidNotification}).StreamId()
+	notifications, ok := respStreams[notificationStream]
+	delete(respStreams, notificationStream)
+	records := protocol.MatchByStreamId(reqStreams, respStreams, kMaxResponseWaitNs)
+	if !ok || len(*notifications) == 0 {
+		return records
+	}
+	for _, message := range *notifications {
+		notification := message.(*Response)
+		push := &Request{
+			FrameBase: protocol.NewFrameBase(notification.TimestampNs(), 0, 0),
+			Xid:       kXidNotification,
+			OpCode:    OpNotification,
+			Path:      notification.EventPath,
+			Push:      true,
+		}
+		records = append(records, protocol.Record{Req: push, Resp: notification, ResponseStatus: protocol.SuccessStatus})
+	}
+	slices.SortFunc(records, func(r1, r2 protocol.Record) int {
+		return cmp.Compare(r1.Req.TimestampNs(), r2.Req.TimestampNs())
+	})
+	return records
+}
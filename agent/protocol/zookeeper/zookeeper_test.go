@@ -0,0 +1,264 @@
+package zookeeper_test
+
+import (
+	"cmp"
+	"encoding/binary"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/protocoltest"
+	"kyanos/agent/protocol/zookeeper"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type writer []byte
+
+func (w writer) int(v int32) writer {
+	return binary.BigEndian.AppendUint32(w, uint32(v))
+}
+
+func (w writer) long(v int64) writer {
+	return binary.BigEndian.AppendUint64(w, uint64(v))
+}
+
+func (w writer) bool(v bool) writer {
+	if v {
+		return append(w, 1)
+	}
+	return append(w, 0)
+}
+
+func (w writer) str(s string) writer {
+	return append(w.int(int32(len(s))), s...)
+}
+
+func (w writer) packet() []byte {
+	return append(writer(nil).int(int32(len(w))), w...)
+}
+
+func connectRequest(sessionId int64) []byte {
+	return writer(nil).int(0).long(0).int(30000).long(sessionId).str(string(make([]byte, 16))).bool(false).packet()
+}
+
+func connectResponse(timeout int32, sessionId int64) []byte {
+	return writer(nil).int(0).int(timeout).long(sessionId).str(string(make([]byte, 16))).bool(false).packet()
+}
+
+func request(xid int32, op zookeeper.OpCode) writer {
+	return writer(nil).int(xid).int(int32(op))
+}
+
+func reply(xid int32, zxid int64, err int32) writer {
+	return writer(nil).int(xid).long(zxid).int(err)
+}
+
+// stat is the Stat of a znode, 68 bytes.
+func stat() []byte {
+	return writer(nil).long(1).long(1).long(0).long(0).int(0).int(0).int(0).long(0).int(5).int(0).long(1)
+}
+
+// acl is the open ACL for world:anyone.
+func acl(w writer) writer {
+	return w.int(1).int(31).str("world").str("anyone")
+}
+
+func match(t *testing.T, requests []byte, responses []byte) []protocol.Record {
+	parser := zookeeper.NewZookeeperStreamParser()
+	reqStreams := protocoltest.ParseAll(t, parser, requests, protocol.Request, 10)
+	respStreams := protocoltest.ParseAll(t, parser, responses, protocol.Response, 20)
+	records := parser.Match(reqStreams, respStreams)
+	slices.SortStableFunc(records, func(r1, r2 protocol.Record) int {
+		return cmp.Compare(r1.Req.(*zookeeper.Request).Xid, r2.Req.(*zookeeper.Request).Xid)
+	})
+	return records
+}
+
+func TestMatchConnectAndOperations(t *testing.T) {
+	requests := connectRequest(0)
+	requests = append(requests, acl(request(1, zookeeper.OpCreate).str("/app/lock-").str("owner")).int(3).packet()...)
+	requests = append(requests, request(2, zookeeper.OpGetData).str("/app/config").bool(false).packet()...)
+	requests = append(requests, request(3, zookeeper.OpSetData).str("/app/config").str("v2").int(4).packet()...)
+	requests = append(requests, request(4, zookeeper.OpGetChildren).str("/app").bool(false).packet()...)
+
+	responses := connectResponse(30000, 0x1000)
+	responses = append(responses, reply(1, 0x10, 0).str("/app/lock-0000000007").packet()...)
+	responses = append(responses, append(reply(2, 0x10, 0).str("payload"), stat()...).packet()...)
+	responses = append(responses, append(reply(3, 0x11, 0), stat()...).packet()...)
+	responses = append(responses, reply(4, 0x11, 0).int(3).str("a").str("b").str("c").packet()...)
+
+	records := match(t, requests, responses)
+	assert.Len(t, records, 5)
+	for _, record := range records {
+		assert.Equal(t, protocol.SuccessStatus, record.ResponseStatus)
+	}
+
+	connect := records[0].Req.(*zookeeper.Request)
+	assert.Equal(t, int32(30000), connect.Timeout)
+	connected := records[0].Resp.(*zookeeper.Response)
+	assert.Equal(t, int64(0x1000), connected.SessionId)
+
+	create := records[1].Req.(*zookeeper.Request)
+	assert.Equal(t, zookeeper.OpCreate, create.OpCode)
+	assert.Equal(t, "/app/lock-", create.Path)
+	assert.Equal(t, int32(3), create.CreateFlags)
+	assert.Equal(t, 5, create.DataSize)
+	assert.Equal(t, "/app/lock-0000000007", records[1].Resp.(*zookeeper.Response).Path)
+
+	getData := records[2].Resp.(*zookeeper.Response)
+	assert.Equal(t, zookeeper.OpGetData, getData.OpCode)
+	assert.Equal(t, 7, getData.DataSize)
+
+	setData := records[3].Req.(*zookeeper.Request)
+	assert.Equal(t, int32(4), setData.Version)
+	assert.Equal(t, int64(0x11), records[3].Resp.(*zookeeper.Response).Zxid)
+
+	assert.Equal(t, 3, records[4].Resp.(*zookeeper.Response).NumChildren)
+}
+
+func TestErrorCodes(t *testing.T) {
+	requests := request(1, zookeeper.OpSetData).str("/app/config").str("v3").int(4).packet()
+	requests = append(requests, request(2, zookeeper.OpExists).str("/app/missing").bool(true).packet()...)
+	requests = append(requests, request(3, zookeeper.OpGetData).str("/app/missing").bool(false).packet()...)
+	requests = append(requests, request(4, zookeeper.OpCreate).str("/app").str("").int(0).int(0).packet()...)
+
+	// BADVERSION, NONODE twice and NODEEXISTS
+	responses := reply(1, 0x20, -103).packet()
+	responses = append(responses, reply(2, 0x20, -101).packet()...)
+	responses = append(responses, reply(3, 0x20, -101).packet()...)
+	responses = append(responses, reply(4, 0x20, -105).packet()...)
+
+	records := match(t, requests, responses)
+	assert.Len(t, records, 4)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+	// exists returns no stat for a missing node
+	assert.Equal(t, protocol.MissStatus, records[1].ResponseStatus)
+	assert.Equal(t, protocol.FailStatus, records[2].ResponseStatus)
+	assert.Equal(t, protocol.FailStatus, records[3].ResponseStatus)
+	assert.Equal(t, "NODEEXISTS", zookeeper.ErrorName(records[3].Resp.(*zookeeper.Response).Err))
+}
+
+func TestExpiredSession(t *testing.T) {
+	records := match(t, connectRequest(0x1000), connectResponse(0, 0))
+	assert.Len(t, records, 1)
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+}
+
+func TestWatchNotification(t *testing.T) {
+	requests := request(1, zookeeper.OpGetData).str("/kafka/controller").bool(true).packet()
+	responses := append(reply(1, 0x30, 0).str("{}"), stat()...).packet()
+	// NodeDataChanged in SyncConnected
+	responses = append(responses, reply(-1, -1, 0).int(3).int(3).str("/kafka/controller").packet()...)
+
+	records := match(t, requests, responses)
+	assert.Len(t, records, 2)
+
+	push := records[0].Req.(*zookeeper.Request)
+	assert.True(t, push.Push)
+	assert.Equal(t, 0, push.ByteSize())
+	assert.Equal(t, "/kafka/controller", push.Path)
+	assert.Equal(t, push.TimestampNs(), records[0].Resp.TimestampNs())
+	notification := records[0].Resp.(*zookeeper.Response)
+	assert.Equal(t, int32(3), notification.EventType)
+	assert.Equal(t, "/kafka/controller", notification.EventPath)
+	assert.Equal(t, protocol.SuccessStatus, records[0].ResponseStatus)
+
+	getData := records[1].Req.(*zookeeper.Request)
+	assert.True(t, getData.Watch)
+	assert.False(t, getData.Push)
+}
+
+func TestMulti(t *testing.T) {
+	w := request(7, zookeeper.OpMulti)
+	w = acl(w.int(int32(zookeeper.OpCreate)).bool(false).int(-1).str("/app/a").str("1")).int(0)
+	w = w.int(int32(zookeeper.OpSetData)).bool(false).int(-1).str("/app/b").str("2").int(-1)
+	w = w.int(int32(zookeeper.OpCheck)).bool(false).int(-1).str("/app/c").int(5)
+	w = w.int(int32(zookeeper.OpDelete)).bool(false).int(-1).str("/app/d").int(-1)
+	w = w.int(-1).bool(true).int(-1)
+
+	// the multi fails with the error of the check
+	r := reply(7, 0x40, -103)
+	for _, err := range []int32{0, 0, -103, -2} {
+		r = r.int(-1).bool(false).int(err).int(err)
+	}
+	r = r.int(-1).bool(true).int(-1)
+
+	records := match(t, w.packet(), r.packet())
+	assert.Len(t, records, 1)
+	multi := records[0].Req.(*zookeeper.Request)
+	assert.Equal(t, []zookeeper.MultiOp{
+		{OpCode: zookeeper.OpCreate, Path: "/app/a"},
+		{OpCode: zookeeper.OpSetData, Path: "/app/b"},
+		{OpCode: zookeeper.OpCheck, Path: "/app/c"},
+		{OpCode: zookeeper.OpDelete, Path: "/app/d"},
+	}, multi.Ops)
+	assert.Equal(t, []string{"/app/a", "/app/b", "/app/c", "/app/d"}, multi.Paths())
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+}
+
+func TestPingsAndAuth(t *testing.T) {
+	requests := request(-2, zookeeper.OpPing).packet()
+	requests = append(requests, request(-4, zookeeper.OpAuth).int(0).str("digest").str("user:secret").packet()...)
+	requests = append(requests, request(-2, zookeeper.OpPing).packet()...)
+
+	responses := reply(-2, 0x50, 0).packet()
+	responses = append(responses, reply(-4, 0, -110).packet()...)
+	responses = append(responses, reply(-2, 0x50, 0).packet()...)
+
+	records := match(t, requests, responses)
+	assert.Len(t, records, 3)
+	assert.Equal(t, zookeeper.OpAuth, records[0].Req.(*zookeeper.Request).OpCode)
+	// AUTHFAILED
+	assert.Equal(t, protocol.FailStatus, records[0].ResponseStatus)
+	for _, record := range records[1:] {
+		assert.Equal(t, zookeeper.OpPing, record.Resp.(*zookeeper.Response).OpCode)
+		assert.Equal(t, protocol.SuccessStatus, record.ResponseStatus)
+	}
+}
+
+func TestParsePartialAndInvalid(t *testing.T) {
+	parser := zookeeper.NewZookeeperStreamParser()
+	data := request(1, zookeeper.OpGetData).str("/app/config").bool(false).packet()
+
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data[:10], 10)
+	assert.Equal(t, protocol.NeedsMoreData, parser.ParseStream(streamBuffer, protocol.Request).ParseState)
+	streamBuffer.Add(11, data[10:], 10)
+	result := parser.ParseStream(streamBuffer, protocol.Request)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	assert.Equal(t, len(data), result.ReadBytes)
+
+	// an unknown op code
+	invalid := buffer.New(1 << 20)
+	invalid.Add(1, request(1, 55).str("/app").packet(), 10)
+	assert.Equal(t, protocol.Invalid, parser.ParseStream(invalid, protocol.Request).ParseState)
+	// an unknown error code
+	invalid = buffer.New(1 << 20)
+	invalid.Add(1, reply(1, 0, -99).packet(), 10)
+	assert.Equal(t, protocol.Invalid, parser.ParseStream(invalid, protocol.Response).ParseState)
+}
+
+func TestFindBoundary(t *testing.T) {
+	parser := zookeeper.NewZookeeperStreamParser()
+	garbage := []byte("\xff\xffgarbage")
+	data := append(garbage, request(5, zookeeper.OpExists).str("/app").bool(false).packet()...)
+	streamBuffer := buffer.New(1 << 20)
+	streamBuffer.Add(1, data, 10)
+	assert.Equal(t, len(garbage), parser.FindBoundary(streamBuffer, protocol.Request, 0))
+}
+
+func TestFilterPathPrefix(t *testing.T) {
+	filter := zookeeper.Filter{PathPrefix: "/kafka"}
+	assert.True(t, filter.FilterByRequest())
+	assert.True(t, filter.Filter(&zookeeper.Request{OpCode: zookeeper.OpGetData, Path: "/kafka/brokers/ids"}, nil))
+	assert.False(t, filter.Filter(&zookeeper.Request{OpCode: zookeeper.OpGetData, Path: "/hbase/meta"}, nil))
+	assert.True(t, filter.Filter(&zookeeper.Request{OpCode: zookeeper.OpMulti, Ops: []zookeeper.MultiOp{
+		{OpCode: zookeeper.OpCheck, Path: "/hbase/meta"},
+		{OpCode: zookeeper.OpSetData, Path: "/kafka/config"},
+	}}, nil))
+	assert.True(t, filter.Filter(&zookeeper.Request{OpCode: zookeeper.OpNotification, Path: "/kafka/controller", Push: true}, nil))
+	// pings have no path
+	assert.False(t, filter.Filter(&zookeeper.Request{OpCode: zookeeper.OpPing}, nil))
+	assert.False(t, zookeeper.Filter{}.FilterByRequest())
+}
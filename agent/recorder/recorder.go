@@ -0,0 +1,242 @@
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/protocol"
+	"kyanos/common"
+)
+
+// error rates of fewer requests are too noisy to trigger a dump
+const kMinRequestsForErrorRate = 20
+
+type Options struct {
+	// keep the records of this long, the recorder is disabled if it is 0
+	Window         time.Duration
+	MaxMemoryBytes int64
+	DumpDir        string
+	// serve POST /dump on this address if not empty
+	Listen string
+	// dump when the p99 latency of a TriggerInterval exceeds LatencyThreshold,
+	// or the percentage of failed requests exceeds ErrorRateThreshold
+	LatencyThreshold   time.Duration
+	ErrorRateThreshold float64
+	TriggerInterval    time.Duration
+	// minimum time between two dumps triggered by the thresholds
+	Cooldown time.Duration
+}
+
+func (o Options) Enabled() bool {
+	return o.Window > 0
+}
+
+func (o Options) hasThresholds() bool {
+	return o.LatencyThreshold > 0 || o.ErrorRateThreshold > 0
+}
+
+type dumpResult struct {
+	File    string `json:"file"`
+	Records int    `json:"records"`
+	err     error
+}
+
+// FlightRecorder keeps the records of the last window in memory and writes
+// them to a file when triggered, so the history before an incident can be
+// analysed after it happened.
+type FlightRecorder struct {
+	options Options
+	ring    *Ring
+
+	// stats of the current trigger interval
+	requests int
+	failed   int
+	// in milliseconds
+	latencies []float64
+
+	lastThresholdDump time.Time
+	httpTriggers      chan chan dumpResult
+}
+
+func NewFlightRecorder(options Options) *FlightRecorder {
+	return &FlightRecorder{
+		options:      options,
+		ring:         NewRing(options.Window, options.MaxMemoryBytes),
+		httpTriggers: make(chan chan dumpResult),
+	}
+}
+
+func (f *FlightRecorder) Receive(record *anc.AnnotatedRecord, now time.Time) {
+	f.ring.Add(record, now)
+	// the stats are only reset by CheckThresholds, which doesn't run without
+	// thresholds
+	if !f.options.hasThresholds() {
+		return
+	}
+	f.requests++
+	if status, ok := record.Response().(protocol.StatusfulMessage); ok && status.Status() == protocol.FailStatus {
+		f.failed++
+	}
+	if f.options.LatencyThreshold > 0 && record.TotalDuration >= 0 {
+		f.latencies = append(f.latencies, record.TotalDuration/1e6)
+	}
+}
+
+// CheckThresholds returns the reason of a dump if a threshold is exceeded by
+// the records received since the last check, which starts a new interval.
+func (f *FlightRecorder) CheckThresholds(now time.Time) string {
+	reason := ""
+	if f.requests > 0 && now.Sub(f.lastThresholdDump) >= f.options.Cooldown {
+		p99 := percentile(f.latencies, 0.99)
+		errorRate := float64(f.failed) * 100 / float64(f.requests)
+		if f.options.LatencyThreshold > 0 && p99 > float64(f.options.LatencyThreshold)/1e6 {
+			reason = "latency"
+			common.AgentLog.Infof("p99 latency %.2fms exceeds %s", p99, f.options.LatencyThreshold)
+		} else if f.options.ErrorRateThreshold > 0 && f.requests >= kMinRequestsForErrorRate &&
+			errorRate >= f.options.ErrorRateThreshold {
+			reason = "error-rate"
+			common.AgentLog.Infof("error rate %.1f%% exceeds %.1f%%", errorRate, f.options.ErrorRateThreshold)
+		}
+	}
+	if reason != "" {
+		f.lastThresholdDump = now
+	}
+	f.requests, f.failed = 0, 0
+	f.latencies = f.latencies[:0]
+	return reason
+}
+
+// percentile sorts values, it returns 0 if there are none.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	slices.Sort(values)
+	return values[int(float64(len(values)-1)*p)]
+}
+
+func (f *FlightRecorder) dumpFile(reason string, now time.Time) string {
+	return filepath.Join(f.options.DumpDir, fmt.Sprintf("kyanos-record-%s-%s.jsonl", now.Format("20060102-150405.000"), reason))
+}
+
+// writeRecords writes one JSON object per line as --json-output does.
+func writeRecords(path string, records []*anc.AnnotatedRecord) dumpResult {
+	result := dumpResult{File: path, Records: len(records)}
+	file, err := os.Create(path)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			common.AgentLog.Warnf("marshal record failed: %v", err)
+		}
+	}
+	result.err = w.Flush()
+	return result
+}
+
+// startDump snapshots the ring and writes it in the background, so records
+// keep being received while a large window is written.
+func (f *FlightRecorder) startDump(reason string, now time.Time) <-chan dumpResult {
+	records := f.ring.Snapshot(now)
+	path := f.dumpFile(reason, now)
+	done := make(chan dumpResult, 1)
+	go func() {
+		result := writeRecords(path, records)
+		if result.err != nil {
+			common.AgentLog.Errorf("dump records to %s failed: %v", path, result.err)
+		} else {
+			common.AgentLog.Infof("Dumped %d records to %s, triggered by %s", result.Records, path, reason)
+		}
+		done <- result
+	}()
+	return done
+}
+
+// Handler serves POST /dump, which responds after the dump is written.
+func (f *FlightRecorder) Handler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dump", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST to dump the records", http.StatusMethodNotAllowed)
+			return
+		}
+		resultChannel := make(chan dumpResult, 1)
+		select {
+		case f.httpTriggers <- resultChannel:
+		case <-ctx.Done():
+			http.Error(w, "kyanos is stopping", http.StatusServiceUnavailable)
+			return
+		}
+		result := <-resultChannel
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	return mux
+}
+
+// Run receives records until ctx is done and dumps them on SIGUSR1, on
+// POST /dump and when a threshold is exceeded.
+func (f *FlightRecorder) Run(ctx context.Context, recordsChannel <-chan *anc.AnnotatedRecord) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	if f.options.Listen != "" {
+		server := &http.Server{Addr: f.options.Listen, Handler: f.Handler(ctx)}
+		go func() {
+			<-ctx.Done()
+			server.Close()
+		}()
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				common.AgentLog.Errorf("serve dump trigger failed: %v", err)
+			}
+		}()
+	}
+	common.AgentLog.Infof("Recording the last %s of records, send SIGUSR1 to pid %d to dump them to %s",
+		f.options.Window, os.Getpid(), f.options.DumpDir)
+
+	var checkThresholds <-chan time.Time
+	if f.options.hasThresholds() {
+		ticker := time.NewTicker(f.options.TriggerInterval)
+		defer ticker.Stop()
+		checkThresholds = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-recordsChannel:
+			f.Receive(record, time.Now())
+		case <-signals:
+			f.startDump("signal", time.Now())
+		case resultChannel := <-f.httpTriggers:
+			done := f.startDump("http", time.Now())
+			go func() { resultChannel <- <-done }()
+		case now := <-checkThresholds:
+			if reason := f.CheckThresholds(now); reason != "" {
+				f.startDump(reason, now)
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package recorder
+
+import (
+	"testing"
+	"time"
+
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiveWithoutThresholds(t *testing.T) {
+	f := NewFlightRecorder(Options{Window: time.Minute, MaxMemoryBytes: 1 << 20})
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		record := &anc.AnnotatedRecord{Record: protocol.Record{}, TotalDuration: 5e6}
+		f.Receive(record, now)
+	}
+	// CheckThresholds never runs, nothing may pile up besides the ring
+	assert.Equal(t, 0, f.requests)
+	assert.Empty(t, f.latencies)
+	assert.Equal(t, 1000, f.ring.Len())
+	assert.LessOrEqual(t, f.ring.Bytes(), int64(1<<20))
+}
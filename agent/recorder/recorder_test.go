@@ -0,0 +1,134 @@
+package recorder_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"kyanos/agent/analysis"
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/recorder"
+	"kyanos/bpf"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseRedis(t *testing.T, data string, messageType protocol.MessageType) protocol.ParsedMessage {
+	streamBuffer := buffer.New(1024)
+	streamBuffer.Add(0, []byte(data), 1)
+	result := protocol.GetParserByProtocol(bpf.AgentTrafficProtocolTKProtocolRedis).ParseStream(streamBuffer, messageType)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	return result.ParsedMessages[0]
+}
+
+func redisRecord(t *testing.T, resp string, totalDuration float64) *anc.AnnotatedRecord {
+	record := analysis.CreateAnnotedRecord()
+	record.Record = protocol.Record{
+		Req:  parseRedis(t, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", protocol.Request),
+		Resp: parseRedis(t, resp, protocol.Response),
+	}
+	record.ConnDesc.Protocol = uint32(bpf.AgentTrafficProtocolTKProtocolRedis)
+	record.TotalDuration = totalDuration
+	record.ReqSize = 22
+	record.RespSize = 9
+	return record
+}
+
+func TestRingWindowAndMemory(t *testing.T) {
+	start := time.Now()
+	ring := recorder.NewRing(time.Minute, 0)
+	for i := 0; i < 10; i++ {
+		ring.Add(redisRecord(t, "$3\r\nbar\r\n", 1e6), start.Add(time.Duration(i)*10*time.Second))
+	}
+	// records older than a minute before the last one are dropped
+	assert.Equal(t, 7, ring.Len())
+	assert.Len(t, ring.Snapshot(start.Add(150*time.Second)), 1)
+	assert.Len(t, ring.Snapshot(start.Add(time.Hour)), 0)
+	assert.Equal(t, int64(0), ring.Bytes())
+
+	ring = recorder.NewRing(time.Hour, 2000)
+	for i := 0; i < 10; i++ {
+		ring.Add(redisRecord(t, "$3\r\nbar\r\n", 1e6), start)
+	}
+	assert.Equal(t, 3, ring.Len())
+	assert.LessOrEqual(t, ring.Bytes(), int64(2000))
+}
+
+func TestCheckThresholds(t *testing.T) {
+	f := recorder.NewFlightRecorder(recorder.Options{
+		Window:             time.Minute,
+		LatencyThreshold:   100 * time.Millisecond,
+		ErrorRateThreshold: 10,
+		Cooldown:           time.Minute,
+	})
+	now := time.Now()
+	for i := 0; i < 30; i++ {
+		f.Receive(redisRecord(t, "$3\r\nbar\r\n", 5e6), now)
+	}
+	assert.Equal(t, "", f.CheckThresholds(now))
+
+	f.Receive(redisRecord(t, "$3\r\nbar\r\n", 500e6), now)
+	assert.Equal(t, "latency", f.CheckThresholds(now.Add(time.Second)))
+
+	for i := 0; i < 30; i++ {
+		f.Receive(redisRecord(t, "-ERR unknown\r\n", 5e6), now)
+	}
+	// still cooling down from the latency dump
+	assert.Equal(t, "", f.CheckThresholds(now.Add(2*time.Second)))
+	for i := 0; i < 30; i++ {
+		f.Receive(redisRecord(t, "-ERR unknown\r\n", 5e6), now)
+	}
+	assert.Equal(t, "error-rate", f.CheckThresholds(now.Add(2*time.Minute)))
+}
+
+func TestDumpOverHttp(t *testing.T) {
+	dir := t.TempDir()
+	f := recorder.NewFlightRecorder(recorder.Options{Window: time.Minute, DumpDir: dir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records := make(chan *anc.AnnotatedRecord)
+	go f.Run(ctx, records)
+	records <- redisRecord(t, "$3\r\nbar\r\n", 1e6)
+	records <- redisRecord(t, "-ERR unknown\r\n", 1e6)
+
+	server := httptest.NewServer(f.Handler(ctx))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/dump")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/dump", "", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result struct {
+		File    string `json:"file"`
+		Records int    `json:"records"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, 2, result.Records)
+
+	file, err := os.Open(result.File)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var lines []map[string]any
+	for scanner.Scan() {
+		var line map[string]any
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "Redis", lines[0]["protocol"])
+}
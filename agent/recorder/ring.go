@@ -0,0 +1,83 @@
+package recorder
+
+import (
+	"time"
+
+	anc "kyanos/agent/analysis/common"
+)
+
+// rough memory used by a record besides its request and response
+const kRecordOverhead = 512
+const kEventDetailSize = 64
+
+type ringEntry struct {
+	record  *anc.AnnotatedRecord
+	arrival time.Time
+	size    int64
+}
+
+// Ring keeps the records received during the last window, the oldest records
+// are dropped earlier if their estimated size exceeds maxBytes.
+type Ring struct {
+	window   time.Duration
+	maxBytes int64
+	entries  []ringEntry
+	head     int
+	bytes    int64
+}
+
+func NewRing(window time.Duration, maxBytes int64) *Ring {
+	return &Ring{window: window, maxBytes: maxBytes}
+}
+
+func recordSize(record *anc.AnnotatedRecord) int64 {
+	events := len(record.ReqSyscallEventDetails) + len(record.RespSyscallEventDetails) +
+		len(record.ReqNicEventDetails) + len(record.RespNicEventDetails)
+	return int64(max(record.ReqSize, record.ReqPlainTextSize)+max(record.RespSize, record.RespPlainTextSize)) +
+		int64(events*kEventDetailSize) + kRecordOverhead
+}
+
+func (r *Ring) Add(record *anc.AnnotatedRecord, now time.Time) {
+	entry := ringEntry{record: record, arrival: now, size: recordSize(record)}
+	r.entries = append(r.entries, entry)
+	r.bytes += entry.size
+	r.evict(now)
+}
+
+func (r *Ring) evict(now time.Time) {
+	for r.head < len(r.entries) {
+		oldest := r.entries[r.head]
+		if now.Sub(oldest.arrival) <= r.window && (r.maxBytes <= 0 || r.bytes <= r.maxBytes) {
+			break
+		}
+		r.bytes -= oldest.size
+		r.entries[r.head] = ringEntry{}
+		r.head++
+	}
+	// compact once the dropped entries are the majority, so appends amortize
+	if r.head > 0 && r.head*2 >= len(r.entries) {
+		n := copy(r.entries, r.entries[r.head:])
+		clear(r.entries[n:])
+		r.entries = r.entries[:n]
+		r.head = 0
+	}
+}
+
+// Snapshot returns the records of the window in arrival order.
+func (r *Ring) Snapshot(now time.Time) []*anc.AnnotatedRecord {
+	r.evict(now)
+	records := make([]*anc.AnnotatedRecord, 0, len(r.entries)-r.head)
+	for _, entry := range r.entries[r.head:] {
+		records = append(records, entry.record)
+	}
+	return records
+}
+
+func (r *Ring) Len() int {
+	return len(r.entries) - r.head
+}
+
+// Bytes is the estimated memory used by the records in the ring.
+func (r *Ring) Bytes() int64 {
+	return r.bytes
+}
@@ -0,0 +1,170 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"kyanos/agent/analysis"
+	anc "kyanos/agent/analysis/common"
+	"kyanos/bpf"
+	"kyanos/common"
+)
+
+var Formats = []string{"dot", "mermaid", "json"}
+
+// Run aggregates the records for duration, or until ctx is done, and writes
+// the dependency graph to output, which is a file path or "stdout".
+func Run(ctx context.Context, recordsChannel <-chan *anc.AnnotatedRecord, format string, output string,
+	duration time.Duration, containerResolver func(pid uint32) string) {
+	g := analysis.NewDependencyGraph(containerResolver)
+	start := time.Now()
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	common.AgentLog.Infof("Collecting the dependencies for %s..", duration)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-timer.C:
+			break loop
+		case record := <-recordsChannel:
+			g.Receive(record)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "stdout" {
+		file, err := os.Create(output)
+		if err != nil {
+			common.AgentLog.Errorf("create graph output failed: %v", err)
+			return
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := Write(w, format, g, time.Since(start)); err != nil {
+		common.AgentLog.Errorf("write graph failed: %v", err)
+	}
+}
+
+// Write writes g in format, window is the time the records were collected
+// in and is used to compute the request rates.
+func Write(w io.Writer, format string, g *analysis.DependencyGraph, window time.Duration) error {
+	switch format {
+	case "dot":
+		return writeDot(w, g, window)
+	case "mermaid":
+		return writeMermaid(w, g, window)
+	case "json":
+		return writeJson(w, g, window)
+	default:
+		return fmt.Errorf("unsupported graph format %q, can be: %s", format, strings.Join(Formats, " | "))
+	}
+}
+
+func edgeLabel(edge *analysis.GraphEdge, window time.Duration) (protocol string, stats string) {
+	protocol = bpf.ProtocolNamesMap[edge.Protocol]
+	if protocol == "" {
+		protocol = "unknown"
+	}
+	protocol = fmt.Sprintf("%s :%d", protocol, edge.Port)
+	stats = fmt.Sprintf("%.2f req/s, err %.1f%%, p99 %.2fms",
+		float64(edge.Requests)/window.Seconds(), edge.ErrorRate(), edge.Latency.CalculatePercentile(0.99))
+	return
+}
+
+func writeDot(w io.Writer, g *analysis.DependencyGraph, window time.Duration) error {
+	var b strings.Builder
+	b.WriteString("digraph kyanos {\n  rankdir=LR;\n")
+	for _, node := range g.Nodes() {
+		shape := "box"
+		if node.Kind == analysis.RemoteNode {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, shape=%s];\n", node.Id, node.Name, shape)
+	}
+	for _, edge := range g.Edges() {
+		protocol, stats := edgeLabel(edge, window)
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", edge.From, edge.To, protocol+"\n"+stats)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidText escapes the characters which end a quoted mermaid label.
+func mermaidText(s string) string {
+	return strings.ReplaceAll(s, "\"", "#quot;")
+}
+
+func writeMermaid(w io.Writer, g *analysis.DependencyGraph, window time.Duration) error {
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	// mermaid ids can't contain most punctuation, nodes are numbered instead
+	ids := make(map[string]string)
+	for i, node := range g.Nodes() {
+		ids[node.Id] = fmt.Sprintf("n%d", i)
+		if node.Kind == analysis.RemoteNode {
+			fmt.Fprintf(&b, "  %s([\"%s\"])\n", ids[node.Id], mermaidText(node.Name))
+		} else {
+			fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[node.Id], mermaidText(node.Name))
+		}
+	}
+	for _, edge := range g.Edges() {
+		protocol, stats := edgeLabel(edge, window)
+		fmt.Fprintf(&b, "  %s -->|\"%s<br/>%s\"| %s\n", ids[edge.From], protocol, stats, ids[edge.To])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type jsonNode struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type jsonEdge struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Protocol  string  `json:"protocol"`
+	Port      uint16  `json:"port"`
+	Requests  int     `json:"requests"`
+	Qps       float64 `json:"qps"`
+	ErrorRate float64 `json:"error_rate"`
+	P99Ms     float64 `json:"p99_ms"`
+}
+
+type jsonGraph struct {
+	WindowSeconds float64    `json:"window_seconds"`
+	Nodes         []jsonNode `json:"nodes"`
+	Edges         []jsonEdge `json:"edges"`
+}
+
+func writeJson(w io.Writer, g *analysis.DependencyGraph, window time.Duration) error {
+	result := jsonGraph{WindowSeconds: window.Seconds(), Nodes: []jsonNode{}, Edges: []jsonEdge{}}
+	for _, node := range g.Nodes() {
+		result.Nodes = append(result.Nodes, jsonNode{Id: node.Id, Name: node.Name, Kind: string(node.Kind)})
+	}
+	for _, edge := range g.Edges() {
+		result.Edges = append(result.Edges, jsonEdge{
+			From:      edge.From,
+			To:        edge.To,
+			Protocol:  bpf.ProtocolNamesMap[edge.Protocol],
+			Port:      edge.Port,
+			Requests:  edge.Requests,
+			Qps:       float64(edge.Requests) / window.Seconds(),
+			ErrorRate: edge.ErrorRate(),
+			P99Ms:     edge.Latency.CalculatePercentile(0.99),
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
@@ -0,0 +1,104 @@
+package graph_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"kyanos/agent/analysis"
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/render/graph"
+	"kyanos/bpf"
+	"kyanos/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, p bpf.AgentTrafficProtocolT, data string, messageType protocol.MessageType) protocol.ParsedMessage {
+	streamBuffer := buffer.New(1024)
+	streamBuffer.Add(0, []byte(data), 1)
+	result := protocol.GetParserByProtocol(p).ParseStream(streamBuffer, messageType)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	return result.ParsedMessages[0]
+}
+
+func redisRecord(t *testing.T, pid uint32, resp string, side common.SideEnum, localPort common.Port, remotePort common.Port) *anc.AnnotatedRecord {
+	record := analysis.CreateAnnotedRecord()
+	record.Record = protocol.Record{
+		Req:  parse(t, bpf.AgentTrafficProtocolTKProtocolRedis, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", protocol.Request),
+		Resp: parse(t, bpf.AgentTrafficProtocolTKProtocolRedis, resp, protocol.Response),
+	}
+	record.ConnDesc = common.ConnDesc{
+		LocalPort:  localPort,
+		RemotePort: remotePort,
+		RemoteAddr: net.ParseIP("10.0.0.2"),
+		LocalAddr:  net.ParseIP("10.0.0.1"),
+		Pid:        pid,
+		Protocol:   uint32(bpf.AgentTrafficProtocolTKProtocolRedis),
+		Side:       side,
+	}
+	record.TotalDuration = 2e6
+	return record
+}
+
+func newGraph(t *testing.T) *analysis.DependencyGraph {
+	g := analysis.NewDependencyGraph(func(pid uint32) string {
+		return map[uint32]string{1: "web", 2: "redis"}[pid]
+	})
+	// web calls the redis on 10.0.0.2, which calls back the local redis
+	g.Receive(redisRecord(t, 1, "$3\r\nbar\r\n", common.ClientSide, 40000, 6379))
+	g.Receive(redisRecord(t, 1, "-ERR unknown\r\n", common.ClientSide, 40000, 6379))
+	g.Receive(redisRecord(t, 2, "$3\r\nbar\r\n", common.ServerSide, 6379, 50000))
+	return g
+}
+
+func TestDependencyGraph(t *testing.T) {
+	g := newGraph(t)
+	nodes := g.Nodes()
+	assert.Len(t, nodes, 3)
+	assert.Equal(t, "container:redis", nodes[0].Id)
+	assert.Equal(t, "container:web", nodes[1].Id)
+	assert.Equal(t, analysis.RemoteNode, nodes[2].Kind)
+
+	edges := g.Edges()
+	assert.Len(t, edges, 2)
+	assert.Equal(t, "container:web", edges[0].From)
+	assert.Equal(t, "remote:10.0.0.2", edges[0].To)
+	assert.Equal(t, uint16(6379), edges[0].Port)
+	assert.Equal(t, 2, edges[0].Requests)
+	assert.Equal(t, 50.0, edges[0].ErrorRate())
+	assert.Equal(t, "remote:10.0.0.2", edges[1].From)
+	assert.Equal(t, "container:redis", edges[1].To)
+	assert.Equal(t, uint16(6379), edges[1].Port)
+}
+
+func TestWriteGraph(t *testing.T) {
+	g := newGraph(t)
+	var b bytes.Buffer
+	assert.NoError(t, graph.Write(&b, "dot", g, 2*time.Second))
+	assert.Contains(t, b.String(), `"container:web" [label="web", shape=box];`)
+	assert.Contains(t, b.String(), `"remote:10.0.0.2" [label="10.0.0.2", shape=ellipse];`)
+	assert.Contains(t, b.String(), `"container:web" -> "remote:10.0.0.2" [label="Redis :6379\n1.00 req/s, err 50.0%, p99 `)
+
+	b.Reset()
+	assert.NoError(t, graph.Write(&b, "mermaid", g, 2*time.Second))
+	assert.Contains(t, b.String(), "graph LR\n  n0[\"redis\"]\n  n1[\"web\"]\n  n2([\"10.0.0.2\"])\n")
+	assert.Contains(t, b.String(), "  n2 -->|\"Redis :6379<br/>0.50 req/s, err 0.0%, p99 ")
+
+	b.Reset()
+	assert.NoError(t, graph.Write(&b, "json", g, 2*time.Second))
+	var result map[string]any
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &result))
+	assert.Equal(t, 2.0, result["window_seconds"])
+	assert.Len(t, result["nodes"], 3)
+	edge := result["edges"].([]any)[0].(map[string]any)
+	assert.Equal(t, "Redis", edge["protocol"])
+	assert.Equal(t, 1.0, edge["qps"])
+	assert.Equal(t, 50.0, edge["error_rate"])
+
+	assert.Error(t, graph.Write(&b, "svg", g, 2*time.Second))
+}
@@ -0,0 +1,150 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	anc "kyanos/agent/analysis/common"
+	"kyanos/common"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Transport int
+
+const (
+	Grpc Transport = iota
+	Http
+)
+
+// Endpoint is the collector given by --output:
+//
+//	otlp://host:4317            OTLP/gRPC without TLS
+//	otlp+http://host:4318       OTLP/HTTP without TLS, the path defaults to /v1/traces
+//	otlp+https://host:4318/path OTLP/HTTP over TLS
+type Endpoint struct {
+	Transport Transport
+	Address   string
+	Path      string
+	Insecure  bool
+}
+
+func ParseEndpoint(output string) (Endpoint, error) {
+	u, err := url.Parse(output)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if u.Host == "" {
+		return Endpoint{}, fmt.Errorf("missing collector address in %q", output)
+	}
+	endpoint := Endpoint{Address: u.Host, Path: u.Path}
+	switch u.Scheme {
+	case "otlp", "otlp+grpc":
+		endpoint.Transport = Grpc
+		endpoint.Insecure = true
+	case "otlp+http":
+		endpoint.Transport = Http
+		endpoint.Insecure = true
+	case "otlp+https":
+		endpoint.Transport = Http
+	default:
+		return Endpoint{}, fmt.Errorf("unsupported scheme %q, can be: otlp | otlp+http | otlp+https", u.Scheme)
+	}
+	if endpoint.Transport == Grpc && strings.Trim(endpoint.Path, "/") != "" {
+		return Endpoint{}, fmt.Errorf("OTLP/gRPC endpoint can't have a path: %q", output)
+	}
+	return endpoint, nil
+}
+
+func NewSpanExporter(ctx context.Context, endpoint Endpoint) (sdktrace.SpanExporter, error) {
+	if endpoint.Transport == Grpc {
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint.Address)}
+		if endpoint.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint.Address)}
+	if endpoint.Path != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(endpoint.Path))
+	}
+	if endpoint.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// Exporter converts annotated records to spans, each record is the root span
+// of its own trace since kyanos doesn't see the trace context of the process.
+type Exporter struct {
+	provider     *sdktrace.TracerProvider
+	tracer       trace.Tracer
+	processNames common.ProcessNameCache
+}
+
+func NewExporter(ctx context.Context, spanExporter sdktrace.SpanExporter) (*Exporter, error) {
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("kyanos")),
+		resource.WithFromEnv(),
+		resource.WithHost())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res))
+	return &Exporter{
+		provider:     provider,
+		tracer:       provider.Tracer("kyanos"),
+		processNames: make(common.ProcessNameCache),
+	}, nil
+}
+
+// Shutdown flushes the pending spans.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return e.provider.Shutdown(ctx)
+}
+
+// Run exports the records to output until ctx is done.
+func Run(ctx context.Context, recordsChannel <-chan *anc.AnnotatedRecord, output string) {
+	endpoint, err := ParseEndpoint(output)
+	if err != nil {
+		common.AgentLog.Errorf("invalid OTLP output: %v", err)
+		return
+	}
+	spanExporter, err := NewSpanExporter(ctx, endpoint)
+	if err != nil {
+		common.AgentLog.Errorf("create OTLP exporter failed: %v", err)
+		return
+	}
+	exporter, err := NewExporter(ctx, spanExporter)
+	if err != nil {
+		common.AgentLog.Errorf("create OTLP exporter failed: %v", err)
+		return
+	}
+	common.AgentLog.Infof("Exporting records as spans to %s", output)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := exporter.Shutdown(shutdownCtx); err != nil {
+			common.AgentLog.Warnf("flush spans failed: %v", err)
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-recordsChannel:
+			exporter.Export(record)
+		}
+	}
+}
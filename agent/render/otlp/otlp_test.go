@@ -0,0 +1,172 @@
+package otlp_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"kyanos/agent/analysis"
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/buffer"
+	"kyanos/agent/protocol"
+	"kyanos/agent/render/otlp"
+	"kyanos/bpf"
+	"kyanos/common"
+
+	"github.com/stretchr/testify/assert"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	endpoint, err := otlp.ParseEndpoint("otlp://localhost:4317")
+	assert.NoError(t, err)
+	assert.Equal(t, otlp.Endpoint{Transport: otlp.Grpc, Address: "localhost:4317", Insecure: true}, endpoint)
+
+	endpoint, err = otlp.ParseEndpoint("otlp+https://collector:4318/custom/traces")
+	assert.NoError(t, err)
+	assert.Equal(t, otlp.Endpoint{Transport: otlp.Http, Address: "collector:4318", Path: "/custom/traces"}, endpoint)
+
+	for _, output := range []string{"wide", "otlp://", "http://localhost:4318", "otlp://localhost:4317/v1/traces"} {
+		_, err = otlp.ParseEndpoint(output)
+		assert.Error(t, err, output)
+	}
+}
+
+// collector is a stand-in of an OTLP/HTTP collector.
+type collector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := &collectortrace.ExportTraceServiceRequest{}
+	if r.URL.Path != "/v1/traces" || proto.Unmarshal(body, req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	for _, resourceSpans := range req.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			c.spans = append(c.spans, scopeSpans.Spans...)
+		}
+	}
+	c.mu.Unlock()
+	resp, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func parse(t *testing.T, p bpf.AgentTrafficProtocolT, data string, messageType protocol.MessageType, ts uint64) protocol.ParsedMessage {
+	streamBuffer := buffer.New(1024)
+	streamBuffer.Add(0, []byte(data), ts)
+	result := protocol.GetParserByProtocol(p).ParseStream(streamBuffer, messageType)
+	assert.Equal(t, protocol.Success, result.ParseState)
+	return result.ParsedMessages[0]
+}
+
+func newRecord(t *testing.T, p bpf.AgentTrafficProtocolT, req string, resp string, side common.SideEnum) *anc.AnnotatedRecord {
+	record := analysis.CreateAnnotedRecord()
+	record.Record = protocol.Record{
+		Req:  parse(t, p, req, protocol.Request, 1_700_000_000_000_000_000),
+		Resp: parse(t, p, resp, protocol.Response, 1_700_000_000_002_000_000),
+	}
+	record.ConnDesc = common.ConnDesc{
+		LocalPort:  40000,
+		RemotePort: 8080,
+		RemoteAddr: net.ParseIP("10.0.0.2"),
+		LocalAddr:  net.ParseIP("10.0.0.1"),
+		Protocol:   uint32(p),
+		Side:       side,
+	}
+	record.StartTs = 1_700_000_000_000_000_000
+	record.EndTs = 1_700_000_000_002_000_000
+	record.TotalDuration = 2e6
+	record.BlackBoxDuration = 1e6
+	record.ReqSyscallEventDetails = []anc.SyscallEventDetail{{ByteSize: 40, Timestamp: record.StartTs}}
+	record.ReqNicEventDetails = []anc.NicEventDetail{{
+		PacketEventDetail: anc.PacketEventDetail{ByteSize: 92, Timestamp: record.StartTs + 1000},
+		Attributes:        map[string]any{"time-eth0": int64(record.StartTs + 2000), "time-lo": int64(record.StartTs + 1000)},
+	}}
+	return record
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]any {
+	result := make(map[string]any)
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			result[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			result[kv.Key] = v.IntValue
+		case *commonpb.AnyValue_BoolValue:
+			result[kv.Key] = v.BoolValue
+		}
+	}
+	return result
+}
+
+func TestExportToCollector(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	ctx := context.Background()
+	endpoint, err := otlp.ParseEndpoint("otlp+http://" + strings.TrimPrefix(server.URL, "http://"))
+	assert.NoError(t, err)
+	spanExporter, err := otlp.NewSpanExporter(ctx, endpoint)
+	assert.NoError(t, err)
+	exporter, err := otlp.NewExporter(ctx, spanExporter)
+	assert.NoError(t, err)
+
+	exporter.Export(newRecord(t, bpf.AgentTrafficProtocolTKProtocolHTTP,
+		"GET /foo/bar HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n", common.ClientSide))
+	exporter.Export(newRecord(t, bpf.AgentTrafficProtocolTKProtocolRedis,
+		"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "$3\r\nbar\r\n", common.ServerSide))
+	assert.NoError(t, exporter.Shutdown(ctx))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !assert.Len(t, c.spans, 2) {
+		return
+	}
+	httpSpan, redisSpan := c.spans[0], c.spans[1]
+	if httpSpan.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		httpSpan, redisSpan = redisSpan, httpSpan
+	}
+
+	assert.Equal(t, "GET", httpSpan.Name)
+	assert.Equal(t, tracepb.Span_SPAN_KIND_CLIENT, httpSpan.Kind)
+	assert.Equal(t, uint64(1_700_000_000_000_000_000), httpSpan.StartTimeUnixNano)
+	assert.Equal(t, uint64(1_700_000_000_002_000_000), httpSpan.EndTimeUnixNano)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, httpSpan.Status.Code)
+	httpAttributes := attributes(httpSpan.Attributes)
+	assert.Equal(t, "GET", httpAttributes["http.request.method"])
+	assert.Equal(t, "/foo/bar", httpAttributes["url.path"])
+	assert.Equal(t, "10.0.0.2", httpAttributes["server.address"])
+	assert.Equal(t, int64(503), httpAttributes["http.response.status_code"])
+	assert.Equal(t, int64(1000000), httpAttributes["kyanos.network_duration_ns"])
+	var eventNames []string
+	for _, event := range httpSpan.Events {
+		eventNames = append(eventNames, event.Name)
+	}
+	assert.Equal(t, []string{"syscall write", "nic out", "nic out"}, eventNames)
+	assert.Equal(t, "lo", attributes(httpSpan.Events[1].Attributes)["kyanos.interface"])
+
+	assert.Equal(t, "GET", redisSpan.Name)
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, redisSpan.Kind)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, redisSpan.Status.Code)
+	redisAttributes := attributes(redisSpan.Attributes)
+	assert.Equal(t, "redis", redisAttributes["db.system"])
+	assert.Equal(t, "GET", redisAttributes["db.operation.name"])
+	assert.Equal(t, "10.0.0.2", redisAttributes["client.address"])
+}
@@ -0,0 +1,464 @@
+package otlp
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	anc "kyanos/agent/analysis/common"
+	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/amqp"
+	"kyanos/agent/protocol/cql"
+	"kyanos/agent/protocol/dubbo"
+	"kyanos/agent/protocol/http2"
+	kc "kyanos/agent/protocol/kafka/common"
+	"kyanos/agent/protocol/memcached"
+	"kyanos/agent/protocol/mongodb"
+	"kyanos/agent/protocol/mqtt"
+	"kyanos/agent/protocol/mysql"
+	"kyanos/agent/protocol/nats"
+	"kyanos/agent/protocol/postgresql"
+	"kyanos/agent/protocol/rocketmq"
+	"kyanos/agent/protocol/thrift"
+	"kyanos/agent/protocol/zookeeper"
+	"kyanos/bpf"
+	"kyanos/common"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RocketMQ request codes of sending and pulling messages.
+const (
+	kRocketMQSendMessage      = 10
+	kRocketMQPullMessage      = 11
+	kRocketMQSendMessageV2    = 310
+	kRocketMQSendBatchMessage = 320
+)
+
+// spanInfo is the protocol specific part of a span.
+type spanInfo struct {
+	name       string
+	kind       trace.SpanKind
+	attributes []attribute.KeyValue
+	failed     bool
+}
+
+// Export starts and ends the span of record, the span is sent to the
+// collector in batches.
+func (e *Exporter) Export(record *anc.AnnotatedRecord) {
+	info := describe(record)
+	start, end := recordTimeRange(record)
+	attributes := append(connAttributes(record), info.attributes...)
+	attributes = append(attributes, semconv.ProcessPID(int(record.Pid)))
+	if name := e.processNames.Get(record.Pid); name != "" {
+		attributes = append(attributes, semconv.ProcessExecutableName(name))
+	}
+	if record.BlackBoxDuration >= 0 {
+		if record.Side == common.ServerSide {
+			attributes = append(attributes, attribute.Int64("kyanos.internal_duration_ns", int64(record.BlackBoxDuration)))
+		} else {
+			attributes = append(attributes, attribute.Int64("kyanos.network_duration_ns", int64(record.BlackBoxDuration)))
+		}
+	}
+	if record.ReadFromSocketBufferDuration >= 0 {
+		attributes = append(attributes, attribute.Int64("kyanos.socket_read_duration_ns", int64(record.ReadFromSocketBufferDuration)))
+	}
+	if record.ReqSize >= 0 {
+		attributes = append(attributes, attribute.Int("kyanos.request_size", record.ReqSize))
+	}
+	if record.RespSize >= 0 {
+		attributes = append(attributes, attribute.Int("kyanos.response_size", record.RespSize))
+	}
+
+	_, span := e.tracer.Start(context.Background(), info.name,
+		trace.WithSpanKind(info.kind),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attributes...))
+	addTimingEvents(span, record)
+	if info.failed {
+		span.SetStatus(codes.Error, "")
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// recordTimeRange falls back to the timestamps of the messages when kernel
+// events are missing.
+func recordTimeRange(record *anc.AnnotatedRecord) (time.Time, time.Time) {
+	start, end := record.StartTs, record.EndTs
+	if start == 0 || start == math.MaxUint64 {
+		start = record.Request().TimestampNs()
+	}
+	if end == 0 || end == math.MaxUint64 || end < start {
+		end = max(record.Response().TimestampNs(), start)
+	}
+	return time.Unix(0, int64(start)), time.Unix(0, int64(end))
+}
+
+func connAttributes(record *anc.AnnotatedRecord) []attribute.KeyValue {
+	remoteAddr := ipString(record.RemoteAddr)
+	attributes := []attribute.KeyValue{
+		semconv.NetworkTransportTCP,
+		semconv.NetworkPeerAddress(remoteAddr),
+		semconv.NetworkPeerPort(int(record.RemotePort)),
+		semconv.NetworkLocalAddress(ipString(record.LocalAddr)),
+		semconv.NetworkLocalPort(int(record.LocalPort)),
+		attribute.String("kyanos.protocol", bpf.ProtocolNamesMap[bpf.AgentTrafficProtocolT(record.Protocol)]),
+	}
+	if record.Side == common.ServerSide {
+		attributes = append(attributes,
+			semconv.ServerPort(int(record.LocalPort)),
+			semconv.ClientAddress(remoteAddr),
+			semconv.ClientPort(int(record.RemotePort)))
+	} else {
+		attributes = append(attributes,
+			semconv.ServerAddress(remoteAddr),
+			semconv.ServerPort(int(record.RemotePort)))
+	}
+	if record.IsSsl {
+		attributes = append(attributes, attribute.Bool("kyanos.ssl", true))
+	}
+	return attributes
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// addTimingEvents adds the syscalls and the packets passing the network
+// interfaces as span events, so the time spent by each layer shows up in the
+// span's timeline.
+func addTimingEvents(span trace.Span, record *anc.AnnotatedRecord) {
+	serverSide := record.Side == common.ServerSide
+	addSyscallEvents(span, record.ReqSyscallEventDetails, record.SyscallDisplayName(true), "request")
+	addNicEvents(span, record.ReqNicEventDetails, nicDirection(!serverSide), "request")
+	addNicEvents(span, record.RespNicEventDetails, nicDirection(serverSide), "response")
+	addSyscallEvents(span, record.RespSyscallEventDetails, record.SyscallDisplayName(false), "response")
+}
+
+func nicDirection(egress bool) string {
+	if egress {
+		return "nic out"
+	}
+	return "nic in"
+}
+
+func addSyscallEvents(span trace.Span, details []anc.SyscallEventDetail, syscall string, message string) {
+	for _, detail := range details {
+		span.AddEvent("syscall "+syscall, trace.WithTimestamp(time.Unix(0, int64(detail.Timestamp))),
+			trace.WithAttributes(
+				attribute.String("kyanos.message", message),
+				attribute.Int("kyanos.bytes", detail.ByteSize)))
+	}
+}
+
+func addNicEvents(span trace.Span, details []anc.NicEventDetail, name string, message string) {
+	type nicEvent struct {
+		ifname string
+		ts     int64
+		bytes  int
+	}
+	events := make([]nicEvent, 0)
+	for _, detail := range details {
+		for key, value := range detail.Attributes {
+			ifname, found := strings.CutPrefix(key, "time-")
+			ts, ok := value.(int64)
+			if found && ok {
+				events = append(events, nicEvent{ifname, ts, detail.ByteSize})
+			}
+		}
+	}
+	slices.SortFunc(events, func(e1, e2 nicEvent) int {
+		return cmp.Compare(e1.ts, e2.ts)
+	})
+	for _, event := range events {
+		span.AddEvent(name, trace.WithTimestamp(time.Unix(0, event.ts)),
+			trace.WithAttributes(
+				attribute.String("kyanos.message", message),
+				attribute.String("kyanos.interface", event.ifname),
+				attribute.Int("kyanos.bytes", event.bytes)))
+	}
+}
+
+// describe maps the request and response to the attributes of the
+// semantic conventions of HTTP, RPC, database and messaging spans.
+func describe(record *anc.AnnotatedRecord) spanInfo {
+	info := spanInfo{
+		name: bpf.ProtocolNamesMap[bpf.AgentTrafficProtocolT(record.Protocol)],
+		kind: trace.SpanKindClient,
+	}
+	if record.Side == common.ServerSide {
+		info.kind = trace.SpanKindServer
+	}
+	if status, ok := record.Response().(protocol.StatusfulMessage); ok {
+		info.failed = status.Status() == protocol.FailStatus
+	}
+
+	switch req := record.Request().(type) {
+	case *protocol.ParsedHttpRequest:
+		info.name = req.Method
+		info.attributes = append(info.attributes,
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLPath(req.Path))
+		if resp, ok := record.Response().(*protocol.ParsedHttpResponse); ok && resp.StatusCode > 0 {
+			info.attributes = append(info.attributes, semconv.HTTPResponseStatusCode(resp.StatusCode))
+			// 4xx are the client's fault, they are errors of client spans only
+			info.failed = resp.StatusCode >= 500 || (resp.StatusCode >= 400 && record.Side != common.ServerSide)
+		}
+	case *http2.Request:
+		if !req.Grpc {
+			info.name = req.Method
+			info.attributes = append(info.attributes,
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.URLPath(req.Path))
+			break
+		}
+		info.name = req.GrpcService + "/" + req.GrpcMethod
+		info.attributes = append(info.attributes,
+			semconv.RPCSystemGRPC,
+			semconv.RPCService(req.GrpcService),
+			semconv.RPCMethod(req.GrpcMethod))
+		if resp, ok := record.Response().(*http2.Response); ok && resp.Grpc && resp.GrpcStatus >= 0 {
+			info.attributes = append(info.attributes, semconv.RPCGRPCStatusCodeKey.Int(resp.GrpcStatus))
+		}
+	case *dubbo.Request:
+		info.attributes = append(info.attributes, semconv.RPCSystemApacheDubbo)
+		if req.Method != "" {
+			info.name = req.FullMethod()
+			info.attributes = append(info.attributes,
+				semconv.RPCService(req.Service),
+				semconv.RPCMethod(req.Method))
+		}
+	case *thrift.Request:
+		info.name = req.FullMethod()
+		info.attributes = append(info.attributes,
+			semconv.RPCSystemKey.String("thrift"),
+			semconv.RPCMethod(req.Method))
+		if req.Service != "" {
+			info.attributes = append(info.attributes, semconv.RPCService(req.Service))
+		}
+	case *zookeeper.Request:
+		info.name = req.OpCode.String()
+		info.attributes = append(info.attributes,
+			semconv.DBSystemKey.String("zookeeper"),
+			semconv.DBOperationName(req.OpCode.String()))
+		if req.Path != "" {
+			info.name += " " + req.Path
+			info.attributes = append(info.attributes, attribute.String("zookeeper.path", req.Path))
+		}
+		// a watch notification is sent by the server and received by the client
+		if req.Push && info.kind == trace.SpanKindClient {
+			info.kind = trace.SpanKindConsumer
+		} else if req.Push {
+			info.kind = trace.SpanKindProducer
+		}
+	case *protocol.RedisMessage:
+		info.name = req.Command()
+		info.attributes = append(info.attributes,
+			semconv.DBSystemRedis,
+			semconv.DBOperationName(req.Command()),
+			semconv.DBQueryText(req.Payload()))
+		// a pub/sub message or an invalidation is pushed by the server
+		if req.IsPush() && info.kind == trace.SpanKindClient {
+			info.kind = trace.SpanKindConsumer
+		} else if req.IsPush() {
+			info.kind = trace.SpanKindProducer
+		}
+	case *memcached.Request:
+		info.name = req.Command
+		info.attributes = append(info.attributes,
+			semconv.DBSystemMemcached,
+			semconv.DBOperationName(req.Command))
+	case *mysql.MysqlPacket:
+		info.attributes = append(info.attributes, semconv.DBSystemMySQL)
+		info.name = "mysql"
+		if statement := req.SqlText(); statement != "" {
+			info.attributes = append(info.attributes, semconv.DBQueryText(statement))
+			if operation := sqlOperation(statement); operation != "" {
+				info.name = operation
+				info.attributes = append(info.attributes, semconv.DBOperationName(operation))
+			}
+		}
+	case *mysql.Transaction:
+		info.attributes = append(info.attributes, semconv.DBSystemMySQL)
+		info.name = "transaction"
+	case *postgresql.Request:
+		info.attributes = append(info.attributes, semconv.DBSystemPostgreSQL)
+		info.name = "postgresql"
+		if req.Query != "" {
+			info.attributes = append(info.attributes, semconv.DBQueryText(req.Query))
+			if operation := sqlOperation(req.Query); operation != "" {
+				info.name = operation
+				info.attributes = append(info.attributes, semconv.DBOperationName(operation))
+			}
+		}
+	case *cql.Request:
+		info.attributes = append(info.attributes, semconv.DBSystemCassandra)
+		info.name = req.Opcode.String()
+		if req.Query != "" {
+			info.attributes = append(info.attributes, semconv.DBQueryText(req.Query))
+			if operation := sqlOperation(req.Query); operation != "" && req.Opcode != cql.OpBatch {
+				info.name = operation
+				info.attributes = append(info.attributes, semconv.DBOperationName(operation))
+			}
+		}
+		if req.Keyspace != "" {
+			info.attributes = append(info.attributes, semconv.DBNamespace(req.Keyspace))
+		}
+		if req.Table != "" {
+			info.attributes = append(info.attributes, semconv.DBCollectionName(req.Table))
+		}
+		if req.Consistency != "" {
+			info.attributes = append(info.attributes, semconv.DBCassandraConsistencyLevelKey.String(strings.ToLower(req.Consistency)))
+		}
+	case *mongodb.MongoDBFrame:
+		info.attributes = append(info.attributes, semconv.DBSystemMongoDB)
+		info.name = "mongodb"
+		if req.Command != "" {
+			info.name = req.Command
+			info.attributes = append(info.attributes, semconv.DBOperationName(req.Command))
+		} else if req.OpMsgType != "" {
+			info.name = req.OpMsgType
+			info.attributes = append(info.attributes, semconv.DBOperationName(req.OpMsgType))
+		}
+		if req.Database != "" {
+			info.attributes = append(info.attributes, semconv.DBNamespace(req.Database))
+		}
+		if req.Collection != "" {
+			info.attributes = append(info.attributes, semconv.DBCollectionName(req.Collection))
+		}
+	case *kc.Request:
+		info.name = req.Apikey.String()
+		info.attributes = append(info.attributes,
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationName(req.Apikey.String()))
+		if req.ClientId != "" {
+			info.attributes = append(info.attributes, semconv.MessagingClientID(req.ClientId))
+		}
+		var topics []string
+		switch originReq := req.OriginReq.(type) {
+		case kc.ProduceReq:
+			info.attributes = append(info.attributes, semconv.MessagingOperationTypePublish)
+			if info.kind == trace.SpanKindClient {
+				info.kind = trace.SpanKindProducer
+			}
+			for _, topic := range originReq.Topics {
+				topics = append(topics, topic.Name)
+			}
+			info.name = "publish"
+		case kc.FetchReq:
+			info.attributes = append(info.attributes, semconv.MessagingOperationTypeReceive)
+			for _, topic := range originReq.Topics {
+				topics = append(topics, topic.Name)
+			}
+			info.name = "receive"
+		}
+		if len(topics) == 1 {
+			info.name += " " + topics[0]
+			info.attributes = append(info.attributes, semconv.MessagingDestinationName(topics[0]))
+		}
+	case *rocketmq.RocketMQMessage:
+		info.name = "rocketmq"
+		info.attributes = append(info.attributes, semconv.MessagingSystemRocketmq)
+		switch req.RequestCode {
+		case kRocketMQSendMessage, kRocketMQSendMessageV2, kRocketMQSendBatchMessage:
+			info.name = "publish"
+			info.attributes = append(info.attributes, semconv.MessagingOperationTypePublish)
+			if info.kind == trace.SpanKindClient {
+				info.kind = trace.SpanKindProducer
+			}
+		case kRocketMQPullMessage:
+			info.name = "receive"
+			info.attributes = append(info.attributes, semconv.MessagingOperationTypeReceive)
+		}
+		// SEND_MESSAGE_V2 uses single letter keys for its header fields
+		topic := req.Properties["topic"]
+		if topic == "" {
+			topic = req.Properties["b"]
+		}
+		if topic != "" {
+			info.name += " " + topic
+			info.attributes = append(info.attributes, semconv.MessagingDestinationName(topic))
+		}
+	case *nats.NatsMessage:
+		info.name = "request " + req.Subject
+		info.attributes = append(info.attributes,
+			semconv.MessagingSystemKey.String("nats"),
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(req.Subject),
+			semconv.MessagingMessageBodySize(len(req.Payload)))
+	case *mqtt.Packet:
+		info.name = req.Type.String()
+		info.attributes = append(info.attributes, semconv.MessagingSystemKey.String("mqtt"))
+		switch req.Type {
+		case mqtt.Publish:
+			info.name = "publish " + req.Topic
+			info.attributes = append(info.attributes,
+				semconv.MessagingOperationTypePublish,
+				semconv.MessagingDestinationName(req.Topic),
+				semconv.MessagingMessageBodySize(req.PayloadSize))
+			if info.kind == trace.SpanKindClient {
+				info.kind = trace.SpanKindProducer
+			}
+		case mqtt.PubRel:
+			info.attributes = append(info.attributes, semconv.MessagingDestinationName(req.Topic))
+		}
+	case *amqp.Message:
+		info.name = req.Method.String()
+		info.attributes = append(info.attributes, semconv.MessagingSystemRabbitmq)
+		message := req
+		switch req.Method {
+		case amqp.BasicPublish:
+			info.name = "publish"
+			info.attributes = append(info.attributes, semconv.MessagingOperationTypePublish)
+			if info.kind == trace.SpanKindClient {
+				info.kind = trace.SpanKindProducer
+			}
+		case amqp.BasicGet:
+			info.name = "receive"
+			info.attributes = append(info.attributes, semconv.MessagingOperationTypeReceive)
+			if resp, ok := record.Response().(*amqp.Message); ok && resp.Method == amqp.BasicGetOk {
+				message = resp
+			}
+		}
+		if message.Method == amqp.BasicPublish || message.Method == amqp.BasicGetOk {
+			// the default exchange has an empty name
+			exchange := message.Exchange
+			if exchange == "" {
+				exchange = "amq.default"
+			}
+			info.name += " " + exchange
+			info.attributes = append(info.attributes,
+				semconv.MessagingDestinationName(exchange),
+				semconv.MessagingRabbitmqDestinationRoutingKey(message.RoutingKey),
+				semconv.MessagingMessageBodySize(int(message.BodySize)))
+		} else if req.Queue != "" {
+			info.attributes = append(info.attributes, semconv.MessagingDestinationName(req.Queue))
+		}
+	}
+	return info
+}
+
+// sqlOperation returns the first keyword of a statement in upper case.
+func sqlOperation(statement string) string {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return ""
+	}
+	operation := strings.ToUpper(strings.TrimLeft(fields[0], "("))
+	for _, c := range operation {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+	}
+	return operation
+}
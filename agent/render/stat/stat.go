@@ -216,6 +216,13 @@ func renderToTable(connstats *[]*analysis.ConnStat, t *table.Model, metric commo
 			fmt.Sprintf("%.2f", p99),
 			fmt.Sprintf("%d", record.Count),
 		}
+		if len(record.SamplesMap[metric]) == 0 {
+			// the metric is not available for any record, e.g. kernel
+			// timings of a capture file
+			for idx := 2; idx < 7; idx++ {
+				row[idx] = common.NotAvailable
+			}
+		}
 		if metric.IsTotalMeaningful() {
 			row = append(row, fmt.Sprintf("%.1f", record.SumMap[metric]))
 		}
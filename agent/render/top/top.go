@@ -0,0 +1,313 @@
+package top
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"kyanos/agent/analysis"
+	rc "kyanos/agent/render/common"
+	"kyanos/bpf"
+	c "kyanos/common"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type topKeyMap rc.KeyMap
+
+var keyMap = topKeyMap{
+	"c": key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "group by process/container"),
+	),
+	"sort": key.NewBinding(
+		key.WithKeys("1", "2", "3", "4", "5", "6", "7", "8", "9", "0"),
+		key.WithHelp("1-9,0", "sort by column"),
+	),
+	"q": key.NewBinding(
+		key.WithKeys("q", "esc", "ctrl+c"),
+		key.WithHelp("q", "quit"),
+	),
+}
+
+func (k topKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{keyMap["c"], keyMap["sort"], keyMap["q"]}
+}
+
+func (k topKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{k.ShortHelp()}
+}
+
+// column describes how a column of the table is rendered and sorted, value
+// is used for sorting, name columns are sorted by text instead.
+type column struct {
+	title  string
+	width  int
+	text   func(s *analysis.TopSnapshot, stat *analysis.TopStat) string
+	value  func(s *analysis.TopSnapshot, stat *analysis.TopStat) float64
+	isName bool
+}
+
+func rateColumn(title string, width int, count func(stat *analysis.TopStat) float64) column {
+	return column{
+		title: title,
+		width: width,
+		text: func(s *analysis.TopSnapshot, stat *analysis.TopStat) string {
+			return fmt.Sprintf("%.1f", s.PerSecond(count(stat)))
+		},
+		value: func(s *analysis.TopSnapshot, stat *analysis.TopStat) float64 {
+			return s.PerSecond(count(stat))
+		},
+	}
+}
+
+// latencyColumn shows the p50 and p99 latency in milliseconds, it is sorted
+// by p99.
+func latencyColumn(title string, width int, latency func(stat *analysis.TopStat) *analysis.PercentileCalculator) column {
+	return column{
+		title: title,
+		width: width,
+		text: func(s *analysis.TopSnapshot, stat *analysis.TopStat) string {
+			return fmt.Sprintf("%.2f/%.2f", latency(stat).CalculatePercentile(0.5), latency(stat).CalculatePercentile(0.99))
+		},
+		value: func(s *analysis.TopSnapshot, stat *analysis.TopStat) float64 {
+			return latency(stat).CalculatePercentile(0.99)
+		},
+	}
+}
+
+func valueColumn(title string, width int, value func(stat *analysis.TopStat) float64) column {
+	return column{
+		title: title,
+		width: width,
+		text: func(s *analysis.TopSnapshot, stat *analysis.TopStat) string {
+			return fmt.Sprintf("%.2f", value(stat))
+		},
+		value: func(s *analysis.TopSnapshot, stat *analysis.TopStat) float64 {
+			return value(stat)
+		},
+	}
+}
+
+var columns = []column{
+	{
+		title:  "PID/COMMAND",
+		width:  30,
+		text:   func(s *analysis.TopSnapshot, stat *analysis.TopStat) string { return stat.Name },
+		isName: true,
+	},
+	{
+		title: "PROTOCOL",
+		width: 10,
+		text: func(s *analysis.TopSnapshot, stat *analysis.TopStat) string {
+			return bpf.ProtocolNamesMap[stat.Protocol]
+		},
+		isName: true,
+	},
+	rateColumn("OUT REQ/s", 10, func(stat *analysis.TopStat) float64 { return float64(stat.OutRequests) }),
+	valueColumn("OUT ERR%", 9, func(stat *analysis.TopStat) float64 { return stat.OutErrorRate() }),
+	latencyColumn("OUT P50/P99(ms)", 16, func(stat *analysis.TopStat) *analysis.PercentileCalculator { return stat.OutLatency }),
+	rateColumn("IN REQ/s", 10, func(stat *analysis.TopStat) float64 { return float64(stat.InRequests) }),
+	valueColumn("IN ERR%", 8, func(stat *analysis.TopStat) float64 { return stat.InErrorRate() }),
+	latencyColumn("IN P50/P99(ms)", 16, func(stat *analysis.TopStat) *analysis.PercentileCalculator { return stat.InLatency }),
+	{
+		// sorted by the sum of both directions
+		title: "IN/OUT(KB/s)",
+		width: 14,
+		text: func(s *analysis.TopSnapshot, stat *analysis.TopStat) string {
+			return fmt.Sprintf("%.1f/%.1f", s.PerSecond(float64(stat.BytesIn)/1024), s.PerSecond(float64(stat.BytesOut)/1024))
+		},
+		value: func(s *analysis.TopSnapshot, stat *analysis.TopStat) float64 {
+			return s.PerSecond(float64(stat.BytesIn+stat.BytesOut) / 1024)
+		},
+	},
+	{
+		title: "ACTIVE CONNS",
+		width: 12,
+		text: func(s *analysis.TopSnapshot, stat *analysis.TopStat) string {
+			return fmt.Sprintf("%d", stat.ActiveConns)
+		},
+		value: func(s *analysis.TopSnapshot, stat *analysis.TopStat) float64 { return float64(stat.ActiveConns) },
+	},
+}
+
+type snapshotMsg *analysis.TopSnapshot
+
+type model struct {
+	table        table.Model
+	additionHelp help.Model
+	snapshot     *analysis.TopSnapshot
+	groupBy      analysis.TopGroupBy
+	// index of the sorting column, -1 sorts by the total number of requests
+	sortBy  int
+	reverse bool
+}
+
+func newModel(groupBy analysis.TopGroupBy) *model {
+	t := table.New(table.WithFocused(true), table.WithHeight(20))
+	s := table.DefaultStyles()
+	s.Header = s.Header.
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color("240")).
+		BorderBottom(true).
+		Bold(false)
+	s.Selected = s.Selected.
+		Foreground(lipgloss.Color("229")).
+		Background(lipgloss.Color("57")).
+		Bold(false)
+	t.SetStyles(s)
+	m := &model{
+		table:        t,
+		additionHelp: help.New(),
+		groupBy:      groupBy,
+		sortBy:       -1,
+		reverse:      true,
+	}
+	m.updateColumns()
+	return m
+}
+
+func (m *model) updateColumns() {
+	cols := make([]table.Column, len(columns))
+	for i, col := range columns {
+		cols[i] = table.Column{Title: col.title, Width: col.width}
+	}
+	if m.groupBy == analysis.TopByContainer {
+		cols[0].Title = "CONTAINER"
+	}
+	if m.sortBy >= 0 {
+		if m.reverse {
+			cols[m.sortBy].Title += "↓"
+		} else {
+			cols[m.sortBy].Title += "↑"
+		}
+	}
+	// rows must fit the new columns before they are set
+	m.table.SetRows(nil)
+	m.table.SetColumns(cols)
+}
+
+func (m *model) sortedStats() []*analysis.TopStat {
+	stats := slices.Clone(m.snapshot.Stats(m.groupBy))
+	slices.SortFunc(stats, func(s1, s2 *analysis.TopStat) int {
+		var result int
+		if m.sortBy < 0 {
+			result = cmp.Compare(s1.Requests(), s2.Requests())
+		} else if col := columns[m.sortBy]; col.isName {
+			result = cmp.Compare(col.text(m.snapshot, s1), col.text(m.snapshot, s2))
+		} else {
+			result = cmp.Compare(col.value(m.snapshot, s1), col.value(m.snapshot, s2))
+		}
+		if result == 0 {
+			result = cmp.Compare(s1.Name, s2.Name)
+		}
+		if m.reverse {
+			return -result
+		}
+		return result
+	})
+	return stats
+}
+
+func (m *model) updateRows() {
+	if m.snapshot == nil {
+		return
+	}
+	rows := make([]table.Row, 0)
+	for _, stat := range m.sortedStats() {
+		row := make(table.Row, len(columns))
+		for i, col := range columns {
+			row[i] = col.text(m.snapshot, stat)
+		}
+		rows = append(rows, row)
+	}
+	m.table.SetRows(rows)
+}
+
+func (m *model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg := msg.(type) {
+	case snapshotMsg:
+		m.snapshot = msg
+		m.updateRows()
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.table.SetHeight(max(msg.Height-8, 5))
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		case "c":
+			if m.groupBy == analysis.TopByProcess {
+				m.groupBy = analysis.TopByContainer
+			} else {
+				m.groupBy = analysis.TopByProcess
+			}
+			m.updateColumns()
+			m.updateRows()
+			return m, nil
+		case "1", "2", "3", "4", "5", "6", "7", "8", "9", "0":
+			i := (int(msg.String()[0]-'0') + 9) % 10
+			if i == m.sortBy {
+				m.reverse = !m.reverse
+			} else {
+				m.sortBy = i
+				// names ascending, numbers descending
+				m.reverse = !columns[i].isName
+			}
+			m.updateColumns()
+			m.updateRows()
+			return m, nil
+		}
+	}
+	m.table, cmd = m.table.Update(msg)
+	return m, cmd
+}
+
+func (m *model) View() string {
+	var s string
+	if m.snapshot == nil {
+		s = "\n Waiting for the first interval..\n\n"
+	} else {
+		requests := 0
+		for _, stat := range m.snapshot.ByProcess {
+			requests += stat.Requests()
+		}
+		s = fmt.Sprintf("\n Refreshed every %s, %.1f requests/s\n\n", m.snapshot.Interval.Round(100*time.Millisecond),
+			m.snapshot.PerSecond(float64(requests)))
+	}
+	s += rc.BaseTableStyle.Render(m.table.View()) + "\n  " + m.additionHelp.View(keyMap)
+	return s
+}
+
+// StartTopRender renders the snapshots until ctx is done or the user quits.
+func StartTopRender(ctx context.Context, ch <-chan *analysis.TopSnapshot, groupBy analysis.TopGroupBy) {
+	c.SetLogToFile()
+	m := newModel(groupBy)
+	prog := tea.NewProgram(m, tea.WithContext(ctx), tea.WithAltScreen())
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case snapshot := <-ch:
+				prog.Send(snapshotMsg(snapshot))
+			}
+		}
+	}()
+	if _, err := prog.Run(); err != nil {
+		fmt.Println("Error running program:", err)
+		os.Exit(1)
+	}
+}
@@ -18,7 +18,7 @@ type WatchOptions struct {
 }
 
 func (w *WatchOptions) Init() {
-	if w.Opts != "" {
+	if w.Opts != "" && !w.OtlpOutput() {
 		if strings.Contains(w.Opts, "wide") {
 			w.WideOutput = true
 		}
@@ -32,5 +32,11 @@ func (w *WatchOptions) Init() {
 }
 
 func (w *WatchOptions) UseTui() bool {
-	return !w.DebugOutput && w.JsonOutput == ""
+	return !w.DebugOutput && w.JsonOutput == "" && !w.OtlpOutput()
+}
+
+// OtlpOutput reports whether the records are exported as spans to the
+// collector given by --output, like otlp://localhost:4317.
+func (w *WatchOptions) OtlpOutput() bool {
+	return strings.HasPrefix(w.Opts, "otlp")
 }
@@ -85,6 +85,13 @@ func addNicEventsDiagram(events []common.NicEventDetail, prevNicArrow *diagrams.
 	}
 }
 
+func usedDuration(r *common.AnnotatedRecord, metric common.MetricType, duration float64) string {
+	if !r.IsMetricAvailable(metric) {
+		return common.NotAvailable
+	}
+	return fmt.Sprintf("%.2fms", c.ConvertDurationToMillisecondsIfNeeded(duration, false))
+}
+
 func addSocketBufferDiagram(duration float64, prevDiagram *diagrams.Shape, shapes *[]*diagrams.Shape, isReq bool) *diagrams.Shape {
 	var arrowType diagrams.ShapeType
 	var connectFunc func(shape *diagrams.Shape, subShape *diagrams.Shape)
@@ -151,7 +158,7 @@ func ViewRecordTimeDetailAsFlowChartForServer(r *common.AnnotatedRecord) string
 	socketToAppArrow := addSocketBufferDiagram(r.CopyToSocketBufferDuration, lastNicShape, &shapes, true)
 	shapes = append(shapes, socketToAppArrow)
 	applicationStart := diagrams.Shape{
-		Content: fmt.Sprintf(" Process(used:%.2fms) ", c.ConvertDurationToMillisecondsIfNeeded(r.ReadFromSocketBufferDuration, false)),
+		Content: fmt.Sprintf(" Process(used:%s) ", usedDuration(r, common.ReadFromSocketBufferDuration, r.ReadFromSocketBufferDuration)),
 		Type:    diagrams.Rectangle,
 	}
 	diagrams.AddToRight(socketToAppArrow, &applicationStart)
@@ -164,7 +171,7 @@ func ViewRecordTimeDetailAsFlowChartForServer(r *common.AnnotatedRecord) string
 	shapes = append(shapes, &appStartToAppEndArrow)
 
 	applicationEnd := diagrams.Shape{
-		Content: fmt.Sprintf(" Process(used:%.2fms) ", c.ConvertDurationToMillisecondsIfNeeded(r.BlackBoxDuration, false)),
+		Content: fmt.Sprintf(" Process(used:%s) ", usedDuration(r, common.BlackBoxDuration, r.BlackBoxDuration)),
 		Type:    diagrams.Rectangle,
 	}
 	shapes = append(shapes, &applicationEnd)
@@ -241,8 +248,8 @@ func ViewRecordTimeDetailAsFlowChartForClientSide(r *common.AnnotatedRecord) str
 	socketBufferToLeftArrow := addSocketBufferDiagram(r.CopyToSocketBufferDuration, lastNicShape, &shapes, false)
 
 	applicationEnd := diagrams.Shape{
-		Content: fmt.Sprintf(" Process(used:%.2fms) ",
-			c.ConvertDurationToMillisecondsIfNeeded(r.ReadFromSocketBufferDuration, false)),
+		Content: fmt.Sprintf(" Process(used:%s) ",
+			usedDuration(r, common.ReadFromSocketBufferDuration, r.ReadFromSocketBufferDuration)),
 		Type:   diagrams.Rectangle,
 		IsLast: true,
 	}
@@ -8,6 +8,7 @@ import (
 	"kyanos/agent/analysis/common"
 	"kyanos/agent/protocol"
 	rc "kyanos/agent/render/common"
+	"kyanos/agent/render/otlp"
 	"kyanos/bpf"
 	c "kyanos/common"
 	"os"
@@ -136,6 +137,9 @@ var (
 			}
 		},
 		data: func(r *common.AnnotatedRecord) string {
+			if !r.IsMetricAvailable(common.BlackBoxDuration) {
+				return common.NotAvailable
+			}
 			if r.BlackBoxDuration == -1 {
 				return "-"
 			}
@@ -154,6 +158,9 @@ var (
 			}
 		},
 		data: func(r *common.AnnotatedRecord) string {
+			if !r.IsMetricAvailable(common.ReadFromSocketBufferDuration) {
+				return common.NotAvailable
+			}
 			if r.ReadFromSocketBufferDuration == -1 {
 				return "-"
 			}
@@ -566,6 +573,8 @@ func RunWatchRender(ctx context.Context, ch chan *common.AnnotatedRecord, option
 				}))
 			}
 		}
+	} else if options.OtlpOutput() {
+		otlp.Run(ctx, ch, options.Opts)
 	} else if options.JsonOutput != "" {
 		var jsonFile *os.File
 		var err error
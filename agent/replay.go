@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	ac "kyanos/agent/common"
+	"kyanos/agent/conn"
+	"kyanos/agent/pcap"
+	"kyanos/bpf"
+	"kyanos/common"
+)
+
+// setupEventRecorder creates the event file and chains the custom event hooks
+// with the recorder, so every event read from the perf buffers is written to
+// the file before it is processed.
+func setupEventRecorder(options *ac.AgentOptions) (*bpf.EventFileWriter, error) {
+	writer, err := bpf.NewEventFileWriter(options.RecordEventsFile, common.LaunchEpochTime)
+	if err != nil {
+		return nil, err
+	}
+	syscallHook := options.CustomSyscallEventHook
+	options.CustomSyscallEventHook = func(evt *bpf.SyscallEventData) {
+		writer.WriteSyscallEvent(evt)
+		if syscallHook != nil {
+			syscallHook(evt)
+		}
+	}
+	sslHook := options.CustomSslEventHook
+	options.CustomSslEventHook = func(evt *bpf.SslData) {
+		writer.WriteSslEvent(evt)
+		if sslHook != nil {
+			sslHook(evt)
+		}
+	}
+	connHook := options.CustomConnEventHook
+	options.CustomConnEventHook = func(evt *bpf.AgentConnEvtT) {
+		writer.WriteConnEvent(evt)
+		if connHook != nil {
+			connHook(evt)
+		}
+	}
+	kernHook := options.CustomKernEventHook
+	options.CustomKernEventHook = func(evt *bpf.AgentKernEvt) {
+		writer.WriteKernEvent(evt)
+		if kernHook != nil {
+			kernHook(evt)
+		}
+	}
+	firstPacketHook := options.CustomFirstPacketHook
+	options.CustomFirstPacketHook = func(evt *bpf.AgentFirstPacketEvt) {
+		writer.WriteFirstPacketEvent(evt)
+		if firstPacketHook != nil {
+			firstPacketHook(evt)
+		}
+	}
+	common.AgentLog.Infof("Recording events to %s", options.RecordEventsFile)
+	return writer, nil
+}
+
+// replayEvents feeds the events of the event file to the processors in place
+// of the perf buffer readers.
+func replayEvents(ctx context.Context, options *ac.AgentOptions, reader *bpf.EventFileReader, pm *conn.ProcessorManager) {
+	defer reader.Close()
+	firstPacketChannel := make(chan *bpf.AgentFirstPacketEvt, 10)
+	firstPacketProcessor := conn.NewFirstPacketProcessor(firstPacketChannel, pm.GetFirstPacketEventsChannels())
+	go firstPacketProcessor.Start()
+
+	err := bpf.ReplayEvents(ctx, reader, options.ReplaySpeed, pm.GetSyscallEventsChannels(), pm.GetSslEventsChannels(),
+		pm.GetConnEventsChannels(), pm.GetKernEventsChannels(), firstPacketChannel)
+	if err != nil {
+		common.AgentLog.Errorf("Replay %s failed: %v", options.ReplayFile, err)
+		return
+	}
+	common.AgentLog.Infof("All events in %s replayed", options.ReplayFile)
+}
+
+// readPcapFile feeds the TCP payloads of the capture file to the processors
+// as syscall events, there are no kernel events so the timing fields that
+// depend on them are unavailable.
+func readPcapFile(ctx context.Context, options *ac.AgentOptions, pm *conn.ProcessorManager) {
+	err := pcap.ReplayFile(ctx, options.ReadPcapFile, options.TraceSide, options.MessageFilter.Protocol(),
+		pm.GetSyscallEventsChannels(), pm.GetConnEventsChannels())
+	if err != nil {
+		common.AgentLog.Errorf("Read %s failed: %v", options.ReadPcapFile, err)
+		return
+	}
+	common.AgentLog.Infof("All packets in %s read", options.ReadPcapFile)
+}
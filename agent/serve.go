@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"kyanos/agent/analysis"
+	anc "kyanos/agent/analysis/common"
+	ac "kyanos/agent/common"
+	"kyanos/agent/metadata"
+	"kyanos/common"
+	"net/http"
+)
+
+// serveMetrics exposes the records as Prometheus metrics on
+// options.PrometheusListen until ctx is done.
+func serveMetrics(ctx context.Context, options *ac.AgentOptions, recordsChannel <-chan *anc.AnnotatedRecord) {
+	exporter := analysis.NewPrometheusExporter(&options.AnalysisOptions, containerNameResolver(ctx, options))
+	go exporter.Run(ctx, recordsChannel)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter.Handler())
+	server := &http.Server{Addr: options.PrometheusListen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	common.AgentLog.Infof("Serving Prometheus metrics on %s/metrics", options.PrometheusListen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		common.AgentLog.Errorf("serve Prometheus metrics failed: %v", err)
+	}
+}
+
+// containerNameResolver returns nil if no container runtime is available.
+func containerNameResolver(ctx context.Context, options *ac.AgentOptions) func(pid uint32) string {
+	cc := options.Cc
+	if cc == nil {
+		var err error
+		cc, err, _ = metadata.NewContainerCache(ctx, options.DockerEndpoint, options.ContainerdEndpoint, options.CriRuntimeEndpoint)
+		if err != nil {
+			common.AgentLog.Infof("container label disabled, no container runtime found: %v", err)
+			return nil
+		}
+	}
+	return func(pid uint32) string {
+		return cc.GetByPid(int(pid)).Name
+	}
+}
@@ -223,22 +223,28 @@ const (
 type AgentTrafficProtocolT uint32
 
 const (
-	AgentTrafficProtocolTKProtocolUnset    AgentTrafficProtocolT = 0
-	AgentTrafficProtocolTKProtocolUnknown  AgentTrafficProtocolT = 1
-	AgentTrafficProtocolTKProtocolHTTP     AgentTrafficProtocolT = 2
-	AgentTrafficProtocolTKProtocolHTTP2    AgentTrafficProtocolT = 3
-	AgentTrafficProtocolTKProtocolMySQL    AgentTrafficProtocolT = 4
-	AgentTrafficProtocolTKProtocolCQL      AgentTrafficProtocolT = 5
-	AgentTrafficProtocolTKProtocolPGSQL    AgentTrafficProtocolT = 6
-	AgentTrafficProtocolTKProtocolDNS      AgentTrafficProtocolT = 7
-	AgentTrafficProtocolTKProtocolRedis    AgentTrafficProtocolT = 8
-	AgentTrafficProtocolTKProtocolNATS     AgentTrafficProtocolT = 9
-	AgentTrafficProtocolTKProtocolMongo    AgentTrafficProtocolT = 10
-	AgentTrafficProtocolTKProtocolKafka    AgentTrafficProtocolT = 11
-	AgentTrafficProtocolTKProtocolMux      AgentTrafficProtocolT = 12
-	AgentTrafficProtocolTKProtocolAMQP     AgentTrafficProtocolT = 13
-	AgentTrafficProtocolTKProtocolRocketMQ AgentTrafficProtocolT = 14
-	AgentTrafficProtocolTKNumProtocols     AgentTrafficProtocolT = 15
+	AgentTrafficProtocolTKProtocolUnset     AgentTrafficProtocolT = 0
+	AgentTrafficProtocolTKProtocolUnknown   AgentTrafficProtocolT = 1
+	AgentTrafficProtocolTKProtocolHTTP      AgentTrafficProtocolT = 2
+	AgentTrafficProtocolTKProtocolHTTP2     AgentTrafficProtocolT = 3
+	AgentTrafficProtocolTKProtocolMySQL     AgentTrafficProtocolT = 4
+	AgentTrafficProtocolTKProtocolCQL       AgentTrafficProtocolT = 5
+	AgentTrafficProtocolTKProtocolPGSQL     AgentTrafficProtocolT = 6
+	AgentTrafficProtocolTKProtocolDNS       AgentTrafficProtocolT = 7
+	AgentTrafficProtocolTKProtocolRedis     AgentTrafficProtocolT = 8
+	AgentTrafficProtocolTKProtocolNATS      AgentTrafficProtocolT = 9
+	AgentTrafficProtocolTKProtocolMongo     AgentTrafficProtocolT = 10
+	AgentTrafficProtocolTKProtocolKafka     AgentTrafficProtocolT = 11
+	AgentTrafficProtocolTKProtocolMux       AgentTrafficProtocolT = 12
+	AgentTrafficProtocolTKProtocolAMQP      AgentTrafficProtocolT = 13
+	AgentTrafficProtocolTKProtocolRocketMQ  AgentTrafficProtocolT = 14
+	AgentTrafficProtocolTKProtocolMemcached AgentTrafficProtocolT = 15
+	AgentTrafficProtocolTKProtocolDubbo     AgentTrafficProtocolT = 16
+	AgentTrafficProtocolTKProtocolThrift    AgentTrafficProtocolT = 17
+	AgentTrafficProtocolTKProtocolMQTT      AgentTrafficProtocolT = 18
+	AgentTrafficProtocolTKProtocolZooKeeper AgentTrafficProtocolT = 19
+	AgentTrafficProtocolTKProtocolWebSocket AgentTrafficProtocolT = 20
+	AgentTrafficProtocolTKNumProtocols      AgentTrafficProtocolT = 21
 )
 
 // LoadAgent returns the embedded CollectionSpec for Agent.
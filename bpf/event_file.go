@@ -0,0 +1,282 @@
+package bpf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"kyanos/common"
+	"os"
+	"sync"
+	"time"
+)
+
+// An event file starts with a header: magic(4 bytes) + version(2 bytes) +
+// LaunchEpochTime(8 bytes), followed by events in the order they were read
+// from the perf buffers. Each event is: kind(1 byte) + nanoseconds since the
+// recording started(8 bytes) + payload length(4 bytes) + payload.
+// Payloads are the little endian encoding of the event struct, data events
+// append their Buf to it.
+
+const eventFileMagic = "KYEV"
+const eventFileVersion uint16 = 1
+
+type RecordedEventKind uint8
+
+const (
+	RecordedSyscallEvent RecordedEventKind = iota + 1
+	RecordedSslEvent
+	RecordedConnEvent
+	RecordedKernEvent
+	RecordedFirstPacketEvent
+)
+
+var ErrInvalidEventFile = errors.New("not a kyanos event file")
+
+// maxRecordedPayloadSize is the size of the largest event the bpf programs
+// submit, no recorded payload is longer than it.
+var maxRecordedPayloadSize = max(binary.Size(AgentKernEvtData{}), binary.Size(AgentKernEvtSslData{}))
+
+type EventFileWriter struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+	err   error
+}
+
+func NewEventFileWriter(path string, launchEpochTime uint64) (*EventFileWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(file, 1024*1024)
+	header := make([]byte, 0, 14)
+	header = append(header, eventFileMagic...)
+	header = binary.LittleEndian.AppendUint16(header, eventFileVersion)
+	header = binary.LittleEndian.AppendUint64(header, launchEpochTime)
+	if _, err := w.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &EventFileWriter{file: file, w: w, start: time.Now()}, nil
+}
+
+func (w *EventFileWriter) write(kind RecordedEventKind, header any, buf []byte) {
+	payload := new(bytes.Buffer)
+	if err := binary.Write(payload, binary.LittleEndian, header); err != nil {
+		common.BPFLog.Warningf("[recorder] encode event failed: %s\n", err)
+		return
+	}
+	payload.Write(buf)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	prefix := make([]byte, 0, 13)
+	prefix = append(prefix, byte(kind))
+	prefix = binary.LittleEndian.AppendUint64(prefix, uint64(time.Since(w.start).Nanoseconds()))
+	prefix = binary.LittleEndian.AppendUint32(prefix, uint32(payload.Len()))
+	if _, err := w.w.Write(prefix); err != nil {
+		w.err = err
+	} else if _, err := w.w.Write(payload.Bytes()); err != nil {
+		w.err = err
+	}
+	if w.err != nil {
+		common.BPFLog.Errorf("[recorder] write event file failed, stop recording: %s\n", w.err)
+	}
+}
+
+func (w *EventFileWriter) WriteSyscallEvent(evt *SyscallEventData) {
+	w.write(RecordedSyscallEvent, &evt.SyscallEvent, evt.Buf)
+}
+
+func (w *EventFileWriter) WriteSslEvent(evt *SslData) {
+	w.write(RecordedSslEvent, &evt.SslEventHeader, evt.Buf)
+}
+
+func (w *EventFileWriter) WriteConnEvent(evt *AgentConnEvtT) {
+	w.write(RecordedConnEvent, evt, nil)
+}
+
+func (w *EventFileWriter) WriteKernEvent(evt *AgentKernEvt) {
+	w.write(RecordedKernEvent, evt, nil)
+}
+
+func (w *EventFileWriter) WriteFirstPacketEvent(evt *AgentFirstPacketEvt) {
+	w.write(RecordedFirstPacketEvent, evt, nil)
+}
+
+func (w *EventFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.w.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RecordedEvent is an event read from an event file, only the field
+// corresponding to Kind is set.
+type RecordedEvent struct {
+	Kind RecordedEventKind
+	// time elapsed since the recording started
+	Offset      time.Duration
+	Syscall     *SyscallEventData
+	Ssl         *SslData
+	Conn        *AgentConnEvtT
+	Kern        *AgentKernEvt
+	FirstPacket *AgentFirstPacketEvt
+}
+
+type EventFileReader struct {
+	file            *os.File
+	r               *bufio.Reader
+	LaunchEpochTime uint64
+}
+
+func NewEventFileReader(path string) (*EventFileReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(file, 1024*1024)
+	header := make([]byte, 14)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != eventFileMagic {
+		file.Close()
+		return nil, ErrInvalidEventFile
+	}
+	if version := binary.LittleEndian.Uint16(header[4:6]); version != eventFileVersion {
+		file.Close()
+		return nil, fmt.Errorf("unsupported event file version: %d", version)
+	}
+	return &EventFileReader{
+		file:            file,
+		r:               r,
+		LaunchEpochTime: binary.LittleEndian.Uint64(header[6:14]),
+	}, nil
+}
+
+// Next returns the next event in the file, io.EOF is returned when all
+// events have been read.
+func (r *EventFileReader) Next() (*RecordedEvent, error) {
+	prefix := make([]byte, 13)
+	if _, err := io.ReadFull(r.r, prefix); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// the recording was interrupted in the middle of an event
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	evt := &RecordedEvent{
+		Kind:   RecordedEventKind(prefix[0]),
+		Offset: time.Duration(binary.LittleEndian.Uint64(prefix[1:9])),
+	}
+	length := binary.LittleEndian.Uint32(prefix[9:13])
+	if uint64(length) > uint64(maxRecordedPayloadSize) {
+		return nil, fmt.Errorf("event payload too long: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return nil, io.EOF
+	}
+
+	var err error
+	switch evt.Kind {
+	case RecordedSyscallEvent:
+		evt.Syscall = new(SyscallEventData)
+		evt.Syscall.Buf, err = decodeRecordedEvent(payload, &evt.Syscall.SyscallEvent)
+	case RecordedSslEvent:
+		evt.Ssl = new(SslData)
+		evt.Ssl.Buf, err = decodeRecordedEvent(payload, &evt.Ssl.SslEventHeader)
+	case RecordedConnEvent:
+		evt.Conn = new(AgentConnEvtT)
+		_, err = decodeRecordedEvent(payload, evt.Conn)
+	case RecordedKernEvent:
+		evt.Kern = new(AgentKernEvt)
+		_, err = decodeRecordedEvent(payload, evt.Kern)
+	case RecordedFirstPacketEvent:
+		evt.FirstPacket = new(AgentFirstPacketEvt)
+		_, err = decodeRecordedEvent(payload, evt.FirstPacket)
+	default:
+		err = fmt.Errorf("unknown event kind: %d", evt.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return evt, nil
+}
+
+// decodeRecordedEvent decodes the struct at the beginning of payload and
+// returns the remaining bytes.
+func decodeRecordedEvent(payload []byte, data any) ([]byte, error) {
+	size := binary.Size(data)
+	if size < 0 || size > len(payload) {
+		return nil, fmt.Errorf("event payload too short: %d", len(payload))
+	}
+	if err := binary.Read(bytes.NewReader(payload[:size]), binary.LittleEndian, data); err != nil {
+		return nil, err
+	}
+	return payload[size:], nil
+}
+
+func (r *EventFileReader) Close() error {
+	return r.file.Close()
+}
+
+// ReplayEvents feeds the events of the file to the channels the same way the
+// Pull*Events functions do. The intervals between events are kept, divided by
+// speed, a speed <= 0 replays the events as fast as possible.
+func ReplayEvents(ctx context.Context, reader *EventFileReader, speed float64,
+	syscallChannels []chan *SyscallEventData, sslChannels []chan *SslData, connChannels []chan *AgentConnEvtT,
+	kernChannels []chan *AgentKernEvt, firstPacketChannel chan *AgentFirstPacketEvt) error {
+	start := time.Now()
+	for {
+		evt, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if speed > 0 {
+			wait := time.Duration(float64(evt.Offset)/speed) - time.Since(start)
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(wait):
+				}
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+		}
+
+		switch evt.Kind {
+		case RecordedSyscallEvent:
+			tgidfd := evt.Syscall.SyscallEvent.Ke.ConnIdS.TgidFd
+			syscallChannels[int(tgidfd)%len(syscallChannels)] <- evt.Syscall
+		case RecordedSslEvent:
+			tgidfd := evt.Ssl.SslEventHeader.Ke.ConnIdS.TgidFd
+			sslChannels[int(tgidfd)%len(sslChannels)] <- evt.Ssl
+		case RecordedConnEvent:
+			tgidFd := uint64(evt.Conn.ConnInfo.ConnId.Upid.Pid)<<32 | uint64(evt.Conn.ConnInfo.ConnId.Fd)
+			connChannels[int(tgidFd)%len(connChannels)] <- evt.Conn
+		case RecordedKernEvent:
+			tgidFd := evt.Kern.ConnIdS.TgidFd
+			kernChannels[int(tgidFd)%len(kernChannels)] <- evt.Kern
+		case RecordedFirstPacketEvent:
+			firstPacketChannel <- evt.FirstPacket
+		}
+	}
+}
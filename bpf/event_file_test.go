@@ -0,0 +1,106 @@
+package bpf_test
+
+import (
+	"context"
+	"io"
+	"kyanos/bpf"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	writer, err := bpf.NewEventFileWriter(path, 12345)
+	assert.NoError(t, err)
+
+	syscallEvent := &bpf.SyscallEventData{
+		SyscallEvent: bpf.SyscallEvent{Ke: bpf.AgentKernEvt{Ts: 100, Seq: 1, Len: 5, ConnIdS: bpf.AgentConnIdS_t{TgidFd: 1<<32 | 3}}, BufSize: 5},
+		Buf:          []byte("hello"),
+	}
+	sslEvent := &bpf.SslData{
+		SslEventHeader: bpf.SslEventHeader{Ke: bpf.AgentKernEvt{Ts: 200}, SyscallSeq: 7, BufSize: 3},
+		Buf:            []byte("abc"),
+	}
+	connEvent := &bpf.AgentConnEvtT{Ts: 50, ConnType: bpf.AgentConnTypeTKConnect}
+	connEvent.ConnInfo.ConnId.Upid.Pid = 1
+	connEvent.ConnInfo.ConnId.Fd = 3
+	kernEvent := &bpf.AgentKernEvt{Ts: 150, Len: 5, Step: bpf.AgentStepTNIC_OUT}
+	firstPacketEvent := &bpf.AgentFirstPacketEvt{Ts: 10, Len: 5}
+
+	writer.WriteConnEvent(connEvent)
+	writer.WriteSyscallEvent(syscallEvent)
+	writer.WriteSslEvent(sslEvent)
+	writer.WriteKernEvent(kernEvent)
+	writer.WriteFirstPacketEvent(firstPacketEvent)
+	assert.NoError(t, writer.Close())
+
+	reader, err := bpf.NewEventFileReader(path)
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.Equal(t, uint64(12345), reader.LaunchEpochTime)
+
+	evt, err := reader.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, bpf.RecordedConnEvent, evt.Kind)
+	assert.Equal(t, connEvent, evt.Conn)
+	evt, _ = reader.Next()
+	assert.Equal(t, syscallEvent, evt.Syscall)
+	evt, _ = reader.Next()
+	assert.Equal(t, sslEvent, evt.Ssl)
+	evt, _ = reader.Next()
+	assert.Equal(t, kernEvent, evt.Kern)
+	evt, _ = reader.Next()
+	assert.Equal(t, firstPacketEvent, evt.FirstPacket)
+	_, err = reader.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReplayEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	writer, err := bpf.NewEventFileWriter(path, 0)
+	assert.NoError(t, err)
+	for fd := uint64(0); fd < 4; fd++ {
+		writer.WriteKernEvent(&bpf.AgentKernEvt{ConnIdS: bpf.AgentConnIdS_t{TgidFd: fd}})
+	}
+	assert.NoError(t, writer.Close())
+
+	reader, err := bpf.NewEventFileReader(path)
+	assert.NoError(t, err)
+	defer reader.Close()
+	kernChannels := []chan *bpf.AgentKernEvt{make(chan *bpf.AgentKernEvt, 4), make(chan *bpf.AgentKernEvt, 4)}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = bpf.ReplayEvents(ctx, reader, 0, nil, nil, nil, kernChannels, nil)
+	assert.NoError(t, err)
+	assert.Len(t, kernChannels[0], 2)
+	assert.Len(t, kernChannels[1], 2)
+}
+
+func TestNotEventFile(t *testing.T) {
+	_, err := bpf.NewEventFileReader("prog_test.go")
+	assert.ErrorIs(t, err, bpf.ErrInvalidEventFile)
+}
+
+func TestEventFilePayloadTooLong(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	writer, err := bpf.NewEventFileWriter(path, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	// a syscall event claiming a 4GiB payload
+	_, err = file.Write([]byte{byte(bpf.RecordedSyscallEvent), 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	reader, err := bpf.NewEventFileReader(path)
+	assert.NoError(t, err)
+	defer reader.Close()
+	_, err = reader.Next()
+	assert.ErrorContains(t, err, "event payload too long")
+}
@@ -344,7 +344,7 @@ func parseConnEvent(record []byte) (*AgentConnEvtT, error) {
 	return &event, nil
 }
 
-func PullFirstPacketEvents(ctx context.Context, channel chan *AgentFirstPacketEvt, perfCPUBufferPageNum int) error {
+func PullFirstPacketEvents(ctx context.Context, channel chan *AgentFirstPacketEvt, perfCPUBufferPageNum int, hook FirstPacketEventHook) error {
 	pageSize := os.Getpagesize()
 	perCPUBuffer := pageSize * perfCPUBufferPageNum
 	eventSize := int(unsafe.Sizeof(AgentFirstPacketEvt{}))
@@ -375,6 +375,9 @@ func PullFirstPacketEvents(ctx context.Context, channel chan *AgentFirstPacketEv
 					common.AgentLog.Errorf("[firstPacketReader] first packet event err: %s\n", err)
 					continue
 				} else {
+					if hook != nil {
+						hook(evt)
+					}
 					channel <- evt
 				}
 			}
@@ -461,3 +464,4 @@ type SyscallEventHook func(evt *SyscallEventData)
 type SslEventHook func(evt *SslData)
 type ConnEventHook func(evt *AgentConnEvtT)
 type KernEventHook func(evt *AgentKernEvt)
+type FirstPacketEventHook func(evt *AgentFirstPacketEvt)
@@ -52,6 +52,13 @@ enum traffic_protocol_t {
   kProtocolMux,
   kProtocolAMQP,
   kProtocolRocketMQ,
+  kProtocolMemcached,
+  kProtocolDubbo,
+  kProtocolThrift,
+  kProtocolMQTT,
+  kProtocolZooKeeper,
+  // not inferred, set in user space when an HTTP/1.1 connection is upgraded
+  kProtocolWebSocket,
   kNumProtocols
 };
 
@@ -160,6 +160,371 @@ static __always_inline int is_mysql_protocol(const char *old_buf, size_t count,
   return kUnknown;
 }
 
+// PostgreSQL startup message:
+//      0         8        16        24        32
+//      +---------+---------+---------+---------+
+//      |                length                 |
+//      +---------+---------+---------+---------+
+//      |      protocol version(3.0)            |
+//      +---------+---------+---------+---------+
+//      |   "user\0" ... key-value pairs ...    |
+//      +----------------------------------------
+// Regular message: tag(1 byte) + length(4 bytes, including itself) + payload.
+// Only Query('Q') and Parse('P') are used to infer, they are the most common
+// first message of a request on an established connection.
+static __always_inline enum message_type_t is_pgsql_protocol(const char *old_buf, size_t count) {
+  // length(4 bytes) + version(4 bytes) + "user"(4 bytes)
+  static const int32_t kMinStartupLength = 12;
+  // Assume startup message won't be larger than 10KiB.
+  static const int32_t kMaxStartupLength = 10240;
+  static const int32_t kMinPayloadLength = 8;
+  static const int32_t kMaxPayloadLength = 30000;
+
+  if (count < 5) {
+    return kUnknown;
+  }
+
+  char buf[12] = {};
+  if (count >= kMinStartupLength) {
+    bpf_probe_read_user(buf, 12, old_buf);
+    int32_t length = read_big_endian_int32(buf);
+    if (length >= kMinStartupLength && length <= kMaxStartupLength &&
+        buf[4] == 0x00 && buf[5] == 0x03 && buf[6] == 0x00 && buf[7] == 0x00 &&
+        buf[8] == 'u' && buf[9] == 's' && buf[10] == 'e' && buf[11] == 'r') {
+      return kRequest;
+    }
+  } else {
+    bpf_probe_read_user(buf, 5, old_buf);
+  }
+
+  if (buf[0] != 'Q' && buf[0] != 'P') {
+    return kUnknown;
+  }
+  int32_t length = read_big_endian_int32(buf + 1);
+  if (length < kMinPayloadLength || length > kMaxPayloadLength) {
+    return kUnknown;
+  }
+  // If the whole query message is in the buffer, the SQL text must end with \0.
+  if (buf[0] == 'Q' && length + 1 <= (int32_t)count) {
+    char last = 0;
+    bpf_probe_read_user(&last, 1, old_buf + length);
+    if (last != '\0') {
+      return kUnknown;
+    }
+  }
+  return kRequest;
+}
+
+// CQL(Cassandra native protocol) frame header, v3 and later:
+//      0         8        16        24        32         40
+//      +---------+---------+---------+---------+---------+
+//      | version |  flags  |      stream       | opcode  |
+//      +---------+---------+---------+---------+---------+
+//      |                length                 |
+//      +---------+---------+---------+---------+
+// The highest bit of version is set in responses. v5 segments after the
+// handshake are not inferred, the STARTUP before them is.
+static __always_inline enum message_type_t is_cql_protocol(const char *old_buf, size_t count) {
+  static const int kFrameHeaderLength = 9;
+  // Cassandra refuses frames larger than native_transport_max_frame_size, 16MB by default.
+  static const int32_t kMaxFrameLength = 256 * 1024 * 1024;
+
+  if (count < kFrameHeaderLength) {
+    return kUnknown;
+  }
+  char buf[9] = {};
+  bpf_probe_read_user(buf, 9, old_buf);
+  bool is_response = (buf[0] & 0x80) != 0;
+  uint8_t version = buf[0] & 0x7f;
+  if (version < 3 || version > 5) {
+    return kUnknown;
+  }
+  // COMPRESSION, TRACING, CUSTOM_PAYLOAD, WARNING and USE_BETA
+  if ((buf[1] & 0xe0) != 0) {
+    return kUnknown;
+  }
+  uint8_t opcode = buf[4];
+  int32_t length = read_big_endian_int32(buf + 5);
+  if (length < 0 || length > kMaxFrameLength) {
+    return kUnknown;
+  }
+  if (!is_response) {
+    // STARTUP, OPTIONS, QUERY, PREPARE, EXECUTE, REGISTER, BATCH, AUTH_RESPONSE
+    if (opcode == 0x01 || opcode == 0x05 || opcode == 0x07 || opcode == 0x09 || opcode == 0x0a ||
+        opcode == 0x0b || opcode == 0x0d || opcode == 0x0f) {
+      return kRequest;
+    }
+  } else {
+    // ERROR, READY, AUTHENTICATE, SUPPORTED, RESULT, EVENT, AUTH_CHALLENGE, AUTH_SUCCESS
+    if (opcode == 0x00 || opcode == 0x02 || opcode == 0x03 || opcode == 0x06 || opcode == 0x08 ||
+        opcode == 0x0c || opcode == 0x0e || opcode == 0x10) {
+      return kResponse;
+    }
+  }
+  return kUnknown;
+}
+
+// NATS is text based, only the operations which can't be confused with
+// Redis are used: INFO and MSG from the server, CONNECT, PUB and SUB from the
+// client. PING, PONG, +OK and -ERR are valid Redis messages as well.
+static __always_inline enum message_type_t is_nats_protocol(const char *old_buf, size_t count) {
+  // the shortest is "SUB a 1\r\n"
+  if (count < 9) {
+    return kUnknown;
+  }
+  char buf[9] = {};
+  bpf_probe_read_user(buf, 9, old_buf);
+  if (buf[0] == 'I' && buf[1] == 'N' && buf[2] == 'F' && buf[3] == 'O' && buf[4] == ' ' && buf[5] == '{') {
+    return kResponse;
+  }
+  if (buf[0] == 'C' && buf[1] == 'O' && buf[2] == 'N' && buf[3] == 'N' && buf[4] == 'E' && buf[5] == 'C' &&
+      buf[6] == 'T' && buf[7] == ' ' && buf[8] == '{') {
+    return kRequest;
+  }
+  if (buf[0] == 'M' && buf[1] == 'S' && buf[2] == 'G' && buf[3] == ' ') {
+    return kResponse;
+  }
+  if (buf[0] == 'H' && buf[1] == 'M' && buf[2] == 'S' && buf[3] == 'G' && buf[4] == ' ') {
+    return kResponse;
+  }
+  if (buf[0] == 'P' && buf[1] == 'U' && buf[2] == 'B' && buf[3] == ' ') {
+    return kRequest;
+  }
+  if (buf[0] == 'H' && buf[1] == 'P' && buf[2] == 'U' && buf[3] == 'B' && buf[4] == ' ') {
+    return kRequest;
+  }
+  if (buf[0] == 'S' && buf[1] == 'U' && buf[2] == 'B' && buf[3] == ' ') {
+    return kRequest;
+  }
+  return kUnknown;
+}
+
+static __always_inline enum message_type_t is_amqp_protocol(const char *old_buf, size_t count) {
+  // the protocol header "AMQP\0\0\9\1" or a method frame:
+  // type(1) channel(2) size(4) class-id(2) method-id(2)
+  if (count < 8) {
+    return kUnknown;
+  }
+  char buf[11] = {};
+  if (count < 12) {
+    bpf_probe_read_user(buf, 8, old_buf);
+  } else {
+    bpf_probe_read_user(buf, 11, old_buf);
+  }
+  if (buf[0] == 'A' && buf[1] == 'M' && buf[2] == 'Q' && buf[3] == 'P' && buf[4] == 0 && buf[5] == 0 &&
+      buf[6] == 9 && buf[7] == 1) {
+    return kRequest;
+  }
+  if (count < 12 || buf[0] != 1) {
+    return kUnknown;
+  }
+  uint32_t size = ((uint8_t)buf[3] << 24) | ((uint8_t)buf[4] << 16) | ((uint8_t)buf[5] << 8) | (uint8_t)buf[6];
+  if (size < 4 || size > (1 << 20)) {
+    return kUnknown;
+  }
+  uint16_t class_id = ((uint8_t)buf[7] << 8) | (uint8_t)buf[8];
+  uint16_t method_id = ((uint8_t)buf[9] << 8) | (uint8_t)buf[10];
+  if (method_id == 0 || method_id > 120) {
+    return kUnknown;
+  }
+  switch (class_id) {
+    case 10:
+      // the server starts the handshake with connection.start, secure and tune
+      if (method_id == 10 || method_id == 20 || method_id == 30 || method_id == 41 || method_id == 51 ||
+          method_id == 60 || method_id == 61 || method_id == 71) {
+        return kResponse;
+      }
+      return kRequest;
+    case 20:
+    case 40:
+    case 50:
+    case 85:
+    case 90:
+      return method_id % 10 == 0 ? kRequest : kResponse;
+    case 60:
+      // basic.return, basic.deliver, basic.get-ok and basic.get-empty
+      if (method_id == 50 || method_id == 60 || method_id == 71 || method_id == 72) {
+        return kResponse;
+      }
+      return method_id % 10 == 0 ? kRequest : kResponse;
+  }
+  return kUnknown;
+}
+
+static __always_inline enum message_type_t is_memcached_protocol(const char *old_buf, size_t count) {
+  if (count < 5) {
+    return kUnknown;
+  }
+  char buf[8] = {};
+  bpf_probe_read_user(buf, 5, old_buf);
+  // binary protocol: magic(1) opcode(1) key length(2) extras length(1) data type(1)
+  if ((uint8_t)buf[0] == 0x80 || (uint8_t)buf[0] == 0x81) {
+    if (count < 24) {
+      return kUnknown;
+    }
+    bpf_probe_read_user(buf, 8, old_buf);
+    if ((uint8_t)buf[1] > 0x22 || buf[5] != 0) {
+      return kUnknown;
+    }
+    return (uint8_t)buf[0] == 0x80 ? kRequest : kResponse;
+  }
+
+  // text protocol, every request and reply line ends with \r\n
+  char last_buf[2] = {};
+  bpf_probe_read_user(last_buf, 2, old_buf + count - 2);
+  if (last_buf[0] != '\r' || last_buf[1] != '\n') {
+    return kUnknown;
+  }
+  if ((buf[0] == 'g' && buf[1] == 'e' && buf[2] == 't' && (buf[3] == ' ' || (buf[3] == 's' && buf[4] == ' '))) ||
+      (buf[0] == 's' && buf[1] == 'e' && buf[2] == 't' && buf[3] == ' ') ||
+      (buf[0] == 'a' && buf[1] == 'd' && buf[2] == 'd' && buf[3] == ' ') ||
+      (buf[0] == 'c' && buf[1] == 'a' && buf[2] == 's' && buf[3] == ' ') ||
+      (buf[0] == 'i' && buf[1] == 'n' && buf[2] == 'c' && buf[3] == 'r' && buf[4] == ' ') ||
+      (buf[0] == 'd' && buf[1] == 'e' && buf[2] == 'c' && buf[3] == 'r' && buf[4] == ' ') ||
+      (buf[0] == 'd' && buf[1] == 'e' && buf[2] == 'l' && buf[3] == 'e' && buf[4] == 't') ||
+      (buf[0] == 't' && buf[1] == 'o' && buf[2] == 'u' && buf[3] == 'c' && buf[4] == 'h') ||
+      (buf[0] == 'r' && buf[1] == 'e' && buf[2] == 'p' && buf[3] == 'l' && buf[4] == 'a') ||
+      (buf[0] == 'a' && buf[1] == 'p' && buf[2] == 'p' && buf[3] == 'e' && buf[4] == 'n') ||
+      (buf[0] == 'p' && buf[1] == 'r' && buf[2] == 'e' && buf[3] == 'p' && buf[4] == 'e')) {
+    return kRequest;
+  }
+  if ((buf[0] == 'V' && buf[1] == 'A' && buf[2] == 'L' && buf[3] == 'U' && buf[4] == 'E') ||
+      (buf[0] == 'E' && buf[1] == 'N' && buf[2] == 'D' && buf[3] == '\r') ||
+      (buf[0] == 'S' && buf[1] == 'T' && buf[2] == 'O' && buf[3] == 'R' && buf[4] == 'E') ||
+      (buf[0] == 'N' && buf[1] == 'O' && buf[2] == 'T' && buf[3] == '_') ||
+      (buf[0] == 'D' && buf[1] == 'E' && buf[2] == 'L' && buf[3] == 'E' && buf[4] == 'T') ||
+      (buf[0] == 'T' && buf[1] == 'O' && buf[2] == 'U' && buf[3] == 'C' && buf[4] == 'H')) {
+    return kResponse;
+  }
+  return kUnknown;
+}
+
+// MQTT is binary and its fixed header is short, so only the packets whose
+// remaining length matches the size of the syscall are accepted: CONNECT
+// with its protocol name, PUBLISH and SUBSCRIBE from the client, CONNACK and
+// SUBACK from the server.
+static __always_inline enum message_type_t is_mqtt_protocol(const char *old_buf, size_t count) {
+  if (count < 4) {
+    return kUnknown;
+  }
+  char buf[12] = {};
+  if (count < 12) {
+    bpf_probe_read_user(buf, 4, old_buf);
+  } else {
+    bpf_probe_read_user(buf, 12, old_buf);
+  }
+  uint8_t type = (uint8_t)buf[0] >> 4;
+  uint8_t flags = buf[0] & 0x0f;
+  // the remaining length, packets of more than 16KB are not inferred
+  size_t remaining = (uint8_t)buf[1] & 0x7f;
+  size_t header_length = 2;
+  if ((uint8_t)buf[1] & 0x80) {
+    if ((uint8_t)buf[2] & 0x80) {
+      return kUnknown;
+    }
+    remaining |= ((uint8_t)buf[2]) << 7;
+    header_length = 3;
+  }
+  if (header_length + remaining != count) {
+    return kUnknown;
+  }
+  switch (type) {
+    case 1:
+      // the protocol name "MQTT" of 3.1.1 and 5.0, or "MQIsdp" of 3.1
+      if (flags != 0 || count < 12 || buf[header_length] != 0) {
+        return kUnknown;
+      }
+      if ((buf[header_length + 1] == 4 && buf[header_length + 2] == 'M' && buf[header_length + 3] == 'Q' &&
+           buf[header_length + 4] == 'T' && buf[header_length + 5] == 'T') ||
+          (buf[header_length + 1] == 6 && buf[header_length + 2] == 'M' && buf[header_length + 3] == 'Q' &&
+           buf[header_length + 4] == 'I' && buf[header_length + 5] == 's')) {
+        return kRequest;
+      }
+      return kUnknown;
+    case 2:
+      // acknowledge flags and the return code
+      if (flags != 0 || remaining < 2 || (uint8_t)buf[header_length] > 1) {
+        return kUnknown;
+      }
+      return kResponse;
+    case 3: {
+      // the topic length is within the packet
+      size_t topic_length = ((uint8_t)buf[header_length] << 8) | (uint8_t)buf[header_length + 1];
+      if ((flags & 0x06) == 0x06 || topic_length == 0 || topic_length + 2 > remaining) {
+        return kUnknown;
+      }
+      return kRequest;
+    }
+    case 8:
+      if (flags != 2 || remaining < 5) {
+        return kUnknown;
+      }
+      return kRequest;
+    case 9:
+      if (flags != 0 || remaining < 3) {
+        return kUnknown;
+      }
+      return kResponse;
+  }
+  return kUnknown;
+}
+
+static __always_inline enum message_type_t is_dubbo_protocol(const char *old_buf, size_t count) {
+  // magic(2) flag(1) status(1) request id(8) data length(4)
+  if (count < 16) {
+    return kUnknown;
+  }
+  char buf[4] = {};
+  bpf_probe_read_user(buf, 4, old_buf);
+  if ((uint8_t)buf[0] != 0xda || (uint8_t)buf[1] != 0xbb) {
+    return kUnknown;
+  }
+  uint8_t flag = buf[2];
+  // the low 5 bits are the serialization, hessian2 is 2
+  if ((flag & 0x1f) == 0 || (flag & 0x1f) > 25) {
+    return kUnknown;
+  }
+  if (flag & 0x80) {
+    return kRequest;
+  }
+  // the status of a response is one of 20, 30, 31, 40, ... 100
+  uint8_t status = buf[3];
+  if (status < 20 || status > 100) {
+    return kUnknown;
+  }
+  return kResponse;
+}
+
+static __always_inline enum message_type_t is_thrift_protocol(const char *old_buf, size_t count) {
+  // frame size(4) followed by the header of the strict binary protocol
+  // 0x80 0x01 0x00 type, or the compact protocol 0x82 (type << 5 | 1)
+  if (count < 8) {
+    return kUnknown;
+  }
+  char buf[8] = {};
+  bpf_probe_read_user(buf, 8, old_buf);
+  uint32_t size = ((uint8_t)buf[0] << 24) | ((uint8_t)buf[1] << 16) | ((uint8_t)buf[2] << 8) | (uint8_t)buf[3];
+  if (size < 4 || size > (16 << 20)) {
+    return kUnknown;
+  }
+  uint8_t type = 0;
+  if ((uint8_t)buf[4] == 0x80 && buf[5] == 1 && buf[6] == 0) {
+    type = buf[7];
+  } else if ((uint8_t)buf[4] == 0x82 && (buf[5] & 0x1f) == 1) {
+    type = (uint8_t)buf[5] >> 5;
+  } else {
+    return kUnknown;
+  }
+  // call and oneway are requests, reply and exception are responses
+  if (type == 1 || type == 4) {
+    return kRequest;
+  }
+  if (type == 2 || type == 3) {
+    return kResponse;
+  }
+  return kUnknown;
+}
+
 static __always_inline int is_redis_protocol(const char *old_buf, size_t count) {
   if (count < 3) {
     return false;
@@ -177,7 +542,9 @@ static __always_inline int is_redis_protocol(const char *old_buf, size_t count)
       // Bulk strings start with $
       first_byte != '$' &&
       // Arrays start with *
-      first_byte != '*') {
+      first_byte != '*' &&
+      // RESP3 pushes, like the messages of a subscriber, start with >
+      first_byte != '>') {
     return false;
   }
 
@@ -213,6 +580,24 @@ static __always_inline enum message_type_t is_http_protocol(const char *old_buf,
   return kUnknown;
 }
 
+// HTTP/2 connection preface: "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n".
+// Only new connections can be inferred, frames of an established connection
+// have nothing distinctive enough.
+static __always_inline enum message_type_t is_http2_protocol(const char *old_buf, size_t count) {
+  static const int kPrefaceLength = 24;
+  if (count < kPrefaceLength) {
+    return kUnknown;
+  }
+  char buf[16] = {};
+  bpf_probe_read_user(buf, 16, old_buf);
+  if (buf[0] == 'P' && buf[1] == 'R' && buf[2] == 'I' && buf[3] == ' ' &&
+      buf[4] == '*' && buf[5] == ' ' && buf[6] == 'H' && buf[7] == 'T' &&
+      buf[8] == 'T' && buf[9] == 'P' && buf[10] == '/' && buf[11] == '2' &&
+      buf[12] == '.' && buf[13] == '0' && buf[14] == '\r' && buf[15] == '\n') {
+    return kRequest;
+  }
+  return kUnknown;
+}
 
 // MongoDB protocol
 static __inline enum message_type_t is_mongo_protocol(const char* old_buf, size_t count) {
@@ -375,6 +760,52 @@ static __inline enum message_type_t is_dns_protocol(const char* buf, size_t coun
 
 #define TRACE_PROTOCOL(p) (trace_protocol == kProtocolUnset || trace_protocol == p)
 
+// ZooKeeper packets are preceded by their length, which must match the size
+// of the syscall. The connect request and response are recognized by their
+// length and protocol version 0, the other requests by the xid and the op
+// code, the replies by the error code.
+static __always_inline enum message_type_t is_zookeeper_protocol(const char *old_buf, size_t count) {
+  if (count < 12) {
+    return kUnknown;
+  }
+  char buf[20] = {};
+  if (count < 20) {
+    bpf_probe_read_user(buf, 12, old_buf);
+  } else {
+    bpf_probe_read_user(buf, 20, old_buf);
+  }
+  uint32_t length = ((uint8_t)buf[0] << 24) | ((uint8_t)buf[1] << 16) | ((uint8_t)buf[2] << 8) | (uint8_t)buf[3];
+  if (length + 4 != count) {
+    return kUnknown;
+  }
+  int32_t xid = ((uint8_t)buf[4] << 24) | ((uint8_t)buf[5] << 16) | ((uint8_t)buf[6] << 8) | (uint8_t)buf[7];
+  int32_t op = ((uint8_t)buf[8] << 24) | ((uint8_t)buf[9] << 16) | ((uint8_t)buf[10] << 8) | (uint8_t)buf[11];
+  if (xid == 0) {
+    // the password is at most 16 bytes, and the read only flag is optional
+    if (length >= 28 && length <= 45) {
+      return kRequest;
+    }
+    if (length >= 20 && length <= 37) {
+      return kResponse;
+    }
+    return kUnknown;
+  }
+  if ((xid == -2 && op == 11) || (xid == -4 && op == 100) || (xid == -8 && (op == 101 || op == 105))) {
+    return kRequest;
+  }
+  if (xid > 0 && ((op >= 1 && op <= 22 && op != 10) || (op >= 100 && op <= 107) || op == -11)) {
+    return kRequest;
+  }
+  if (count < 20 || (xid <= 0 && xid != -1 && xid != -2 && xid != -4 && xid != -8)) {
+    return kUnknown;
+  }
+  int32_t err = ((uint8_t)buf[16] << 24) | ((uint8_t)buf[17] << 16) | ((uint8_t)buf[18] << 8) | (uint8_t)buf[19];
+  if (err == 0 || (err >= -15 && err <= -1) || (err >= -118 && err <= -100)) {
+    return kResponse;
+  }
+  return kUnknown;
+}
+
 static __always_inline struct protocol_message_t infer_protocol(const char *buf, size_t count, 
     size_t total_count, struct conn_info_t *conn_info, enum traffic_protocol_t trace_protocol) {
   struct protocol_message_t protocol_message;
@@ -384,16 +815,36 @@ static __always_inline struct protocol_message_t infer_protocol(const char *buf,
 
   if (TRACE_PROTOCOL(kProtocolHTTP) && (protocol_message.type = is_http_protocol(buf, count)) != kUnknown) {
     protocol_message.protocol = kProtocolHTTP;
+  } else if (TRACE_PROTOCOL(kProtocolHTTP2) && (protocol_message.type = is_http2_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolHTTP2;
   } else if (TRACE_PROTOCOL(kProtocolMongo) && (protocol_message.type = is_mongo_protocol(buf, count)) != kUnknown)  {
     protocol_message.protocol = kProtocolMongo;
   } else if (TRACE_PROTOCOL(kProtocolMySQL) && (protocol_message.type = is_mysql_protocol(buf, count, conn_info)) != kUnknown)  {
     protocol_message.protocol = kProtocolMySQL;
+  } else if (TRACE_PROTOCOL(kProtocolPGSQL) && (protocol_message.type = is_pgsql_protocol(buf, count)) != kUnknown)  {
+    protocol_message.protocol = kProtocolPGSQL;
+  } else if (TRACE_PROTOCOL(kProtocolCQL) && (protocol_message.type = is_cql_protocol(buf, count)) != kUnknown)  {
+    protocol_message.protocol = kProtocolCQL;
   } else if (TRACE_PROTOCOL(kProtocolRocketMQ) && (protocol_message.type = is_rocketmq_protocol(buf, count)) != kUnknown) {
     protocol_message.protocol = kProtocolRocketMQ;
+  } else if (TRACE_PROTOCOL(kProtocolDubbo) && (protocol_message.type = is_dubbo_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolDubbo;
+  } else if (TRACE_PROTOCOL(kProtocolThrift) && (protocol_message.type = is_thrift_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolThrift;
   } else if (TRACE_PROTOCOL(kProtocolKafka) && (protocol_message.type = is_kafka_protocol(buf, count, total_count, conn_info)) != kUnknown) {
     protocol_message.protocol = kProtocolKafka;
   } else if (TRACE_PROTOCOL(kProtocolDNS) && (protocol_message.type = is_dns_protocol(buf, count)) != kUnknown) {
     protocol_message.protocol = kProtocolDNS;
+  } else if (TRACE_PROTOCOL(kProtocolAMQP) && (protocol_message.type = is_amqp_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolAMQP;
+  } else if (TRACE_PROTOCOL(kProtocolNATS) && (protocol_message.type = is_nats_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolNATS;
+  } else if (TRACE_PROTOCOL(kProtocolMemcached) && (protocol_message.type = is_memcached_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolMemcached;
+  } else if (TRACE_PROTOCOL(kProtocolMQTT) && (protocol_message.type = is_mqtt_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolMQTT;
+  } else if (TRACE_PROTOCOL(kProtocolZooKeeper) && (protocol_message.type = is_zookeeper_protocol(buf, count)) != kUnknown) {
+    protocol_message.protocol = kProtocolZooKeeper;
   } else if (TRACE_PROTOCOL(kProtocolRedis) && is_redis_protocol(buf, count)) {
     protocol_message.protocol = kProtocolRedis;
   }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/amqp"
+
+	"github.com/spf13/cobra"
+)
+
+var amqpCmd *cobra.Command = &cobra.Command{
+	Use:   "amqp [--exchange EXCHANGES] [--queue QUEUES] [--routing-key ROUTING_KEYS]",
+	Short: "watch AMQP 0-9-1 (RabbitMQ) message",
+	Long:  `Filter AMQP methods based on exchange, queue and routing key. Synchronous methods are paired with their replies, basic.publish is captured only on channels in confirm mode and is paired with basic.ack or basic.nack.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exchanges, err := cmd.Flags().GetStringSlice("exchange")
+		if err != nil {
+			logger.Fatalf("invalid exchange: %v\n", err)
+		}
+		queues, err := cmd.Flags().GetStringSlice("queue")
+		if err != nil {
+			logger.Fatalf("invalid queue: %v\n", err)
+		}
+		routingKeys, err := cmd.Flags().GetStringSlice("routing-key")
+		if err != nil {
+			logger.Fatalf("invalid routing-key: %v\n", err)
+		}
+
+		options.MessageFilter = amqp.Filter{
+			TargetExchanges:   exchanges,
+			TargetQueues:      queues,
+			TargetRoutingKeys: routingKeys,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	amqpCmd.Flags().StringSlice("exchange", []string{}, "Specify the exchanges to monitor, seperate by ','")
+	amqpCmd.Flags().StringSlice("queue", []string{}, "Specify the queues to monitor, seperate by ','")
+	amqpCmd.Flags().StringSlice("routing-key", []string{}, "Specify the routing keys to monitor, seperate by ','")
+
+	amqpCmd.Flags().SortFlags = false
+	amqpCmd.PersistentFlags().SortFlags = false
+	copy := *amqpCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *amqpCmd
+	statCmd.AddCommand(&copy2)
+}
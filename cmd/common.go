@@ -5,6 +5,8 @@ import (
 	"kyanos/agent"
 	ac "kyanos/agent/common"
 	"kyanos/agent/protocol"
+	"kyanos/agent/protocol/filter"
+	"kyanos/agent/render/otlp"
 	"kyanos/common"
 	"os"
 
@@ -58,6 +60,18 @@ func startAgent() {
 		options.Side = side
 	} else {
 		options.WatchOptions.MaxRecords = maxRecords
+		if options.WatchOptions.OtlpOutput() {
+			if _, err := otlp.ParseEndpoint(options.WatchOptions.Opts); err != nil {
+				logger.Fatalf("invalid output: %v\n", err)
+			}
+		}
+	}
+	if FilterExpression != "" {
+		messageFilter, err := filter.New(FilterExpression, options.MessageFilter)
+		if err != nil {
+			logger.Fatalf("invalid filter: %v\n", err)
+		}
+		options.MessageFilter = messageFilter
 	}
 	options.IfName = IfName
 	options.BTFFilePath = BTFFilePath
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/cql"
+
+	"github.com/spf13/cobra"
+)
+
+var cqlCmd *cobra.Command = &cobra.Command{
+	Use:   "cql [--keyspaces KEYSPACES|--tables TABLES|--consistency LEVELS]",
+	Short: "watch CQL(Cassandra/Scylla) message",
+	Long:  `Filter CQL messages based on keyspace, table or consistency level. Filter flags are combined with AND(&&).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		keyspaces, err := cmd.Flags().GetStringSlice("keyspaces")
+		if err != nil {
+			logger.Fatalf("invalid keyspaces: %v\n", err)
+		}
+		tables, err := cmd.Flags().GetStringSlice("tables")
+		if err != nil {
+			logger.Fatalf("invalid tables: %v\n", err)
+		}
+		consistencies, err := cmd.Flags().GetStringSlice("consistency")
+		if err != nil {
+			logger.Fatalf("invalid consistency: %v\n", err)
+		}
+
+		options.MessageFilter = cql.Filter{
+			TargetKeyspaces:     keyspaces,
+			TargetTables:        tables,
+			TargetConsistencies: consistencies,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	cqlCmd.Flags().StringSlice("keyspaces", []string{}, "Specify the keyspaces to monitor, seperate by ','")
+	cqlCmd.Flags().StringSlice("tables", []string{}, "Specify the tables to monitor(users or shop.users), seperate by ','")
+	cqlCmd.Flags().StringSlice("consistency", []string{}, "Specify the consistency levels to monitor(ONE,LOCAL_QUORUM), seperate by ','")
+
+	cqlCmd.Flags().SortFlags = false
+	cqlCmd.PersistentFlags().SortFlags = false
+	copy := *cqlCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *cqlCmd
+	statCmd.AddCommand(&copy2)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/dubbo"
+
+	"github.com/spf13/cobra"
+)
+
+var dubboCmd *cobra.Command = &cobra.Command{
+	Use:   "dubbo [--service SERVICES] [--method METHODS]",
+	Short: "watch Dubbo message",
+	Long:  `Filter Dubbo requests based on service and method. The invocation is decoded from hessian2 requests only, requests of other serializations have no service and method.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		services, err := cmd.Flags().GetStringSlice("service")
+		if err != nil {
+			logger.Fatalf("invalid service: %v\n", err)
+		}
+		methods, err := cmd.Flags().GetStringSlice("method")
+		if err != nil {
+			logger.Fatalf("invalid method: %v\n", err)
+		}
+
+		options.MessageFilter = dubbo.Filter{
+			TargetServices: services,
+			TargetMethods:  methods,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	dubboCmd.Flags().StringSlice("service", []string{}, "Specify the dubbo service interfaces to monitor, seperate by ','")
+	dubboCmd.Flags().StringSlice("method", []string{}, "Specify the dubbo methods to monitor, seperate by ','")
+	dubboCmd.Flags().SortFlags = false
+	dubboCmd.PersistentFlags().SortFlags = false
+	copy := *dubboCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *dubboCmd
+	statCmd.AddCommand(&copy2)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/http2"
+
+	"github.com/spf13/cobra"
+)
+
+var grpcCmd *cobra.Command = &cobra.Command{
+	Use:   "grpc [--service SERVICES|--method METHODS]",
+	Short: "watch gRPC(HTTP/2) message",
+	Long:  `Filter gRPC messages based on service or method. Filter flags are combined with AND(&&).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		services, err := cmd.Flags().GetStringSlice("service")
+		if err != nil {
+			logger.Fatalf("invalid service: %v\n", err)
+		}
+		methods, err := cmd.Flags().GetStringSlice("method")
+		if err != nil {
+			logger.Fatalf("invalid method: %v\n", err)
+		}
+
+		options.MessageFilter = http2.GrpcFilter{
+			TargetServices: services,
+			TargetMethods:  methods,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	grpcCmd.Flags().StringSlice("service", []string{}, "Specify the gRPC services to monitor(helloworld.Greeter or Greeter), seperate by ','")
+	grpcCmd.Flags().StringSlice("method", []string{}, "Specify the gRPC methods to monitor(SayHello), seperate by ','")
+
+	grpcCmd.Flags().SortFlags = false
+	grpcCmd.PersistentFlags().SortFlags = false
+	copy := *grpcCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *grpcCmd
+	statCmd.AddCommand(&copy2)
+}
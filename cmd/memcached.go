@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/memcached"
+
+	"github.com/spf13/cobra"
+)
+
+var memcachedCmd *cobra.Command = &cobra.Command{
+	Use:   "memcached [--command COMMANDS] [--keys KEYS] [--key-prefix PREFIX]",
+	Short: "watch Memcached message",
+	Long:  `Filter Memcached requests of the text and binary protocols based on command and key. Commands of the binary protocol are named after the text commands, a get which returns no item is a miss.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		commands, err := cmd.Flags().GetStringSlice("command")
+		if err != nil {
+			logger.Fatalf("invalid command: %v\n", err)
+		}
+		keys, err := cmd.Flags().GetStringSlice("keys")
+		if err != nil {
+			logger.Fatalf("invalid keys: %v\n", err)
+		}
+		prefix, err := cmd.Flags().GetString("key-prefix")
+		if err != nil {
+			logger.Fatalf("invalid prefix: %v\n", err)
+		}
+
+		options.MessageFilter = memcached.Filter{
+			TargetCommands: commands,
+			TargetKeys:     keys,
+			KeyPrefix:      prefix,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	memcachedCmd.Flags().StringSlice("command", []string{}, "Specify the memcached command to monitor(get, set), seperate by ','")
+	memcachedCmd.Flags().StringSlice("keys", []string{}, "Specify the memcached keys to monitor, seperate by ','")
+	memcachedCmd.Flags().String("key-prefix", "", "Specify the memcached key prefix to monitor")
+	memcachedCmd.Flags().SortFlags = false
+	memcachedCmd.PersistentFlags().SortFlags = false
+	copy := *memcachedCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *memcachedCmd
+	statCmd.AddCommand(&copy2)
+}
@@ -7,10 +7,28 @@ import (
 )
 
 var mongodbCmd *cobra.Command = &cobra.Command{
-	Use:   "mongodb",
+	Use:   "mongodb [--command COMMANDS|--db DATABASES|--collection COLLECTIONS]",
 	Short: "watch mongodb message",
+	Long:  `Filter MongoDB messages based on the command, the database and the collection of the request. Filter flags are combined with AND(&&).`,
 	Run: func(cmd *cobra.Command, args []string) {
-		options.MessageFilter = mongodb.NewMongoDBFilter()
+		commands, err := cmd.Flags().GetStringSlice("command")
+		if err != nil {
+			logger.Fatalf("invalid command: %v\n", err)
+		}
+		databases, err := cmd.Flags().GetStringSlice("db")
+		if err != nil {
+			logger.Fatalf("invalid db: %v\n", err)
+		}
+		collections, err := cmd.Flags().GetStringSlice("collection")
+		if err != nil {
+			logger.Fatalf("invalid collection: %v\n", err)
+		}
+
+		options.MessageFilter = &mongodb.MongoDBFilter{
+			Commands:    commands,
+			Databases:   databases,
+			Collections: collections,
+		}
 		options.LatencyFilter = initLatencyFilter(cmd)
 		options.SizeFilter = initSizeFilter(cmd)
 		startAgent()
@@ -18,7 +36,11 @@ var mongodbCmd *cobra.Command = &cobra.Command{
 }
 
 func init() {
+	mongodbCmd.Flags().StringSlice("command", []string{}, "Specify the commands to monitor, like find, insert or aggregate, seperate by ','")
+	mongodbCmd.Flags().StringSlice("db", []string{}, "Specify the databases to monitor, seperate by ','")
+	mongodbCmd.Flags().StringSlice("collection", []string{}, "Specify the collections to monitor, seperate by ','")
 
+	mongodbCmd.Flags().SortFlags = false
 	mongodbCmd.PersistentFlags().SortFlags = false
 	copy := *mongodbCmd
 	watchCmd.AddCommand(&copy)
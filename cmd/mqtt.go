@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/mqtt"
+
+	"github.com/spf13/cobra"
+)
+
+var mqttCmd *cobra.Command = &cobra.Command{
+	Use:   "mqtt [--topic TOPICS]",
+	Short: "watch MQTT message",
+	Long:  `Filter MQTT 3.1.1 and 5.0 packets based on topic. Publishes of QoS 1 and 2 are shown with their PUBACK or PUBREC, the publishes of QoS 0 and those delivered by the broker are not shown.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topics, err := cmd.Flags().GetStringSlice("topic")
+		if err != nil {
+			logger.Fatalf("invalid topic: %v\n", err)
+		}
+
+		options.MessageFilter = mqtt.Filter{
+			TargetTopics: topics,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	mqttCmd.Flags().StringSlice("topic", []string{}, "Specify the topics to monitor, wildcards + and # are supported, seperate by ','")
+	mqttCmd.Flags().SortFlags = false
+	mqttCmd.PersistentFlags().SortFlags = false
+	copy := *mqttCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *mqttCmd
+	statCmd.AddCommand(&copy2)
+}
@@ -2,15 +2,61 @@ package cmd
 
 import (
 	"kyanos/agent/protocol/mysql"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 var mysqlCmd *cobra.Command = &cobra.Command{
-	Use:   "mysql",
+	Use:   "mysql [--sql-regex REGEX|--command COMMANDS|--error-only|--min-rows ROWS|--schema SCHEMA|--transactions]",
 	Short: "watch MYSQL message",
+	Long:  `Filter MySQL messages based on SQL text, command, error responses, rows and the default database. Filter flags are combined with AND(&&).`,
 	Run: func(cmd *cobra.Command, args []string) {
-		options.MessageFilter = mysql.MysqlFilter{}
+		var sqlReg *regexp.Regexp
+		if sqlRegStr, err := cmd.Flags().GetString("sql-regex"); err != nil {
+			logger.Fatalf("invalid sql-regex: %v\n", err)
+		} else if len(sqlRegStr) > 0 {
+			if sqlReg, err = regexp.Compile(sqlRegStr); err != nil {
+				logger.Fatalf("invalid sql-regex: %v\n", err)
+			}
+		}
+		commands, err := cmd.Flags().GetStringSlice("command")
+		if err != nil {
+			logger.Fatalf("invalid command: %v\n", err)
+		}
+		for i, command := range commands {
+			commands[i] = strings.ToLower(command)
+			if !slices.Contains(mysql.CommandNames(), commands[i]) {
+				logger.Fatalf("invalid command: %s, can be: %s\n", command, strings.Join(mysql.CommandNames(), ", "))
+			}
+		}
+		errorOnly, err := cmd.Flags().GetBool("error-only")
+		if err != nil {
+			logger.Fatalf("invalid error-only: %v\n", err)
+		}
+		minRows, err := cmd.Flags().GetInt("min-rows")
+		if err != nil {
+			logger.Fatalf("invalid min-rows: %v\n", err)
+		}
+		schema, err := cmd.Flags().GetString("schema")
+		if err != nil {
+			logger.Fatalf("invalid schema: %v\n", err)
+		}
+		transactions, err := cmd.Flags().GetBool("transactions")
+		if err != nil {
+			logger.Fatalf("invalid transactions: %v\n", err)
+		}
+
+		options.MessageFilter = mysql.MysqlFilter{
+			TargetSqlReg:   sqlReg,
+			TargetCommands: commands,
+			ErrorOnly:      errorOnly,
+			MinRows:        minRows,
+			Schema:         schema,
+			Transactions:   transactions,
+		}
 		options.LatencyFilter = initLatencyFilter(cmd)
 		options.SizeFilter = initSizeFilter(cmd)
 		startAgent()
@@ -18,6 +64,14 @@ var mysqlCmd *cobra.Command = &cobra.Command{
 }
 
 func init() {
+	mysqlCmd.Flags().String("sql-regex", "", "Specify the regex for SQL text to monitor, like: '^(?i)update '")
+	mysqlCmd.Flags().StringSlice("command", []string{}, "Specify the commands to monitor, like query, stmt_prepare or stmt_execute, seperate by ','")
+	mysqlCmd.Flags().Bool("error-only", false, "Only show requests whose response is an ERR packet")
+	mysqlCmd.Flags().Int("min-rows", 0, "Only show requests which returned or affected at least this many rows")
+	mysqlCmd.Flags().String("schema", "", "Only show requests sent while this database is the default, set by COM_INIT_DB or USE")
+	mysqlCmd.Flags().Bool("transactions", false, "Only show the records of transactions, from BEGIN to COMMIT or ROLLBACK, whose latency is the duration of the transaction")
+
+	mysqlCmd.Flags().SortFlags = false
 	mysqlCmd.PersistentFlags().SortFlags = false
 	copy := *mysqlCmd
 	watchCmd.AddCommand(&copy)
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/nats"
+
+	"github.com/spf13/cobra"
+)
+
+var natsCmd *cobra.Command = &cobra.Command{
+	Use:   "nats [--subject SUBJECTS]",
+	Short: "watch NATS request-reply message",
+	Long:  `Filter NATS requests based on subject. Only requests published with a reply subject are captured, the latency is the time until the reply arrives.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subjects, err := cmd.Flags().GetStringSlice("subject")
+		if err != nil {
+			logger.Fatalf("invalid subject: %v\n", err)
+		}
+
+		options.MessageFilter = nats.Filter{
+			TargetSubjects: subjects,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	natsCmd.Flags().StringSlice("subject", []string{}, "Specify the subjects to monitor, wildcards(orders.*, orders.>) can be used, seperate by ','")
+
+	natsCmd.Flags().SortFlags = false
+	natsCmd.PersistentFlags().SortFlags = false
+	copy := *natsCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *natsCmd
+	statCmd.AddCommand(&copy2)
+}
@@ -1,23 +1,47 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"kyanos/agent/render/graph"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
 
 var overviewCmd = &cobra.Command{
-	Use:   "overview [--metrics <metric_name>]",
+	Use:   "overview [--metrics <metric_name>] [--graph dot|mermaid|json]",
 	Short: "Overview the dependencies like mysql/redis/.. in one cmd line.",
 	Example: `
 # Basic Usage
 sudo kyanos overview
+
+# Write the dependencies seen in 30 seconds as a Graphviz graph
+sudo kyanos overview --graph dot --duration 30s --graph-output deps.dot
 `,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) { Mode = AnalysisMode },
 	Run: func(cmd *cobra.Command, args []string) {
 		overview = true
 		groupBy = "remote-ip/protocol-adaptive"
+		if graphFormat != "" {
+			if !slices.Contains(graph.Formats, graphFormat) {
+				logger.Fatalf("invalid graph: %q, can be: %s\n", graphFormat, strings.Join(graph.Formats, " | "))
+			}
+			if graphDuration <= 0 {
+				logger.Fatalf("invalid duration: %s\n", graphDuration)
+			}
+			options.GraphFormat = graphFormat
+			options.GraphOutput = graphOutput
+			options.GraphDuration = graphDuration
+		}
 		startAgent()
 	},
 }
 
 var overview bool
+var graphFormat string
+var graphOutput string
+var graphDuration time.Duration
 
 func init() {
 	overviewCmd.PersistentFlags().StringVarP(&enabledMetricsString, "metric", "m", "t", `Specify the statistical dimensions, including:
@@ -26,6 +50,9 @@ func init() {
 	p/respsize:  response size,
 	n/network-time:  network device latency,
 	s/socket-time:  time spent reading from the socket buffer`)
+	overviewCmd.PersistentFlags().StringVar(&graphFormat, "graph", "", "Write the dependencies of the processes as a graph instead of rendering the table, can be: dot | mermaid | json")
+	overviewCmd.PersistentFlags().DurationVar(&graphDuration, "duration", 10*time.Second, "How long to collect the requests before writing the graph")
+	overviewCmd.PersistentFlags().StringVar(&graphOutput, "graph-output", "stdout", "Write the graph to a file instead of the terminal")
 
 	overviewCmd.Flags().SortFlags = false
 	overviewCmd.PersistentFlags().SortFlags = false
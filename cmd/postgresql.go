@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/postgresql"
+	"regexp"
+
+	"github.com/spf13/cobra"
+)
+
+var postgresqlCmd *cobra.Command = &cobra.Command{
+	Use:   "postgresql [--sql-regex REGEX|--error-only]",
+	Short: "watch PostgreSQL message",
+	Long:  `Filter PostgreSQL messages based on SQL text or error responses. Filter flags are combined with AND(&&).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var sqlReg *regexp.Regexp
+		if sqlRegStr, err := cmd.Flags().GetString("sql-regex"); err != nil {
+			logger.Fatalf("invalid sql-regex: %v\n", err)
+		} else if len(sqlRegStr) > 0 {
+			if sqlReg, err = regexp.Compile(sqlRegStr); err != nil {
+				logger.Fatalf("invalid sql-regex: %v\n", err)
+			}
+		}
+		errorOnly, err := cmd.Flags().GetBool("error-only")
+		if err != nil {
+			logger.Fatalf("invalid error-only: %v\n", err)
+		}
+
+		options.MessageFilter = postgresql.Filter{
+			TargetSqlReg: sqlReg,
+			ErrorOnly:    errorOnly,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	postgresqlCmd.Flags().String("sql-regex", "", "Specify the regex for SQL text to monitor, like: '^(?i)select .* from users'")
+	postgresqlCmd.Flags().Bool("error-only", false, "Only show requests whose response is an ErrorResponse")
+
+	postgresqlCmd.Flags().SortFlags = false
+	postgresqlCmd.PersistentFlags().SortFlags = false
+	copy := *postgresqlCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *postgresqlCmd
+	statCmd.AddCommand(&copy2)
+}
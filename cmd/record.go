@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var recordCmd = &cobra.Command{
+	Use:   "record [--window 10m] [--trigger-latency 500ms] [--trigger-error-rate 5]",
+	Short: "Keep the records of the last minutes in memory and dump them to a file when triggered.",
+	Long: `Run in the background like a flight recorder, the records of the last window are kept in memory
+and written to a JSON lines file when:
+  - kyanos receives SIGUSR1
+  - POST /dump is called on --listen
+  - the p99 latency or the error rate of a --trigger-interval exceeds the thresholds`,
+	Example: `
+# Keep the last 10 minutes, dump with: sudo kill -USR1 <pid of kyanos>
+sudo kyanos record --window 10m --dump-dir /var/log/kyanos
+
+# Dump automatically when the p99 latency exceeds 500ms, or on demand over HTTP
+sudo kyanos record --trigger-latency 500ms --listen 127.0.0.1:9899
+curl -X POST http://127.0.0.1:9899/dump
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if recordWindow <= 0 {
+			logger.Fatalf("invalid window: %s\n", recordWindow)
+		}
+		if recordTriggerInterval <= 0 {
+			logger.Fatalf("invalid trigger-interval: %s\n", recordTriggerInterval)
+		}
+		options.FlightRecorderOptions.Window = recordWindow
+		options.FlightRecorderOptions.MaxMemoryBytes = int64(recordMaxMemoryMB) * 1024 * 1024
+		options.FlightRecorderOptions.DumpDir = recordDumpDir
+		options.FlightRecorderOptions.TriggerInterval = recordTriggerInterval
+		options.FlightRecorderOptions.Cooldown = recordCooldown
+		startAgent()
+	},
+}
+
+var recordWindow time.Duration
+var recordMaxMemoryMB int
+var recordDumpDir string
+var recordTriggerInterval time.Duration
+var recordCooldown time.Duration
+
+func init() {
+	recordCmd.PersistentFlags().DurationVar(&recordWindow, "window", 10*time.Minute, "Keep the records of this long")
+	recordCmd.PersistentFlags().IntVar(&recordMaxMemoryMB, "max-memory-mb", 256, "Drop the oldest records earlier if they use more memory than this, 0 means no limit")
+	recordCmd.PersistentFlags().StringVar(&recordDumpDir, "dump-dir", ".", "Directory to write the dumps to")
+	recordCmd.PersistentFlags().StringVar(&options.FlightRecorderOptions.Listen, "listen", "", "Serve POST /dump on this address, like 127.0.0.1:9899")
+	recordCmd.PersistentFlags().DurationVar(&options.FlightRecorderOptions.LatencyThreshold, "trigger-latency", 0, "Dump when the p99 latency of a trigger interval exceeds this")
+	recordCmd.PersistentFlags().Float64Var(&options.FlightRecorderOptions.ErrorRateThreshold, "trigger-error-rate", 0, "Dump when the percentage of failed requests of a trigger interval exceeds this")
+	recordCmd.PersistentFlags().DurationVar(&recordTriggerInterval, "trigger-interval", 10*time.Second, "Interval to evaluate the thresholds on")
+	recordCmd.PersistentFlags().DurationVar(&recordCooldown, "cooldown", time.Minute, "Minimum time between two dumps triggered by the thresholds")
+	recordCmd.PersistentFlags().StringVar(&SidePar, "side", "all", "Filter based on connection side. can be: server | client")
+
+	recordCmd.Flags().SortFlags = false
+	recordCmd.PersistentFlags().SortFlags = false
+	rootCmd.AddCommand(recordCmd)
+}
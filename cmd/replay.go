@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"slices"
+
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <file> [watch|stat] [protocol] [flags]",
+	Short: "Replay the events recorded by --record-events",
+	Long: `Feed the events recorded by '--record-events' to watch or stat, no root privilege is required.
+Filters applied in the kernel (like --pids, --remote-ports) only take effect when recording.`,
+	Example: `
+sudo kyanos watch --record-events /tmp/kyanos.events
+kyanos replay /tmp/kyanos.events
+kyanos replay /tmp/kyanos.events watch http --path /foo/bar
+kyanos replay /tmp/kyanos.events stat redis --slow
+kyanos replay /tmp/kyanos.events watch --replay-speed 0
+	`,
+	// flags belong to the replayed command
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+			cmd.Help()
+			return
+		}
+		options.ReplayFile = args[0]
+		replayArgs := args[1:]
+		if len(replayArgs) == 0 || !slices.Contains([]string{watchCmd.Name(), statCmd.Name()}, replayArgs[0]) {
+			replayArgs = append([]string{watchCmd.Name()}, replayArgs...)
+		}
+		rootCmd.SetArgs(replayArgs)
+		if err := rootCmd.Execute(); err != nil {
+			logger.Fatalf("replay %s failed: %v\n", options.ReplayFile, err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+}
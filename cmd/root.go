@@ -58,6 +58,7 @@ var CriRuntimeEndpoint string
 var ContainerId string
 var ContainerName string
 var PodName string
+var FilterExpression string
 
 func init() {
 	rootCmd.PersistentFlags().StringSliceVarP(&FilterPids, "pids", "p", []string{}, "Filter by pids, seperate by ','")
@@ -65,6 +66,7 @@ func init() {
 	rootCmd.PersistentFlags().StringSliceVarP(&RemotePorts, common.RemotePortsVarName, "", []string{}, "Filter by remote ports, seperate by ','")
 	rootCmd.PersistentFlags().StringSliceVarP(&LocalPorts, common.LocalPortsVarName, "", []string{}, "Filter by local ports, seperate by ','")
 	rootCmd.PersistentFlags().StringSliceVarP(&RemoteIps, common.RemoteIpsVarName, "", []string{}, "Filter by remote ips, seperate by ','")
+	rootCmd.PersistentFlags().StringVar(&FilterExpression, "filter", "", "Filter by an expression of the record fields, like 'http.status >= 500 && latency > 200ms'")
 	// rootCmd.PersistentFlags().StringVar(&IfName, "ifname", "eth0", "--ifname eth0")
 	rootCmd.PersistentFlags().StringVar(&BTFFilePath, "btf", "", "specify kernel BTF file")
 
@@ -106,6 +108,10 @@ func init() {
 
 	rootCmd.PersistentFlags().BoolVar(&options.StartGopsServer, "gops", false, "start gops server")
 
+	// offline capture
+	rootCmd.PersistentFlags().StringVar(&options.RecordEventsFile, "record-events", "", "Record the captured events to a file, which can be analysed later by 'kyanos replay <file>'")
+	rootCmd.PersistentFlags().Float64Var(&options.ReplaySpeed, "replay-speed", 1, "Speed of 'kyanos replay', 2 replays twice as fast as recorded, 0 replays as fast as possible")
+
 	rootCmd.PersistentFlags().MarkHidden("default-log-level")
 	rootCmd.PersistentFlags().MarkHidden("agent-log-level")
 	rootCmd.PersistentFlags().MarkHidden("bpf-event-log-level")
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [--prometheus-listen :9898]",
+	Short: "Run in the background and expose the request-response statistics as Prometheus metrics.",
+	Example: `
+# Expose metrics of all protocols on http://localhost:9898/metrics
+sudo kyanos serve --prometheus-listen :9898
+
+# Only the requests sent by the processes on this host, to port 6379 and 3306
+sudo kyanos serve --side client --remote-ports 6379,3306
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if prometheusListen == "" {
+			logger.Fatalf("invalid prometheus-listen: %q\n", prometheusListen)
+		}
+		options.PrometheusListen = prometheusListen
+		options.AnalysisOptions.ProtocolSpecificClassfiers = protocolSpecificClassfiers()
+		startAgent()
+	},
+}
+
+var prometheusListen string
+
+func init() {
+	serveCmd.PersistentFlags().StringVar(&prometheusListen, "prometheus-listen", ":9898", "Address to serve Prometheus metrics on, the path is /metrics")
+	serveCmd.PersistentFlags().StringVar(&SidePar, "side", "all", "Filter based on connection side. can be: server | client")
+
+	serveCmd.Flags().SortFlags = false
+	serveCmd.PersistentFlags().SortFlags = false
+	rootCmd.AddCommand(serveCmd)
+}
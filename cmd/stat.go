@@ -12,7 +12,7 @@ import (
 )
 
 var statCmd = &cobra.Command{
-	Use:   "stat [--metrics pqtsn] [--samples 10] [--group-by conn|remote-ip|remote-port|local-port|protocol|http-path] [--sort-by avg|max|p50|p90|p99]",
+	Use:   "stat [--metrics pqtsn] [--samples 10] [--group-by conn|remote-ip|remote-port|local-port|protocol|http-path|grpc-method|cql-query|nats-subject|dubbo-method|thrift-method|mqtt-topic|sql-digest|mongo-command] [--sort-by avg|max|p50|p90|p99]",
 	Short: "Analysis connections statistics. Aggregate metrics such as latency and size for request-response pairs.",
 	Example: `
 # Basic Usage, only count HTTP connections, print results when press 'ctlc+c' 
@@ -26,6 +26,9 @@ sudo kyanos stat http --slow --path /example
 
 # find the the remote client which requests big keys
 sudo kyanos stat redis --bigresp
+
+# Find the slowest SQL statements, those only differing in their values are grouped
+sudo kyanos stat mysql --group-by sql-digest --metric total-time
 	`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) { Mode = AnalysisMode },
 	Run: func(cmd *cobra.Command, args []string) {
@@ -100,16 +103,29 @@ func createAnalysisOptions() (anc.AnalysisOptions, error) {
 	options.SlowMode = slowMode
 	options.BigReqMode = bigReqModel
 	options.BigRespMode = bigRespModel
-	options.ProtocolSpecificClassfiers = make(map[bpf.AgentTrafficProtocolT]anc.ClassfierType)
-	// currently only set it hardly
-	options.ProtocolSpecificClassfiers[bpf.AgentTrafficProtocolTKProtocolHTTP] = anc.HttpPath
-	options.ProtocolSpecificClassfiers[bpf.AgentTrafficProtocolTKProtocolRedis] = anc.RedisCommand
-	options.ProtocolSpecificClassfiers[bpf.AgentTrafficProtocolTKProtocolMySQL] = anc.RemoteIp
+	options.ProtocolSpecificClassfiers = protocolSpecificClassfiers()
 	options.TimeLimit = timeLimit
 
 	options.Overview = overview
 	return options, nil
 }
+
+func protocolSpecificClassfiers() map[bpf.AgentTrafficProtocolT]anc.ClassfierType {
+	classfiers := make(map[bpf.AgentTrafficProtocolT]anc.ClassfierType)
+	// currently only set it hardly
+	classfiers[bpf.AgentTrafficProtocolTKProtocolHTTP] = anc.HttpPath
+	classfiers[bpf.AgentTrafficProtocolTKProtocolRedis] = anc.RedisCommand
+	classfiers[bpf.AgentTrafficProtocolTKProtocolMySQL] = anc.SqlDigest
+	classfiers[bpf.AgentTrafficProtocolTKProtocolPGSQL] = anc.SqlDigest
+	classfiers[bpf.AgentTrafficProtocolTKProtocolHTTP2] = anc.GrpcMethod
+	classfiers[bpf.AgentTrafficProtocolTKProtocolCQL] = anc.CqlQuery
+	classfiers[bpf.AgentTrafficProtocolTKProtocolNATS] = anc.NatsSubject
+	classfiers[bpf.AgentTrafficProtocolTKProtocolDubbo] = anc.DubboMethod
+	classfiers[bpf.AgentTrafficProtocolTKProtocolThrift] = anc.ThriftMethod
+	classfiers[bpf.AgentTrafficProtocolTKProtocolMQTT] = anc.MqttTopic
+	classfiers[bpf.AgentTrafficProtocolTKProtocolMongo] = anc.MongoCommand
+	return classfiers
+}
 func init() {
 	statCmd.PersistentFlags().StringVarP(&enabledMetricsString, "metric", "m", "t", `Specify the statistical dimensions, including:
 	t/total-time:  total time taken for request response,
@@ -123,7 +139,7 @@ func init() {
 			"refer to the '--full-body' option.")
 	statCmd.PersistentFlags().StringVarP(&groupBy, "group-by", "g", "default",
 		"Specify aggregation dimension: \n"+
-			"('conn', 'local-port', 'remote-port', 'remote-ip', 'protocol', 'http-path', 'none')\n"+
+			"('conn', 'local-port', 'remote-port', 'remote-ip', 'protocol', 'http-path', 'grpc-method', 'cql-query', 'nats-subject', 'dubbo-method', 'thrift-method', 'mqtt-topic', 'sql-digest', 'mongo-command', 'none')\n"+
 			"note: 'none' is aggregate all req-resp pair together")
 	// statCmd.PersistentFlags().StringVar(&subGroupBy, "sub-group-by", "default",
 	// 	"Specify sub aggregation dimension: like `group-by`, but before set this option you must specify `group-by`")
@@ -140,6 +156,7 @@ func init() {
 	statCmd.PersistentFlags().Int64("req-size", 0, "Filter based on request bytes size")
 	statCmd.PersistentFlags().Int64("resp-size", 0, "Filter based on response bytes size")
 	statCmd.PersistentFlags().StringVar(&SidePar, "side", "all", "Filter based on connection side. can be: server | client")
+	statCmd.PersistentFlags().StringVar(&options.ReadPcapFile, "read-pcap", "", "Analyze the TCP traffic in a pcap or pcapng file instead of tracing the kernel, no root privilege is required")
 
 	statCmd.Flags().SortFlags = false
 	statCmd.PersistentFlags().SortFlags = false
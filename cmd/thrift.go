@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/thrift"
+
+	"github.com/spf13/cobra"
+)
+
+var thriftCmd *cobra.Command = &cobra.Command{
+	Use:   "thrift [--service SERVICES] [--method METHODS]",
+	Short: "watch Thrift message",
+	Long:  `Filter Thrift requests of the binary and compact protocols over the framed transport based on service and method. The service is only known when the client uses TMultiplexedProtocol.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		services, err := cmd.Flags().GetStringSlice("service")
+		if err != nil {
+			logger.Fatalf("invalid service: %v\n", err)
+		}
+		methods, err := cmd.Flags().GetStringSlice("method")
+		if err != nil {
+			logger.Fatalf("invalid method: %v\n", err)
+		}
+
+		options.MessageFilter = thrift.Filter{
+			TargetServices: services,
+			TargetMethods:  methods,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	thriftCmd.Flags().StringSlice("service", []string{}, "Specify the thrift services of multiplexed clients to monitor, seperate by ','")
+	thriftCmd.Flags().StringSlice("method", []string{}, "Specify the thrift methods to monitor, seperate by ','")
+	thriftCmd.Flags().SortFlags = false
+	thriftCmd.PersistentFlags().SortFlags = false
+	copy := *thriftCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *thriftCmd
+	statCmd.AddCommand(&copy2)
+}
@@ -1 +1,44 @@
 package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top [--interval 2s] [--containers]",
+	Short: "Show request rates, error rates and latencies of each process, refreshed continuously.",
+	Example: `
+# Refresh every 2 seconds, press 'c' to group by container
+sudo kyanos top
+
+# Refresh every second and group by container from the start
+sudo kyanos top --interval 1s --containers
+
+# Only the requests served by the processes on this host
+sudo kyanos top --side server
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if topInterval < 100*time.Millisecond {
+			logger.Fatalf("invalid interval: %s, must be at least 100ms\n", topInterval)
+		}
+		options.TopEnable = true
+		options.TopInterval = topInterval
+		options.TopByContainer = topByContainer
+		startAgent()
+	},
+}
+
+var topInterval time.Duration
+var topByContainer bool
+
+func init() {
+	topCmd.PersistentFlags().DurationVar(&topInterval, "interval", 2*time.Second, "Refresh interval")
+	topCmd.PersistentFlags().BoolVar(&topByContainer, "containers", false, "Group by container instead of process")
+	topCmd.PersistentFlags().StringVar(&SidePar, "side", "all", "Filter based on connection side. can be: server | client")
+
+	topCmd.Flags().SortFlags = false
+	topCmd.PersistentFlags().SortFlags = false
+	rootCmd.AddCommand(topCmd)
+}
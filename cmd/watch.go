@@ -7,15 +7,30 @@ import (
 )
 
 var maxRecords int
-var supportedProtocols = []string{"http", "redis", "mysql", "rocketmq", "kafka", "mongodb", "dns"}
+var supportedProtocols = []string{"http", "redis", "mysql", "rocketmq", "kafka", "mongodb", "dns", "postgresql", "grpc", "cql", "nats", "amqp", "memcached", "dubbo", "thrift", "mqtt", "zookeeper", "websocket"}
 var watchCmd = &cobra.Command{
-	Use: "watch [http|redis|mysql|rocketmq|mongodb|dns] [flags]",
+	Use: "watch [http|redis|mysql|rocketmq|mongodb|dns|postgresql|grpc|cql|nats|amqp|memcached|dubbo|thrift|mqtt|zookeeper|websocket] [flags]",
 	Example: `
 sudo kyanos watch
 sudo kyanos watch http --side server --pid 1234 --path /foo/bar --host ubuntu.com
 sudo kyanos watch redis --command GET,SET --keys foo,bar --key-prefix app1:
 sudo kyanos watch mysql --latency 100 --req-size 1024 --resp-size 2048
+sudo kyanos watch mysql --sql-regex "(?i)^update" --error-only --schema shop
+sudo kyanos watch mysql --transactions --latency 5000
+sudo kyanos watch mongodb --command find,aggregate --db shop --collection orders
 sudo kyanos watch rocketmq --request-codes 10,11 --languages JAVA,Go
+sudo kyanos watch postgresql --sql-regex "(?i)^update" --error-only
+sudo kyanos watch grpc --service helloworld.Greeter --method SayHello
+sudo kyanos watch cql --keyspaces shop --consistency LOCAL_QUORUM
+sudo kyanos watch nats --subject 'orders.>'
+sudo kyanos watch amqp --exchange shop --routing-key order.paid
+sudo kyanos watch memcached --command get,gets --key-prefix session:
+sudo kyanos watch dubbo --service org.apache.dubbo.demo.DemoService --method sayHello
+sudo kyanos watch thrift --method getUser
+sudo kyanos watch mqtt --topic 'sensors/+/temperature'
+sudo kyanos watch zookeeper --path-prefix /kafka
+sudo kyanos watch websocket --id-field id
+sudo kyanos watch --filter 'http.status >= 500 && latency > 200ms'
 	`,
 	Short:            "Capture the request/response recrods",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) { Mode = WatchMode },
@@ -47,7 +62,8 @@ func init() {
 	watchCmd.PersistentFlags().BoolVar(&options.WatchOptions.DebugOutput, "debug-output", false, "Print output to console instead display ui")
 	watchCmd.PersistentFlags().StringVar(&options.WatchOptions.JsonOutput, "json-output", "", "Output in JSON format. Use 'stdout' to print to terminal, or provide a file path to write to a file")
 	watchCmd.PersistentFlags().StringVar(&SidePar, "side", "all", "Filter based on connection side. can be: server | client")
-	watchCmd.PersistentFlags().StringVarP(&options.WatchOptions.Opts, "output", "o", "", "Can be `wide`")
+	watchCmd.PersistentFlags().StringVar(&options.ReadPcapFile, "read-pcap", "", "Analyze the TCP traffic in a pcap or pcapng file instead of tracing the kernel, no root privilege is required")
+	watchCmd.PersistentFlags().StringVarP(&options.WatchOptions.Opts, "output", "o", "", "Can be `wide`, or an OTLP collector to export the records as spans to, like otlp://localhost:4317 (gRPC) or otlp+http://localhost:4318 (HTTP)")
 	watchCmd.PersistentFlags().IntVar(&options.WatchOptions.MaxRecordContentDisplayBytes, "max-print-bytes", 1024, "Control how may bytes of record's req/resp can be printed, \n exceeded part are truncated")
 	watchCmd.PersistentFlags().BoolVar(&options.WatchOptions.TraceDevEvent, "trace-dev-event", true, "Collect dev layer events to measure network interface time spent.")
 	watchCmd.PersistentFlags().BoolVar(&options.WatchOptions.TraceSocketEvent, "trace-socket-event", false, "Collect socket layer events to measure the time spent on socket data copying.")
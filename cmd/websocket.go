@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/websocket"
+
+	"github.com/spf13/cobra"
+)
+
+var websocketCmd *cobra.Command = &cobra.Command{
+	Use:   "websocket [--id-field FIELD]",
+	Short: "watch WebSocket message",
+	Long:  `Watch the WebSocket messages of the HTTP/1.1 connections upgraded with 101 Switching Protocols. Each message sent by the client or pushed by the server is shown alone, unless the JSON text messages of the client are paired with the replies of the server by an id field.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		idField, err := cmd.Flags().GetString("id-field")
+		if err != nil {
+			logger.Fatalf("invalid id field: %v\n", err)
+		}
+
+		websocket.IdField = idField
+		options.MessageFilter = websocket.Filter{}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	websocketCmd.Flags().String("id-field", "", "Specify the JSON field pairing the text messages of the client with the replies of the server, like id or meta.requestId")
+	websocketCmd.Flags().SortFlags = false
+	websocketCmd.PersistentFlags().SortFlags = false
+	copy := *websocketCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *websocketCmd
+	statCmd.AddCommand(&copy2)
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"kyanos/agent/protocol/zookeeper"
+
+	"github.com/spf13/cobra"
+)
+
+var zookeeperCmd *cobra.Command = &cobra.Command{
+	Use:   "zookeeper [--path-prefix PREFIX]",
+	Short: "watch ZooKeeper message",
+	Long:  `Filter ZooKeeper requests based on the znode path. A multi matches if any of its operations does, watch notifications are shown as records pushed by the server and match by the path of the watch.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		prefix, err := cmd.Flags().GetString("path-prefix")
+		if err != nil {
+			logger.Fatalf("invalid prefix: %v\n", err)
+		}
+
+		options.MessageFilter = zookeeper.Filter{
+			PathPrefix: prefix,
+		}
+		options.LatencyFilter = initLatencyFilter(cmd)
+		options.SizeFilter = initSizeFilter(cmd)
+		startAgent()
+	},
+}
+
+func init() {
+	zookeeperCmd.Flags().String("path-prefix", "", "Specify the znode path prefix to monitor")
+	zookeeperCmd.Flags().SortFlags = false
+	zookeeperCmd.PersistentFlags().SortFlags = false
+	copy := *zookeeperCmd
+	watchCmd.AddCommand(&copy)
+	copy2 := *zookeeperCmd
+	statCmd.AddCommand(&copy2)
+}
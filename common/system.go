@@ -45,6 +45,41 @@ func GetAllPids() ([]int32, error) {
 	return process.Pids()
 }
 
+// GetPidName returns the name of the process, or an empty string if the
+// process doesn't exist.
+func GetPidName(pid int32) string {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return ""
+	}
+	name, err := proc.Name()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// Process names are cached by pid, the cache is dropped when it grows too large
+// since pids are reused.
+const kMaxCachedProcessNames = 10000
+
+type ProcessNameCache map[uint32]string
+
+func (c *ProcessNameCache) Get(pid uint32) string {
+	if pid == 0 {
+		return ""
+	}
+	name, ok := (*c)[pid]
+	if !ok {
+		if len(*c) >= kMaxCachedProcessNames {
+			*c = make(ProcessNameCache)
+		}
+		name = GetPidName(int32(pid))
+		(*c)[pid] = name
+	}
+	return name
+}
+
 func GetPidCmdString(pid int32) string {
 	proc, err := process.NewProcess(pid)
 	if err != nil {